@@ -0,0 +1,128 @@
+package ipfslite
+
+import (
+	"net"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// AddrFilter decides which addresses of other peers may be dialed. An
+// address is refused when it matches any of the deny rules or, if Allow
+// is not empty, when it isn't in one of the allowed ranges. Addresses
+// without an IP, e.g. DNS ones, are only dialed when Allow is empty. The
+// peers themselves can be vetted with AllowPeer.
+type AddrFilter struct {
+	// Allow lists the only ranges which may be dialed.
+	Allow []*net.IPNet
+	// Deny lists ranges which are never dialed.
+	Deny []*net.IPNet
+	// DenyPrivate refuses private (RFC1918 and alike), link-local and
+	// loopback addresses.
+	DenyPrivate bool
+	// DenyRelay refuses relay circuit addresses.
+	DenyRelay bool
+	// AllowPeer, when set, is asked before dialing each address of a peer
+	// and once the peer of each connection is known, in both directions.
+	// The peers it refuses are neither dialed nor accepted.
+	AllowPeer func(peer.ID, multiaddr.Multiaddr) bool
+}
+
+// Allowed tells whether a may be dialed.
+func (f *AddrFilter) Allowed(a multiaddr.Multiaddr) bool {
+	if f.DenyRelay {
+		if _, err := a.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+			return false
+		}
+	}
+	if f.DenyPrivate && (manet.IsPrivateAddr(a) || manet.IsIPLoopback(a)) {
+		return false
+	}
+	ip, err := manet.ToIP(a)
+	if err != nil {
+		return len(f.Allow) == 0
+	}
+	for _, n := range f.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, n := range f.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerAllowed tells whether the address a of p may be dialed.
+func (f *AddrFilter) peerAllowed(p peer.ID, a multiaddr.Multiaddr) bool {
+	return f.Allowed(a) && (f.AllowPeer == nil || f.AllowPeer(p, a))
+}
+
+// FilterPeers returns peers with only their allowed addresses. Peers left
+// without any address are dropped.
+func (f *AddrFilter) FilterPeers(peers []peer.AddrInfo) []peer.AddrInfo {
+	out := make([]peer.AddrInfo, 0, len(peers))
+	for _, p := range peers {
+		addrs := make([]multiaddr.Multiaddr, 0, len(p.Addrs))
+		for _, a := range p.Addrs {
+			if f.peerAllowed(p.ID, a) {
+				addrs = append(addrs, a)
+			} else {
+				logger.Debugf("filtered out address %s of %s", a, p.ID)
+			}
+		}
+		if len(addrs) > 0 {
+			out = append(out, peer.AddrInfo{ID: p.ID, Addrs: addrs})
+		}
+	}
+	return out
+}
+
+// Option returns the libp2p option making the host refuse to dial the
+// addresses which aren't allowed, including the ones found through the DHT.
+// Incoming connections are only filtered by AllowPeer.
+func (f *AddrFilter) Option() libp2p.Option {
+	return libp2p.ConnectionGater(&filterGater{f})
+}
+
+// filterGater applies an AddrFilter to the connections of a host.
+type filterGater struct {
+	filter *AddrFilter
+}
+
+func (g *filterGater) InterceptPeerDial(peer.ID) bool {
+	return true
+}
+
+func (g *filterGater) InterceptAddrDial(p peer.ID, a multiaddr.Multiaddr) bool {
+	if !g.filter.peerAllowed(p, a) {
+		logger.Debugf("not dialing address %s of %s", a, p)
+		return false
+	}
+	return true
+}
+
+func (g *filterGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *filterGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if g.filter.AllowPeer != nil && !g.filter.AllowPeer(p, addrs.RemoteMultiaddr()) {
+		logger.Debugf("refusing %s connection with %s", dir, p)
+		return false
+	}
+	return true
+}
+
+func (g *filterGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,104 @@
+package ipfslite
+
+import (
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAddrFilter(t *testing.T) {
+	relay := "/ip4/1.2.3.4/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit"
+	for _, c := range []struct {
+		filter AddrFilter
+		addr   string
+		ok     bool
+	}{
+		{AddrFilter{}, "/ip4/10.0.0.1/tcp/4001", true},
+		{AddrFilter{DenyPrivate: true}, "/ip4/10.0.0.1/tcp/4001", false},
+		{AddrFilter{DenyPrivate: true}, "/ip4/192.168.1.1/tcp/4001", false},
+		{AddrFilter{DenyPrivate: true}, "/ip4/127.0.0.1/tcp/4001", false},
+		{AddrFilter{DenyPrivate: true}, "/ip6/fe80::1/tcp/4001", false},
+		{AddrFilter{DenyPrivate: true}, "/ip4/8.8.8.8/tcp/4001", true},
+		{AddrFilter{DenyRelay: true}, relay, false},
+		{AddrFilter{}, relay, true},
+		{AddrFilter{Deny: []*net.IPNet{mustCIDR(t, "8.8.0.0/16")}}, "/ip4/8.8.8.8/tcp/4001", false},
+		{AddrFilter{Allow: []*net.IPNet{mustCIDR(t, "8.8.0.0/16")}}, "/ip4/8.8.8.8/tcp/4001", true},
+		{AddrFilter{Allow: []*net.IPNet{mustCIDR(t, "8.8.0.0/16")}}, "/ip4/9.9.9.9/tcp/4001", false},
+		{AddrFilter{Allow: []*net.IPNet{mustCIDR(t, "8.8.0.0/16")}}, "/dns4/example.com/tcp/4001", false},
+		{AddrFilter{DenyPrivate: true}, "/dns4/example.com/tcp/4001", true},
+		{AddrFilter{
+			Allow: []*net.IPNet{mustCIDR(t, "8.8.0.0/16")},
+			Deny:  []*net.IPNet{mustCIDR(t, "8.8.8.0/24")},
+		}, "/ip4/8.8.8.8/tcp/4001", false},
+	} {
+		a := multiaddr.StringCast(c.addr)
+		if got := c.filter.Allowed(a); got != c.ok {
+			t.Errorf("%+v %s: expected %v got %v", c.filter, c.addr, c.ok, got)
+		}
+	}
+}
+
+func TestAddrFilterPeers(t *testing.T) {
+	f := &AddrFilter{DenyPrivate: true}
+	p1, _ := peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	p2, _ := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	peers := f.FilterPeers([]peer.AddrInfo{
+		{ID: p1, Addrs: []multiaddr.Multiaddr{
+			multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001"),
+			multiaddr.StringCast("/ip4/8.8.8.8/tcp/4001"),
+		}},
+		{ID: p2, Addrs: []multiaddr.Multiaddr{
+			multiaddr.StringCast("/ip4/192.168.0.1/tcp/4001"),
+		}},
+	})
+	if len(peers) != 1 || peers[0].ID != p1 || len(peers[0].Addrs) != 1 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+}
+
+// connAddrs are the addresses of a connection being gated.
+type connAddrs struct {
+	local, remote multiaddr.Multiaddr
+}
+
+func (c connAddrs) LocalMultiaddr() multiaddr.Multiaddr  { return c.local }
+func (c connAddrs) RemoteMultiaddr() multiaddr.Multiaddr { return c.remote }
+
+func TestAddrFilterAllowPeer(t *testing.T) {
+	p1, _ := peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	p2, _ := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	addr := multiaddr.StringCast("/ip4/8.8.8.8/tcp/4001")
+	f := &AddrFilter{AllowPeer: func(p peer.ID, a multiaddr.Multiaddr) bool {
+		return p == p1 && a.Equal(addr)
+	}}
+	peers := f.FilterPeers([]peer.AddrInfo{
+		{ID: p1, Addrs: []multiaddr.Multiaddr{addr, multiaddr.StringCast("/ip4/9.9.9.9/tcp/4001")}},
+		{ID: p2, Addrs: []multiaddr.Multiaddr{addr}},
+	})
+	if len(peers) != 1 || peers[0].ID != p1 || len(peers[0].Addrs) != 1 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+
+	g := &filterGater{f}
+	if !g.InterceptAddrDial(p1, addr) || g.InterceptAddrDial(p2, addr) {
+		t.Fatal("expected only the allowed peer to be dialed")
+	}
+	conn := connAddrs{local: multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"), remote: addr}
+	if !g.InterceptSecured(network.DirInbound, p1, conn) || g.InterceptSecured(network.DirInbound, p2, conn) {
+		t.Fatal("expected only the allowed peer to be accepted")
+	}
+	if g.InterceptSecured(network.DirOutbound, p2, conn) {
+		t.Fatal("expected the refused peer to be disconnected")
+	}
+}
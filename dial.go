@@ -0,0 +1,77 @@
+package ipfslite
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// DialFailure is why a peer couldn't be connected.
+type DialFailure string
+
+const (
+	// FailureNoAddresses means the peer had no address to dial.
+	FailureNoAddresses DialFailure = "no_addresses"
+	// FailureTimeout means no address answered in time.
+	FailureTimeout DialFailure = "timeout"
+	// FailureRefused means the peer host refused the connection, usually
+	// because nothing listens on the port.
+	FailureRefused DialFailure = "refused"
+	// FailureSwarmKey means the security handshake failed, or timed out
+	// and the peer turned out to use another swarm key, see
+	// Config.SwarmKey and ErrSwarmKeyMismatch.
+	FailureSwarmKey DialFailure = "swarm_key_mismatch"
+	// FailureDNS means the DNS names of the addresses couldn't be
+	// resolved, even after retrying, rather than the peer being
+	// unreachable.
+	FailureDNS DialFailure = "dns"
+	// FailureOther is any other error, e.g. an unreachable network.
+	FailureOther DialFailure = "other"
+)
+
+// DialResult is the outcome of dialing a bootstrap peer. Err is nil when
+// the peer was connected, Transport is then the transport of the
+// connection as named by AddrTransport.
+type DialResult struct {
+	ID        peer.ID
+	Err       error
+	Failure   DialFailure
+	Transport string
+}
+
+// Connected returns the number of peers connected in results.
+func Connected(results []DialResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// dialFailure classifies a dial error. The swarm wraps the errors of each
+// address into a single one, so they are mostly told apart by message.
+func dialFailure(err error) DialFailure {
+	msg := err.Error()
+	switch {
+	case isHandshakeFailure(err):
+		return FailureSwarmKey
+	case strings.Contains(msg, "no addresses") || strings.Contains(msg, "no good addresses"):
+		return FailureNoAddresses
+	case err == context.DeadlineExceeded || strings.Contains(msg, "timeout") ||
+		strings.Contains(msg, "deadline exceeded"):
+		return FailureTimeout
+	case strings.Contains(msg, "connection refused"):
+		return FailureRefused
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return FailureTimeout
+	}
+	if isDNSFailure(err) {
+		return FailureDNS
+	}
+	return FailureOther
+}
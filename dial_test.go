@@ -0,0 +1,175 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestBootstrapReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	psk := newPSK(t)
+	h1, err := libp2p.New(ctx, libp2p.PrivateNetwork(psk), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	good, err := libp2p.New(ctx, libp2p.PrivateNetwork(psk), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer good.Close()
+	down, err := libp2p.New(ctx, libp2p.PrivateNetwork(psk), libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	// A port nothing listens on
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := manet.FromNetAddr(l.Addr())
+	l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A peer of its own, as the addresses dialed for down end up in the
+	// peerstore
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Peer{ctx: ctx, cfg: &Config{}, Host: h1, Dht: nullRouting{}}
+	results, err := p.BootstrapReport([]peer.AddrInfo{
+		{ID: good.ID(), Addrs: good.Addrs()},
+		{ID: down.ID(), Addrs: []multiaddr.Multiaddr{closed}},
+		{ID: unknown},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Connected(results) != 1 || results[0].Err != nil || results[0].ID != good.ID() || results[0].Transport != "tcp" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	for i, want := range []DialFailure{"", FailureRefused, FailureNoAddresses} {
+		if results[i].Failure != want {
+			t.Errorf("peer %d: expected %q got %q (%v)", i, want, results[i].Failure, results[i].Err)
+		}
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	if f := dialFailure(errors.New("failed to negotiate security protocol: EOF")); f != FailureSwarmKey {
+		t.Fatalf("expected a swarm key mismatch got %q", f)
+	}
+	if f := dialFailure(context.DeadlineExceeded); f != FailureTimeout {
+		t.Fatalf("expected a timeout got %q", f)
+	}
+	if f := dialFailure(&net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}); f != FailureTimeout {
+		t.Fatalf("expected a timeout got %q", f)
+	}
+	if f := dialFailure(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "leader.example"}}); f != FailureDNS {
+		t.Fatalf("expected a DNS failure got %q", f)
+	}
+}
+
+func TestPreferTransports(t *testing.T) {
+	addrs := func(ss ...string) []multiaddr.Multiaddr {
+		out := make([]multiaddr.Multiaddr, len(ss))
+		for i, s := range ss {
+			out[i] = multiaddr.StringCast(s)
+		}
+		return out
+	}
+	peers := []peer.AddrInfo{
+		{ID: "a", Addrs: addrs("/ip4/1.2.3.4/udp/4001/quic", "/ip4/1.2.3.4/tcp/4001", "/ip4/1.2.3.4/tcp/4002/ws")},
+		{ID: "b", Addrs: addrs("/ip4/1.2.3.5/udp/4001/quic")},
+	}
+	got := PreferTransports(peers, []string{"tcp", "ws"})
+	if len(got) != 2 || len(got[0].Addrs) != 2 || AddrTransport(got[0].Addrs[0]) != "tcp" || AddrTransport(got[0].Addrs[1]) != "ws" {
+		t.Fatalf("unexpected addresses %v", got)
+	}
+	// No preferred address, all are kept
+	if len(got[1].Addrs) != 1 {
+		t.Fatalf("expected the QUIC address to be kept %v", got[1])
+	}
+	if AddrTransport(multiaddr.StringCast("/ip4/1.2.3.4/tcp/1/wss")) != "wss" || AddrTransport(multiaddr.StringCast("/ip4/1.2.3.4")) != "" {
+		t.Fatal("unexpected transport names")
+	}
+}
+
+// flakyDNS fails the first lookups of every name.
+type flakyDNS struct {
+	fails int
+	calls map[string]int
+}
+
+func (b *flakyDNS) LookupIPAddr(_ context.Context, name string) ([]net.IPAddr, error) {
+	b.calls[name]++
+	if b.calls[name] <= b.fails {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func (b *flakyDNS) LookupTXT(_ context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name}
+}
+
+func TestResolvePeer(t *testing.T) {
+	defer func(r *madns.Resolver, d time.Duration) {
+		dnsResolver, dnsRetryDelay = r, d
+	}(dnsResolver, dnsRetryDelay)
+	dnsRetryDelay = time.Millisecond
+	backend := &flakyDNS{fails: dnsAttempts - 1, calls: map[string]int{}}
+	dnsResolver = &madns.Resolver{Backend: backend}
+
+	pinfo := peer.AddrInfo{ID: "a", Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/dns4/leader.example/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+	}}
+	got, err := resolvePeer(context.Background(), pinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Addrs) != 2 || got.Addrs[0].String() != "/ip4/127.0.0.1/tcp/4001" || backend.calls["leader.example"] != dnsAttempts {
+		t.Fatalf("unexpected addresses %v after %d lookups", got.Addrs, backend.calls["leader.example"])
+	}
+
+	// The other addresses are dialed when a name doesn't resolve
+	backend.fails = 10
+	backend.calls = map[string]int{}
+	got, err = resolvePeer(context.Background(), pinfo)
+	if err != nil || len(got.Addrs) != 1 || got.Addrs[0] != pinfo.Addrs[1] {
+		t.Fatalf("unexpected addresses %v (%v)", got.Addrs, err)
+	}
+	_, err = resolvePeer(context.Background(), peer.AddrInfo{ID: "b", Addrs: pinfo.Addrs[:1]})
+	if err == nil || dialFailure(err) != FailureDNS {
+		t.Fatalf("expected a DNS failure got %v", err)
+	}
+	_, err = resolvePeer(context.Background(), peer.AddrInfo{ID: "c", Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/dnsaddr/leader.example"),
+	}})
+	if err == nil {
+		t.Fatal("expected a DNS failure")
+	}
+}
@@ -0,0 +1,98 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// Number of times the DNS name of an address is looked up before the
+// address is given up, and the wait before the first retry, doubled for
+// each one.
+const dnsAttempts = 3
+
+var dnsRetryDelay = 500 * time.Millisecond
+
+// dnsResolver resolves the DNS addresses of the bootstrap peers and the
+// DNSLink records of names, replaced by tests.
+var dnsResolver = madns.DefaultResolver
+
+// resolvePeer replaces the dns, dns4, dns6 and dnsaddr addresses of pinfo
+// with the addresses they resolve to, so that a lookup failing for a
+// moment, which the swarm would take for an unreachable peer, is retried.
+// The error is only set when the peer had DNS addresses only and none of
+// them resolved.
+func resolvePeer(ctx context.Context, pinfo peer.AddrInfo) (peer.AddrInfo, error) {
+	addrs := make([]multiaddr.Multiaddr, 0, len(pinfo.Addrs))
+	var dnsErr error
+	for _, a := range pinfo.Addrs {
+		if !madns.Matches(a) {
+			addrs = append(addrs, a)
+			continue
+		}
+		resolved, err := resolveAddr(ctx, a)
+		if err != nil {
+			logger.Warnf("failed resolving %s of %s: %s", a, pinfo.ID, err)
+			dnsErr = err
+			continue
+		}
+		addrs = append(addrs, resolved...)
+	}
+	if len(addrs) == 0 && dnsErr != nil {
+		return pinfo, &dnsFailure{err: dnsErr}
+	}
+	return peer.AddrInfo{ID: pinfo.ID, Addrs: addrs}, nil
+}
+
+// resolveAddr looks up the DNS name of a, retrying with a backoff.
+func resolveAddr(ctx context.Context, a multiaddr.Multiaddr) ([]multiaddr.Multiaddr, error) {
+	delay := dnsRetryDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resolved []multiaddr.Multiaddr
+		resolved, err = dnsResolver.Resolve(ctx, a)
+		if err == nil && len(resolved) == 0 {
+			err = errors.New("no address found")
+		}
+		if err == nil {
+			return resolved, nil
+		}
+		if attempt == dnsAttempts {
+			return nil, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// dnsFailure is the error of a peer whose addresses couldn't be resolved.
+type dnsFailure struct {
+	err error
+}
+
+func (e *dnsFailure) Error() string {
+	return "failed resolving addresses: " + e.err.Error()
+}
+
+// isDNSFailure tells whether err comes from looking up the DNS name of an
+// address.
+func isDNSFailure(err error) bool {
+	if _, ok := err.(*dnsFailure); ok {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "no such host")
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package ipfslite
+
+import "syscall"
+
+// dscpControl doesn't mark the sockets on this platform.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	logger.Warn("DSCP marking is not supported on this platform")
+	return nil
+}
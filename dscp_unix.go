@@ -0,0 +1,30 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package ipfslite
+
+import (
+	"strings"
+	"syscall"
+)
+
+// dscpControl returns the dialer control setting the DSCP of the socket,
+// through IP_TOS for IPv4 and IPV6_TCLASS for IPv6. Failing to set it
+// fails the dial, as the network may require the marking.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	tos := dscp << 2
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			} else {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
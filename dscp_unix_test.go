@@ -0,0 +1,41 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package ipfslite
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestDSCPControl(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := net.Dialer{Control: dscpControl(10)}
+	conn, err := d.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		tos, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if err != nil || serr != nil {
+		t.Fatal(err, serr)
+	}
+	// DSCP is the top 6 bits of the TOS byte
+	if tos != 10<<2 {
+		t.Fatalf("expected TOS %d got %d", 10<<2, tos)
+	}
+}
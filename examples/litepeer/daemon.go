@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/StreamSpace/ss-light-client/lib"
+	logger "github.com/ipfs/go-log/v2"
+)
+
+var log = logger.Logger("litepeer")
+
+// Each download of the daemon listens on free ports so that they don't
+// conflict with each other.
+var daemonListenAddrs = []string{
+	"/ip4/0.0.0.0/tcp/0",
+	"/ip6/::/tcp/0",
+	"/ip4/0.0.0.0/tcp/0/ws",
+}
+
+// Number of finished jobs whose outcome can still be asked by default.
+const defaultKeptJobs = 100
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+	// Some files of a directory failed with -dirContinue, they are
+	// listed in the result
+	jobPartial = "partial"
+)
+
+type downloadRequest struct {
+	Sharable    string `json:"sharable"`
+	Destination string `json:"destination"`
+}
+
+type job struct {
+	mtx sync.Mutex
+
+	ID          string           `json:"id"`
+	Sharable    string           `json:"sharable"`
+	Destination string           `json:"destination"`
+	Status      string           `json:"status"`
+	Progress    *lib.ProgressOut `json:"progress,omitempty"`
+	Result      *lib.Out         `json:"result,omitempty"`
+}
+
+func (j *job) UpdateProgress(p lib.ProgressOut) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	j.Progress = &p
+}
+
+func (j *job) setStatus(status string, res *lib.Out) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	j.Status = status
+	j.Result = res
+}
+
+func (j *job) MarshalJSON() ([]byte, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	type plain job
+	return json.Marshal((*plain)(j))
+}
+
+// daemon runs downloads requested over HTTP, at most cap(slots) at a time.
+// Downloads are written under dir, and the outcome of the last keep
+// finished jobs is kept.
+type daemon struct {
+	cfg     lib.Config
+	timeout string
+	slots   chan struct{}
+	dir     string
+	keep    int
+
+	mtx      sync.Mutex
+	next     int
+	jobs     map[string]*job
+	finished []string
+}
+
+func newDaemon(cfg lib.Config, timeout string, concurrency int, dir string, keep int) *daemon {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if keep <= 0 {
+		keep = defaultKeptJobs
+	}
+	cfg.ListenAddrs = daemonListenAddrs
+	// Reuse the connections to the metadata service across downloads
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = lib.NewHTTPClient()
+	}
+	// Several downloads share the process, don't mix their states
+	cfg.StateFile = ""
+	cfg.PaymentsFile = ""
+	return &daemon{
+		cfg:     cfg,
+		timeout: timeout,
+		slots:   make(chan struct{}, concurrency),
+		dir:     dir,
+		keep:    keep,
+		jobs:    make(map[string]*job),
+	}
+}
+
+// destination returns where a download asked to be written to dst goes.
+// Only paths relative to the download directory and staying in it are
+// accepted.
+func (d *daemon) destination(dst string) (string, error) {
+	if filepath.IsAbs(dst) {
+		return "", fmt.Errorf("destination %s is not relative to the download directory", dst)
+	}
+	rel := filepath.Clean(filepath.FromSlash(dst))
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("destination %s is outside the download directory", dst)
+	}
+	return filepath.Join(d.dir, rel), nil
+}
+
+// finish records the outcome of j, forgetting the oldest finished job
+// once more than d.keep are kept.
+func (d *daemon) finish(j *job, status string, res *lib.Out) {
+	j.setStatus(status, res)
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.finished = append(d.finished, j.ID)
+	for len(d.finished) > d.keep {
+		delete(d.jobs, d.finished[0])
+		d.finished = d.finished[1:]
+	}
+}
+
+func (d *daemon) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.health)
+	mux.HandleFunc("/download", d.download)
+	mux.HandleFunc("/jobs/", d.status)
+	return mux
+}
+
+func (d *daemon) health(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "OK")
+}
+
+func (d *daemon) download(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := downloadRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Sharable) == 0 {
+		http.Error(w, "sharable not provided", http.StatusBadRequest)
+		return
+	}
+	if len(req.Destination) == 0 {
+		req.Destination = "."
+	}
+	dst, err := d.destination(req.Destination)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := d.cfg
+	lc, err := lib.NewLightClient(dst, d.timeout, false, &cfg)
+	if err != nil {
+		http.Error(w, "failed setting up client: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.mtx.Lock()
+	d.next++
+	j := &job{
+		ID:          fmt.Sprintf("%d", d.next),
+		Sharable:    req.Sharable,
+		Destination: req.Destination,
+		Status:      jobQueued,
+	}
+	d.jobs[j.ID] = j
+	d.mtx.Unlock()
+
+	go func() {
+		d.slots <- struct{}{}
+		defer func() { <-d.slots }()
+		j.setStatus(jobRunning, nil)
+		out := runJob(j, func() *lib.Out {
+			return lc.Start(j.Sharable, false, true, j)
+		})
+		status := jobFailed
+		switch out.Status {
+		case http.StatusOK:
+			status = jobDone
+		case http.StatusPartialContent:
+			status = jobPartial
+		}
+		d.finish(j, status, out)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": j.ID})
+}
+
+// runJob runs the download of j. A panic fails the job instead of the
+// daemon, so that the other jobs proceed.
+func runJob(j *job, start func() *lib.Out) (out *lib.Out) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Job %s for %s panicked: %v\n%s", j.ID, j.Sharable, r, debug.Stack())
+			out = lib.NewOut(http.StatusInternalServerError, "Download crashed", fmt.Sprint(r), nil)
+		}
+	}()
+	return start()
+}
+
+func (d *daemon) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	d.mtx.Lock()
+	j, ok := d.jobs[id]
+	d.mtx.Unlock()
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j)
+}
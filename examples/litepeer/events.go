@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+
+	"github.com/StreamSpace/ss-light-client/lib"
+)
+
+// socketEvents writes the steps, progress and result of a download to a
+// Unix domain socket, one JSON envelope per line.
+type socketEvents struct {
+	mtx  sync.Mutex
+	conn net.Conn
+	enc  *json.Encoder
+	next lib.ProgressUpdater
+}
+
+func dialEvents(path string, next lib.ProgressUpdater) (*socketEvents, error) {
+	switch runtime.GOOS {
+	case "plan9", "js":
+		return nil, fmt.Errorf("unix sockets are not supported on %s", runtime.GOOS)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &socketEvents{conn: conn, enc: json.NewEncoder(conn), next: next}, nil
+}
+
+func (e *socketEvents) send(out *lib.Out) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	// Events are best effort, the download goes on if the reader is gone
+	e.enc.Encode(out)
+}
+
+func (e *socketEvents) step(code lib.StepCode, message string) {
+	if code == lib.StepBootstrapProgress {
+		// Sent with its details by bootstrap
+		return
+	}
+	e.send(lib.NewOut(200, message, "", map[string]string{"step": code.String()}))
+}
+
+// bootstrap sends the progress of connecting to peers along with the step.
+func (e *socketEvents) bootstrap(ev lib.BootstrapEvent) {
+	e.send(lib.NewOut(200, "Connecting to peers", "", struct {
+		Step string `json:"step"`
+		lib.BootstrapEvent
+	}{lib.StepBootstrapProgress.String(), ev}))
+}
+
+func (e *socketEvents) UpdateProgress(p lib.ProgressOut) {
+	e.send(lib.NewOut(200, "Progress", "", p))
+	e.next.UpdateProgress(p)
+}
+
+func (e *socketEvents) Close() error {
+	return e.conn.Close()
+}
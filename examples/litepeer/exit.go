@@ -0,0 +1,50 @@
+package main
+
+import "net/http"
+
+// Exit codes of the command, one per class of failure so that scripts can
+// tell them apart.
+const (
+	exitSuccess      = 0
+	exitFailure      = 1
+	exitInvalidInput = 2
+	exitService      = 3
+	exitNoPeers      = 4
+	exitTimeout      = 5
+	exitWrite        = 6
+	exitPartial      = 7
+	exitCost         = 8
+	exitThroughput   = 9
+	exitClock        = 10
+)
+
+// exitCode maps the status of an Out to the exit code of the process.
+func exitCode(status int) int {
+	switch status {
+	case http.StatusOK:
+		return exitSuccess
+	case http.StatusBadRequest:
+		return exitInvalidInput
+	case http.StatusServiceUnavailable, http.StatusUnauthorized, http.StatusGone:
+		// The service failed or refused the session or the sharable
+		return exitService
+	case http.StatusForbidden, http.StatusBadGateway:
+		// The peers refused the swarm key or none could be connected
+		return exitNoPeers
+	case http.StatusGatewayTimeout:
+		return exitTimeout
+	case http.StatusNotFound:
+		return exitWrite
+	case http.StatusPartialContent:
+		return exitPartial
+	case http.StatusPaymentRequired:
+		return exitCost
+	case http.StatusRequestTimeout:
+		return exitThroughput
+	case http.StatusPreconditionFailed:
+		// The system clock appears incorrect
+		return exitClock
+	default:
+		return exitFailure
+	}
+}
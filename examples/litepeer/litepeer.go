@@ -1,35 +1,171 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/StreamSpace/ss-light-client/lib"
 	logger "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
 )
 
 // Command arguments
 var (
 	destination = flag.String("dst", ".", "Complete file path on disk to store downloaded file")
 	sharable    = flag.String("sharable", "", "Sharable string provided for file")
+	byoFile     = flag.String("metadata", "", "JSON file with the metadata to use instead of asking the service (testing only)")
+	name        = flag.String("name", "", "IPNS name or DNSLink domain to download instead of a sharable")
 	timeout     = flag.String("timeout", "15m", "Timeout duration for download")
 	onlyInfo    = flag.Bool("info", false, "Get only fetch info")
+	deepInfo    = flag.Bool("deep", false, "With '-info', also check that the leaders can be connected and serve the content")
+	preflight   = flag.Bool("preflight", false, "Only check that the swarm can be joined and serves the content, without downloading it")
+	infoTimeout = flag.Duration("infoTimeout", 30*time.Second, "Timeout for fetching info with '-info' or '-preflight'")
+	verify      = flag.Bool("verify", false, "Download and check the content against its hash without writing it")
+	verifyFile  = flag.String("verifyFile", "", "Check a file downloaded earlier against the hash of the sharable, without downloading")
+	durable     = flag.Bool("durable", false, "Sync the downloaded file to disk before reporting the download complete")
+	verifyDst   = flag.Bool("verifyContent", false, "Check the downloaded file against its hash before reporting the download complete")
 	stat        = flag.Bool("stat", false, "Get stat of the last fetch")
-	enableLog   = flag.Bool("logToStderr", false, "Enable app logs on stderr")
+	netStats    = flag.Bool("netStatsOnFailure", false, "Report the connections, streams and memory of the node when a download fails")
+	webhook     = flag.String("webhook", "", "URL sent a POST request with the result of the download once it ended")
+	webhookKey  = flag.String("webhookSecret", "", "Secret signing the '-webhook' requests with HMAC-SHA256")
+	enableLog   = flag.Bool("logToStderr", false, "Enable app logs on stderr (same as '-loglevel info')")
+	logLevel    = flag.String("loglevel", "", "Level of the app logs on stderr (error, warn, info or debug)")
 	showProg    = flag.Bool("progress", false, "Enable progress on stdout")
+	progSocket  = flag.String("progressSocket", "", "Unix socket to write the steps, progress and result to as JSON lines")
+	progEvery   = flag.Int("progressEvery", 0, "Only show progress every N percent (0 to update it every 500ms)")
 	jsonOut     = flag.Bool("json", false, "Display output in json format")
+	quiet       = flag.Bool("quiet", false, "Only print the result of the command and errors")
+	jsonVersion = flag.Int("jsonVersion", lib.OutVersion, "Version of the json output to use")
+	dhtMode     = flag.String("dht", "client", "DHT mode (auto, client, server or off)")
+	whoami      = flag.Bool("whoami", false, "Show the peer ID and listen addresses at startup")
+	stateFile   = flag.String("state", "", "File to save the download state to, to resume after a restart")
+	keepPartial = flag.Bool("keepPartial", false, "Leave the part file of a failed download in place")
+	partialDir  = flag.String("partialDir", "", "Directory to move the part files of failed downloads to")
+	fileMode    = flag.String("fileMode", "0644", "Permission of the downloaded files, in octal")
+	peerCache   = flag.String("peerCache", "", "File to remember the peers of each swarm in, to dial them first next time")
+	sidecar     = flag.Bool("sidecar", false, "Write the CID of the content to <dst>.cid once downloaded")
+	sidecarSum  = flag.Bool("sidecarSHA256", false, "Also write the SHA-256 of the file to <dst>.sha256, as sha256sum does")
+	manifest    = flag.String("manifest", "", "File to write the list of received blocks and their peers to")
+	traceFile   = flag.String("trace", "", "File to write a JSON lines trace of the download to, for issue reports")
+	outputMode  = flag.String("output", "file", "What to make of the content: file, car, tar, bytes (written to '-dst') or discard")
+	settleWait  = flag.Duration("settleWait", 5*time.Second, "Time left to send the last micropayments after the transfer (0 for none)")
+	flushPay    = flag.Bool("flushPayments", false, "Send the last micropayments right away instead of waiting, if supported")
+	payments    = flag.String("payments", "", "File to save the micropayment receipts of the download to")
+	swarms      = flag.String("allowedSwarms", "", "Comma separated fingerprints of the swarms allowed to join")
+	dialTimeout = flag.Duration("dialTimeout", 0, "Timeout for dialing a peer (0 for libp2p default)")
+	keepAlive   = flag.Duration("keepAlive", 0, "TCP keepalive period for peer connections (0 for default)")
+	security    = flag.String("security", "both", "Security protocol of the peer connections (noise, tls or both)")
+	keyType     = flag.String("keyType", "ed25519", "Type of the key identifying the client (ed25519, secp256k1 or rsa)")
+	keyBits     = flag.Int("keyBits", 0, "Size of RSA keys (0 for 2048)")
+	dscp        = flag.Int("dscp", 0, "DSCP value (0-63) marking the peer connections, Linux and macOS only (0 for none)")
+	allowAddrs  = flag.String("allowAddrs", "", "Comma separated CIDR ranges which are the only ones dialed")
+	denyAddrs   = flag.String("denyAddrs", "", "Comma separated CIDR ranges never dialed")
+	allowPeers  = flag.String("allowPeers", "", "Comma separated peer IDs which are the only ones connected")
+	denyPrivate = flag.Bool("denyPrivate", false, "Don't dial private, link-local or loopback addresses")
+	denyRelay   = flag.Bool("denyRelay", false, "Don't dial relay circuit addresses")
+	bootDials   = flag.Int("bootstrapConcurrency", 0, "Maximum leaders dialed at the same time (0 for no limit)")
+	transports  = flag.String("transports", "", "Comma separated transports of the leader addresses dialed, among tcp, ws, wss, quic and relay (empty for all)")
+	dstTemplate = flag.String("dstTemplate", "", "Name of the completed file in the destination directory, e.g. {filename}-{hash8}.bin")
+	mirrors     = flag.String("mirrors", "", "Comma separated extra paths to also write the file to")
+	mirrorPol   = flag.String("mirrorPolicy", "abort", "What to do when writing to a mirror fails (abort or continue)")
+	maxRetries  = flag.Int("maxRetries", 0, "Total retries allowed across the download (0 for no limit)")
+	retryTime   = flag.Duration("maxRetryTime", 0, "Time after the start of the download past which nothing is retried (0 for no limit)")
+	getRetries  = flag.Int("getRetries", 0, "Times to retry looking for the content when no peer serves it (0 for 2, -1 for none)")
+	minRate     = flag.Int64("minThroughput", 0, "Stop downloads receiving less bytes per second than this for too long (0 for no limit)")
+	rateWindow  = flag.Duration("throughputWindow", 30*time.Second, "Period the throughput checked against '-minThroughput' is averaged over")
+	rateGrace   = flag.Duration("throughputGrace", 2*time.Minute, "Time the throughput may stay below '-minThroughput' before stopping")
+	maxCost     = flag.Float64("maxCost", 0, "Refuse or stop downloads costing more than this, in the unit of the rate (0 for no limit)")
+	maxRequests = flag.Int("maxRequests", 0, "Maximum concurrent block requests (0 for no limit)")
+	maxMemory   = flag.Int64("maxMemory", 0, "Bytes of content fetched ahead of what is written to disk (0 for no limit)")
+	streamOrder = flag.Bool("streamOrder", false, "Fetch files from the beginning in order, to play media while downloading")
+	segments    = flag.Int("segments", 0, "Fetch a single file in this many ranges at the same time, experimental (0 for sequential)")
+	sparse      = flag.Bool("sparse", false, "Write the blocks of a file at their position as they arrive instead of in order")
+	dirWorkers  = flag.Int("dirConcurrency", 4, "Number of files of a directory downloaded at the same time")
+	dirContinue = flag.Bool("dirContinue", false, "Keep downloading the other files of a directory when one fails")
+	daemonAddr  = flag.String("daemon", "", "Run as a download service listening on this address, e.g. 127.0.0.1:7070")
+	daemonJobs  = flag.Int("jobs", 2, "Number of downloads run at the same time in daemon mode")
+	daemonDir   = flag.String("daemonDir", ".", "Directory the downloads of the daemon mode are written under")
+	daemonKeep  = flag.Int("keepJobs", defaultKeptJobs, "Number of finished jobs whose outcome is kept in daemon mode")
+	hash        = flag.String("hash", "", "CID to download from the '-leader' peers without the metadata service (testing only)")
+	swarmKey    = flag.String("swarmkey", "", "swarm.key file of the swarm of the '-leader' peers (testing only)")
+	cacheDir    = flag.String("cacheDir", "", "Directory keeping the downloaded blocks on disk across runs")
+	cacheInfo   = flag.Bool("cacheInfo", false, "Show the size and ages of the blocks in '-cacheDir' without downloading")
+	cacheClear  = flag.Bool("cacheClear", false, "Remove blocks from '-cacheDir' without downloading")
+	clearCIDs   = flag.String("cacheClearCIDs", "", "Comma separated CIDs whose blocks are the only ones removed by '-cacheClear'")
+	clearOlder  = flag.Duration("cacheClearOlderThan", 0, "Only remove blocks written longer ago with '-cacheClear'")
+	clockSkew   = flag.Duration("maxClockSkew", 5*time.Minute, "Difference with the clock of the metadata service past which the system clock is reported incorrect")
+	rejectSkew  = flag.Bool("rejectClockSkew", false, "Fail instead of warning when the system clock appears incorrect")
+	externalIP  = flag.String("externalIP", "", "Public IP to report to the metadata service instead of asking public IP echo services")
+	endpoint    = flag.String("endpoint", "", "Address of the metadata service, or comma separated addresses tried in order (empty for the built-in one)")
+	listen      = flag.String("listen", "", "Comma separated multiaddrs to listen on (empty for TCP port 45000 and websockets on 45001)")
+	announce    = flag.String("announce", "", "Comma separated multiaddrs peers can dial back besides the listen addresses, e.g. of a forwarded port")
+	autoNAT     = flag.String("autonat", "auto", "How reachability is learned (auto, public, private or service)")
+	minPeers    = flag.Int("minPeers", 0, "Number of peers to keep trying to connect to during the download (0 for 5)")
 	help        = flag.Bool("help", false, "Show command usage")
+
+	leaders stringList
 )
 
+func init() {
+	flag.Var(&leaders, "leader", "Multiaddr of a leader to download '-hash' from, with its peer ID (repeatable, testing only)")
+}
+
+// stringList is a flag which can be repeated.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// setEnvDefaults sets the flags given in the environment, before the
+// command line is parsed so that the flags passed override them.
+func setEnvDefaults() error {
+	env, err := lib.ReadEnv(lib.EnvPrefix)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{
+		"endpoint": env.Endpoint,
+		"timeout":  env.Timeout,
+		"listen":   strings.Join(env.ListenAddrs, ","),
+		"cacheDir": env.CacheDir,
+	}
+	if env.MinPeers > 0 {
+		values["minPeers"] = strconv.Itoa(env.MinPeers)
+	}
+	for name, v := range values {
+		if len(v) > 0 {
+			flag.Set(name, v)
+		}
+	}
+	return nil
+}
+
 func returnError(err string, printUsage bool) {
 	fmt.Println("ERR: " + err)
 	if printUsage {
 		usage()
+		os.Exit(exitInvalidInput)
 	}
-	os.Exit(1)
+	os.Exit(exitFailure)
 }
 
 func usage() {
@@ -49,30 +185,228 @@ as the light-client binary itself.
 
     > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
 
+The full link copied from the browser can be used in place of the sharable.
+
+    > ./swrm-client -sharable https://hive.streamspace.io/d/fzhnp4jhFnMUKVGMKpt4kBMrvX
+
 To save the binary in a custom location with a custom name, you need to provide 
 the path along with the filename in '-dst' flag.  By default the file will be 
 saved where the binary is with the default filename. 
 
     > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
 
-To only see the link information you add the '-info' flag.
+To only see the link information you add the '-info' flag. It includes an estimate 
+of the cost of the download when the file size is known. The lookup gives up 
+after 30s, which can be changed with '-infoTimeout'.
+
+    > ./swrm-client -info -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -infoTimeout 10s
+
+Add '-deep' to also check whether the file can be downloaded now: the leaders are 
+connected and the first block of the file is fetched, which takes longer.
+
+    > ./swrm-client -info -deep -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -infoTimeout 2m
 
-    > ./swrm-client -info -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
+To gate a download on these checks, '-preflight' fails with the exit code of the 
+check which failed: swarm key refused, no leader connected, content not available 
+or cost over '-maxCost'. Nothing is downloaded nor billed.
+
+    > ./swrm-client -preflight -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -maxCost 0.5
+
+Unattended downloads can be given a budget with '-maxCost'. Downloads estimated to 
+cost more are refused and downloads whose micropayments go over it are stopped.
+So are downloads whose cost can't be told.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -maxCost 0.5
+
+They can also give up on swarms too slow to finish in reasonable time with 
+'-minThroughput', in bytes per second, instead of waiting for the timeout.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -minThroughput 100000 -throughputGrace 5m
+
+To check that the content can be downloaded and matches its hash without keeping 
+it, add the '-verify' flag. Nothing is written to disk.
+
+    > ./swrm-client -verify -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
+
+A file downloaded earlier can be checked against the sharable with '-verifyFile'. 
+Only the metadata is fetched, the hash of the file is computed locally.
+
+    > ./swrm-client -verifyFile $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
+
+To check the downloaded file itself, add '-verifyContent'. The download is only 
+reported complete, and billed, when the check passes.
+
+    > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -verifyContent
+
+With '-durable' the downloaded file is synced to disk before the download is 
+reported complete, so that it survives a power loss right after. Waiting for the 
+disk makes downloads slower, especially directories of many files.
+
+    > ./swrm-client -dst /archive/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -durable
+
+To find out why a download failed, '-netStatsOnFailure' adds the peers, connections 
+and streams by protocol of the node, and its memory use, to the failed result.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -json -netStatsOnFailure
+
+To be told when a download ends, '-webhook' POSTs its result and stats as JSON to 
+a URL, whether it succeeded or not. With '-webhookSecret' the body is signed with 
+HMAC-SHA256 in the X-Signature-256 header.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -webhook https://example.com/done -webhookSecret s3cret
+
+The completed file can be named after its metadata with '-dstTemplate'. {filename}, 
+{hash}, {hash8} and {date} are replaced, the result is relative to the destination 
+directory.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -dstTemplate '{date}/{filename}-{hash8}'
 
 By default light-client returns normal text as output. If you need a json output 
 add '-json' flag with your command.
 
     > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -json
+
+The json output is {"version", "status", "message", "details", "data"}. Scripts 
+can pin the version they expect with '-jsonVersion'. Add '-quiet' to only get the 
+final result, and the failed steps if any.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -json -quiet
   
+To write the file to more locations in one pass add them with the '-mirrors' flag. 
+By default the download fails if writing to one of them fails, use 
+'-mirrorPolicy continue' to go on with the remaining ones.
+
+    > ./swrm-client -dst $HOME/greeter.txt -mirrors /mnt/share/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
+
+When the sharable points to a directory it is downloaded to '-dst' with its files 
+fetched in parallel. Use '-dirConcurrency' to change how many are fetched at the 
+same time and '-dirContinue' to keep going when one of them fails.
+
+    > ./swrm-client -dst $HOME/photos -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -dirConcurrency 8
+
+A single large file can be fetched in several ranges at the same time with the 
+experimental '-segments' flag, which may be faster on swarms with many peers. It 
+is ignored along with '-mirrors' or '-state'.
+
+    > ./swrm-client -dst $HOME/movie.mp4 -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -segments 4
+
+When peers serve the blocks out of order, '-sparse' writes each block at its 
+position in the file as soon as it arrives, so that a late block doesn't hold 
+back the others. The blocks written are kept in the '-state' file to resume.
+
+    > ./swrm-client -dst $HOME/movie.mp4 -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -sparse
+
+On devices with little memory, bound the content fetched ahead of what is written 
+to disk with '-maxMemory', in bytes. Keep the blocks on disk with '-cacheDir' for 
+files larger than the memory.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -maxMemory 4194304 -cacheDir /data/swrm-cache
+
+To play media while it downloads use '-streamOrder'. The file is fetched from the 
+beginning in order, so a player can read the part file as it grows. '-stat' shows 
+the time to the first byte.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -streamOrder -stat
+
 To see the download progress use '-progress' flag.
 
     > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -progress
 
+When the output goes to a log, add '-progressEvery 10' to only show it every 10%.
+
+A supervising process can follow the download on a Unix socket it listens on with 
+'-progressSocket'. The steps, progress and result are written to it as JSON lines, 
+in the same envelope as the '-json' output.
+
+    > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -progressSocket /tmp/swrm.sock
+
 To see the logs of the command use '-logToStderr' flag. Note : '-logToStderr' and 
 '-progress' flags cannot be used together.
 
     > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -logToStderr
+
+'-logToStderr' shows the info logs of the client only. Use '-loglevel' to choose 
+the level (error, warn, info or debug) instead.
+
+    > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -loglevel debug
  
+For testing, the metadata service can be bypassed with '-metadata' and a JSON file 
+describing the download. The service is not told about the download either.
+
+    {"hash": "Qm...", "leaders": ["/ip4/1.2.3.4/tcp/4001/p2p/Qm..."], 
+     "swarm_key": "/key/swarm/psk/1.0.0/\n/base16/\n...", "rate": "", "filename": "file"}
+
+    > ./swrm-client -dst $HOME/greeter.txt -metadata byo.json
+
+The same can be given with flags, '-leader' being repeated for each leader.
+
+    > ./swrm-client -dst $HOME/greeter.txt -hash Qm... -swarmkey swarm.key \
+        -leader /ip4/1.2.3.4/tcp/4001/p2p/Qm... -leader /ip4/5.6.7.8/tcp/4001/p2p/Qm...
+
+To keep a record of the blocks the file is made of and of the peers which served 
+them, write a manifest with '-manifest'.
+
+    > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -manifest greeter.json
+
+To report an issue, record what happened during the download with '-trace'. Each 
+line is a JSON event: metadata requests, bootstrap attempts, peer connections, 
+blocks and the result. The session cookie and swarm key are left out.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -trace trace.jsonl
+
+With '-output' the content can be written to '-dst' as a CAR archive of its blocks, 
+a tar archive or its raw bytes, or fetched without writing anything with 'discard'.
+
+    > ./swrm-client -dst $HOME/greeter.car -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -output car
+
+To check the file later without the service, write its CID and SHA-256 next to it.
+
+    > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -sidecar -sidecarSHA256
+    > cd $HOME && sha256sum -c greeter.txt.sha256
+
+To keep the downloaded blocks across runs use '-cacheDir'. The cache can be 
+inspected with '-cacheInfo' and trimmed with '-cacheClear', optionally only for 
+some CIDs with '-cacheClearCIDs' or for old blocks with '-cacheClearOlderThan'. 
+Nothing is downloaded then.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -cacheDir $HOME/.swrm-cache
+    > ./swrm-client -cacheDir $HOME/.swrm-cache -cacheInfo
+    > ./swrm-client -cacheDir $HOME/.swrm-cache -cacheClear -cacheClearOlderThan 168h
+
+To run the client as a local download service use '-daemon' with the address to 
+listen on. Downloads are queued with 'POST /download {"sharable": "...", 
+"destination": "..."}', which returns the job id, and followed with 
+'GET /jobs/<id>'. 'GET /healthz' tells whether the service is up. At most '-jobs' 
+downloads run at the same time. Destinations are relative to '-daemonDir' and 
+can't leave it. The outcome of the last '-keepJobs' finished jobs is kept. Jobs 
+end "done", "failed" or, when some files of a directory failed with 
+'-dirContinue', "partial" with the failed files in the result.
+
+    > ./swrm-client -daemon 127.0.0.1:7070 -jobs 4 -daemonDir $HOME/Downloads
+    > curl -d '{"sharable": "fzhnp4jhFnMUKVGMKpt4kBMrvX"}' http://127.0.0.1:7070/download
+
+In containers the endpoint, timeout, listen addresses, cache directory and minimum 
+peers can be given with the SSLIGHT_ENDPOINT, SSLIGHT_TIMEOUT, SSLIGHT_LISTEN_ADDRS, 
+SSLIGHT_CACHE_DIR and SSLIGHT_MIN_PEERS environment variables. Flags override them.
+
+    > SSLIGHT_TIMEOUT=30m SSLIGHT_CACHE_DIR=/cache ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX
+
+Several metadata services can be given to '-endpoint', separated by commas. When one 
+is unreachable or fails the next one is tried.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -endpoint https://a.example.com,https://b.example.com
+
+The public IP of the client is found by asking public IP echo services. Where 
+firewalls block them, give it with '-externalIP'.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -externalIP 203.0.113.7
+
+Sessions and micropayments depend on the system clock. When it differs from the 
+clock of the metadata service by more than '-maxClockSkew' a warning is shown, 
+add '-rejectClockSkew' to fail instead. '-maxClockSkew 0' disables the check.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -rejectClockSkew
+
 To see the connected peers and ledger for the last download use '-stat' flag.
 
     > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -stat
@@ -82,6 +416,86 @@ timeout for the download to finish. default is 15m.
  	
     > ./swrm-client -dst $HOME/greeter.txt -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -timeout 5m
 
+Content published under an IPNS name or a DNSLink domain can be downloaded with 
+the '-name' flag instead of '-sharable'.
+
+    > ./swrm-client -name /ipns/example.com
+
+To debug connectivity issues use the '-whoami' flag, which shows the peer ID and 
+the addresses the client listens on.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -whoami
+
+Interrupted downloads can be resumed by saving their state with the '-state' flag. 
+Running the same command again continues where the previous run stopped.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -state $HOME/.swrm-state
+
+Without '-state' the part file of a failed download is deleted. Use '-keepPartial' 
+to leave it in place, or '-partialDir' to move it to a directory for inspection.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -partialDir /var/tmp/swrm-failed
+
+When downloading repeatedly from the same swarm, remember the peers to connect 
+to them first next time.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -peerCache $HOME/.swrm-peers
+
+On unreliable (e.g. cellular) networks shorter dial timeouts and keepalives help 
+to give up on unreachable peers sooner.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -dialTimeout 10s -keepAlive 10s
+
+On managed networks, mark the traffic with a DSCP value so that routers can 
+prioritize it, e.g. 8 (CS1) for bulk downloads. Supported on Linux and macOS.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -dscp 8
+
+To only use one security protocol, e.g. for compliance, use '-security noise' or 
+'-security tls'. Leaders which don't support it can't be connected.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -security tls
+
+On devices with little memory, limit how many leaders are dialed at the same time.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -bootstrapConcurrency 4
+
+By default the DHT runs in client mode. Use '-dht off' to only fetch from the 
+leaders provided by the service, or '-dht server' if the machine is publicly reachable.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -dht off
+
+When a port is forwarded to the machine, announce its public address so that peers 
+and relays can dial back, and skip probing the reachability with '-autonat public'. 
+Behind a NAT which drops inbound connections, use '-autonat private'. Forcing the 
+reachability is the only way to stop AutoNAT from probing, it can't be turned off. 
+Neither changes the IP reported to the metadata service, see '-externalIP'.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -listen /ip4/0.0.0.0/tcp/45000 -announce /ip4/203.0.113.7/tcp/45000 -autonat public
+
+The addresses dialed can be restricted, e.g. to stay off private networks and relays 
+or to only use some ranges.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -denyPrivate -denyRelay
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -allowAddrs 203.0.113.0/24
+
+To only use vetted peers, list them with '-allowPeers'. Other peers are neither 
+dialed nor accepted, leaders included.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -allowPeers QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+
+When some transport is blocked, e.g. UDP, '-transports' only dials the leader 
+addresses of the listed ones, leaders without any being dialed on all theirs. 
+'-stat' tells the transport each leader was connected over.
+
+    > ./swrm-client -sharable fzhnp4jhFnMUKVGMKpt4kBMrvX -transports tcp,ws -stat
+
+The exit code tells the outcome of the command: 0 success, 1 other failure, 
+2 invalid input, 3 metadata service error or expired sharable, 4 no peer connected 
+or swarm key refused, 5 timeout, 6 failed writing to the destination, 7 some files 
+of a directory failed, 8 cost limit exceeded, 9 throughput too low, 10 system clock 
+incorrect.
+
 To see usage
 
     > ./swrm-client -help
@@ -119,25 +533,231 @@ func CallClear() {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes the command and returns the exit code of the process.
+func run() int {
+	if err := setEnvDefaults(); err != nil {
+		returnError(err.Error(), true)
+	}
 	flag.Parse()
 
 	if *help {
 		usage()
-		return
+		return exitSuccess
 	}
 
-	if *enableLog && *showProg {
+	if *enableLog && len(*logLevel) == 0 {
+		*logLevel = "info"
+	}
+	if *quiet && *showProg {
+		returnError("Quiet and progress options cannot be used together", true)
+	}
+	if len(*logLevel) > 0 && *showProg {
 		returnError("Log and progress options cannot be used together", true)
-	} else if *enableLog {
-		logger.SetLogLevel("*", "debug")
+	} else if len(*logLevel) > 0 {
+		switch *logLevel {
+		case "error", "warn", "info", "debug":
+		default:
+			returnError("Invalid log level "+*logLevel, true)
+		}
+		logger.SetLogLevel("ss_light", *logLevel)
+		logger.SetLogLevel("litepeer", *logLevel)
+	}
+	if err := lib.SetOutVersion(*jsonVersion); err != nil {
+		returnError(err.Error(), false)
 	}
-	if len(*sharable) == 0 {
+	if *cacheInfo || *cacheClear {
+		return runCache()
+	}
+	if len(*sharable) == 0 && len(*name) == 0 && len(*daemonAddr) == 0 && len(*byoFile) == 0 && len(*hash) == 0 {
 		returnError("Sharable string not provided", true)
 	}
-	lc, err := lib.NewLightClient(*destination, *timeout, *jsonOut)
+	cfg := &lib.Config{
+		DHTMode:               *dhtMode,
+		MaxConcurrentRequests: *maxRequests,
+		MaxMemory:             *maxMemory,
+		StreamOrder:           *streamOrder,
+		GetFileRetries:        *getRetries,
+		ShowIdentity:          *whoami,
+		Quiet:                 *quiet,
+		ProgressEvery:         *progEvery,
+		StateFile:             *stateFile,
+		KeepPartialOnError:    *keepPartial,
+		PartialRetentionDir:   *partialDir,
+		PeerCacheFile:         *peerCache,
+		PaymentsFile:          *payments,
+		ManifestFile:          *manifest,
+		TraceFile:             *traceFile,
+		WriteSidecar:          *sidecar,
+		SidecarSHA256:         *sidecarSum,
+		SettleWait:            *settleWait,
+		FlushPayments:         *flushPay,
+		DialTimeout:           *dialTimeout,
+		KeepAlive:             *keepAlive,
+		DSCP:                  *dscp,
+		KeyType:               *keyType,
+		SecurityTransport:     *security,
+		AutoNAT:               *autoNAT,
+		KeyBits:               *keyBits,
+		BootstrapConcurrency:  *bootDials,
+		MaxCost:               *maxCost,
+		MinThroughput:         *minRate,
+		ThroughputWindow:      *rateWindow,
+		ThroughputGrace:       *rateGrace,
+		DenyPrivateAddrs:      *denyPrivate,
+		DenyRelayAddrs:        *denyRelay,
+		MirrorPolicy:          *mirrorPol,
+		DestinationTemplate:   *dstTemplate,
+		DirConcurrency:        *dirWorkers,
+		DirContinueOnError:    *dirContinue,
+		CacheDir:              *cacheDir,
+		ParallelSegments:      *segments,
+		SparseAssembly:        *sparse,
+		VerifyContent:         *verifyDst,
+		Durable:               *durable,
+		NetworkStatsOnFailure: *netStats,
+		CompletionWebhook:     *webhook,
+		WebhookSecret:         *webhookKey,
+		Endpoint:              *endpoint,
+		MaxClockSkew:          *clockSkew,
+		RejectClockSkew:       *rejectSkew,
+		MinPeers:              *minPeers,
+	}
+	mode, err := strconv.ParseUint(*fileMode, 8, 32)
+	if err != nil {
+		returnError("Invalid file mode "+*fileMode, true)
+	}
+	cfg.FileMode = os.FileMode(mode)
+	if *maxRetries > 0 || *retryTime > 0 {
+		cfg.RetryBudget = &lib.RetryBudget{MaxRetries: *maxRetries, MaxTime: *retryTime}
+	}
+	if *settleWait == 0 {
+		cfg.SettleWait = -1
+	}
+	if *clockSkew == 0 {
+		cfg.MaxClockSkew = -1
+	}
+	if len(*byoFile) > 0 {
+		buf, err := ioutil.ReadFile(*byoFile)
+		if err != nil {
+			returnError("Failed reading metadata reason:"+err.Error(), false)
+		}
+		cfg.BYOMetadata = &lib.Metadata{}
+		err = json.Unmarshal(buf, cfg.BYOMetadata)
+		if err != nil {
+			returnError("Invalid metadata reason:"+err.Error(), false)
+		}
+	}
+	if len(*hash) > 0 || len(*swarmKey) > 0 || len(leaders) > 0 {
+		switch {
+		case len(*byoFile) > 0:
+			returnError("Metadata file and '-hash' options cannot be used together", true)
+		case len(*hash) == 0:
+			returnError("Hash not provided", true)
+		case len(*swarmKey) == 0:
+			returnError("Swarm key not provided", true)
+		case len(leaders) == 0:
+			returnError("No leader provided", true)
+		}
+		key, err := ioutil.ReadFile(*swarmKey)
+		if err != nil {
+			returnError("Failed reading swarm key reason:"+err.Error(), false)
+		}
+		cfg.BYOMetadata = &lib.Metadata{
+			Hash:     *hash,
+			Leaders:  leaders,
+			SwarmKey: string(key),
+		}
+	}
+	if len(*externalIP) > 0 {
+		ip := *externalIP
+		cfg.ExternalIPFunc = func(context.Context) (string, error) {
+			return ip, nil
+		}
+	}
+	if endpoints := strings.Split(*endpoint, ","); len(endpoints) > 1 {
+		cfg.Endpoint, cfg.Endpoints = endpoints[0], endpoints[1:]
+	}
+	if len(*listen) > 0 {
+		cfg.ListenAddrs = strings.Split(*listen, ",")
+	}
+	if len(*announce) > 0 {
+		cfg.ExternalAddrs = strings.Split(*announce, ",")
+	}
+	if len(*mirrors) > 0 {
+		cfg.Mirrors = strings.Split(*mirrors, ",")
+	}
+	if len(*swarms) > 0 {
+		cfg.AllowedSwarmFingerprints = strings.Split(*swarms, ",")
+	}
+	if len(*allowAddrs) > 0 {
+		cfg.AllowAddrs = strings.Split(*allowAddrs, ",")
+	}
+	if len(*denyAddrs) > 0 {
+		cfg.DenyAddrs = strings.Split(*denyAddrs, ",")
+	}
+	if len(*transports) > 0 {
+		cfg.PreferredTransports = strings.Split(*transports, ",")
+	}
+	if len(*allowPeers) > 0 {
+		allowed := map[peer.ID]bool{}
+		for _, s := range strings.Split(*allowPeers, ",") {
+			id, err := peer.Decode(s)
+			if err != nil {
+				returnError("Invalid peer ID "+s, true)
+			}
+			allowed[id] = true
+		}
+		cfg.AllowPeer = func(id peer.ID, _ multiaddr.Multiaddr) bool {
+			return allowed[id]
+		}
+	}
+	if len(*daemonAddr) > 0 {
+		d := newDaemon(*cfg, *timeout, *daemonJobs, *daemonDir, *daemonKeep)
+		fmt.Println("Listening on " + *daemonAddr)
+		err := http.ListenAndServe(*daemonAddr, d.routes())
+		returnError("Daemon stopped reason:"+err.Error(), false)
+	}
+	lc, err := lib.NewLightClient(*destination, *timeout, *jsonOut, cfg)
 	if err != nil {
 		returnError("Failed setting up client reason:"+err.Error(), true)
 	}
+	if *onlyInfo || *preflight {
+		ctx, cancel := context.WithTimeout(context.Background(), *infoTimeout)
+		defer cancel()
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			select {
+			case <-sig:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		var out *lib.Out
+		switch {
+		case *preflight:
+			out = lc.PreflightCheck(ctx, *sharable)
+		case *deepInfo:
+			out = lc.DeepInfo(ctx, *sharable)
+		default:
+			out = lc.Info(ctx, *sharable)
+		}
+		lib.OutMessage(out, *jsonOut)
+		return exitCode(out.Status)
+	}
+	if *verify {
+		out := lc.Verify(context.Background(), *sharable)
+		lib.OutMessage(out, *jsonOut)
+		return exitCode(out.Status)
+	}
+	if len(*verifyFile) > 0 {
+		out := lc.VerifyFile(context.Background(), *sharable, *verifyFile)
+		lib.OutMessage(out, *jsonOut)
+		return exitCode(out.Status)
+	}
 	var upd lib.ProgressUpdater
 	upd = &noopProgress{}
 	if !*onlyInfo && *showProg {
@@ -145,9 +765,68 @@ func main() {
 			jsonOut: *jsonOut,
 		}
 	}
-	out := lc.Start(*sharable, *onlyInfo, *stat, upd)
+	var out *lib.Out
+	if len(*progSocket) > 0 {
+		events, err := dialEvents(*progSocket, upd)
+		if err != nil {
+			returnError("Failed connecting to progress socket reason:"+err.Error(), false)
+		}
+		defer events.Close()
+		cfg.StepHook = events.step
+		cfg.BootstrapHook = events.bootstrap
+		upd = events
+		defer func() { events.send(out) }()
+	}
+	switch {
+	case lib.OutputMode(*outputMode) != lib.OutputFile:
+		out = startOutput(lc, lib.OutputMode(*outputMode), upd)
+	case len(*name) > 0:
+		out = lc.StartName(context.Background(), *name, *stat, upd)
+	default:
+		out = lc.Start(*sharable, *onlyInfo, *stat, upd)
+	}
 	lib.OutMessage(out, *jsonOut)
-	return
+	return exitCode(out.Status)
+}
+
+// startOutput writes the content to the destination in mode, streamed
+// instead of downloaded to a part file.
+func startOutput(lc *lib.LightClient, mode lib.OutputMode, upd lib.ProgressUpdater) *lib.Out {
+	var w io.Writer
+	if mode != lib.OutputDiscard {
+		f, err := os.Create(*destination)
+		if err != nil {
+			return lib.NewOut(404, "Destination not usable", err.Error(), nil)
+		}
+		defer f.Close()
+		w = f
+	}
+	return lc.StartOutput(context.Background(), *sharable, mode, w, *stat, upd)
+}
+
+// runCache shows or clears the blocks of the cache directory.
+func runCache() int {
+	if len(*cacheDir) == 0 {
+		returnError("Cache directory not provided", true)
+	}
+	if *cacheInfo {
+		info, err := lib.ReadCacheInfo(*cacheDir)
+		if err != nil {
+			returnError("Failed reading cache reason:"+err.Error(), false)
+		}
+		lib.OutMessage(lib.NewOut(200, "Cache", "", info), *jsonOut)
+		return exitSuccess
+	}
+	opts := lib.ClearCacheOptions{OlderThan: *clearOlder}
+	if len(*clearCIDs) > 0 {
+		opts.CIDs = strings.Split(*clearCIDs, ",")
+	}
+	removed, err := lib.ClearCache(context.Background(), *cacheDir, opts)
+	if err != nil {
+		returnError("Failed clearing cache reason:"+err.Error(), false)
+	}
+	lib.OutMessage(lib.NewOut(200, "Removed", "", removed), *jsonOut)
+	return exitSuccess
 }
 
 type noopProgress struct{}
@@ -163,9 +842,12 @@ type updateProgress struct {
 
 func (u *updateProgress) UpdateProgress(p lib.ProgressOut) {
 	var out *lib.Out
-	if u.jsonOut {
+	switch {
+	case u.jsonOut:
 		out = lib.NewOut(200, "Progress", "", p)
-	} else {
+	case p.SizeUnknown:
+		out = lib.NewOut(200, "Progress", "", p.Downloaded)
+	default:
 		out = lib.NewOut(200, "Progress", "", fmt.Sprintf("%d%% (%s / %s)", p.Percentage, p.Downloaded, p.TotalSize))
 	}
 	lib.OutMessage(out, u.jsonOut)
@@ -20,7 +20,9 @@ import (
 	"github.com/ipfs/go-merkledag"
 	ufsio "github.com/ipfs/go-unixfs/io"
 	host "github.com/libp2p/go-libp2p-core/host"
+	network "github.com/libp2p/go-libp2p-core/network"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	pnet "github.com/libp2p/go-libp2p-core/pnet"
 	routing "github.com/libp2p/go-libp2p-core/routing"
 )
 
@@ -42,6 +44,50 @@ type Config struct {
 	EnableLogs bool
 	Mtdt       map[string]interface{}
 	Rate       string
+
+	// BlockNotifier, when set, is notified of every block received from
+	// the network and the peer which sent it.
+	BlockNotifier BlockNotifier
+
+	// MaxConcurrentRequests bounds the number of blocks requested at the
+	// same time by GetFile. Zero leaves it to bitswap, which is the best
+	// choice on fast links. On lossy or slow links (e.g. cellular) a low
+	// value such as 8 avoids saturating the uplink with wants.
+	MaxConcurrentRequests int
+
+	// DialTimeout bounds the time spent dialing each peer in Bootstrap.
+	// Zero keeps the libp2p default of 60s.
+	DialTimeout time.Duration
+
+	// SwarmKey is the key of the private network the host was set up
+	// with. When set, a peer whose dial times out in Bootstrap is probed
+	// to tell a wrong swarm key apart from a slow peer.
+	SwarmKey pnet.PSK
+
+	// BootstrapConcurrency bounds the number of peers dialed at the same
+	// time by Bootstrap. Zero dials all of them at once.
+	BootstrapConcurrency int
+
+	// BlockSource, when set, is asked for every block needed by GetFile
+	// before the swarm. Only the blocks it doesn't have are fetched from
+	// the network, see BlockSourceStats.
+	BlockSource BlockSource
+
+	// MaxMemory bounds the file data fetched by the readers of GetFile
+	// ahead of what they have read, in bytes. Blocks are only requested
+	// while there is room, so a slow writer holds back the fetching
+	// instead of piling up blocks. Each reader may still go one block
+	// over, and the buffers of bitswap and of the connections come on
+	// top. A low value leaves fewer blocks in flight, which lowers the
+	// throughput on high latency links. Zero doesn't bound it.
+	MaxMemory int64
+
+	// StreamOrder requests the blocks of the files read with GetFile in
+	// the order of the file, so that the beginning and then the next bytes
+	// to read arrive first, e.g. to start playing media before the rest
+	// is fetched. Bitswap otherwise wants the blocks fetched ahead in any
+	// order. The blocks are always ordered when MaxMemory is set.
+	StreamOrder bool
 }
 
 // Peer is an IPFS-Lite peer. It provides a DAG service that can fetch and put
@@ -58,12 +104,16 @@ type Peer struct {
 	ipld.DAGService // become a DAG service
 	bstore          blockstore.Blockstore
 	bserv           blockservice.BlockService
+	srcStats        *sourceStats
+	memBudget       *memoryBudget
 }
 
 // New creates an IPFS-Lite Peer. It uses the given datastore, libp2p Host and
 // Routing (usuall the DHT). The Host and the Routing may be nil if
-// config.Offline is set to true, as they are not used in that case. Peer
-// implements the ipld.DAGService interface.
+// config.Offline is set to true, as they are not used in that case. A nil
+// Routing on an online Peer disables content routing, so blocks are only
+// fetched from directly connected peers. Peer implements the
+// ipld.DAGService interface.
 func New(
 	ctx context.Context,
 	store datastore.Batching,
@@ -78,12 +128,20 @@ func New(
 	if cfg.EnableLogs {
 		logging.SetLogLevel("*", "Debug")
 	}
+	if dht == nil {
+		dht = nullRouting{}
+	}
 	p := &Peer{
 		ctx:   ctx,
 		cfg:   cfg,
 		Host:  host,
 		Dht:   dht,
 		store: store,
+		// Allocated separately for the alignment of the atomic counters
+		srcStats: &sourceStats{},
+	}
+	if cfg.MaxMemory > 0 {
+		p.memBudget = newMemoryBudget(cfg.MaxMemory)
 	}
 
 	err := p.setupBlockstore()
@@ -128,7 +186,11 @@ func (p *Peer) setupBlockService() error {
 		return err
 	}
 	p.Scp = scpModule
-	bswap := bitswap.New(p.ctx, scpModule, p.bstore, bitswap.ProvideEnabled(false))
+	bsOpts := []bitswap.Option{bitswap.ProvideEnabled(false)}
+	if p.cfg.BlockNotifier != nil {
+		bsOpts = append(bsOpts, bitswap.EnableWireTap(&notifierTap{p.cfg.BlockNotifier}))
+	}
+	bswap := bitswap.New(p.ctx, scpModule, p.bstore, bsOpts...)
 	p.bserv = blockservice.New(p.bstore, bswap)
 	return nil
 }
@@ -149,43 +211,87 @@ func (p *Peer) autoclose() {
 // could be contacted. It is fine to pass a list where some peers will not be
 // reachable.
 func (p *Peer) Bootstrap(peers []peer.AddrInfo) int {
-	connected := make(chan struct{})
+	n, _ := p.BootstrapWithError(peers)
+	return n
+}
+
+// BootstrapWithError is like Bootstrap but also returns ErrSwarmKeyMismatch
+// when no peer could be connected because all of them failed the security
+// handshake, which tells a wrong swarm key apart from unreachable peers.
+func (p *Peer) BootstrapWithError(peers []peer.AddrInfo) (int, error) {
+	results, err := p.BootstrapReport(peers)
+	if err != nil {
+		return 0, err
+	}
+	return Connected(results), nil
+}
+
+// BootstrapReport is like BootstrapWithError but returns the outcome of
+// dialing each of the peers, in the same order.
+func (p *Peer) BootstrapReport(peers []peer.AddrInfo) ([]DialResult, error) {
+	ctx := p.ctx
+	if p.cfg.DialTimeout > 0 {
+		ctx = network.WithDialPeerTimeout(ctx, p.cfg.DialTimeout)
+	}
+
+	var slots chan struct{}
+	if p.cfg.BootstrapConcurrency > 0 {
+		slots = make(chan struct{}, p.cfg.BootstrapConcurrency)
+	}
 
 	var wg sync.WaitGroup
-	for _, pinfo := range peers {
+	results := make([]DialResult, len(peers))
+	for i, pinfo := range peers {
 		//h.Peerstore().AddAddrs(pinfo.ID, pinfo.Addrs, peerstore.PermanentAddrTTL)
 		wg.Add(1)
-		go func(pinfo peer.AddrInfo) {
+		go func(i int, pinfo peer.AddrInfo) {
 			defer wg.Done()
-			err := p.Host.Connect(p.ctx, pinfo)
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			results[i].ID = pinfo.ID
+			pinfo, err := resolvePeer(ctx, pinfo)
+			if err == nil {
+				err = p.Host.Connect(ctx, pinfo)
+			}
 			if err != nil {
 				logger.Warn(err)
+				results[i].Err = err
+				results[i].Failure = dialFailure(err)
+				if results[i].Failure == FailureTimeout && swarmKeyRejected(p.ctx, pinfo, p.cfg.SwarmKey) {
+					results[i].Failure = FailureSwarmKey
+				}
 				return
 			}
-			logger.Info("Connected to", pinfo.ID)
-			connected <- struct{}{}
-		}(pinfo)
+			if conns := p.Host.Network().ConnsToPeer(pinfo.ID); len(conns) > 0 {
+				results[i].Transport = AddrTransport(conns[0].RemoteMultiaddr())
+			}
+			logger.Infof("Connected to %s over %s", pinfo.ID, results[i].Transport)
+		}(i, pinfo)
 	}
+	wg.Wait()
 
-	go func() {
-		wg.Wait()
-		close(connected)
-	}()
-
-	i := 0
-	for range connected {
-		i++
-	}
+	i := Connected(results)
 	if nPeers := len(peers); i < nPeers/2 {
 		logger.Warnf("only connected to %d bootstrap peers out of %d", i, nPeers)
 	}
+	handshakeFailures := 0
+	for _, r := range results {
+		if r.Failure == FailureSwarmKey {
+			handshakeFailures++
+		}
+	}
+	if i == 0 && handshakeFailures > 0 && handshakeFailures == len(peers) {
+		return results, ErrSwarmKeyMismatch
+	}
 
 	err := p.Dht.Bootstrap(p.ctx)
 	if err != nil {
 		logger.Error(err)
-		return 0
+		return results, err
 	}
-	return i
+	return results, nil
 }
 
 // Session returns a session-based NodeGetter.
@@ -200,9 +306,20 @@ func (p *Peer) Session(ctx context.Context) ipld.NodeGetter {
 // GetFile returns a reader to a file as identified by its root CID. The file
 // must have been added as a UnixFS DAG (default for IPFS).
 func (p *Peer) GetFile(ctx context.Context, c cid.Cid) (ufsio.DagReader, error) {
-	n, err := p.Get(ctx, c)
-	if err != nil {
-		return nil, err
+	var ng ipld.NodeGetter = p
+	if p.cfg.MaxConcurrentRequests > 0 {
+		ng = newLimitedGetter(p, p.cfg.MaxConcurrentRequests)
+	}
+	if p.cfg.BlockSource != nil {
+		ng = &sourceGetter{
+			NodeGetter: ng,
+			source:     p.cfg.BlockSource,
+			dag:        p,
+			stats:      p.srcStats,
+		}
+	}
+	if p.cfg.StreamOrder && p.memBudget == nil {
+		ng = &orderedGetter{NodeGetter: ng}
 	}
-	return ufsio.NewDagReader(ctx, n, p)
+	return newFileReader(ctx, c, ng, p.memBudget)
 }
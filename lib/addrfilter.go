@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// newAddrFilter builds the address filter of cfg. It returns nil when no
+// rule is set.
+func newAddrFilter(cfg *Config) (*ipfslite.AddrFilter, error) {
+	if len(cfg.AllowAddrs) == 0 && len(cfg.DenyAddrs) == 0 && !cfg.DenyPrivateAddrs && !cfg.DenyRelayAddrs && cfg.AllowPeer == nil {
+		return nil, nil
+	}
+	f := &ipfslite.AddrFilter{
+		DenyPrivate: cfg.DenyPrivateAddrs,
+		DenyRelay:   cfg.DenyRelayAddrs,
+		AllowPeer:   cfg.AllowPeer,
+	}
+	var err error
+	f.Allow, err = parseCIDRs(cfg.AllowAddrs)
+	if err != nil {
+		return nil, err
+	}
+	f.Deny, err = parseCIDRs(cfg.DenyAddrs)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseCIDRs(ranges []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, r := range ranges {
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address range %q: %s", r, err.Error())
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// dialable returns peers with only the addresses the client may dial, of
+// the preferred transports when some are.
+func (l *LightClient) dialable(peers []peer.AddrInfo) []peer.AddrInfo {
+	if l.addrFilter != nil {
+		peers = l.addrFilter.FilterPeers(peers)
+	}
+	return ipfslite.PreferTransports(peers, l.cfg.PreferredTransports)
+}
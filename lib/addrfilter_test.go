@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestNewAddrFilter(t *testing.T) {
+	f, err := newAddrFilter(&Config{})
+	if err != nil || f != nil {
+		t.Fatalf("expected no filter got %v %v", f, err)
+	}
+	_, err = newAddrFilter(&Config{DenyAddrs: []string{"10.0.0.1"}})
+	if err == nil {
+		t.Fatal("expected an error for an address without mask")
+	}
+
+	f, err = newAddrFilter(&Config{DenyAddrs: []string{"10.0.0.0/8"}, DenyRelayAddrs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &LightClient{cfg: &Config{}, addrFilter: f}
+	p, _ := peer.Decode("QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	peers := l.dialable([]peer.AddrInfo{{ID: p, Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+	}}})
+	if len(peers) != 1 || len(peers[0].Addrs) != 1 || peers[0].Addrs[0].String() != "/ip4/1.2.3.4/tcp/4001" {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+
+	// Only vetted peers
+	f, err = newAddrFilter(&Config{AllowPeer: func(id peer.ID, _ multiaddr.Multiaddr) bool {
+		return id != p
+	}})
+	if err != nil || f == nil {
+		t.Fatalf("expected a filter got %v %v", f, err)
+	}
+	l.addrFilter = f
+	peers = l.dialable([]peer.AddrInfo{{ID: p, Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+	}}})
+	if len(peers) != 0 {
+		t.Fatalf("expected the peer to be refused got %v", peers)
+	}
+
+	// Preferred transports, along with the address rules
+	l.addrFilter, _ = newAddrFilter(&Config{DenyAddrs: []string{"10.0.0.0/8"}})
+	l.cfg.PreferredTransports = []string{"tcp"}
+	peers = l.dialable([]peer.AddrInfo{{ID: p, Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic"),
+		multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+	}}})
+	if len(peers) != 1 || len(peers[0].Addrs) != 1 || peers[0].Addrs[0].String() != "/ip4/1.2.3.4/tcp/4001" {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"github.com/libp2p/go-libp2p-core/metrics"
+)
+
+// BandwidthReport is the traffic of the node of a download with the peers.
+// Downloaded is what was received and Uploaded what was sent: the
+// requests and micropayments of the client, and the blocks bitswap served
+// to other peers. Upload is expected to be minimal for a client which only
+// downloads, mostly protocol overhead. The counts are as of the last
+// second, the counter being updated every second.
+type BandwidthReport struct {
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	UploadedBytes   int64 `json:"uploaded_bytes"`
+	// ByProtocol breaks the traffic of streams down by protocol, e.g.
+	// bitswap or the SCP payments. Connection setup and the streams still
+	// negotiating their protocol only count in the totals.
+	ByProtocol map[string]ProtocolBandwidth `json:"by_protocol"`
+}
+
+// ProtocolBandwidth is the traffic of the streams of a protocol.
+type ProtocolBandwidth struct {
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	UploadedBytes   int64 `json:"uploaded_bytes"`
+}
+
+// bandwidthReport returns the traffic counted by bw, nil if bw is nil.
+func bandwidthReport(bw *metrics.BandwidthCounter) *BandwidthReport {
+	if bw == nil {
+		return nil
+	}
+	totals := bw.GetBandwidthTotals()
+	r := &BandwidthReport{
+		DownloadedBytes: totals.TotalIn,
+		UploadedBytes:   totals.TotalOut,
+		ByProtocol:      map[string]ProtocolBandwidth{},
+	}
+	for proto, st := range bw.GetBandwidthByProtocol() {
+		r.ByProtocol[string(proto)] = ProtocolBandwidth{
+			DownloadedBytes: st.TotalIn,
+			UploadedBytes:   st.TotalOut,
+		}
+	}
+	return r
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/metrics"
+)
+
+func TestBandwidthReport(t *testing.T) {
+	if bandwidthReport(nil) != nil {
+		t.Fatal("expected no report without a counter")
+	}
+	bw := metrics.NewBandwidthCounter()
+	bw.LogRecvMessage(1000)
+	bw.LogSentMessage(100)
+	bw.LogRecvMessageStream(900, "/ipfs/bitswap/1.2.0", "peer")
+	bw.LogSentMessageStream(60, "/ipfs/bitswap/1.2.0", "peer")
+	// The counter catches up every second
+	var r *BandwidthReport
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		r = bandwidthReport(bw)
+		if r.DownloadedBytes > 0 && r.ByProtocol["/ipfs/bitswap/1.2.0"].DownloadedBytes > 0 {
+			break
+		}
+	}
+	if r.DownloadedBytes != 1000 || r.UploadedBytes != 100 {
+		t.Fatalf("unexpected totals %+v", r)
+	}
+	if p := r.ByProtocol["/ipfs/bitswap/1.2.0"]; p.DownloadedBytes != 900 || p.UploadedBytes != 60 {
+		t.Fatalf("unexpected bitswap traffic %+v", p)
+	}
+}
+
+func TestStatBandwidth(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	fake.bw = &BandwidthReport{DownloadedBytes: 2048, UploadedBytes: 64, ByProtocol: map[string]ProtocolBandwidth{}}
+	out := l.Start("", false, true, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	r := out.Data.(StatOut).Report()
+	if r.Bandwidth == nil || r.Bandwidth.UploadedBytes != 64 {
+		t.Fatalf("unexpected bandwidth %+v", r.Bandwidth)
+	}
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Wait between the checks of the peers connected when
+// Config.RebootstrapInterval is not set, and how long the client keeps
+// dialing the leaders to get enough peers before giving up.
+const (
+	defaultRebootstrapInterval = 30 * time.Second
+	rebootstrapTimeout         = 15 * time.Minute
+)
+
+// BootstrapFailure tells why a leader couldn't be connected. The list of
+// them is the data of the Out returned when no peer could be connected.
+type BootstrapFailure struct {
+	Peer string `json:"peer"`
+	// Reason is one of no_addresses, dns, timeout, refused,
+	// swarm_key_mismatch or other.
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bootstrapFailures describes the peers which weren't connected, results
+// being the outcome of dialing the ones which had dialable addresses.
+func bootstrapFailures(peers []peer.AddrInfo, results []ipfslite.DialResult) []BootstrapFailure {
+	dialed := make(map[peer.ID]ipfslite.DialResult, len(results))
+	for _, r := range results {
+		dialed[r.ID] = r
+	}
+	out := []BootstrapFailure{}
+	for _, p := range peers {
+		r, ok := dialed[p.ID]
+		switch {
+		case !ok:
+			// Every address was refused by the address rules
+			out = append(out, BootstrapFailure{
+				Peer:   p.ID.String(),
+				Reason: string(ipfslite.FailureNoAddresses),
+				Error:  "no address allowed by the address rules",
+			})
+		case r.Err != nil:
+			out = append(out, BootstrapFailure{
+				Peer:   p.ID.String(),
+				Reason: string(r.Failure),
+				Error:  r.Err.Error(),
+			})
+		}
+	}
+	return out
+}
+
+// bootstrapTransports maps the peers connected in results to the transport
+// of their connection.
+func bootstrapTransports(results []ipfslite.DialResult) map[string]string {
+	out := make(map[string]string)
+	for _, r := range results {
+		if r.Err == nil {
+			out[r.ID.String()] = r.Transport
+		}
+	}
+	return out
+}
+
+// rebootstrap dials the leaders again, every Config.RebootstrapInterval,
+// whenever fewer than target peers are connected, until ctx is done. The
+// leaders are left alone while enough peers are connected, so a download
+// well under way doesn't keep dialing them, and dialed again once peers
+// drop. count is the number of peers connected at bootstrap.
+func (l *LightClient) rebootstrap(
+	ctx context.Context,
+	lite node,
+	leaders []peer.AddrInfo,
+	target int,
+	events *bootstrapEvents,
+	count int,
+) {
+	interval := l.cfg.RebootstrapInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultRebootstrapInterval
+	}
+	clock := l.clock()
+	// since is when the peers went below target, zero while enough are
+	// connected
+	var since time.Time
+	if count < target {
+		since = clock.Now()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(interval):
+		}
+		if len(lite.host().Network().Peers()) >= target {
+			since = time.Time{}
+			continue
+		}
+		if since.IsZero() {
+			since = clock.Now()
+		} else if clock.Since(since) > rebootstrapTimeout {
+			log.Warn("Tried getting more peers for 15mins")
+			l.step(StepPeersTimeout, timeoutError, "Download timed out")
+			return
+		}
+		n := lite.Bootstrap(l.dialable(leaders))
+		events.report(n)
+		// STEP : Re-Bootstrap done
+		if n > count {
+			l.step(StepMorePeers, success, "Found more peers to connect")
+		}
+		count = n
+	}
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"testing"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+)
+
+func TestStartNoPeers(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 0)
+	defer done()
+	l.cfg.BYOMetadata.Leaders = []string{testLeader}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != noPeers {
+		t.Fatalf("expected no peers got %+v", out)
+	}
+	failures, ok := out.Data.([]BootstrapFailure)
+	if !ok || len(failures) != 1 || failures[0].Reason != string(ipfslite.FailureRefused) {
+		t.Fatalf("unexpected failures %+v", out.Data)
+	}
+	// Reported right away rather than retried
+	if fake.bootstraps != 1 {
+		t.Fatalf("expected 1 bootstrap got %d", fake.bootstraps)
+	}
+}
+
+func TestStartNoLeaders(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 1024, 0)
+	defer done()
+
+	out := l.Start("", false, false, nil)
+	if out.Status != noPeers || out.Details != "no leaders in metadata" {
+		t.Fatalf("unexpected result %+v", out)
+	}
+}
+
+func TestBootstrapFailuresFiltered(t *testing.T) {
+	metadata, err := (&Metadata{Hash: "x", Leaders: []string{testLeader}}).info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The leader wasn't dialed at all
+	failures := bootstrapFailures(metadata.Cookie.Leaders, nil)
+	if len(failures) != 1 || failures[0].Reason != string(ipfslite.FailureNoAddresses) {
+		t.Fatalf("unexpected failures %+v", failures)
+	}
+	connected := []ipfslite.DialResult{{ID: metadata.Cookie.Leaders[0].ID}}
+	if failures := bootstrapFailures(metadata.Cookie.Leaders, connected); len(failures) != 0 {
+		t.Fatalf("connected leader reported %+v", failures)
+	}
+}
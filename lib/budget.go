@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How often the cost of a download is checked against Config.MaxCost.
+var budgetCheckInterval = time.Second
+
+// errCostUnknown is returned when Config.MaxCost is set but the cost of a
+// download can't be told. Such downloads are refused rather than let
+// through unchecked.
+var errCostUnknown = errors.New("cost can't be checked against the limit")
+
+// checkBudget fails when the estimated cost of the content of metadata is
+// above Config.MaxCost, or when the rate can't be read. Content of unknown
+// size is only checked while it is downloaded.
+func (l *LightClient) checkBudget(metadata *info) error {
+	if l.cfg.MaxCost <= 0 {
+		return nil
+	}
+	if _, _, err := parseRate(metadata.Rate); err != nil {
+		return fmt.Errorf("%w: %s", errCostUnknown, err.Error())
+	}
+	fi := metadata.fileInfo()
+	if fi.Cost == nil {
+		return nil
+	}
+	if fi.Cost.Amount > l.cfg.MaxCost {
+		return fmt.Errorf("estimated cost %g is above the limit of %g", fi.Cost.Amount, l.cfg.MaxCost)
+	}
+	return nil
+}
+
+// budgetOut is the Out of a download refused or stopped because of
+// Config.MaxCost.
+func budgetOut(err error, data interface{}) *Out {
+	if errors.Is(err, errCostUnknown) {
+		return NewOut(costExceeded, "Unable to check the cost", err.Error(), data)
+	}
+	return NewOut(costExceeded, "Cost limit exceeded", err.Error(), data)
+}
+
+// budgetWatch stops a download once the micropayments made so far go above
+// the limit, or once they can't be told.
+type budgetWatch struct {
+	mtx sync.Mutex
+	err error
+}
+
+func watchBudget(ctx context.Context, limit float64, paid func() (float64, error), stop func()) *budgetWatch {
+	b := &budgetWatch{}
+	if limit <= 0 {
+		return b
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(budgetCheckInterval):
+			}
+			amount, err := paid()
+			if err != nil {
+				log.Errorf("Unable to check the cost of the download Err: %s", err.Error())
+				b.stop(fmt.Errorf("%w: %s", errCostUnknown, err.Error()), stop)
+				return
+			}
+			if amount > limit {
+				log.Errorf("Cost %g of the download is above the limit of %g", amount, limit)
+				b.stop(fmt.Errorf("paid %g, above the limit of %g", amount, limit), stop)
+				return
+			}
+		}
+	}()
+	return b
+}
+
+func (b *budgetWatch) stop(err error, stop func()) {
+	b.mtx.Lock()
+	b.err = err
+	b.mtx.Unlock()
+	stop()
+}
+
+// failed returns why the download was stopped, nil if it wasn't.
+func (b *budgetWatch) failed() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.err
+}
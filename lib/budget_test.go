@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+func TestCheckBudget(t *testing.T) {
+	l := &LightClient{cfg: &Config{MaxCost: 1}}
+	metadata := &info{Rate: "0.5", Cookie: cookie{Size: 4 * 1024 * 1024}}
+	if err := l.checkBudget(metadata); err == nil {
+		t.Fatal("expected the download to be refused")
+	}
+	l.cfg.MaxCost = 2
+	if err := l.checkBudget(metadata); err != nil {
+		t.Fatal(err)
+	}
+	// Unknown sizes are only checked while downloading
+	l.cfg.MaxCost = 1
+	metadata.Cookie.Size = 0
+	if err := l.checkBudget(metadata); err != nil {
+		t.Fatal(err)
+	}
+	// Nor let through when the rate can't be read
+	metadata.Rate = "free"
+	if err := l.checkBudget(metadata); !errors.Is(err, errCostUnknown) {
+		t.Fatalf("expected the unreadable rate to be refused got %v", err)
+	}
+	l.cfg.MaxCost = 0
+	if err := l.checkBudget(metadata); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowDAG reports every block it serves to contrib after a delay, like
+// blocks coming from the network.
+type slowDAG struct {
+	ipld.DAGService
+	contrib *contributions
+	from    peer.ID
+}
+
+func (d *slowDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	time.Sleep(5 * time.Millisecond)
+	nd, err := d.DAGService.Get(ctx, c)
+	if err == nil {
+		d.contrib.BlockReceived(d.from, c, len(nd.RawData()))
+	}
+	return nd, err
+}
+
+func (d *slowDAG) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	return getManyVia(ctx, keys, d.Get)
+}
+
+func TestStartStopsOverBudget(t *testing.T) {
+	old := budgetCheckInterval
+	budgetCheckInterval = time.Millisecond
+	defer func() { budgetCheckInterval = old }()
+
+	l, fake, _, done := newFakeClient(t, 10*1024*1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Rate = "1"
+	l.cfg.MaxCost = 0.5
+	l.newNode = func(_ context.Context, _ *info, _ pnet.PSK, contrib *contributions) (node, error) {
+		fake.DAGService = &slowDAG{DAGService: fake.DAGService, contrib: contrib, from: fake.h.ID()}
+		// Paid at the rate for what is received, as the SCP engine does
+		fake.payments = func() (float64, error) {
+			return float64(contrib.total()) / (1024 * 1024), nil
+		}
+		return fake, nil
+	}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != costExceeded || out.Message != "Cost limit exceeded" {
+		t.Fatalf("expected status %d got %+v", costExceeded, out)
+	}
+}
+
+func TestStartStopsWithUnknownPayments(t *testing.T) {
+	old := budgetCheckInterval
+	budgetCheckInterval = time.Millisecond
+	defer func() { budgetCheckInterval = old }()
+
+	l, fake, _, done := newFakeClient(t, 10*1024*1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Rate = "0.01"
+	l.cfg.MaxCost = 1
+	l.newNode = func(_ context.Context, _ *info, _ pnet.PSK, contrib *contributions) (node, error) {
+		fake.DAGService = &slowDAG{DAGService: fake.DAGService, contrib: contrib, from: fake.h.ID()}
+		fake.payments = func() (float64, error) { return 0, errPaymentsUnsupported }
+		return fake, nil
+	}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != costExceeded || out.Message != "Unable to check the cost" {
+		t.Fatalf("expected the download to be stopped got %+v", out)
+	}
+}
+
+func TestStartRefusesUnknownRate(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Rate = "free"
+	l.cfg.MaxCost = 1
+
+	out := l.Start("", false, false, nil)
+	if out.Status != costExceeded || out.Message != "Unable to check the cost" {
+		t.Fatalf("expected the download to be refused got %+v", out)
+	}
+	if _, err := os.Stat(l.destination); !os.IsNotExist(err) {
+		t.Fatalf("refused download wrote its destination: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Metadata describes a download without asking the metadata service. It
+// is meant for testing against a real swarm. See Config.BYOMetadata.
+type Metadata struct {
+	// Hash is the CID of the content.
+	Hash string `json:"hash"`
+	// Leaders are the multiaddrs of the peers to bootstrap with, including
+	// their peer ID, e.g. "/ip4/1.2.3.4/tcp/4001/p2p/Qm...".
+	Leaders []string `json:"leaders"`
+	// SwarmKey is the content of the swarm.key file of the swarm.
+	SwarmKey string `json:"swarm_key"`
+	// Rate is passed to the peers as is.
+	Rate string `json:"rate"`
+	// Filename is the name of the file when the destination is a directory.
+	Filename string `json:"filename"`
+}
+
+func (m *Metadata) info() (*info, error) {
+	if len(m.Hash) == 0 {
+		return nil, errors.New("hash not provided")
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(m.Leaders))
+	for _, l := range m.Leaders {
+		addr, err := multiaddr.NewMultiaddr(l)
+		if err != nil {
+			return nil, fmt.Errorf("leader %q: %s", l, err.Error())
+		}
+		addrs = append(addrs, addr)
+	}
+	leaders, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, err
+	}
+	filename := m.Filename
+	if len(filename) == 0 {
+		filename = m.Hash
+	}
+	return &info{
+		Cookie: cookie{
+			Hash:     m.Hash,
+			Filename: filename,
+			Leaders:  leaders,
+		},
+		SwarmKey: []byte(m.SwarmKey),
+		Rate:     m.Rate,
+		byo:      true,
+	}, nil
+}
+
+// startBYO downloads the content described by Config.BYOMetadata. The
+// metadata service is neither asked for the metadata nor told about the
+// completion of the download.
+func (l *LightClient) startBYO(ctx context.Context, onlyInfo bool, stat bool, progUpd ProgressUpdater) *Out {
+	timings := newTimings()
+	metadata, err := l.cfg.BYOMetadata.info()
+	if err != nil {
+		log.Errorf("Invalid metadata provided Err: %s", err.Error())
+		return NewOut(invalidInput, "Invalid metadata provided", err.Error(), nil)
+	}
+	timings.Metadata = time.Since(timings.start)
+	// STEP : Got metadata
+	l.step(StepMetadata, success, "Using provided metadata")
+	if onlyInfo {
+		return NewOut(success, MetaInfo, "", metadata.fileInfo())
+	}
+	err = l.checkDestination()
+	if err != nil {
+		log.Errorf("Unusable destination Err: %s", err.Error())
+		return NewOut(destinationErr, "Destination not usable", err.Error(), nil)
+	}
+	return l.fetch(ctx, metadata.Cookie.Hash, metadata, false, stat, progUpd, timings)
+}
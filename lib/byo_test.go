@@ -0,0 +1,46 @@
+package lib
+
+import "testing"
+
+const testLeader = "/ip4/127.0.0.1/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+
+func TestBYOMetadataInfo(t *testing.T) {
+	m := &Metadata{
+		Hash:     "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB",
+		Leaders:  []string{testLeader},
+		SwarmKey: string(testSwarmKey),
+	}
+	i, err := m.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !i.byo || len(i.Cookie.Leaders) != 1 || i.Cookie.Filename != m.Hash {
+		t.Fatalf("unexpected info %+v", i)
+	}
+	if err := i.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Leaders = []string{"/ip4/127.0.0.1/tcp/4001"}
+	if _, err := m.info(); err == nil {
+		t.Fatal("expected an error for a leader without peer ID")
+	}
+	if _, err := (&Metadata{}).info(); err == nil {
+		t.Fatal("expected an error without hash")
+	}
+}
+
+func TestStartBYOSkipsService(t *testing.T) {
+	old := ApiAddr
+	ApiAddr = "http://127.0.0.1:1"
+	defer func() { ApiAddr = old }()
+
+	l := &LightClient{cfg: &Config{BYOMetadata: &Metadata{
+		Hash:    "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB",
+		Leaders: []string{testLeader},
+	}}}
+	out := l.Start("", true, false, nil)
+	if out.Status != success || out.Message != MetaInfo {
+		t.Fatalf("unexpected output %+v", out)
+	}
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"context"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+func (l *LightClient) rememberHash(sharable, hash string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.hashes == nil {
+		l.hashes = make(map[string]string)
+	}
+	l.hashes[sharable] = hash
+}
+
+// knownHash returns the hash of sharable if it was downloaded before or is
+// part of the loaded state.
+func (l *LightClient) knownHash(sharable string) string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if h, ok := l.hashes[sharable]; ok {
+		return h
+	}
+	if l.resume != nil && l.resume.Sharable == sharable {
+		return l.resume.Metadata.Cookie.Hash
+	}
+	return ""
+}
+
+// IsCached tells whether the content of sharable is completely available
+// in the datastore, so that it can be used without network. The hash of
+// the sharable is only asked to the metadata service if this client hasn't
+// seen it yet.
+func (l *LightClient) IsCached(ctx context.Context, sharable string) (bool, error) {
+	sharable, err := ParseSharable(sharable, l.cfg.AllowedHosts)
+	if err != nil {
+		return false, err
+	}
+	hash := l.knownHash(sharable)
+	if len(hash) == 0 {
+		metadata, err := l.getInfo(ctx, sharable)
+		if err != nil {
+			return false, err
+		}
+		hash = metadata.Cookie.Hash
+		l.rememberHash(sharable, hash)
+	}
+	return l.IsCachedHash(ctx, hash)
+}
+
+// IsCachedHash tells whether every block of the DAG of hash is in the
+// datastore.
+func (l *LightClient) IsCachedHash(ctx context.Context, hash string) (bool, error) {
+	c, err := cid.Decode(hash)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lite, err := ipfslite.New(ctx, l.ds, nil, nil, &ipfslite.Config{Offline: true})
+	if err != nil {
+		return false, err
+	}
+	err = merkledag.Walk(ctx, merkledag.GetLinksWithDAG(lite), c, cid.NewSet().Visit)
+	if err == ipld.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	"github.com/ipfs/go-unixfs/importer"
+)
+
+func TestIsCachedHash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	lite, err := ipfslite.New(ctx, ds, nil, nil, &ipfslite.Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	root, err := importer.BuildDagFromReader(lite, chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &LightClient{cfg: &Config{}, ds: ds}
+	cached, err := l.IsCachedHash(ctx, root.Cid().String())
+	if err != nil || !cached {
+		t.Fatalf("expected content to be cached %v %v", cached, err)
+	}
+	l.rememberHash("sharable", root.Cid().String())
+	cached, err = l.IsCached(ctx, "sharable")
+	if err != nil || !cached {
+		t.Fatalf("expected sharable to be cached %v %v", cached, err)
+	}
+
+	// Drop one leaf
+	if err := lite.Remove(ctx, root.Links()[1].Cid); err != nil {
+		t.Fatal(err)
+	}
+	cached, err = l.IsCachedHash(ctx, root.Cid().String())
+	if err != nil || cached {
+		t.Fatalf("expected partial content not to be cached %v %v", cached, err)
+	}
+
+	if _, err := l.IsCachedHash(ctx, "not-a-cid"); err == nil {
+		t.Fatal("expected an error for an invalid hash")
+	}
+}
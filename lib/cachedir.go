@@ -0,0 +1,343 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Suffix of the files holding the values of a dirDatastore, so that a key
+// and its children don't collide.
+const cacheFileSuffix = ".data"
+
+// dirDatastore keeps every value in its own file under a directory, the
+// path of the file mirroring the key. The modification time of a file is
+// the time its value was written, which gives the age of the cached
+// blocks. It is the datastore of Config.CacheDir.
+type dirDatastore struct {
+	root string
+}
+
+func newDirDatastore(root string) (*dirDatastore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &dirDatastore{root: root}, nil
+}
+
+// openCacheDir returns the datastore of an existing Config.CacheDir.
+func openCacheDir(dir string) (*dirDatastore, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &dirDatastore{root: dir}, nil
+}
+
+func (d *dirDatastore) path(key datastore.Key) string {
+	return filepath.Join(d.root, filepath.FromSlash(key.String())) + cacheFileSuffix
+}
+
+func (d *dirDatastore) key(path string) (datastore.Key, error) {
+	rel, err := filepath.Rel(d.root, strings.TrimSuffix(path, cacheFileSuffix))
+	if err != nil {
+		return datastore.Key{}, err
+	}
+	return datastore.NewKey(filepath.ToSlash(rel)), nil
+}
+
+func (d *dirDatastore) Put(key datastore.Key, value []byte) error {
+	path := d.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	// Written to a temporary file first so that readers never see a
+	// partial value
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(value)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (d *dirDatastore) Get(key datastore.Key) ([]byte, error) {
+	buf, err := ioutil.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, datastore.ErrNotFound
+	}
+	return buf, err
+}
+
+func (d *dirDatastore) Has(key datastore.Key) (bool, error) {
+	_, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *dirDatastore) GetSize(key datastore.Key) (int, error) {
+	st, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return -1, datastore.ErrNotFound
+	}
+	if err != nil {
+		return -1, err
+	}
+	return int(st.Size()), nil
+}
+
+func (d *dirDatastore) Delete(key datastore.Key) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *dirDatastore) Query(q query.Query) (query.Results, error) {
+	var entries []query.Entry
+	err := d.walk(datastore.NewKey(q.Prefix), func(path string, key datastore.Key, info os.FileInfo) error {
+		e := query.Entry{Key: key.String(), Size: int(info.Size())}
+		if !q.KeysOnly {
+			buf, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				// Deleted while walking
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			e.Value = buf
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query.NaiveQueryApply(q, query.ResultsWithEntries(q, entries)), nil
+}
+
+// walk calls fn with every value under prefix.
+func (d *dirDatastore) walk(prefix datastore.Key, fn func(path string, key datastore.Key, info os.FileInfo) error) error {
+	root := filepath.Join(d.root, filepath.FromSlash(prefix.String()))
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, cacheFileSuffix) {
+			return nil
+		}
+		key, err := d.key(path)
+		if err != nil {
+			return err
+		}
+		return fn(path, key, info)
+	})
+	return err
+}
+
+func (d *dirDatastore) Sync(datastore.Key) error {
+	return nil
+}
+
+func (d *dirDatastore) Close() error {
+	return nil
+}
+
+func (d *dirDatastore) Batch() (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
+// CacheInfo describes the blocks held in a Config.CacheDir.
+type CacheInfo struct {
+	Dir    string `json:"dir"`
+	Blocks int    `json:"blocks"`
+	Size   int64  `json:"size"`
+	// Oldest and Newest are the times the oldest and newest blocks were
+	// written, zero when there are none.
+	Oldest time.Time `json:"oldest,omitempty"`
+	Newest time.Time `json:"newest,omitempty"`
+	// Ages counts the blocks by the time since they were written.
+	Ages CacheAges `json:"ages"`
+}
+
+// CacheAges counts blocks written in the last hour, in the last day but
+// not the last hour, and so on.
+type CacheAges struct {
+	LastHour int `json:"last_hour"`
+	LastDay  int `json:"last_day"`
+	LastWeek int `json:"last_week"`
+	Older    int `json:"older"`
+}
+
+func (i *CacheInfo) add(info os.FileInfo, now time.Time) {
+	i.Blocks++
+	i.Size += info.Size()
+	t := info.ModTime()
+	if i.Oldest.IsZero() || t.Before(i.Oldest) {
+		i.Oldest = t
+	}
+	if t.After(i.Newest) {
+		i.Newest = t
+	}
+	switch age := now.Sub(t); {
+	case age < time.Hour:
+		i.Ages.LastHour++
+	case age < 24*time.Hour:
+		i.Ages.LastDay++
+	case age < 7*24*time.Hour:
+		i.Ages.LastWeek++
+	default:
+		i.Ages.Older++
+	}
+}
+
+func (i *CacheInfo) String() string {
+	s := fmt.Sprintf("%d blocks, %.2fMB in %s", i.Blocks, float64(i.Size)/(1024*1024), i.Dir)
+	if i.Blocks == 0 {
+		return s
+	}
+	return fmt.Sprintf("%s, written from %s to %s (last hour %d, last day %d, last week %d, older %d)",
+		s, i.Oldest.Format(time.RFC3339), i.Newest.Format(time.RFC3339),
+		i.Ages.LastHour, i.Ages.LastDay, i.Ages.LastWeek, i.Ages.Older)
+}
+
+// ReadCacheInfo returns the size and ages of the blocks cached in dir, a
+// Config.CacheDir. Nothing is downloaded.
+func ReadCacheInfo(dir string) (*CacheInfo, error) {
+	d, err := openCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	info := &CacheInfo{Dir: dir}
+	now := time.Now()
+	err = d.walk(blockstore.BlockPrefix, func(_ string, _ datastore.Key, fi os.FileInfo) error {
+		info.add(fi, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ClearCacheOptions selects the blocks removed by ClearCache. Without any
+// option every block is removed.
+type ClearCacheOptions struct {
+	// CIDs restricts the removal to the blocks of these DAGs. Blocks
+	// shared with other cached content are removed as well.
+	CIDs []string
+	// OlderThan restricts the removal to blocks written longer ago.
+	OlderThan time.Duration
+}
+
+// ClearCache removes the blocks selected by opts from dir, a
+// Config.CacheDir, and returns what was removed. The state of the clients
+// kept along with the blocks is left alone. Nothing is downloaded.
+func ClearCache(ctx context.Context, dir string, opts ClearCacheOptions) (*CacheInfo, error) {
+	d, err := openCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[datastore.Key]bool
+	if len(opts.CIDs) > 0 {
+		keys, err = dagKeys(ctx, d, opts.CIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	removed := &CacheInfo{Dir: dir}
+	now := time.Now()
+	err = d.walk(blockstore.BlockPrefix, func(path string, key datastore.Key, fi os.FileInfo) error {
+		if keys != nil && !keys[key] {
+			return nil
+		}
+		if opts.OlderThan > 0 && now.Sub(fi.ModTime()) < opts.OlderThan {
+			return nil
+		}
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		removed.add(fi, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// dagKeys returns the datastore keys of the cached blocks of the DAGs of
+// hashes. Blocks which aren't cached are skipped along with their
+// children.
+func dagKeys(ctx context.Context, ds datastore.Batching, hashes []string) (map[datastore.Key]bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lite, err := ipfslite.New(ctx, ds, nil, nil, &ipfslite.Config{Offline: true})
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[datastore.Key]bool)
+	for _, h := range hashes {
+		c, err := cid.Decode(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID %q: %s", h, err.Error())
+		}
+		seen := cid.NewSet()
+		todo := []cid.Cid{c}
+		for len(todo) > 0 {
+			c, todo = todo[0], todo[1:]
+			if !seen.Visit(c) {
+				continue
+			}
+			keys[blockstore.BlockPrefix.Child(dshelp.MultihashToDsKey(c.Hash()))] = true
+			nd, err := lite.Get(ctx, c)
+			if err == ipld.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range nd.Links() {
+				todo = append(todo, l.Cid)
+			}
+		}
+	}
+	return keys, nil
+}
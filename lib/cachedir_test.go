@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer"
+)
+
+func TestDirDatastore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := newDirDatastore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := datastore.NewKey("/blocks/a")
+	if err := d.Put(key, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// A key and its children don't collide
+	if err := d.Put(key.ChildString("b"), []byte("bb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(datastore.NewKey("/other"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get(key); err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value %q %v", v, err)
+	}
+	if n, err := d.GetSize(key.ChildString("b")); err != nil || n != 2 {
+		t.Fatalf("unexpected size %d %v", n, err)
+	}
+	res, err := d.Query(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil || len(entries) != 2 || entries[0].Key != "/blocks/a" || string(entries[1].Value) != "bb" {
+		t.Fatalf("unexpected entries %+v %v", entries, err)
+	}
+
+	if err := d.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := d.Has(key); err != nil || has {
+		t.Fatalf("deleted key still there %v %v", has, err)
+	}
+	if _, err := d.Get(key); err != datastore.ErrNotFound {
+		t.Fatalf("expected not found got %v", err)
+	}
+	if err := d.Delete(key); err != nil {
+		t.Fatalf("deleting a missing key failed %v", err)
+	}
+}
+
+// cacheDAG adds size random bytes to the cache directory dir and returns
+// the root of their DAG.
+func cacheDAG(t *testing.T, ctx context.Context, dir string, size int) ipld.Node {
+	d, err := newDirDatastore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lite, err := ipfslite.New(ctx, d, nil, nil, &ipfslite.Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, size)
+	rand.Read(data)
+	root, err := importer.BuildDagFromReader(lite, chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestClearCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir, err := ioutil.TempDir("", "cachedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 4 leaves and a root each
+	a := cacheDAG(t, ctx, dir, 1024*1024)
+	b := cacheDAG(t, ctx, dir, 1024*1024)
+	info, err := ReadCacheInfo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Blocks != 10 || info.Size < 2*1024*1024 || info.Ages.LastHour != 10 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// Age the blocks of b
+	week := time.Now().Add(-8 * 24 * time.Hour)
+	l := &LightClient{cfg: &Config{}, ds: &dirDatastore{root: dir}}
+	keys, err := dagKeys(ctx, l.ds, []string{b.Cid().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dirDatastore{root: dir}
+	for k := range keys {
+		if err := os.Chtimes(d.path(k), week, week); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err = ReadCacheInfo(dir)
+	if err != nil || info.Ages.Older != 5 || info.Ages.LastHour != 5 || !info.Oldest.Equal(week) {
+		t.Fatalf("unexpected info %+v %v", info, err)
+	}
+
+	removed, err := ClearCache(ctx, dir, ClearCacheOptions{OlderThan: 24 * time.Hour})
+	if err != nil || removed.Blocks != 5 {
+		t.Fatalf("unexpected removal %+v %v", removed, err)
+	}
+	if cached, _ := l.IsCachedHash(ctx, a.Cid().String()); !cached {
+		t.Fatal("recent content removed")
+	}
+	if cached, _ := l.IsCachedHash(ctx, b.Cid().String()); cached {
+		t.Fatal("old content kept")
+	}
+
+	// Some other state is kept
+	if err := d.Put(datastore.NewKey("/clients/x/state"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	c := cacheDAG(t, ctx, dir, 512*1024)
+	removed, err = ClearCache(ctx, dir, ClearCacheOptions{CIDs: []string{a.Cid().String()}})
+	if err != nil || removed.Blocks != 5 {
+		t.Fatalf("unexpected removal %+v %v", removed, err)
+	}
+	if cached, _ := l.IsCachedHash(ctx, c.Cid().String()); !cached {
+		t.Fatal("other content removed")
+	}
+	removed, err = ClearCache(ctx, dir, ClearCacheOptions{})
+	if err != nil || removed.Blocks != 3 {
+		t.Fatalf("unexpected removal %+v %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "clients", "x", "state"+cacheFileSuffix)); err != nil {
+		t.Fatal("state removed along with the blocks")
+	}
+
+	if _, err := ReadCacheInfo(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ChannelState is the state of the SCP payment channel with a peer.
+type ChannelState struct {
+	Peer string `json:"peer"`
+	// Sent is the amount paid to the peer over the channel so far.
+	Sent float64 `json:"sent"`
+	// Status is as told by the SCP engine, e.g. "open" or "closed".
+	Status string `json:"status"`
+}
+
+// channelReporter is implemented by SCP engines able to tell the state of
+// their payment channel with a peer. ok is false when there is none.
+type channelReporter interface {
+	PaymentChannel(p peer.ID) (sent float64, status string, ok bool)
+}
+
+var errChannelsUnsupported = errors.New("SCP engine doesn't report payment channels")
+
+func (n liteNode) paymentChannels() ([]ChannelState, error) {
+	r, ok := interface{}(n.Scp).(channelReporter)
+	if !ok {
+		return nil, errChannelsUnsupported
+	}
+	channels := []ChannelState{}
+	for _, p := range n.Host.Network().Peers() {
+		sent, status, ok := r.PaymentChannel(p)
+		if ok {
+			channels = append(channels, ChannelState{Peer: p.String(), Sent: sent, Status: status})
+		}
+	}
+	return channels, nil
+}
+
+// PaymentChannels returns the payment channels of the download in
+// progress with the peers connected, for a live view of what is paid
+// where the receipts in StatOut.Ledgers only come at the end. It can be
+// called from any goroutine while a download runs. It returns nil when no
+// download is in progress or the SCP engine doesn't report its channels.
+func (l *LightClient) PaymentChannels() []ChannelState {
+	l.mtx.Lock()
+	var lite node
+	if l.active != nil {
+		lite = l.active.lite
+	}
+	l.mtx.Unlock()
+
+	if lite == nil {
+		return nil
+	}
+	channels, err := lite.paymentChannels()
+	if err != nil {
+		log.Debugf("No payment channels Err: %s", err.Error())
+		return nil
+	}
+	return channels
+}
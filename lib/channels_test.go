@@ -0,0 +1,34 @@
+package lib
+
+import "testing"
+
+func TestPaymentChannels(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	if l.PaymentChannels() != nil {
+		t.Fatal("expected no channels without a download")
+	}
+	fake.channels = []ChannelState{
+		{Peer: "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC", Sent: 1.5, Status: "open"},
+	}
+	var live []ChannelState
+	l.cfg.StepHook = func(code StepCode, _ string) {
+		if code == StepDownloading {
+			live = l.PaymentChannels()
+		}
+	}
+	out := l.Start("", false, true, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	if len(live) != 1 || live[0] != fake.channels[0] {
+		t.Fatalf("unexpected channels during the download %+v", live)
+	}
+	stat := out.Data.(StatOut)
+	if len(stat.PaymentChannels) != 1 || stat.PaymentChannels[0].Sent != 1.5 {
+		t.Fatalf("unexpected channels %+v", stat.PaymentChannels)
+	}
+	if r := stat.Report(); len(r.PaymentChannels) != 1 || r.PaymentChannels[0].Status != "open" {
+		t.Fatalf("unexpected report %+v", r.PaymentChannels)
+	}
+}
@@ -0,0 +1,41 @@
+package lib
+
+// cleanups are the teardowns of what a download set up as it went: part
+// files, mirrors, nodes. They are registered right after the thing they
+// undo is created and run in reverse order on any exit of the download,
+// told its result, so that no mode has to undo its own state on each of
+// its error paths. A teardown no longer needed, e.g. once the part file
+// was moved in place, is dropped. They are only used from the goroutine
+// of the download.
+type cleanups struct {
+	list []*cleanup
+}
+
+type cleanup struct {
+	f       func(res *Out)
+	dropped bool
+}
+
+// add registers f, to be run with the result of the download.
+func (c *cleanups) add(f func(res *Out)) *cleanup {
+	cl := &cleanup{f: f}
+	c.list = append(c.list, cl)
+	return cl
+}
+
+// run runs the teardowns not dropped, the last registered first. Later
+// calls do nothing.
+func (c *cleanups) run(res *Out) {
+	list := c.list
+	c.list = nil
+	for i := len(list) - 1; i >= 0; i-- {
+		if !list[i].dropped {
+			list[i].f(res)
+		}
+	}
+}
+
+// drop keeps the teardown from running.
+func (c *cleanup) drop() {
+	c.dropped = true
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+func TestCleanupsOrder(t *testing.T) {
+	var ran []int
+	cl := &cleanups{}
+	for i := 0; i < 3; i++ {
+		i := i
+		c := cl.add(func(res *Out) {
+			if res.Status != internalError {
+				t.Fatalf("unexpected result %+v", res)
+			}
+			ran = append(ran, i)
+		})
+		if i == 1 {
+			c.drop()
+		}
+	}
+	cl.run(NewOut(internalError, "", "", nil))
+	cl.run(nil)
+	if len(ran) != 2 || ran[0] != 2 || ran[1] != 0 {
+		t.Fatalf("unexpected teardowns %v", ran)
+	}
+}
+
+// cancelledClient returns a client whose download is cancelled once it
+// started writing, the blocks of the file but its root being held until
+// then. With dir the file is added to the directory of addTestDir, which
+// becomes the content.
+func cancelledClient(t *testing.T, dir bool) (*LightClient, *fakeNode, context.Context, func()) {
+	l, fake, _, done := newFakeClient(t, 2*1024*1024, 3)
+	file, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := map[cid.Cid]bool{file: true}
+	if dir {
+		ctx := context.Background()
+		d, err := ufsio.NewDirectoryFromNode(fake.DAGService, addTestDir(t, fake.DAGService))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nd, err := fake.Get(ctx, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.AddChild(ctx, "big", nd); err != nil {
+			t.Fatal(err)
+		}
+		top, err := d.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fake.Add(ctx, top); err != nil {
+			t.Fatal(err)
+		}
+		l.cfg.BYOMetadata.Hash = top.Cid().String()
+		err = merkledag.Walk(ctx, merkledag.GetLinksWithDAG(fake.DAGService), top.Cid(), func(c cid.Cid) bool {
+			free[c] = true
+			return c != file
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.DAGService = &heldDAG{
+		gatedDAG: gatedDAG{DAGService: fake.DAGService, gate: make(chan struct{})},
+		free:     free,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cfg.Quiet = true
+	l.cfg.StepHook = func(code StepCode, _ string) {
+		if code == StepDownloading {
+			cancel()
+		}
+	}
+	return l, fake, ctx, func() {
+		cancel()
+		done()
+	}
+}
+
+// noFiles fails if anything is left in dir.
+func noFiles(t *testing.T, dir string) {
+	left, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range left {
+		t.Errorf("%s left in %s", fi.Name(), dir)
+	}
+}
+
+func TestCancelCleansUpFile(t *testing.T) {
+	old := minSegmentSize
+	minSegmentSize = 512 * 1024
+	defer func() { minSegmentSize = old }()
+
+	for _, tc := range []struct {
+		name     string
+		sparse   bool
+		segments int
+		mirrors  bool
+		dir      bool
+	}{
+		{name: "sequential"},
+		{name: "sparse", sparse: true},
+		{name: "segments", segments: 4},
+		{name: "mirrors", mirrors: true},
+		{name: "directory", dir: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, fake, ctx, done := cancelledClient(t, tc.dir)
+			defer done()
+			dir := filepath.Dir(l.destination)
+			l.cfg.SparseAssembly = tc.sparse
+			l.cfg.ParallelSegments = tc.segments
+			if tc.mirrors {
+				mirrorDir, err := ioutil.TempDir("", "mirror")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(mirrorDir)
+				defer noFiles(t, mirrorDir)
+				l.cfg.Mirrors = []string{filepath.Join(mirrorDir, "copy")}
+			}
+			metadata, err := l.cfg.BYOMetadata.info()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := l.fetch(ctx, "sharable", metadata, false, false, nil, &Timings{})
+			if out.Status == success {
+				t.Fatal("expected the download to be cancelled")
+			}
+			if !fake.closed {
+				t.Fatal("node left running")
+			}
+			noFiles(t, dir)
+		})
+	}
+}
+
+func TestCancelCleansUpOutput(t *testing.T) {
+	for _, tc := range []struct {
+		mode OutputMode
+		dir  bool
+	}{
+		{OutputCAR, false},
+		{OutputCAR, true},
+		{OutputTar, false},
+		{OutputTar, true},
+		{OutputBytes, false},
+		{OutputDiscard, false},
+		{OutputDiscard, true},
+	} {
+		name := string(tc.mode)
+		if tc.dir {
+			name += "-directory"
+		}
+		t.Run(name, func(t *testing.T) {
+			l, fake, ctx, done := cancelledClient(t, tc.dir)
+			defer done()
+			var w io.Writer
+			if tc.mode != OutputDiscard {
+				w = &bytes.Buffer{}
+			}
+			out := l.StartOutput(ctx, "", tc.mode, w, false, nil)
+			if out.Status != internalError || out.Message != "Failed writing to destination" {
+				t.Fatalf("expected the output to be cancelled got %+v", out)
+			}
+			if !fake.closed {
+				t.Fatal("node left running")
+			}
+		})
+	}
+}
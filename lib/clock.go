@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Clock tells the time and waits. The timeouts and waits of downloads
+// use it, so that tests can drive them with a fake clock instead of
+// waiting for real.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Since(t time.Time) time.Duration
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+
+func (l *LightClient) clock() Clock {
+	if l.clk == nil {
+		return realClock{}
+	}
+	return l.clk
+}
+
+// Difference with the clock of the metadata service past which the local
+// clock is reported incorrect when Config.MaxClockSkew is not set.
+const defaultMaxClockSkew = 5 * time.Minute
+
+// ClockSkewError tells that the local clock differs from the clock of the
+// metadata service by more than Config.MaxClockSkew. The micropayment
+// receipts and the session cookies carry timestamps, so downloads with a
+// wrong clock fail in confusing ways, e.g. with sessions expiring right
+// away. SkewMilli is positive when the local clock is ahead.
+type ClockSkewError struct {
+	SkewMilli int64 `json:"skew_ms"`
+}
+
+func (e *ClockSkewError) Error() string {
+	skew := time.Duration(e.SkewMilli) * time.Millisecond
+	dir := "ahead of"
+	if skew < 0 {
+		skew, dir = -skew, "behind"
+	}
+	return fmt.Sprintf("system clock appears incorrect, it is %s %s the metadata service: check the date, time and time zone settings",
+		skew.Round(time.Second), dir)
+}
+
+func (l *LightClient) maxClockSkew() time.Duration {
+	if l.cfg.MaxClockSkew == 0 {
+		return defaultMaxClockSkew
+	}
+	return l.cfg.MaxClockSkew
+}
+
+// checkClock compares the local clock with the Date header of resp, a
+// response of the metadata service to a request sent at sent. When they
+// differ by more than the allowed skew, the user is warned and the error
+// is returned.
+func (l *LightClient) checkClock(resp *http.Response, sent time.Time) *ClockSkewError {
+	max := l.maxClockSkew()
+	if max < 0 {
+		return nil
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return nil
+	}
+	// The response was dated somewhere during the round trip, in whole
+	// seconds
+	local := sent.Add(time.Since(sent) / 2)
+	skew := local.Sub(date.Add(500 * time.Millisecond))
+	if skew <= max && skew >= -max {
+		return nil
+	}
+	skewErr := &ClockSkewError{SkewMilli: skew.Milliseconds()}
+	log.Warnf("Clock skew of %s with the metadata service", skew)
+	// STEP : Clock skew
+	l.step(StepClockSkew, internalError, "Warning: "+skewErr.Error())
+	return skewErr
+}
+
+// withClockHint adds the clock skew to the details of a failed download,
+// as it is the likely cause.
+func withClockHint(out *Out, skew *ClockSkewError) *Out {
+	if skew == nil {
+		return out
+	}
+	if len(out.Details) > 0 {
+		out.Details += ", "
+	}
+	out.Details += skew.Error()
+	return out
+}
@@ -0,0 +1,326 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// newDatedServer returns a metadata service whose clock is off by skew.
+func newDatedServer(skew time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		w.Write([]byte(`{"Cookie": {"Id": "cookie", "Hash": "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"}}`))
+	}))
+}
+
+func TestClockSkew(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		serverSkew time.Duration
+		max        time.Duration
+		skewed     bool
+	}{
+		{"in sync", 0, 0, false},
+		{"within default", 2 * time.Minute, 0, false},
+		{"server ahead", time.Hour, 0, true},
+		{"server behind", -time.Hour, 0, true},
+		{"within max", time.Hour, 2 * time.Hour, false},
+		{"disabled", time.Hour, -1, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var steps []StepCode
+			l := &LightClient{cfg: &Config{
+				MaxClockSkew: tc.max,
+				Quiet:        true,
+				StepHook: func(code StepCode, message string) {
+					steps = append(steps, code)
+				},
+			}}
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("Date", time.Now().Add(tc.serverSkew).UTC().Format(http.TimeFormat))
+			skewErr := l.checkClock(resp, time.Now())
+			if skewed := skewErr != nil; skewed != tc.skewed {
+				t.Fatalf("expected skewed %t got %+v", tc.skewed, skewErr)
+			}
+			if !tc.skewed {
+				if len(steps) != 0 {
+					t.Fatalf("unexpected steps %v", steps)
+				}
+				return
+			}
+			if len(steps) != 1 || steps[0] != StepClockSkew {
+				t.Fatalf("expected a clock skew step got %v", steps)
+			}
+			// Positive when the local clock is ahead
+			skew := time.Duration(skewErr.SkewMilli) * time.Millisecond
+			if diff := skew + tc.serverSkew; diff > 2*time.Second || diff < -2*time.Second {
+				t.Fatalf("expected a skew of %s got %s", -tc.serverSkew, skew)
+			}
+		})
+	}
+}
+
+func TestRejectClockSkew(t *testing.T) {
+	srv := newDatedServer(-time.Hour)
+	defer srv.Close()
+	l, err := NewLightClient(".", "1m", false, &Config{
+		Endpoint:        srv.URL,
+		RejectClockSkew: true,
+		ExternalIPFunc:  staticIP("203.0.113.7"),
+		Quiet:           true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := l.getInfo(context.Background(), "sharable")
+	if metadata != nil || err == nil {
+		t.Fatal("expected the metadata to be refused")
+	}
+	out := metadataOut(err)
+	if out.Status != clockSkewed || !strings.Contains(out.Details, "ahead of") {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if _, ok := out.Data.(*ClockSkewError); !ok {
+		t.Fatalf("unexpected data %+v", out.Data)
+	}
+}
+
+// fakeClock only moves when advanced. The channels returned by After fire
+// once the clock passed their deadline.
+type fakeClock struct {
+	mtx     sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// advance moves the clock forward by d, firing the timers due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = pending
+}
+
+// waitTimers waits for n timers to be pending.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mtx.Lock()
+		pending := len(c.waiters)
+		c.mtx.Unlock()
+		if pending >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d pending timers", n)
+}
+
+// gatedDAG holds every request until the gate is closed.
+type gatedDAG struct {
+	ipld.DAGService
+	gate chan struct{}
+}
+
+func (d *gatedDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	select {
+	case <-d.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return d.DAGService.Get(ctx, c)
+}
+
+func (d *gatedDAG) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	return getManyVia(ctx, keys, d.Get)
+}
+
+func TestSettleWaitClock(t *testing.T) {
+	clk := newFakeClock()
+	l := &LightClient{cfg: &Config{}, clk: clk}
+	done := make(chan struct{})
+	go func() {
+		l.settle(context.Background(), nil)
+		close(done)
+	}()
+	clk.waitTimers(t, 1)
+	clk.advance(defaultSettleWait - time.Second)
+	select {
+	case <-done:
+		t.Fatal("settled before the wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.advance(time.Second)
+	<-done
+}
+
+func TestAttemptsStartTimeout(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 5)
+	defer done()
+	fake.DAGService = &gatedDAG{DAGService: fake.DAGService, gate: make(chan struct{})}
+	fc := newFakeClock()
+	l.clk = fc
+	res := make(chan *Out)
+	go func() {
+		res <- l.Start("", false, false, nil)
+	}()
+	// Every attempt waits for the content for 3 minutes
+	for i := 0; i < 3; i++ {
+		fc.waitTimers(t, 1)
+		fc.advance(3 * time.Minute)
+	}
+	out := <-res
+	if out.Status != internalError || out.Message != "Failed on retrying thrice" {
+		t.Fatalf("unexpected result %+v", out)
+	}
+}
+
+func TestLaggedBootstrapClock(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 1, 2)
+	defer done()
+	l.cfg.BYOMetadata.Leaders = []string{
+		"/ip4/127.0.0.1/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+		"/ip4/127.0.0.1/tcp/4002/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	}
+	l.cfg.MinPeers = 2
+	gate := make(chan struct{})
+	fake.DAGService = &gatedDAG{DAGService: fake.DAGService, gate: gate}
+	more := make(chan struct{})
+	l.cfg.StepHook = func(code StepCode, _ string) {
+		if code == StepMorePeers {
+			close(more)
+		}
+	}
+	fc := newFakeClock()
+	l.clk = fc
+	res := make(chan *Out)
+	go func() {
+		res <- l.Start("", false, false, nil)
+	}()
+	// The start timeout and the wait before bootstrapping again
+	fc.waitTimers(t, 2)
+	fc.advance(30 * time.Second)
+	select {
+	case <-more:
+	case <-time.After(10 * time.Second):
+		t.Fatal("not bootstrapped again")
+	}
+	close(gate)
+	if out := <-res; out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	if fake.bootstraps != 2 {
+		t.Fatalf("expected 2 bootstraps got %d", fake.bootstraps)
+	}
+}
+
+func TestRebootstrapOnlyBelowMinPeers(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 1)
+	defer done()
+	l.cfg.BYOMetadata.Leaders = []string{
+		"/ip4/127.0.0.1/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+		"/ip4/127.0.0.1/tcp/4002/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	}
+	l.cfg.MinPeers = 2
+	// The peers are connected on the side, the fake only counts bootstraps
+	mn, err := mocknet.FullMeshLinked(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	defer func() {
+		for _, h := range hosts {
+			h.Close()
+		}
+	}()
+	fake.h = hosts[0]
+	root, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := make(chan struct{})
+	fake.DAGService = &heldDAG{
+		gatedDAG: gatedDAG{DAGService: fake.DAGService, gate: gate},
+		free:     map[cid.Cid]bool{root: true},
+	}
+	bootstraps := func() int {
+		fake.mtx.Lock()
+		defer fake.mtx.Unlock()
+		return fake.bootstraps
+	}
+	fc := newFakeClock()
+	l.clk = fc
+	res := make(chan *Out)
+	go func() {
+		res <- l.Start("", false, false, nil)
+	}()
+	// The start timeout and the first check of the peers
+	fc.waitTimers(t, 2)
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		fc.advance(defaultRebootstrapInterval)
+		fc.waitTimers(t, 2)
+	}
+	if n := bootstraps(); n != 1 {
+		t.Fatalf("leaders dialed with enough peers, %d bootstraps", n)
+	}
+	// Dialed again once a peer drops
+	if err := hosts[0].Network().ClosePeer(hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	fc.advance(defaultRebootstrapInterval)
+	fc.waitTimers(t, 2)
+	if n := bootstraps(); n != 2 {
+		t.Fatalf("expected the leaders to be dialed again got %d bootstraps", n)
+	}
+	close(gate)
+	if out := <-res; out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+}
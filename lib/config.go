@@ -0,0 +1,453 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Config wraps optional settings for the LightClient. A nil Config or its
+// zero value keeps the default behaviour.
+type Config struct {
+	// Endpoint is the address of the metadata service, ApiAddr by
+	// default.
+	Endpoint string
+	// Endpoints are more addresses of the metadata service, tried in
+	// order after Endpoint when it is unreachable or fails with a 5xx
+	// status. When only Endpoints is set ApiAddr is not used.
+	Endpoints []string
+
+	// MaxClockSkew is how far the local clock may be from the clock of
+	// the metadata service, told by the Date header of its responses,
+	// before it is reported incorrect. Timestamps in the receipts and
+	// the session cookies make downloads with a wrong clock fail. Zero
+	// keeps the default of 5m, a negative value disables the check.
+	MaxClockSkew time.Duration
+	// RejectClockSkew fails downloads when the clock is found incorrect,
+	// instead of warning and trying anyway.
+	RejectClockSkew bool
+
+	// AllowedHosts lists hosts, in addition to DefaultSharableHosts, from
+	// which full sharable links are accepted.
+	AllowedHosts []string
+
+	// BYOMetadata, when set, is used by Start instead of the metadata
+	// returned by the service, and the service isn't told about the
+	// download either. The sharable is then ignored. It is meant for
+	// testing and reproducing issues against a known swarm, not for normal
+	// operation.
+	BYOMetadata *Metadata
+
+	// Datastore holds the downloaded blocks and the DHT records. It
+	// defaults to an in-memory one. A persistent datastore keeps the
+	// blocks across restarts, see IsCached.
+	//
+	// Several clients may share a Datastore. The blocks, which are
+	// immutable, are shared between them while the rest of their state is
+	// kept under /clients/<DatastoreNamespace>, the peer ID of the client
+	// by default. Clients reusing the state of a previous run have to set
+	// the same DatastoreNamespace.
+	Datastore          datastore.Batching
+	DatastoreNamespace string
+
+	// CacheDir, when set, is a directory keeping the downloaded blocks on
+	// disk, one file per block, as the Datastore. It can be inspected and
+	// trimmed with ReadCacheInfo and ClearCache. It can't be used along
+	// with Datastore.
+	CacheDir string
+
+	// RetryBudget, when set, bounds the retries of each download across
+	// all its steps, so that it fails in a predictable time instead of
+	// retrying at every layer. The use of the budget is part of the stats.
+	RetryBudget *RetryBudget
+
+	// SecurityTransport is the security protocol of the connections to
+	// peers: "noise", "tls" or "both" (the default). Peers which don't
+	// support the one forced can't be connected. See
+	// ipfslite.SecurityTransport.
+	SecurityTransport string
+
+	// KeyType is the type of the key identifying the client in the swarm:
+	// "ed25519" (the default), "secp256k1" or "rsa". KeyBits is the size of
+	// RSA keys, 2048 if zero. It can't be set for the other types.
+	KeyType string
+	KeyBits int
+
+	// DHTMode is one of "auto", "client", "server" or "off". It defaults to
+	// "client". With "off" the download relies solely on the leaders
+	// returned by the metadata service. See ipfslite.DHTMode for the
+	// reachability tradeoffs.
+	DHTMode string
+
+	// ListenAddrs are the multiaddrs the node listens on. They default to
+	// TCP port 45000 on IPv4 and IPv6 and websockets on port 45001. Several
+	// downloads running at the same time in one process need distinct
+	// ports, e.g. port 0 to pick free ones.
+	ListenAddrs []string
+
+	// AutoNAT is one of "auto" (the default), "public", "private" or
+	// "service": how the node learns whether peers can dial it back. The
+	// AutoNAT client of libp2p can't be removed, forcing the reachability
+	// with "public" or "private" is the only way to stop it from probing,
+	// e.g. "private" behind a NAT which drops inbound connections. There
+	// is no "off" for that reason. See ipfslite.AutoNATMode.
+	AutoNAT string
+
+	// ExternalAddrs are multiaddrs the node can be dialed at on top of the
+	// ones it listens on, e.g. /ip4/203.0.113.7/tcp/45000 when the router
+	// forwards that port. They are announced to the peers along with the
+	// address the peers observed through identify, so that they, and
+	// relays, can dial back. They are unrelated to the src_ip sent to the
+	// metadata service for billing, see ExternalIPFunc: that one only tells
+	// the service where the download comes from and is never announced to
+	// the swarm, and these are never sent to the service.
+	ExternalAddrs []string
+
+	// FileMode is the permission of the files created by downloads,
+	// 0644 by default. Directories get the same permission plus execute
+	// where reading is allowed, e.g. 0700 for 0600. The umask still
+	// applies.
+	FileMode os.FileMode
+
+	// GetFileRetries is the number of times looking for the content is
+	// retried, bootstrapping again in between, when no peer serves it
+	// within a minute. Zero uses 2 retries, negative values disable them.
+	GetFileRetries int
+
+	// MaxConcurrentRequests bounds the number of blocks requested at the
+	// same time. Zero (default) leaves it to bitswap. Values around 8 work
+	// well on cellular links.
+	MaxConcurrentRequests int
+
+	// MaxMemory bounds, in bytes, the file data fetched ahead of what is
+	// written to the destination, shared by the segments of
+	// ParallelSegments and the files of a directory. Blocks are only
+	// requested while there is room, so a slow destination holds the
+	// download back instead of piling up blocks. Fewer blocks in flight
+	// lower the throughput on high latency links, values of a few MB
+	// keep most of it. The buffers of bitswap and the connections come on
+	// top, as does the in-memory Datastore holding every block when
+	// neither Datastore nor CacheDir is set. Zero doesn't bound it.
+	MaxMemory int64
+
+	// StreamOrder fetches files in order, the beginning first, instead of
+	// letting bitswap pick among the blocks fetched ahead, so that media
+	// can be played from the part file, or from the reader of Open, while
+	// the rest downloads. It disables ParallelSegments. See
+	// StatOut.TimeToFirstByte.
+	StreamOrder bool
+
+	// SettleWait is how long to wait after the transfer for SCP to send
+	// the last micropayments. Zero keeps the default of 5s, a negative
+	// value skips the wait.
+	SettleWait time.Duration
+	// FlushPayments asks the SCP engine to send the pending micropayments
+	// right away instead of waiting SettleWait. The wait is still done if
+	// the engine doesn't support it.
+	FlushPayments bool
+
+	// ProgressEvery, when set, only reports progress when the percentage
+	// crosses a multiple of it, e.g. 10 for 0%, 10%, 20%... Zero (default)
+	// reports it every 500ms, which suits interactive use.
+	ProgressEvery int
+
+	// Quiet suppresses everything printed during a download except failed
+	// steps, so that the result returned by Start is the only output. The
+	// StepHook is still called.
+	Quiet bool
+
+	// ShowIdentity prints the peer ID and listen addresses of the node once
+	// it is set up.
+	ShowIdentity bool
+
+	// StateFile, when set, is where the state of a download is saved so
+	// that it can be resumed after the process restarts. Partially
+	// downloaded data is then kept on failure.
+	StateFile string
+
+	// Durable syncs the downloaded data to disk before it is moved to the
+	// destination and the directory entry after, so that a download
+	// reported complete survives a power loss. Syncing waits for the disk
+	// to write everything, which can take seconds for large files on slow
+	// storage, and every file of a directory is synced on its own.
+	Durable bool
+
+	// KeepPartialOnError leaves the part file of a failed download in
+	// place for inspection instead of deleting it. PartialRetentionDir,
+	// when set, is where the part files of failed downloads are moved to
+	// instead, named after the time of the failure. Neither applies with
+	// StateFile, which keeps the part file to resume from.
+	KeepPartialOnError  bool
+	PartialRetentionDir string
+
+	// ManifestFile, when set, is where a Manifest of the download is
+	// written: the root CID and every block received with the peer which
+	// served it.
+	ManifestFile string
+
+	// TraceFile, when set, is where the events of each download started
+	// with Start or StartName are written as they happen, one JSON
+	// TraceEvent per line: the metadata requests, the bootstrap attempts
+	// and their outcome, the connections to peers, every block received
+	// and the result. The file is replaced by every download. It is meant
+	// to be sent along with issue reports, secrets are left out.
+	TraceFile string
+
+	// WriteSidecar writes the CID of the content next to it once the
+	// download succeeded, in <destination>.cid, so that it can be checked
+	// later without the metadata service. SidecarSHA256 also writes the
+	// SHA-256 of a file to <destination>.sha256, in the format of
+	// sha256sum.
+	WriteSidecar  bool
+	SidecarSHA256 bool
+
+	// PeerCacheFile, when set, is where the peers connected at the end of
+	// each download are kept, per swarm. The next downloads in the same
+	// swarm dial them before the leaders, which saves looking for peers
+	// again on stable swarms. Peers are forgotten after PeerCacheTTL, one
+	// day if zero.
+	PeerCacheFile string
+	PeerCacheTTL  time.Duration
+
+	// PaymentsFile, when set, is where the micropayment receipts of the
+	// download are saved while it runs and when it ends, so that payments
+	// to the peers are not lost if the process dies. See LoadPayments.
+	PaymentsFile string
+
+	// AllowedSwarmFingerprints, when not empty, lists the fingerprints (see
+	// SwarmFingerprint) of the swarms the client may join. Downloads using
+	// any other swarm key are refused.
+	AllowedSwarmFingerprints []string
+
+	// NetworkStatsOnFailure logs the connections, streams and memory held
+	// by the node when a download fails, which tells whether it ran out of
+	// them. They are the data of the failed Out when it has no other. See
+	// NetworkStats.
+	NetworkStatsOnFailure bool
+
+	// StepHook, when set, is called on every download milestone in
+	// addition to printing it. Panics in the hook are recovered and logged.
+	StepHook StepHook
+	// BootstrapHook, when set, is told how many peers are connected after
+	// every bootstrap attempt, until the transfer starts, so that the
+	// wait for peers can be shown as it goes. Each event is also a
+	// StepBootstrapProgress step.
+	BootstrapHook BootstrapHook
+	// CompletionWebhook, when set, is sent a POST request with a JSON
+	// WebhookPayload once a download started with Start or StartName
+	// ended, whether it succeeded or not. The result holds the StatOut of
+	// successful downloads even when not asked for. Failed deliveries are
+	// retried and then only logged. With WebhookSecret the body is signed
+	// with HMAC-SHA256 in the WebhookSignatureHeader header.
+	CompletionWebhook string
+	WebhookSecret     string
+
+	// MaxCost, when positive, is the most a download may cost, in the unit
+	// of the rate (see EstimateCost). Downloads estimated to cost more are
+	// refused and downloads whose micropayments add up to more are stopped.
+	// Downloads whose cost can't be told, as the rate can't be read or the
+	// SCP receipts don't tell their amount, are refused or stopped too.
+	MaxCost float64
+
+	// MinThroughput, when positive, is the least bytes per second a
+	// download may receive, averaged over ThroughputWindow (30s by
+	// default). Downloads staying below it for longer than ThroughputGrace
+	// (2m by default) are stopped with a "Throughput too low" Out holding
+	// a ThroughputReport, so that they can be retried later instead of
+	// trickling until the timeout.
+	MinThroughput    int64
+	ThroughputWindow time.Duration
+	ThroughputGrace  time.Duration
+
+	// BlockSource, when set, is asked for the blocks of the content before
+	// the swarm, e.g. a local mirror. Blocks it serves are checked against
+	// their hash and don't cost micropayments. The number of blocks it had
+	// or not is reported in StatOut.
+	BlockSource ipfslite.BlockSource
+
+	// HTTPClient is used for the requests to the metadata service. By
+	// default every client has its own, which keeps its connections alive
+	// between requests. Clients created for many downloads can share one
+	// to also reuse the connections across them.
+	HTTPClient *http.Client
+
+	// MaxMetadataSize caps the bytes read from a response of the metadata
+	// service, 1MB by default, so that a broken or hostile endpoint can't
+	// exhaust the memory. Larger responses fail with "metadata response
+	// too large". Negative disables the limit.
+	MaxMetadataSize int64
+
+	// MaxRedirects is the number of redirects the requests to the metadata
+	// service follow, 3 by default. Negative follows none. It applies to
+	// HTTPClient too, unless it has its own CheckRedirect.
+	MaxRedirects int
+
+	// Clock, when set, replaces the real time for the timeouts and waits
+	// of downloads: the start of each attempt, getting more peers and
+	// settling the micropayments. It is meant for tests.
+	Clock Clock
+
+	// ExternalIPFunc, when set, returns the public IP of the client sent
+	// to the metadata service, instead of asking public IP echo services,
+	// e.g. to query a cloud metadata service or return a static address.
+	// On error, or once the context of the request is done, the address is
+	// reported unknown. It has no effect on the addresses of the node in
+	// the swarm, see ExternalAddrs.
+	ExternalIPFunc func(ctx context.Context) (string, error)
+
+	// DialTimeout bounds the time spent dialing a peer and KeepAlive sets
+	// the TCP keepalive period of outgoing connections. Zero keeps the
+	// libp2p defaults, which suit wired networks. On cellular networks a
+	// DialTimeout around 10s and a KeepAlive around 10s give up on dead
+	// leaders and detect dropped peers sooner.
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+
+	// DSCP marks the packets of the connections dialed to peers with the
+	// given differentiated services code point, 0 to 63, so that managed
+	// networks can prioritize the downloads, e.g. 8 (CS1) to keep them from
+	// starving latency sensitive traffic. It is applied on Linux and macOS
+	// and ignored elsewhere. Zero leaves the packets unmarked.
+	DSCP int
+
+	// AllowAddrs and DenyAddrs are CIDR ranges, e.g. "10.0.0.0/8", of the
+	// addresses which may or may not be dialed. When AllowAddrs is set only
+	// its ranges are dialed, DenyAddrs taking precedence. DenyPrivateAddrs
+	// and DenyRelayAddrs refuse private and relay circuit addresses. The
+	// rules apply to the leaders and to the peers found through the DHT.
+	AllowAddrs       []string
+	DenyAddrs        []string
+	DenyPrivateAddrs bool
+	DenyRelayAddrs   bool
+	// AllowPeer, when set, approves each peer connection: it is asked
+	// before dialing each address of a peer, leaders included, and once
+	// the peer of each connection is known, incoming ones included. The
+	// peers it refuses are neither dialed nor accepted, so that downloads
+	// only use vetted peers. It is called concurrently and should be fast,
+	// e.g. a lookup in a peer ID allowlist.
+	AllowPeer func(peer.ID, multiaddr.Multiaddr) bool
+
+	// BootstrapConcurrency bounds the number of leaders dialed at the same
+	// time. Zero dials all of them at once, which is the fastest. On
+	// low-memory devices a value such as 4 avoids the memory spike of many
+	// simultaneous handshakes. The time bootstrapping took is reported in
+	// StatOut.Timings.Bootstrap.
+	BootstrapConcurrency int
+	// PreferredTransports restricts the addresses of the leaders, and of
+	// the peers of previous downloads, dialed at bootstrap to those of the
+	// listed transports: "tcp", "ws", "wss", "quic" or "relay". Peers
+	// without any such address are dialed on all of theirs. On networks
+	// where some transport is blocked, e.g. UDP, this saves waiting for
+	// its dials to time out. The transport of each connection is reported
+	// in StatOut.BootstrapTransports. Empty dials every address.
+	PreferredTransports []string
+
+	// DestinationTemplate, when set, names the downloaded file once it is
+	// complete. It is a path relative to the directory of the destination
+	// in which {filename}, {hash}, {hash8} (the first 8 characters of the
+	// hash) and {date} (as 2006-01-02) are replaced, e.g.
+	// "{filename}-{hash8}.bin". Paths leaving the directory are refused.
+	DestinationTemplate string
+
+	// Mirrors are additional paths the downloaded file is written to in
+	// the same pass. MirrorPolicy decides what happens when writing to a
+	// mirror fails: MirrorAbort (default) fails the download,
+	// MirrorContinue goes on without that mirror.
+	Mirrors      []string
+	MirrorPolicy string
+
+	// DirConcurrency is the number of files fetched at the same time when
+	// the hash points to a directory. Defaults to 4.
+	DirConcurrency int
+	// DirContinueOnError keeps downloading the other files of a directory
+	// when one of them fails instead of failing the whole download. The
+	// failed files are reported in the result.
+	DirContinueOnError bool
+
+	// ParallelSegments, experimental, splits a single file in as many byte
+	// ranges fetched at the same time, each with its own reader, which can
+	// be faster on swarms with many peers. Segments are at least 4MiB.
+	// Files downloaded along with mirrors or a StateFile are fetched
+	// sequentially, as are files with StreamOrder. Zero or one fetches the
+	// file sequentially.
+	ParallelSegments int
+	// SparseAssembly writes each block of a file at its position in the
+	// destination as soon as it arrives, the file being extended to its
+	// full size first, instead of in order. A block late to come then
+	// doesn't hold back the others, which helps on swarms serving blocks
+	// out of order. The ranges written are kept in the StateFile to resume
+	// the download. It supersedes ParallelSegments and is not used along
+	// with mirrors nor with StreamOrder.
+	SparseAssembly bool
+
+	// VerifyContent checks the downloaded content once transferred: every
+	// block is hashed again and a file is compared with the content of its
+	// blocks. The metadata service is only told about the completion of
+	// downloads which pass, those which fail are discarded. Directories
+	// with failed files are not checked, and content streamed by
+	// StartOutput only has its blocks checked.
+	VerifyContent bool
+
+	// MinPeers is the number of peers the client keeps trying to connect
+	// to during a download, 5 by default. The download starts with fewer,
+	// as long as one is connected.
+	MinPeers int
+
+	// RebootstrapInterval is how often the peers connected are checked
+	// during a download, 30s by default. The leaders are dialed again only
+	// when fewer than MinPeers, or than the leaders if there are fewer of
+	// them, are connected. Negative never dials them again.
+	RebootstrapInterval time.Duration
+}
+
+// endpoints returns the addresses of the metadata service in the order
+// they are tried, first when set.
+func (l *LightClient) endpoints(first string) []string {
+	var all []string
+	if len(l.cfg.Endpoint) > 0 {
+		all = append(all, l.cfg.Endpoint)
+	}
+	all = append(all, l.cfg.Endpoints...)
+	if len(all) == 0 {
+		all = []string{ApiAddr}
+	}
+	if len(first) == 0 {
+		return all
+	}
+	ordered := []string{first}
+	for _, e := range all {
+		if e != first {
+			ordered = append(ordered, e)
+		}
+	}
+	return ordered
+}
+
+func (l *LightClient) minPeers() int {
+	if l.cfg.MinPeers <= 0 {
+		return peerThreshold
+	}
+	return l.cfg.MinPeers
+}
+
+// Permission of the created files when Config.FileMode is not set.
+const defaultFileMode os.FileMode = 0644
+
+func (l *LightClient) fileMode() os.FileMode {
+	if l.cfg.FileMode == 0 {
+		return defaultFileMode
+	}
+	return l.cfg.FileMode.Perm()
+}
+
+// dirMode is fileMode with execute allowed wherever reading is.
+func (l *LightClient) dirMode() os.FileMode {
+	mode := l.fileMode()
+	return mode | (mode&0444)>>2
+}
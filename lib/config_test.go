@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFileModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode, file, dir os.FileMode
+	}{
+		{0, 0644, 0755},
+		{0600, 0600, 0700},
+		{0640, 0640, 0750},
+		{0666, 0666, 0777},
+	} {
+		l := &LightClient{cfg: &Config{FileMode: tc.mode}}
+		if m := l.fileMode(); m != tc.file {
+			t.Errorf("%o: expected file mode %o got %o", tc.mode, tc.file, m)
+		}
+		if m := l.dirMode(); m != tc.dir {
+			t.Errorf("%o: expected dir mode %o got %o", tc.mode, tc.dir, m)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerContribution describes how much of a download was served by a
+// single peer.
+type PeerContribution struct {
+	Bytes     int64     `json:"bytes"`
+	Blocks    int       `json:"blocks"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// contributions attributes received blocks to the peers that served them.
+// It is plugged into the bitswap layer as an ipfslite.BlockNotifier.
+type contributions struct {
+	mtx   sync.Mutex
+	peers map[peer.ID]*PeerContribution
+
+	// blocks are only recorded when a manifest is wanted
+	blocks []ManifestBlock
+	seen   map[cid.Cid]struct{}
+	// onBlock, set before the download starts, is told about every block
+	// when the download is traced
+	onBlock func(peer.ID, cid.Cid, int)
+}
+
+func newContributions() *contributions {
+	return &contributions{
+		peers: make(map[peer.ID]*PeerContribution),
+	}
+}
+
+// recordBlocks makes the tracker keep the list of received blocks for the
+// manifest.
+func (c *contributions) recordBlocks() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.seen = make(map[cid.Cid]struct{})
+}
+
+func (c *contributions) BlockReceived(from peer.ID, k cid.Cid, size int) {
+	if c.onBlock != nil {
+		c.onBlock(from, k, size)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.seen != nil {
+		if _, ok := c.seen[k]; !ok {
+			c.seen[k] = struct{}{}
+			c.blocks = append(c.blocks, ManifestBlock{Cid: k.String(), Size: size, Peer: from.String()})
+		}
+	}
+
+	pc, ok := c.peers[from]
+	if !ok {
+		pc = &PeerContribution{FirstSeen: time.Now()}
+		c.peers[from] = pc
+	}
+	pc.Bytes += int64(size)
+	pc.Blocks++
+}
+
+func (c *contributions) snapshot() map[string]PeerContribution {
+	out := make(map[string]PeerContribution)
+	for p, pc := range c.byPeer() {
+		out[p.String()] = pc
+	}
+	return out
+}
+
+func (c *contributions) byPeer() map[peer.ID]PeerContribution {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	out := make(map[peer.ID]PeerContribution, len(c.peers))
+	for p, pc := range c.peers {
+		out[p] = *pc
+	}
+	return out
+}
+
+// total is the number of bytes received from all the peers.
+func (c *contributions) total() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var n int64
+	for _, pc := range c.peers {
+		n += pc.Bytes
+	}
+	return n
+}
+
+func (c *contributions) receivedBlocks() []ManifestBlock {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return append([]ManifestBlock{}, c.blocks...)
+}
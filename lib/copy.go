@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errSizeMismatch = errors.New("size mismatch")
+
+// copyExact copies src to dst and checks that exactly want bytes were
+// copied, so a truncated stream is not mistaken for a complete file.
+func copyExact(dst io.Writer, src io.Reader, want int64) (int64, error) {
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return n, err
+	}
+	if n != want {
+		return n, fmt.Errorf("%w: copied %d bytes, expected %d", errSizeMismatch, n, want)
+	}
+	return n, nil
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// shortReader claims to hold size bytes but ends early.
+type shortReader struct {
+	io.Reader
+	size int64
+}
+
+func (r *shortReader) Size() int64 { return r.size }
+
+func TestCopyExact(t *testing.T) {
+	buf := &bytes.Buffer{}
+	n, err := copyExact(buf, strings.NewReader("hello"), 5)
+	if err != nil || n != 5 {
+		t.Fatalf("unexpected result %d %v", n, err)
+	}
+
+	r := &shortReader{Reader: strings.NewReader("hel"), size: 5}
+	n, err = copyExact(&bytes.Buffer{}, r, r.Size())
+	if !errors.Is(err, errSizeMismatch) {
+		t.Fatalf("expected a size mismatch got %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 bytes copied got %d", n)
+	}
+}
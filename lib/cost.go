@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Cost is the estimated price of a download.
+type Cost struct {
+	// Amount is Rate multiplied by the size of the file in MB.
+	Amount float64 `json:"amount"`
+	// Currency is the unit following the amount in the rate, if any.
+	Currency string `json:"currency,omitempty"`
+}
+
+// EstimateCost estimates what downloading the file will cost. The rate is
+// read as a price per MB (1024*1024 bytes) optionally followed by a
+// currency, e.g. "0.002" or "0.002 SSC", and the estimate is
+//
+//	Amount = rate * size / (1024*1024)
+//
+// It is only an estimate: payments are made to the peers as blocks are
+// received, so protocol overhead and retransmissions may add to it. An
+// error is returned when the size is unknown or the rate can't be parsed.
+func EstimateCost(fi FileInfo) (Cost, error) {
+	if fi.Size <= 0 {
+		return Cost{}, errors.New("file size unknown")
+	}
+	perMB, currency, err := parseRate(fi.Rate)
+	if err != nil {
+		return Cost{}, err
+	}
+	return Cost{Amount: perMB * float64(fi.Size) / (1024 * 1024), Currency: currency}, nil
+}
+
+// parseRate splits rate into the price per MB and the currency.
+func parseRate(rate string) (float64, string, error) {
+	fields := strings.Fields(rate)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, "", fmt.Errorf("unsupported rate %q", rate)
+	}
+	perMB, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || perMB < 0 {
+		return 0, "", fmt.Errorf("unsupported rate %q", rate)
+	}
+	if len(fields) == 2 {
+		return perMB, fields[1], nil
+	}
+	return perMB, "", nil
+}
@@ -0,0 +1,36 @@
+package lib
+
+import "testing"
+
+func TestEstimateCost(t *testing.T) {
+	for _, tc := range []struct {
+		rate     string
+		size     int64
+		amount   float64
+		currency string
+		fail     bool
+	}{
+		{rate: "0.5", size: 2 * 1024 * 1024, amount: 1},
+		{rate: "2 SSC", size: 512 * 1024, amount: 1, currency: "SSC"},
+		{rate: "", size: 1024, fail: true},
+		{rate: "cheap", size: 1024, fail: true},
+		{rate: "-1", size: 1024, fail: true},
+		{rate: "1 SSC per MB", size: 1024, fail: true},
+		{rate: "1", size: 0, fail: true},
+	} {
+		c, err := EstimateCost(FileInfo{Rate: tc.rate, Size: tc.size})
+		if tc.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.rate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tc.rate, err)
+			continue
+		}
+		if c.Amount != tc.amount || c.Currency != tc.currency {
+			t.Errorf("%q: unexpected cost %+v", tc.rate, c)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestDatastoreNamespace(t *testing.T) {
+	shared := syncds.MutexWrap(datastore.NewMapDatastore())
+	newClient := func(ns string) *LightClient {
+		l, err := NewLightClient(".", "1m", false, &Config{Datastore: shared, DatastoreNamespace: ns})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	key := datastore.NewKey("/providers/x")
+
+	a, b := newClient(""), newClient("")
+	err := a.stateDS.Put(key, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.stateDS.Get(key); err != datastore.ErrNotFound {
+		t.Fatalf("state of another client visible: %v", err)
+	}
+	id, err := peer.IDFromPublicKey(a.pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := shared.Get(datastore.NewKey("/clients/" + id.Pretty()).Child(key)); err != nil || string(v) != "a" {
+		t.Fatalf("state not under the client namespace: %q %v", v, err)
+	}
+	// The blocks are shared
+	if a.ds != b.ds {
+		t.Fatal("blocks not shared")
+	}
+
+	c, d := newClient("batch"), newClient("batch")
+	err = c.stateDS.Put(key, []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.stateDS.Get(key); err != nil || string(v) != "c" {
+		t.Fatalf("state of the same namespace not shared: %q %v", v, err)
+	}
+}
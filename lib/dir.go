@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// Number of files of a directory downloaded at the same time by default.
+const defaultDirConcurrency = 4
+
+// dirEntry is a file or sub-directory of a directory download.
+type dirEntry struct {
+	path string
+	cid  cid.Cid
+	size int64
+	dir  bool
+}
+
+// listDir lists the files and sub-directories of the UnixFS directory c,
+// each directory coming before its content. Paths are relative to the
+// directory, starting with rel.
+func listDir(ctx context.Context, dserv ipld.DAGService, c cid.Cid, rel string) ([]dirEntry, error) {
+	node, err := dserv.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ufsio.NewDirectoryFromNode(dserv, node)
+	if err != nil {
+		return nil, err
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := []dirEntry{}
+	for _, lnk := range links {
+		if lnk.Name != filepath.Base(lnk.Name) || lnk.Name == ".." || lnk.Name == "." {
+			return nil, errors.New("invalid file name in directory: " + lnk.Name)
+		}
+		path := filepath.Join(rel, lnk.Name)
+		child, err := dserv.Get(ctx, lnk.Cid)
+		if err != nil {
+			return nil, err
+		}
+		switch nd := child.(type) {
+		case *merkledag.RawNode:
+			entries = append(entries, dirEntry{path: path, cid: lnk.Cid, size: int64(len(nd.RawData()))})
+		case *merkledag.ProtoNode:
+			fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+			if err != nil {
+				return nil, err
+			}
+			if fsn.IsDir() {
+				sub, err := listDir(ctx, dserv, lnk.Cid, path)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, dirEntry{path: path, cid: lnk.Cid, dir: true})
+				entries = append(entries, sub...)
+				continue
+			}
+			entries = append(entries, dirEntry{path: path, cid: lnk.Cid, size: int64(fsn.FileSize())})
+		default:
+			return nil, errors.New("unsupported node type for " + path)
+		}
+	}
+	return entries, nil
+}
+
+// walkDir lists the files of the UnixFS directory c, creating the
+// directories under root on the way.
+func walkDir(ctx context.Context, dserv ipld.DAGService, c cid.Cid, root, rel string, mode os.FileMode) ([]dirEntry, error) {
+	all, err := listDir(ctx, dserv, c, rel)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Join(root, rel), mode)
+	if err != nil {
+		return nil, err
+	}
+	files := []dirEntry{}
+	for _, e := range all {
+		if !e.dir {
+			files = append(files, e)
+			continue
+		}
+		err = os.MkdirAll(filepath.Join(root, e.path), mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// partDir turns the part file dst of a download which turned out to be a
+// directory into the directory holding its files, closing the part file
+// and the mirrors first. When the download is retried or resumed the part
+// path already is the directory, and the files in it are kept.
+func (l *LightClient) partDir(dst *os.File, w io.Writer) (string, error) {
+	root := dst.Name()
+	dst.Close()
+	if mw, ok := w.(*mirrorWriter); ok {
+		closeMirrors(mw.mirrors, false)
+	}
+	if existingDir(root) {
+		return root, nil
+	}
+	err := os.Remove(root)
+	if err != nil && !os.IsNotExist(err) {
+		return root, err
+	}
+	return root, os.Mkdir(root, l.dirMode())
+}
+
+func existingDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// countingWriter adds the number of bytes written through it to a shared
+// counter.
+type countingWriter struct {
+	io.Writer
+	count *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	atomic.AddInt64(w.count, int64(n))
+	return n, err
+}
+
+// downloadDir downloads the files listed by walkDir into root, fetching
+// up to Config.DirConcurrency of them at the same time and adding the
+// bytes written to done. Unless Config.DirContinueOnError is set, the
+// first failing file stops the download. It returns the files which
+// failed.
+func (l *LightClient) downloadDir(
+	ctx context.Context,
+	lite node,
+	root string,
+	entries []dirEntry,
+	done *int64,
+) ([]DestinationResult, error) {
+	dctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := l.cfg.DirConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultDirConcurrency
+	}
+	jobs := make(chan dirEntry)
+	var mtx sync.Mutex
+	failed := []DestinationResult{}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				err := l.fetchDirEntry(dctx, lite, e, root, done)
+				if err == nil {
+					continue
+				}
+				log.Errorf("Failed downloading %s Err: %s", e.path, err.Error())
+				mtx.Lock()
+				failed = append(failed, DestinationResult{Path: e.path, Error: err.Error()})
+				mtx.Unlock()
+				if !l.cfg.DirContinueOnError {
+					cancel()
+				}
+			}
+		}()
+	}
+	for _, e := range entries {
+		select {
+		case jobs <- e:
+			continue
+		case <-dctx.Done():
+		}
+		break
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(failed) > 0 && !l.cfg.DirContinueOnError {
+		return failed, errors.New(failed[0].Path + ": " + failed[0].Error)
+	}
+	if err := ctx.Err(); err != nil {
+		return failed, err
+	}
+	return failed, nil
+}
+
+// fetchDirEntry writes the file e under root, after what a previous
+// attempt already wrote of it. With Config.Durable the file and its
+// directory entry are synced to disk.
+func (l *LightClient) fetchDirEntry(ctx context.Context, lite node, e dirEntry, root string, done *int64) error {
+	path := filepath.Join(root, e.path)
+	offset := writtenSize(path, e.size)
+	if offset == e.size {
+		return nil
+	}
+	rsc, err := lite.GetFile(ctx, e.cid)
+	if err != nil {
+		return err
+	}
+	defer rsc.Close()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		_, err = rsc.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+	f, err := os.OpenFile(path, flags, l.fileMode())
+	if err != nil {
+		return err
+	}
+	pw := &pauseWriter{Writer: f, ctx: ctx, p: &l.paused}
+	_, err = copyExact(&countingWriter{Writer: pw, count: done}, rsc, e.size-offset)
+	if err == nil && l.cfg.Durable {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err == nil && l.cfg.Durable {
+		err = syncDir(filepath.Dir(f.Name()))
+	}
+	return err
+}
+
+// writtenSize returns the size of the file at path when it may be the
+// start of a file of size bytes, -1 when it has to be written anew.
+func writtenSize(path string, size int64) int64 {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() > size {
+		return -1
+	}
+	return fi.Size()
+}
@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// addTestDir adds a directory holding a.txt and sub/b.txt to dserv.
+func addTestDir(t testing.TB, dserv ipld.DAGService) ipld.Node {
+	ctx := context.Background()
+	sub := ufsio.NewDirectory(dserv)
+	b := merkledag.NewRawNode([]byte("world!"))
+	if err := sub.AddChild(ctx, "b.txt", b); err != nil {
+		t.Fatal(err)
+	}
+	subNode, err := sub.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := ufsio.NewDirectory(dserv)
+	a := merkledag.NewRawNode([]byte("hello"))
+	if err := top.AddChild(ctx, "a.txt", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := top.AddChild(ctx, "sub", subNode); err != nil {
+		t.Fatal(err)
+	}
+	topNode, err := top.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{a, b, subNode, topNode}); err != nil {
+		t.Fatal(err)
+	}
+	return topNode
+}
+
+// checkTestDir fails unless root holds the files of addTestDir.
+func checkTestDir(t *testing.T, root string) {
+	for name, want := range map[string]string{
+		"a.txt":                       "hello",
+		filepath.Join("sub", "b.txt"): "world!",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("%s: expected %q got %q %v", name, want, got, err)
+		}
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+	topNode := addTestDir(t, dserv)
+
+	root, err := ioutil.TempDir("", "walkdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	entries, err := walkDir(ctx, dserv, topNode.Cid(), root, "", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(entries))
+	}
+	if entries[0].path != "a.txt" || entries[0].size != 5 {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+	if entries[1].path != filepath.Join("sub", "b.txt") || entries[1].size != 6 {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+	if fi, err := os.Stat(filepath.Join(root, "sub")); err != nil || !fi.IsDir() {
+		t.Errorf("sub directory not created")
+	}
+}
+
+func TestDownloadDirKeepsWrittenFiles(t *testing.T) {
+	ctx := context.Background()
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	topNode := addTestDir(t, fake.DAGService)
+	root, err := ioutil.TempDir("", "downloaddir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	entries, err := walkDir(ctx, fake, topNode.Cid(), root, "", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	// Left by a previous attempt, a.txt complete and b.txt started
+	if err := ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(root, "sub", "b.txt")
+	if err := ioutil.WriteFile(b, []byte("wor"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Fetching a.txt again fails
+	fake.DAGService = &failingDAG{DAGService: fake.DAGService, bad: entries[0].cid}
+	var written int64
+	failed, err := l.downloadDir(ctx, fake, root, entries, &written)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("download failed %v %v", failed, err)
+	}
+	checkTestDir(t, root)
+	if written != 3 {
+		t.Fatalf("expected the rest of b.txt to be written got %d bytes", written)
+	}
+
+	// Longer than the content, the file is written anew
+	if err := ioutil.WriteFile(b, []byte("world!!!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.fetchDirEntry(ctx, fake, entries[1], root, &written); err != nil {
+		t.Fatal(err)
+	}
+	checkTestDir(t, root)
+
+	// A truncated stream isn't taken for the whole file
+	os.Remove(b)
+	longer := entries[1]
+	longer.size++
+	if err := l.fetchDirEntry(ctx, fake, longer, root, &written); !errors.Is(err, errSizeMismatch) {
+		t.Fatalf("expected a size mismatch got %v", err)
+	}
+}
+
+func TestPartDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	part := filepath.Join(dir, "photos"+partSuffix)
+	dst, err := os.Create(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrors, err := openMirrors([]string{filepath.Join(dir, "copy")}, part, 0, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &LightClient{cfg: &Config{}}
+	root, err := l.partDir(dst, &mirrorWriter{primary: dst, mirrors: mirrors})
+	if err != nil || root != part || !existingDir(part) {
+		t.Fatalf("part file not turned into a directory %v", err)
+	}
+	if _, err := dst.Write([]byte("x")); err == nil {
+		t.Fatal("part file left open")
+	}
+	if _, err := mirrors[0].file.Write([]byte("x")); err == nil {
+		t.Fatal("mirror left open")
+	}
+
+	// Retried, the files already downloaded are kept
+	if err := ioutil.WriteFile(filepath.Join(part, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err = l.partDir(dst, &mirrorWriter{primary: dst})
+	if err != nil || root != part {
+		t.Fatalf("part directory not reused %v", err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(part, "a.txt")); err != nil || string(got) != "hello" {
+		t.Fatalf("downloaded file lost %q %v", got, err)
+	}
+}
+
+func TestStartStalePartDir(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Hash = addTestDir(t, fake.DAGService).Cid().String()
+	part := l.destination + partSuffix
+	if err := os.MkdirAll(filepath.Join(part, "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	checkTestDir(t, l.destination)
+	if existingDir(filepath.Join(l.destination, "old")) {
+		t.Fatal("stale part directory kept")
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Fatalf("part directory left behind: %v", err)
+	}
+}
+
+func TestStartResumesPartDir(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Hash = addTestDir(t, fake.DAGService).Cid().String()
+	metadata, err := l.cfg.BYOMetadata.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.Cookie.Id = "cookie"
+	metadata.FetchedAt = time.Now()
+
+	// Left by an interrupted run
+	part := l.destination + partSuffix
+	if err := os.Mkdir(part, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(part, "a.txt"), []byte("he"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(filepath.Dir(l.destination), "state")
+	l.setSession(&session{sharable: "fzhnp4jhFnMUKVGMKpt4kBMrvX", destination: l.destination, metadata: metadata})
+	err = l.SaveState(state)
+	l.setSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.cfg.BYOMetadata = nil
+	l.cfg.StateFile = state
+	l.cfg.Endpoint = srv.URL
+	l.http = NewHTTPClient()
+	out := l.Start("fzhnp4jhFnMUKVGMKpt4kBMrvX", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	checkTestDir(t, l.destination)
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Fatalf("part directory left behind: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package lib
+
+// syncDir does nothing where directories can't be opened for syncing, the
+// file system flushes their entries along with its journal.
+func syncDir(dir string) error {
+	return nil
+}
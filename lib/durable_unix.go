@@ -0,0 +1,21 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package lib
+
+import "os"
+
+// syncDir flushes the entries of dir to disk, so that the files created or
+// renamed in it survive a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	cerr := d.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables read by the
+// litepeer command, e.g. SSLIGHT_TIMEOUT.
+const EnvPrefix = "SSLIGHT_"
+
+// EnvOptions are the options read by ReadEnv from environment variables
+// sharing a prefix, for deployments such as containers where variables are
+// easier to set than options. Options whose variable is not set keep their
+// zero value.
+type EnvOptions struct {
+	// Endpoint is <prefix>ENDPOINT, see Config.Endpoint. It may list
+	// comma separated addresses, the others being Config.Endpoints.
+	Endpoint string
+	// Timeout is <prefix>TIMEOUT, the timeout given to NewLightClient.
+	Timeout string
+	// ListenAddrs is <prefix>LISTEN_ADDRS, comma separated multiaddrs.
+	ListenAddrs []string
+	// CacheDir is <prefix>CACHE_DIR, see Config.CacheDir.
+	CacheDir string
+	// MinPeers is <prefix>MIN_PEERS, see Config.MinPeers.
+	MinPeers int
+}
+
+// ReadEnv returns the options set in the environment variables starting
+// with prefix. Invalid values are reported with the name of the variable.
+func ReadEnv(prefix string) (*EnvOptions, error) {
+	o := &EnvOptions{
+		Endpoint: os.Getenv(prefix + "ENDPOINT"),
+		CacheDir: os.Getenv(prefix + "CACHE_DIR"),
+	}
+	if v := os.Getenv(prefix + "TIMEOUT"); len(v) > 0 {
+		_, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sTIMEOUT %q", prefix, v)
+		}
+		o.Timeout = v
+	}
+	if v := os.Getenv(prefix + "LISTEN_ADDRS"); len(v) > 0 {
+		o.ListenAddrs = strings.Split(v, ",")
+	}
+	if v := os.Getenv(prefix + "MIN_PEERS"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %sMIN_PEERS %q", prefix, v)
+		}
+		o.MinPeers = n
+	}
+	return o, nil
+}
+
+// Apply sets the options of o on cfg, except those already set there, so
+// that options given explicitly take precedence over the environment.
+// Timeout is not part of the Config and is left to the caller.
+func (o *EnvOptions) Apply(cfg *Config) {
+	if len(cfg.Endpoint) == 0 && len(cfg.Endpoints) == 0 && len(o.Endpoint) > 0 {
+		endpoints := strings.Split(o.Endpoint, ",")
+		cfg.Endpoint, cfg.Endpoints = endpoints[0], endpoints[1:]
+	}
+	if len(cfg.ListenAddrs) == 0 {
+		cfg.ListenAddrs = o.ListenAddrs
+	}
+	if len(cfg.CacheDir) == 0 {
+		cfg.CacheDir = o.CacheDir
+	}
+	if cfg.MinPeers == 0 {
+		cfg.MinPeers = o.MinPeers
+	}
+}
@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// setEnv sets vars and returns a function unsetting them.
+func setEnv(vars map[string]string) func() {
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestReadEnv(t *testing.T) {
+	defer setEnv(map[string]string{
+		"SSTEST_ENDPOINT":     "http://127.0.0.1:8080",
+		"SSTEST_TIMEOUT":      "5m",
+		"SSTEST_LISTEN_ADDRS": "/ip4/0.0.0.0/tcp/0,/ip4/0.0.0.0/tcp/0/ws",
+		"SSTEST_CACHE_DIR":    "/var/cache/sslight",
+		"SSTEST_MIN_PEERS":    "2",
+	})()
+	o, err := ReadEnv("SSTEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &EnvOptions{
+		Endpoint:    "http://127.0.0.1:8080",
+		Timeout:     "5m",
+		ListenAddrs: []string{"/ip4/0.0.0.0/tcp/0", "/ip4/0.0.0.0/tcp/0/ws"},
+		CacheDir:    "/var/cache/sslight",
+		MinPeers:    2,
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Fatalf("expected %+v got %+v", want, o)
+	}
+
+	// Explicit options are kept
+	cfg := &Config{CacheDir: "/tmp/cache", MinPeers: 7}
+	o.Apply(cfg)
+	if cfg.Endpoint != want.Endpoint || len(cfg.ListenAddrs) != 2 || cfg.CacheDir != "/tmp/cache" || cfg.MinPeers != 7 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	// Several endpoints
+	cfg = &Config{}
+	(&EnvOptions{Endpoint: "http://a,http://b"}).Apply(cfg)
+	if cfg.Endpoint != "http://a" || !reflect.DeepEqual(cfg.Endpoints, []string{"http://b"}) {
+		t.Fatalf("unexpected endpoints %q %v", cfg.Endpoint, cfg.Endpoints)
+	}
+
+	o, err = ReadEnv("SSUNSET_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o, &EnvOptions{}) {
+		t.Fatalf("expected no options got %+v", o)
+	}
+}
+
+func TestReadEnvInvalid(t *testing.T) {
+	for _, vars := range []map[string]string{
+		{"SSTEST_TIMEOUT": "soon"},
+		{"SSTEST_MIN_PEERS": "many"},
+		{"SSTEST_MIN_PEERS": "-1"},
+	} {
+		unset := setEnv(vars)
+		_, err := ReadEnv("SSTEST_")
+		unset()
+		if err == nil {
+			t.Fatalf("expected %v to be refused", vars)
+		}
+	}
+}
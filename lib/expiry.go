@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// validUntil is when a sharable expires, zero when the service doesn't
+// tell. The service may give it as an RFC 3339 date or in seconds since
+// the epoch. Other values are ignored rather than failing the metadata.
+type validUntil struct {
+	time.Time
+}
+
+func (v *validUntil) UnmarshalJSON(b []byte) error {
+	var s string
+	if string(b) == "null" {
+		return nil
+	}
+	if json.Unmarshal(b, &s) == nil {
+		if len(s) == 0 {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			log.Warnf("Ignoring invalid expiry %q", s)
+			return nil
+		}
+		v.Time = t
+		return nil
+	}
+	var secs int64
+	if json.Unmarshal(b, &secs) == nil && secs > 0 {
+		v.Time = time.Unix(secs, 0)
+	}
+	return nil
+}
+
+func (v validUntil) MarshalJSON() ([]byte, error) {
+	if v.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(v.Time)
+}
+
+// expiredOut returns the Out refusing the download of metadata if its
+// sharable expired, nil otherwise.
+func (i *info) expiredOut() *Out {
+	until := i.Cookie.ValidUntil.Time
+	if until.IsZero() || time.Now().Before(until) {
+		return nil
+	}
+	log.Errorf("Sharable expired at %s", until)
+	return NewOut(linkExpired, "Sharable expired",
+		fmt.Sprintf("the sharable was valid until %s", until.Format(time.RFC3339)), nil)
+}
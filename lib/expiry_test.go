@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidUntil(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want time.Time
+	}{
+		{`{"Id": "cookie"}`, time.Time{}},
+		{`{"ValidUntil": null}`, time.Time{}},
+		{`{"ValidUntil": "2030-01-02T03:04:05Z"}`, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{`{"ValidUntil": 1893553445}`, time.Unix(1893553445, 0)},
+		// Unknown formats don't fail the metadata
+		{`{"ValidUntil": "tomorrow"}`, time.Time{}},
+		{`{"ValidUntil": {"days": 1}}`, time.Time{}},
+	} {
+		var c cookie
+		err := json.Unmarshal([]byte(tc.json), &c)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.json, err)
+		}
+		if !c.ValidUntil.Equal(tc.want) {
+			t.Fatalf("%s: expected %s got %s", tc.json, tc.want, c.ValidUntil.Time)
+		}
+	}
+}
+
+func TestFetchExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "file")
+	l := &LightClient{destination: dst, cfg: &Config{}}
+	metadata := &info{
+		Cookie: cookie{
+			Hash:       "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB",
+			ValidUntil: validUntil{time.Now().Add(-time.Hour)},
+		},
+		SwarmKey: testSwarmKey,
+	}
+	out := l.fetch(context.Background(), "sharable", metadata, false, false, nil, &Timings{})
+	if out.Status != linkExpired || out.Message != "Sharable expired" {
+		t.Fatalf("expected status %d got %+v", linkExpired, out)
+	}
+	if _, err := os.Stat(dst + partSuffix); !os.IsNotExist(err) {
+		t.Fatal("destination should not be created for an expired sharable")
+	}
+
+	// Not expired yet
+	metadata.Cookie.ValidUntil = validUntil{time.Now().Add(time.Hour)}
+	if out := metadata.expiredOut(); out != nil {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	fi := metadata.fileInfo()
+	if fi.ValidUntil == nil || !fi.ValidUntil.Equal(metadata.Cookie.ValidUntil.Time) {
+		t.Fatalf("expected the expiry in the file info got %v", fi.ValidUntil)
+	}
+}
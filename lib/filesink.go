@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// fileSink writes the content to the part file dst of a download to the
+// destination, and through w to the mirrors. A directory turns the part
+// file into the directory holding its files. Each attempt continues after
+// what is already in the part file.
+type fileSink struct {
+	l   *LightClient
+	dst *os.File
+	w   *mirrorWriter
+	fw  *firstWriteWriter
+
+	// Set by prepare for the attempt
+	c         cid.Cid
+	file      ufsio.DagReader
+	size      int64
+	offset    int64
+	total     int64
+	assembled *rangeSet
+	dir       string
+	entries   []dirEntry
+}
+
+func (s *fileSink) prepare(ctx context.Context, lite node, root *outputRoot) (int64, int64, error) {
+	s.c = root.cid
+	s.fw = &firstWriteWriter{Writer: s.w}
+	if root.file == nil {
+		dir, err := s.l.partDir(s.dst, s.w)
+		if err != nil {
+			return 0, 0, &sinkError{NewOut(destinationErr, "Failed creating destination directory", err.Error(), nil)}
+		}
+		if len(s.l.cfg.Mirrors) > 0 {
+			return 0, 0, errors.New("mirrors are not supported for directories")
+		}
+		s.dir = dir
+		s.entries, err = walkDir(ctx, lite, root.cid, dir, "", s.l.dirMode())
+		if err != nil {
+			return 0, 0, err
+		}
+		// Files written by a previous attempt are kept
+		var total, offset int64
+		for _, e := range s.entries {
+			total += e.size
+			if n := writtenSize(filepath.Join(dir, e.path), e.size); n > 0 {
+				offset += n
+			}
+		}
+		return total, offset, nil
+	}
+
+	s.file = root.file
+	s.size = root.size
+	var err error
+	s.offset = 0
+	s.assembled = s.l.sessionAssembly()
+	if s.assembled != nil {
+		s.offset = s.assembled.total()
+	} else {
+		s.offset, err = s.dst.Seek(0, io.SeekCurrent)
+		if err == nil && s.offset > 0 {
+			_, err = s.file.Seek(s.offset, io.SeekStart)
+		}
+	}
+	if err != nil {
+		return 0, 0, &sinkError{NewOut(internalError, "Failed resuming download", err.Error(), nil)}
+	}
+	s.total = int64(s.file.Size())
+	if s.total == 0 && s.size > 0 {
+		// The size told by the content is wrong
+		log.Warnf("Content reports no data, %d bytes expected", s.size)
+		s.total = unknownSize
+	}
+	return s.total, s.offset, nil
+}
+
+func (s *fileSink) write(ctx context.Context, lite node, done *int64) ([]DestinationResult, error) {
+	if len(s.dir) > 0 {
+		return s.l.downloadDir(ctx, lite, s.dir, s.entries, done)
+	}
+	size := s.total - s.offset
+	pw := &pauseWriter{Writer: s.fw, ctx: ctx, p: &s.l.paused}
+	segs := s.l.segmentCount(size, s.w)
+	var err error
+	switch {
+	case s.total < 0:
+		// Checked against the size told by the metadata, so that a
+		// truncated stream isn't taken for the whole content
+		_, err = copyExact(&countingWriter{Writer: pw, count: done}, s.file, s.size-s.offset)
+	case s.assembled != nil:
+		_, err = s.l.copySparse(ctx, lite, s.c, s.dst, s.total, s.assembled, done, s.fw)
+	case segs > 1:
+		_, err = s.l.copySegments(ctx, lite, s.c, s.dst, s.offset, size, segs, done, s.fw)
+	default:
+		_, err = copyExact(&countingWriter{Writer: pw, count: done}, s.file, size)
+	}
+	return nil, err
+}
+
+func (s *fileSink) verify(ctx context.Context, lite node, c cid.Cid) error {
+	err := verifyDownload(ctx, lite, c, s.dst.Name(), len(s.dir) > 0)
+	if err != nil && len(s.l.cfg.StateFile) > 0 {
+		// A resumed download starts over
+		s.dst.Truncate(0)
+		if s.assembled != nil {
+			s.assembled.reset()
+		}
+	}
+	return err
+}
+
+func (s *fileSink) firstWrite() time.Time {
+	if s.fw == nil {
+		return time.Time{}
+	}
+	return s.fw.first
+}
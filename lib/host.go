@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"errors"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/routing"
+	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
+)
+
+// NewLightClientWithHost returns a client whose downloads run over h and
+// dht, for applications already running a libp2p node, instead of setting
+// up a host of their own. ds, when not nil, holds the blocks as
+// Config.Datastore does. dht may be nil, blocks are then only fetched from
+// the peers connected.
+//
+// h has to be in the private network of the content already: setting it
+// up with the swarm key handed out by the metadata service is up to the
+// caller. The leaders refuse it otherwise and bootstrapping fails with
+// swarm_key_mismatch. The client is identified by the key of h, and the
+// settings of the host itself (ListenAddrs, ExternalAddrs, AutoNAT,
+// DHTMode, SecurityTransport, KeyType, KeepAlive and DSCP) are ignored.
+// The traffic of h isn't known to the client, so StatOut.Bandwidth is
+// nil. h and dht keep running once the downloads are done, closing them
+// is left to the caller.
+func NewLightClientWithHost(
+	h host.Host,
+	dht *dualdht.DHT,
+	ds datastore.Batching,
+	destination string,
+	timeout string,
+	jsonOut bool,
+	cfg *Config,
+) (*LightClient, error) {
+	if h == nil {
+		return nil, errors.New("no host given")
+	}
+	priv := h.Peerstore().PrivKey(h.ID())
+	if priv == nil {
+		return nil, errors.New("private key of the host not found")
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if ds != nil {
+		c := *cfg
+		c.Datastore = ds
+		// The state of the client follows the identity of the host
+		if len(c.DatastoreNamespace) == 0 {
+			c.DatastoreNamespace = h.ID().Pretty()
+		}
+		cfg = &c
+	}
+	l, err := NewLightClient(destination, timeout, jsonOut, cfg)
+	if err != nil {
+		return nil, err
+	}
+	l.privKey, l.pubKey = priv, priv.GetPublic()
+	l.shared = &sharedHost{h: h, dht: dht}
+	return l, nil
+}
+
+// sharedHost is the host given to NewLightClientWithHost.
+type sharedHost struct {
+	h   host.Host
+	dht *dualdht.DHT
+}
+
+// sharedNode is a node on a sharedHost. Closing it only stops its block
+// service, the host outliving the download.
+type sharedNode struct {
+	liteNode
+	cancel context.CancelFunc
+}
+
+func (n sharedNode) close() error {
+	n.cancel()
+	return nil
+}
+
+// setupSharedNode starts an ipfslite peer with cfg on the shared host of
+// the client.
+func (l *LightClient) setupSharedNode(ctx context.Context, cfg *ipfslite.Config) (node, error) {
+	var rt routing.Routing
+	if l.shared.dht != nil {
+		rt = l.shared.dht
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	lite, err := ipfslite.New(ctx, l.ds, l.shared.h, rt, cfg)
+	if err != nil {
+		cancel()
+		log.Errorf("Failed setting up p2p xfer node Err: %s", err.Error())
+		return nil, err
+	}
+	return sharedNode{liteNode: liteNode{Peer: lite}, cancel: cancel}, nil
+}
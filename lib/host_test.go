@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestNewLightClientWithHost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := mocknet.New(ctx).GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := datastore.NewMapDatastore()
+	l, err := NewLightClientWithHost(h, nil, ds, ".", "1m", false, &Config{Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.shared == nil || l.shared.h != h || l.ds != ds {
+		t.Fatal("downloads not run over the given host")
+	}
+	if id, err := peer.IDFromPublicKey(l.pubKey); err != nil || id != h.ID() {
+		t.Fatal("client not identified by the key of the host")
+	}
+	// The state is kept under the identity of the host
+	err = l.stateDS.Put(datastore.NewKey("k"), []byte("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := ds.Has(datastore.NewKey("/clients").ChildString(h.ID().Pretty()).ChildString("k")); !has {
+		t.Fatal("state not namespaced by the host")
+	}
+
+	if _, err := NewLightClientWithHost(nil, nil, nil, ".", "1m", false, nil); err == nil {
+		t.Fatal("expected a host to be required")
+	}
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxMetadataSize = 1 << 20
+	defaultMaxRedirects    = 3
+)
+
+var errMetadataTooLarge = errors.New("metadata response too large")
+
+// newHTTPClient returns the client used for the metadata service. Its
+// connections are kept alive, so the metadata and completion requests of
+// a download, and the requests of successive downloads, share them.
+func NewHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+func (l *LightClient) httpClient() *http.Client {
+	if l.http == nil {
+		return http.DefaultClient
+	}
+	return l.http
+}
+
+// limitRedirects returns the client used with the redirect policy of cfg.
+// A client of the config is copied rather than changed, as it may be
+// shared.
+func limitRedirects(c *http.Client, cfg *Config) *http.Client {
+	if c.CheckRedirect != nil {
+		return c
+	}
+	max := cfg.MaxRedirects
+	if max == 0 {
+		max = defaultMaxRedirects
+	}
+	limited := *c
+	limited.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if max < 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		return nil
+	}
+	return &limited
+}
+
+// readMetadata reads a response of the metadata service, failing with
+// errMetadataTooLarge past Config.MaxMetadataSize.
+func (l *LightClient) readMetadata(r io.Reader) ([]byte, error) {
+	max := l.cfg.MaxMetadataSize
+	if max == 0 {
+		max = defaultMaxMetadataSize
+	}
+	if max < 0 {
+		return ioutil.ReadAll(r)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > max {
+		return nil, errMetadataTooLarge
+	}
+	return buf, nil
+}
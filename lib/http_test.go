@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMetadataConnectionReuse(t *testing.T) {
+	var mtx sync.Mutex
+	conns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+fetchPath {
+			w.Write([]byte(`{"Cookie": {"Id": "cookie", "Hash": "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"}}`))
+		}
+	}))
+	srv.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			mtx.Lock()
+			conns++
+			mtx.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	old := ApiAddr
+	ApiAddr = srv.URL
+	defer func() { ApiAddr = old }()
+
+	l, err := NewLightClient(".", "1m", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		metadata, err := l.getInfo(context.Background(), "sharable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.updateInfo(metadata, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if conns != 1 {
+		t.Fatalf("expected a single connection got %d", conns)
+	}
+}
+
+func TestMetadataLimits(t *testing.T) {
+	meta := `{"Cookie": {"Id": "cookie", "Hash": "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("link") {
+		case "large":
+			w.Write([]byte(meta + strings.Repeat(" ", 2048)))
+		case "loop":
+			http.Redirect(w, r, r.URL.String(), http.StatusFound)
+		case "moved":
+			http.Redirect(w, r, "/"+fetchPath+"?link=sharable", http.StatusFound)
+		default:
+			w.Write([]byte(meta))
+		}
+	}))
+	defer srv.Close()
+	newClient := func(cfg *Config) *LightClient {
+		cfg.Endpoint = srv.URL
+		cfg.ExternalIPFunc = staticIP("203.0.113.7")
+		cfg.Quiet = true
+		l, err := NewLightClient(".", "1m", false, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	ctx := context.Background()
+
+	l := newClient(&Config{MaxMetadataSize: 1024})
+	if _, err := l.getInfo(ctx, "sharable"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.getInfo(ctx, "large"); err != errMetadataTooLarge {
+		t.Fatalf("expected the response to be too large got %v", err)
+	}
+	if _, err := newClient(&Config{MaxMetadataSize: -1}).getInfo(ctx, "large"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.getInfo(ctx, "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.getInfo(ctx, "loop"); err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Fatalf("expected the redirects to stop got %v", err)
+	}
+	if _, err := newClient(&Config{MaxRedirects: -1}).getInfo(ctx, "moved"); err == nil {
+		t.Fatal("expected the redirect not to be followed")
+	}
+	// A shared client is left as is
+	shared := &http.Client{}
+	newClient(&Config{HTTPClient: shared})
+	if shared.CheckRedirect != nil {
+		t.Fatal("shared client changed")
+	}
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// Key types accepted by Config.KeyType.
+const (
+	KeyEd25519   = "ed25519"
+	KeySecp256k1 = "secp256k1"
+	KeyRSA       = "rsa"
+)
+
+// Size of the RSA keys when Config.KeyBits is not set.
+const defaultRSABits = 2048
+
+// generateKey generates the identity of the client. The bit size only
+// applies to RSA keys, the other types having a fixed size.
+func generateKey(keyType string, bits int) (crypto.PrivKey, crypto.PubKey, error) {
+	switch keyType {
+	case "", KeyEd25519, KeySecp256k1:
+		if bits != 0 {
+			return nil, nil, fmt.Errorf("key bits only apply to %s keys, not %s", KeyRSA, keyTypeName(keyType))
+		}
+		typ := crypto.Ed25519
+		if keyType == KeySecp256k1 {
+			typ = crypto.Secp256k1
+		}
+		// The size is ignored for these types
+		return crypto.GenerateKeyPair(typ, 0)
+	case KeyRSA:
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		if bits < crypto.MinRsaKeyBits {
+			return nil, nil, fmt.Errorf("%s keys must have at least %d bits, got %d", KeyRSA, crypto.MinRsaKeyBits, bits)
+		}
+		return crypto.GenerateKeyPair(crypto.RSA, bits)
+	default:
+		return nil, nil, fmt.Errorf("invalid key type %q, expected %s, %s or %s", keyType, KeyEd25519, KeySecp256k1, KeyRSA)
+	}
+}
+
+func keyTypeName(keyType string) string {
+	if len(keyType) == 0 {
+		return KeyEd25519
+	}
+	return keyType
+}
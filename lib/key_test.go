@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"testing"
+
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+)
+
+func TestGenerateKey(t *testing.T) {
+	for _, tc := range []struct {
+		keyType string
+		bits    int
+		want    pb.KeyType
+	}{
+		{"", 0, pb.KeyType_Ed25519},
+		{KeyEd25519, 0, pb.KeyType_Ed25519},
+		{KeySecp256k1, 0, pb.KeyType_Secp256k1},
+		{KeyRSA, 0, pb.KeyType_RSA},
+		{KeyRSA, 3072, pb.KeyType_RSA},
+	} {
+		priv, pub, err := generateKey(tc.keyType, tc.bits)
+		if err != nil {
+			t.Fatalf("%s %d: %s", tc.keyType, tc.bits, err)
+		}
+		if priv.Type() != tc.want || pub.Type() != tc.want {
+			t.Fatalf("%s %d: expected %s got %s", tc.keyType, tc.bits, tc.want, priv.Type())
+		}
+	}
+	for _, tc := range []struct {
+		keyType string
+		bits    int
+	}{
+		{"", 2048},
+		{KeySecp256k1, 256},
+		{KeyRSA, 1024},
+		{"dsa", 0},
+	} {
+		_, _, err := generateKey(tc.keyType, tc.bits)
+		if err == nil {
+			t.Fatalf("%s %d accepted", tc.keyType, tc.bits)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Ports the node listens on by default.
+const (
+	defaultTCPPort = 45000
+	defaultWSPort  = 45001
+)
+
+// ipv6Available tells whether the host can listen on IPv6.
+var ipv6Available = func() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// defaultListenAddrs returns the addresses the node listens on when
+// Config.ListenAddrs is not set. IPv6 is left out on hosts without it.
+func defaultListenAddrs(tcpPort, wsPort int) ([]multiaddr.Multiaddr, error) {
+	formats := []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", tcpPort),
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", wsPort),
+	}
+	if ipv6Available() {
+		formats = append(formats, fmt.Sprintf("/ip6/::/tcp/%d", tcpPort))
+	} else {
+		log.Warn("IPv6 not available, listening on IPv4 only")
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(formats))
+	for _, f := range formats {
+		addr, err := multiaddr.NewMultiaddr(f)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
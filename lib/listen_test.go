@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestListenIPv4Only(t *testing.T) {
+	old := ipv6Available
+	ipv6Available = func() bool { return false }
+	defer func() { ipv6Available = old }()
+
+	addrs, err := defaultListenAddrs(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(multiaddr.P_IP6); err == nil {
+			t.Fatalf("unexpected IPv6 address %s", a)
+		}
+	}
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk, err := decodeSwarmKey(testSwarmKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, _, err := ipfslite.SetupLibp2pWithDHT(ctx, priv, psk, addrs, datastore.NewMapDatastore(), ipfslite.DHTModeOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if len(h.Addrs()) == 0 {
+		t.Fatal("host is not listening")
+	}
+}
+
+func TestExternalAddrsConfig(t *testing.T) {
+	l, err := NewLightClient(".", "1m", false, &Config{
+		AutoNAT:       "public",
+		ExternalAddrs: []string{"/ip4/203.0.113.7/tcp/45000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.autoNAT != ipfslite.AutoNATPublic || len(l.extAddrs) != 1 {
+		t.Fatalf("unexpected settings %q %v", l.autoNAT, l.extAddrs)
+	}
+	for _, cfg := range []*Config{
+		{AutoNAT: "off"},
+		{ExternalAddrs: []string{"203.0.113.7:45000"}},
+	} {
+		if _, err := NewLightClient(".", "1m", false, cfg); err == nil {
+			t.Fatalf("%+v accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Manifest lists the blocks which make up a download and the peers they
+// were received from. It is written to Config.ManifestFile.
+type Manifest struct {
+	Root        string          `json:"root"`
+	Destination string          `json:"destination"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Blocks      []ManifestBlock `json:"blocks"`
+}
+
+// ManifestBlock is a block received during a download. A block received
+// from several peers is listed once, with the first peer which served it.
+type ManifestBlock struct {
+	Cid  string `json:"cid"`
+	Size int    `json:"size"`
+	Peer string `json:"peer"`
+}
+
+func writeManifest(path string, m *Manifest, mode os.FileMode) error {
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestManifestBlocks(t *testing.T) {
+	p1, p2 := peer.ID("peer1"), peer.ID("peer2")
+	b1 := merkledag.NewRawNode([]byte("one")).Cid()
+	b2 := merkledag.NewRawNode([]byte("two")).Cid()
+
+	c := newContributions()
+	c.BlockReceived(p1, b1, 3)
+	if len(c.receivedBlocks()) != 0 {
+		t.Fatal("blocks recorded without a manifest")
+	}
+	c.recordBlocks()
+	c.BlockReceived(p1, b1, 3)
+	c.BlockReceived(p2, b1, 3)
+	c.BlockReceived(p2, b2, 3)
+	blocks := c.receivedBlocks()
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks got %v", blocks)
+	}
+	if blocks[0].Cid != b1.String() || blocks[0].Peer != p1.String() {
+		t.Fatalf("duplicate block not attributed to the first peer %v", blocks[0])
+	}
+
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "manifest.json")
+	err = writeManifest(path, &Manifest{Root: b1.String(), Blocks: blocks}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Root != b1.String() || len(m.Blocks) != 2 || m.Blocks[1].Size != 3 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Policies for a mirror failing during the download.
+const (
+	// MirrorAbort fails the whole download.
+	MirrorAbort = "abort"
+	// MirrorContinue drops the mirror and goes on with the others.
+	MirrorContinue = "continue"
+)
+
+// DestinationResult is the outcome of writing the download to one
+// destination.
+type DestinationResult struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+// mirror is an additional destination of a download.
+type mirror struct {
+	path string
+	file *os.File
+	err  error
+}
+
+// openMirrors creates the part files of the mirrors. When resuming, the
+// data already in the primary part file is copied to them first.
+func openMirrors(paths []string, primaryPart string, offset int64, mode os.FileMode) ([]*mirror, error) {
+	mirrors := []*mirror{}
+	for _, p := range paths {
+		f, err := os.OpenFile(p+partSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			closeMirrors(mirrors, false)
+			return nil, err
+		}
+		m := &mirror{path: p, file: f}
+		mirrors = append(mirrors, m)
+		if offset > 0 {
+			err = copyPrefix(f, primaryPart, offset)
+			if err != nil {
+				closeMirrors(mirrors, false)
+				return nil, err
+			}
+		}
+	}
+	return mirrors, nil
+}
+
+func copyPrefix(dst io.Writer, path string, n int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.CopyN(dst, src, n)
+	return err
+}
+
+// closeMirrors closes the mirrors and, if the download succeeded, moves the
+// part files of the healthy ones in place. Part files are removed
+// otherwise.
+func closeMirrors(mirrors []*mirror, ok bool) []DestinationResult {
+	results := []DestinationResult{}
+	for _, m := range mirrors {
+		err := m.file.Close()
+		if m.err == nil {
+			m.err = err
+		}
+		if ok && m.err == nil {
+			m.err = os.Rename(m.path+partSuffix, m.path)
+		}
+		res := DestinationResult{Path: m.path}
+		if m.err != nil {
+			res.Error = m.err.Error()
+		}
+		if !ok || m.err != nil {
+			os.Remove(m.path + partSuffix)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// mirrorWriter writes to the primary destination and to all the mirrors
+// which haven't failed yet. A failing mirror fails the write unless
+// keepGoing is set, in which case it is just dropped.
+type mirrorWriter struct {
+	primary   io.Writer
+	mirrors   []*mirror
+	keepGoing bool
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	n, err := w.primary.Write(p)
+	if err != nil {
+		return n, err
+	}
+	for _, m := range w.mirrors {
+		if m.err != nil {
+			continue
+		}
+		_, m.err = m.file.Write(p)
+		if m.err != nil {
+			log.Errorf("Failed writing to mirror %s Err: %s", m.path, m.err.Error())
+			if !w.keepGoing {
+				return n, fmt.Errorf("mirror %s: %s", m.path, m.err.Error())
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good")
+	bad := filepath.Join(dir, "bad")
+	mirrors, err := openMirrors([]string{good, bad}, "", 0, defaultFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make writes to the second mirror fail
+	mirrors[1].file.Close()
+
+	primary := &bytes.Buffer{}
+	w := &mirrorWriter{primary: primary, mirrors: mirrors}
+	if _, err := w.Write([]byte("hello")); err == nil {
+		t.Fatal("expected failing mirror to abort the write")
+	}
+
+	w.keepGoing = true
+	mirrors[1].err = nil
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal("expected write to go on without the failing mirror", err)
+	}
+
+	results := closeMirrors(mirrors, true)
+	if len(results) != 2 || results[0].Error != "" || results[1].Error == "" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if _, err := os.Stat(bad + partSuffix); !os.IsNotExist(err) {
+		t.Fatal("expected part file of failed mirror to be removed")
+	}
+	buf, err := ioutil.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hellohello" {
+		t.Fatalf("unexpected mirror content %q", buf)
+	}
+}
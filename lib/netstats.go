@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"encoding/json"
+	"runtime"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// NetworkStats is a snapshot of what the node of a download holds, taken
+// when the download fails with Config.NetworkStatsOnFailure. The libp2p
+// version in use has no resource manager to ask for its limits, so these
+// are the counts telling which resource ran out.
+type NetworkStats struct {
+	Peers               int `json:"peers"`
+	InboundConnections  int `json:"inbound_connections"`
+	OutboundConnections int `json:"outbound_connections"`
+	Streams             int `json:"streams"`
+	// StreamsByProtocol counts the open streams per protocol, streams
+	// still negotiating it being counted under "".
+	StreamsByProtocol map[string]int `json:"streams_by_protocol"`
+	// HeapBytes and Goroutines are those of the whole process.
+	HeapBytes  uint64 `json:"heap_bytes"`
+	Goroutines int    `json:"goroutines"`
+}
+
+func networkStats(h host.Host) NetworkStats {
+	st := NetworkStats{
+		Peers:             len(h.Network().Peers()),
+		StreamsByProtocol: map[string]int{},
+		Goroutines:        runtime.NumGoroutine(),
+	}
+	for _, c := range h.Network().Conns() {
+		if c.Stat().Direction == network.DirInbound {
+			st.InboundConnections++
+		} else {
+			st.OutboundConnections++
+		}
+		for _, s := range c.GetStreams() {
+			st.Streams++
+			st.StreamsByProtocol[string(s.Protocol())]++
+		}
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	st.HeapBytes = mem.HeapAlloc
+	return st
+}
+
+// reportNetworkStats logs the NetworkStats of h if res is a failure, and
+// sets them as the data of res if it has none.
+func (l *LightClient) reportNetworkStats(h host.Host, res *Out) {
+	if res == nil || res.Status == success || res.Status == partialContent {
+		return
+	}
+	st := networkStats(h)
+	buf, _ := json.Marshal(st)
+	log.Warnf("Network stats on failure: %s", buf)
+	if res.Data == nil {
+		res.Data = st
+	}
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/StreamSpace/scp"
+	"github.com/StreamSpace/scp/engine"
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// node is what a download uses of the ipfslite peer. Tests replace it with
+// an in-memory fake through LightClient.newNode.
+type node interface {
+	ipld.DAGService
+	Bootstrap(peers []peer.AddrInfo) int
+	BootstrapWithError(peers []peer.AddrInfo) (int, error)
+	BootstrapReport(peers []peer.AddrInfo) ([]ipfslite.DialResult, error)
+	GetFile(ctx context.Context, c cid.Cid) (ufsio.DagReader, error)
+	ResolveName(ctx context.Context, name string) (cid.Cid, error)
+
+	host() host.Host
+	onPeerConnected(f func())
+	bootstrapDHT(ctx context.Context) error
+	microPayments() ([]*engine.SSReceipt, error)
+	// paid returns the total of the micropayments made so far.
+	paid() (float64, error)
+	flushPayments(ctx context.Context) error
+	// paymentChannels returns the channels with the peers connected.
+	paymentChannels() ([]ChannelState, error)
+	blockSourceStats() (hits, misses int64)
+	// bandwidth returns the traffic of the node, nil if not counted.
+	bandwidth() *BandwidthReport
+	// close stops the host and the DHT of the node.
+	close() error
+}
+
+// nodeFactory sets up the node of a download. Received blocks have to be
+// reported to contrib.
+type nodeFactory func(ctx context.Context, metadata *info, psk pnet.PSK, contrib *contributions) (node, error)
+
+// liteNode is the node backed by an ipfslite peer.
+type liteNode struct {
+	*ipfslite.Peer
+	// bw counts the traffic of the host, nil if it wasn't set up by the
+	// client
+	bw *metrics.BandwidthCounter
+}
+
+func (n liteNode) host() host.Host {
+	return n.Host
+}
+
+func (n liteNode) onPeerConnected(f func()) {
+	n.Scp.AddHook(scp.PeerConnected, f)
+}
+
+func (n liteNode) bootstrapDHT(ctx context.Context) error {
+	return n.Dht.Bootstrap(ctx)
+}
+
+func (n liteNode) microPayments() ([]*engine.SSReceipt, error) {
+	return n.Scp.GetMicroPayments()
+}
+
+func (n liteNode) blockSourceStats() (int64, int64) {
+	return n.BlockSourceStats()
+}
+
+func (n liteNode) bandwidth() *BandwidthReport {
+	return bandwidthReport(n.bw)
+}
+
+func (n liteNode) close() error {
+	// The block service stops along with the context of the peer
+	if dht, ok := n.Dht.(io.Closer); ok {
+		dht.Close()
+	}
+	return n.Host.Close()
+}
+
+// paymentFlusher is implemented by SCP engines able to send the pending
+// micropayments on demand.
+type paymentFlusher interface {
+	Flush(ctx context.Context) error
+}
+
+var errFlushUnsupported = errors.New("SCP engine doesn't support flushing payments")
+
+func (n liteNode) flushPayments(ctx context.Context) error {
+	f, ok := interface{}(n.Scp).(paymentFlusher)
+	if !ok {
+		return errFlushUnsupported
+	}
+	return f.Flush(ctx)
+}
+
+// setupNode is the nodeFactory used outside of tests. It starts a libp2p
+// host in the swarm of psk, unless the client has a shared host, and an
+// ipfslite peer on top of it.
+func (l *LightClient) setupNode(
+	ctx context.Context,
+	metadata *info,
+	psk pnet.PSK,
+	contrib *contributions,
+) (node, error) {
+	cfg := &ipfslite.Config{
+		Mtdt: map[string]interface{}{
+			"download_index": metadata.Cookie.DownloadIndex,
+		},
+		Rate:                  metadata.Rate,
+		BlockNotifier:         contrib,
+		MaxConcurrentRequests: l.cfg.MaxConcurrentRequests,
+		MaxMemory:             l.cfg.MaxMemory,
+		StreamOrder:           l.cfg.StreamOrder,
+		DialTimeout:           l.cfg.DialTimeout,
+		SwarmKey:              psk,
+		BootstrapConcurrency:  l.cfg.BootstrapConcurrency,
+		BlockSource:           l.cfg.BlockSource,
+	}
+	if l.shared != nil {
+		return l.setupSharedNode(ctx, cfg)
+	}
+	listenAddrs := l.listenAddrs
+	if len(listenAddrs) == 0 {
+		var err error
+		listenAddrs, err = defaultListenAddrs(defaultTCPPort, defaultWSPort)
+		if err != nil {
+			log.Errorf("Failed building listen addresses Err: %s", err.Error())
+			return nil, err
+		}
+	}
+	// Closing the host closes its connection manager, so each download
+	// gets its own rather than the one shared by Libp2pOptionsExtra
+	libp2pOpts := ipfslite.Libp2pOptions(libp2p.DefaultTransports)
+	if l.cfg.DialTimeout > 0 || l.cfg.KeepAlive > 0 || l.cfg.DSCP > 0 {
+		libp2pOpts = ipfslite.Libp2pOptions(ipfslite.TCPTransportOptions(ipfslite.TCPConfig{
+			ConnectTimeout: l.cfg.DialTimeout,
+			KeepAlive:      l.cfg.KeepAlive,
+			DSCP:           l.cfg.DSCP,
+		}))
+	}
+	bw := metrics.NewBandwidthCounter()
+	libp2pOpts = append(append([]libp2p.Option{}, libp2pOpts...), l.security.Option(), l.autoNAT.Option(), libp2p.BandwidthReporter(bw))
+	if len(l.extAddrs) > 0 {
+		libp2pOpts = append(libp2pOpts, ipfslite.ExternalAddrs(l.extAddrs))
+	}
+	if l.addrFilter != nil {
+		libp2pOpts = append(libp2pOpts, l.addrFilter.Option())
+	}
+	h, dht, err := ipfslite.SetupLibp2pWithDHT(
+		ctx,
+		l.privKey,
+		psk,
+		listenAddrs,
+		l.stateDS,
+		l.dhtMode,
+		libp2pOpts...,
+	)
+	if err != nil {
+		log.Errorf("Failed setting up libp2p node Err: %s", err.Error())
+		return nil, err
+	}
+	var rt routing.Routing
+	if dht != nil {
+		rt = dht
+	}
+	lite, err := ipfslite.New(ctx, l.ds, h, rt, cfg)
+	if err != nil {
+		log.Errorf("Failed setting up p2p xfer node Err: %s", err.Error())
+		return nil, err
+	}
+	return liteNode{Peer: lite, bw: bw}, nil
+}
@@ -0,0 +1,485 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StreamSpace/scp/engine"
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/importer"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// fakeNode serves content from memory. Bootstrap returns the successive
+// values of peers, the last one being repeated.
+type fakeNode struct {
+	ipld.DAGService
+	h host.Host
+
+	mtx        sync.Mutex
+	peers      []int
+	bootstraps int
+	flushed    bool
+	closed     bool
+	channels   []ChannelState
+	bw         *BandwidthReport
+	// payments returns the micropayments made so far, none when nil
+	payments func() (float64, error)
+}
+
+func (f *fakeNode) Bootstrap([]peer.AddrInfo) int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	n := f.peers[len(f.peers)-1]
+	if f.bootstraps < len(f.peers) {
+		n = f.peers[f.bootstraps]
+	}
+	f.bootstraps++
+	return n
+}
+
+func (f *fakeNode) BootstrapWithError(peers []peer.AddrInfo) (int, error) {
+	return f.Bootstrap(peers), nil
+}
+
+// BootstrapReport connects the number of peers returned by Bootstrap, or
+// reports every peer as refusing the connection if none.
+func (f *fakeNode) BootstrapReport(peers []peer.AddrInfo) ([]ipfslite.DialResult, error) {
+	n := f.Bootstrap(peers)
+	results := make([]ipfslite.DialResult, n)
+	if n == 0 {
+		for _, p := range peers {
+			results = append(results, ipfslite.DialResult{
+				ID:      p.ID,
+				Err:     errors.New("connection refused"),
+				Failure: ipfslite.FailureRefused,
+			})
+		}
+	}
+	return results, nil
+}
+
+func (f *fakeNode) GetFile(ctx context.Context, c cid.Cid) (ufsio.DagReader, error) {
+	nd, err := f.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return ufsio.NewDagReader(ctx, nd, f)
+}
+
+func (f *fakeNode) ResolveName(context.Context, string) (cid.Cid, error) {
+	return cid.Undef, errors.New("names not supported")
+}
+
+func (f *fakeNode) host() host.Host                             { return f.h }
+func (f *fakeNode) onPeerConnected(func())                      {}
+func (f *fakeNode) bootstrapDHT(context.Context) error          { return nil }
+func (f *fakeNode) microPayments() ([]*engine.SSReceipt, error) { return nil, nil }
+func (f *fakeNode) flushPayments(context.Context) error         { f.flushed = true; return nil }
+func (f *fakeNode) paymentChannels() ([]ChannelState, error)    { return f.channels, nil }
+func (f *fakeNode) blockSourceStats() (int64, int64)            { return 0, 0 }
+func (f *fakeNode) bandwidth() *BandwidthReport                 { return f.bw }
+func (f *fakeNode) close() error                                { f.closed = true; return f.h.Close() }
+
+func (f *fakeNode) paid() (float64, error) {
+	if f.payments == nil {
+		return 0, nil
+	}
+	return f.payments()
+}
+
+// newFakeClient returns a client downloading to a temporary directory from
+// a fakeNode holding size random bytes.
+func newFakeClient(t testing.TB, size int, peers ...int) (*LightClient, *fakeNode, []byte, func()) {
+	dir, err := ioutil.TempDir("", "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mn := mocknet.New(context.Background())
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, size)
+	rand.Read(data)
+	dserv := mdtest.Mock()
+	root, err := importer.BuildDagFromReader(dserv, chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeNode{DAGService: dserv, h: h, peers: peers}
+	l := &LightClient{
+		destination: filepath.Join(dir, "file"),
+		timeout:     time.Minute,
+		cfg: &Config{
+			BYOMetadata: &Metadata{Hash: root.Cid().String(), SwarmKey: string(testSwarmKey)},
+			SettleWait:  -1,
+		},
+		newNode: func(context.Context, *info, pnet.PSK, *contributions) (node, error) {
+			return fake, nil
+		},
+	}
+	return l, fake, data, func() {
+		h.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// getManyVia gets every key with get, all at the same time, and returns the
+// blocks as they arrive like bitswap does. The DAGs wrapping another one in
+// tests implement GetMany with it so that only their Get differs.
+func getManyVia(ctx context.Context, keys []cid.Cid, get func(context.Context, cid.Cid) (ipld.Node, error)) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(keys))
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func(k cid.Cid) {
+			defer wg.Done()
+			nd, err := get(ctx, k)
+			out <- &ipld.NodeOption{Node: nd, Err: err}
+		}(k)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func TestStartWithFakeNode(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 10*1024*1024, 3)
+	defer done()
+
+	var mtx sync.Mutex
+	steps := map[StepCode]bool{}
+	l.cfg.StepHook = func(code StepCode, message string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		steps[code] = true
+	}
+	out := l.Start("", false, true, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	for _, code := range []StepCode{StepMetadata, StepAttempt, StepAgentReady, StepBootstrapped, StepDownloading, StepDownloadStarted, StepFinishing} {
+		if !steps[code] {
+			t.Errorf("step %s not reached", code)
+		}
+	}
+	st, ok := out.Data.(StatOut)
+	if !ok || st.Bytes != int64(len(data)) {
+		t.Fatalf("unexpected stats %+v", out.Data)
+	}
+	// Counted from the start, before the metadata
+	if st.TimeToFirstByte <= 0 || st.TimeToFirstByte < st.Timings.Metadata+st.Timings.FirstByte {
+		t.Fatalf("unexpected time to first byte %s with %s", st.TimeToFirstByte, st.Timings)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded content differs")
+	}
+}
+
+func TestStartNetworkBytes(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	cached := fake.DAGService
+	l.newNode = func(_ context.Context, _ *info, _ pnet.PSK, contrib *contributions) (node, error) {
+		// Blocks from the network are reported to contrib
+		fake.DAGService = &slowDAG{DAGService: cached, contrib: contrib, from: fake.h.ID()}
+		return fake, nil
+	}
+	out := l.Start("", false, true, nil)
+	st, ok := out.Data.(StatOut)
+	if !ok || st.NetworkBytes < 1024*1024 || st.ServedFromCache {
+		t.Fatalf("expected the content from the network got %+v", out.Data)
+	}
+
+	// Blocks already in the datastore are not received
+	fake.DAGService = cached
+	l.newNode = func(context.Context, *info, pnet.PSK, *contributions) (node, error) {
+		return fake, nil
+	}
+	out = l.Start("", false, true, nil)
+	st, ok = out.Data.(StatOut)
+	if !ok || st.NetworkBytes != 0 || !st.ServedFromCache {
+		t.Fatalf("expected the content from the cache got %+v", out.Data)
+	}
+}
+
+func TestStartFlushPayments(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.SettleWait = 0
+	l.cfg.FlushPayments = true
+
+	start := time.Now()
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	if !fake.flushed {
+		t.Fatal("payments not flushed")
+	}
+	if time.Since(start) >= defaultSettleWait {
+		t.Fatal("waited although payments were flushed")
+	}
+}
+
+func TestStartDurable(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.Durable = true
+
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded content differs")
+	}
+}
+
+func TestStartMissingContent(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	// Content the fake doesn't have
+	l.cfg.BYOMetadata.Hash = "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"
+	l.cfg.GetFileRetries = 2
+
+	out := l.Start("", false, false, nil)
+	if out.Status != internalError {
+		t.Fatalf("expected a failure got %+v", out)
+	}
+	// Three attempts, each bootstrapping once plus once per retry
+	if fake.bootstraps != 9 {
+		t.Fatalf("expected 9 bootstraps got %d", fake.bootstraps)
+	}
+	if _, err := os.Stat(l.destination + partSuffix); !os.IsNotExist(err) {
+		t.Fatal("part file not removed")
+	}
+}
+
+func TestStartNetworkStatsOnFailure(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Hash = "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"
+	l.cfg.GetFileRetries = -1
+	l.cfg.NetworkStatsOnFailure = true
+
+	out := l.Start("", false, false, nil)
+	st, ok := out.Data.(NetworkStats)
+	if out.Status != internalError || !ok || st.Goroutines == 0 || st.StreamsByProtocol == nil {
+		t.Fatalf("expected the network stats of the failure got %+v", out)
+	}
+}
+
+// goroutinesSettle waits for the number of goroutines to go back to at
+// most max and returns the last count.
+func goroutinesSettle(max int) int {
+	n := runtime.NumGoroutine()
+	for i := 0; i < 50 && n > max; i++ {
+		time.Sleep(100 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	return n
+}
+
+func TestStartClosesNode(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	// Every download gets a host of its own, as with setupNode
+	mn := mocknet.New(context.Background())
+	var nodes []*fakeNode
+	l.newNode = func(context.Context, *info, pnet.PSK, *contributions) (node, error) {
+		h, err := mn.GenPeer()
+		if err != nil {
+			return nil, err
+		}
+		n := &fakeNode{DAGService: fake.DAGService, h: h, peers: []int{3}}
+		nodes = append(nodes, n)
+		return n, nil
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		out := l.Start("", false, false, nil)
+		if out.Status != success {
+			t.Fatalf("download failed %+v", out)
+		}
+	}
+	for _, n := range nodes {
+		if !n.closed {
+			t.Fatal("node not closed")
+		}
+	}
+	if n := goroutinesSettle(before + 5); n > before+5 {
+		t.Fatalf("goroutines leaked, %d before %d after", before, n)
+	}
+}
+
+// openFDs returns the number of files the process has open, -1 where it
+// can't be told.
+func openFDs() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+func TestStartReleasesLiteNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	psk, err := decodeSwarmKey(testSwarmKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+	h, _, err := ipfslite.SetupLibp2pWithDHT(ctx, priv, psk, []multiaddr.Multiaddr{listen}, nil, ipfslite.DHTModeOff, ipfslite.Libp2pOptions(libp2p.DefaultTransports)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	leader, err := ipfslite.New(ctx, syncds.MutexWrap(datastore.NewMapDatastore()), h, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	root, err := importer.BuildDagFromReader(leader, chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "leak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	download := func(i int) {
+		dst := filepath.Join(dir, fmt.Sprint(i))
+		l, err := NewLightClient(dst, "1m", false, &Config{
+			BYOMetadata: &Metadata{
+				Hash:     root.Cid().String(),
+				Leaders:  []string{fmt.Sprintf("%s/p2p/%s", h.Addrs()[0], h.ID())},
+				SwarmKey: string(testSwarmKey),
+			},
+			ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"},
+			DHTMode:     "off",
+			SettleWait:  -1,
+			Quiet:       true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := l.Start("", false, false, nil)
+		if out.Status != success {
+			t.Fatalf("download failed %+v", out)
+		}
+		got, err := ioutil.ReadFile(dst)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("unexpected download %v", err)
+		}
+		os.Remove(dst)
+	}
+	// Whatever is set up once for the process is left out
+	download(0)
+	before, fds := runtime.NumGoroutine(), openFDs()
+	for i := 1; i <= 10; i++ {
+		download(i)
+	}
+	if n := goroutinesSettle(before + 5); n > before+5 {
+		t.Fatalf("goroutines leaked, %d before %d after", before, n)
+	}
+	if n := openFDs(); n > fds+3 {
+		t.Fatalf("files leaked, %d open before %d after", fds, n)
+	}
+}
+
+func TestSetupNodeConnManager(t *testing.T) {
+	l, err := NewLightClient(".", "1m", false, &Config{
+		ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"},
+		DHTMode:     "off",
+		Quiet:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := (&Metadata{Hash: "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB", SwarmKey: string(testSwarmKey)}).info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk, err := decodeSwarmKey(testSwarmKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, err := l.setupNode(ctx, metadata, psk, newContributions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := first.host().ConnManager()
+	first.close()
+	// Closed along with the first host
+	second, err := l.setupNode(ctx, metadata, psk, newContributions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.close()
+	if second.host().ConnManager() == cm {
+		t.Fatal("connection manager shared between downloads")
+	}
+}
+
+func TestStartIntoDirectory(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Filename = "file.bin"
+	dir := filepath.Dir(l.destination)
+	l.destination = dir
+
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("download not named after the content %v", err)
+	}
+}
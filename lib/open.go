@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// ReadSeekCloser is the content returned by Open. Size is the size of the
+// whole file.
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+	Size() uint64
+}
+
+// Open starts a node for sharable and returns a reader of its content
+// instead of writing it to the destination. Blocks are fetched as they
+// are read, so seeking to a range only fetches that range. Reads fail
+// once ctx is done and closing the reader stops the node. Directories
+// can't be opened. With Config.StreamOrder the blocks fetched ahead of the
+// reader arrive in order, so that a player reading it can keep up.
+func (l *LightClient) Open(ctx context.Context, sharable string) (ReadSeekCloser, *FileInfo, error) {
+	// The node lives as long as the reader
+	ctx, cancel := context.WithCancel(ctx)
+	lite, metadata, c, count, out := l.startNode(ctx, sharable)
+	if out != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("%s: %s", out.Message, out.Details)
+	}
+	if count == 0 {
+		// Reads would only wait for ctx
+		lite.close()
+		cancel()
+		return nil, nil, fmt.Errorf("no peers connected: none of the %d leaders could be connected", len(metadata.Cookie.Leaders))
+	}
+	rsc, err := l.getFile(ctx, lite, c, func() int {
+		return lite.Bootstrap(l.dialable(metadata.bootstrapPeers()))
+	})
+	if err != nil {
+		lite.close()
+		cancel()
+		if err == ufsio.ErrIsDir {
+			return nil, nil, fmt.Errorf("%s is a directory", c)
+		}
+		return nil, nil, fmt.Errorf("failed getting file: %s", err.Error())
+	}
+	fi := metadata.fileInfo()
+	return &openReader{
+		DagReader: rsc,
+		l:         l,
+		lite:      lite,
+		metadata:  metadata,
+		start:     time.Now(),
+		cancel:    cancel,
+	}, &fi, nil
+}
+
+// startNode gets the metadata of sharable, or uses Config.BYOMetadata, and
+// returns a node of its swarm bootstrapped with the leaders, along with
+// the CID of the content and the number of leaders connected. The node
+// stops with ctx. On failure the Out to return is set instead.
+func (l *LightClient) startNode(ctx context.Context, sharable string) (node, *info, cid.Cid, int, *Out) {
+	metadata, out := l.sharableInfo(ctx, sharable)
+	if out != nil {
+		return nil, nil, cid.Undef, 0, out
+	}
+	if out := metadata.expiredOut(); out != nil {
+		return nil, nil, cid.Undef, 0, out
+	}
+	c, err := cid.Decode(metadata.Cookie.Hash)
+	if err != nil {
+		return nil, nil, cid.Undef, 0, NewOut(internalError, "Failed decoding filehash provided", err.Error(), nil)
+	}
+	psk, err := decodeSwarmKey(metadata.SwarmKey, l.cfg.AllowedSwarmFingerprints)
+	if err != nil {
+		log.Errorf("Refusing swarm key Err: %s", err.Error())
+		return nil, nil, cid.Undef, 0, NewOut(internalError, "Swarm key not accepted", err.Error(), nil)
+	}
+
+	newNode := l.newNode
+	if newNode == nil {
+		newNode = l.setupNode
+	}
+	lite, err := newNode(ctx, metadata, psk, newContributions())
+	if err != nil {
+		return nil, nil, cid.Undef, 0, NewOut(internalError, "Failed setting up light client", err.Error(), nil)
+	}
+	// STEP : Download agent created
+	l.step(StepAgentReady, success, "Download agent initialized")
+	results, err := lite.BootstrapReport(l.dialable(metadata.bootstrapPeers()))
+	if err == ipfslite.ErrSwarmKeyMismatch {
+		lite.close()
+		log.Errorf("Failed bootstrapping Err: %s", err.Error())
+		return nil, nil, cid.Undef, 0, NewOut(swarmKeyErr, "Swarm key mismatch", err.Error(), nil)
+	}
+	if err != nil {
+		// The peers connected are still usable
+		log.Warnf("Failed bootstrapping DHT Err: %s", err.Error())
+	}
+	count := ipfslite.Connected(results)
+	// STEP : Bootstrap done
+	l.step(StepBootstrapped, success, fmt.Sprintf("Bootstrapped agent with %d leaders", count))
+	return lite, metadata, c, count, nil
+}
+
+// openReader is the reader returned by Open. It owns the node.
+type openReader struct {
+	ufsio.DagReader
+	l        *LightClient
+	lite     node
+	metadata *info
+	start    time.Time
+	cancel   context.CancelFunc
+
+	once sync.Once
+	err  error
+}
+
+// Close stops the node after letting its micropayments be sent, and tells
+// the metadata service the content was fetched.
+func (r *openReader) Close() error {
+	r.once.Do(func() {
+		r.err = r.DagReader.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), r.l.timeout)
+		defer cancel()
+		r.l.settle(ctx, r.lite)
+		if !r.metadata.byo {
+			err := r.l.updateInfo(r.metadata, int64(time.Since(r.start).Seconds()))
+			if err != nil {
+				log.Warnf("Failed updating metadata after reading Err: %s", err.Error())
+			}
+		}
+		r.lite.close()
+		r.cancel()
+	})
+	return r.err
+}
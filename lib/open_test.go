@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+
+	rsc, fi, err := l.Open(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Hash != l.cfg.BYOMetadata.Hash || rsc.Size() != uint64(len(data)) {
+		t.Fatalf("unexpected info %+v size %d", fi, rsc.Size())
+	}
+	// Range read in the middle of the file
+	_, err = rsc.Seek(300*1024, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	_, err = io.ReadFull(rsc, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[300*1024:300*1024+1000]) {
+		t.Fatal("range content differs")
+	}
+	_, err = rsc.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("content differs")
+	}
+	if fake.closed {
+		t.Fatal("node closed before the reader")
+	}
+	err = rsc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fake.closed {
+		t.Fatal("node not closed along with the reader")
+	}
+	if _, err := os.Stat(l.destination); !os.IsNotExist(err) {
+		t.Fatal("open wrote to the destination")
+	}
+}
+
+func TestOpenCancel(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rsc, _, err := l.Open(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsc.Close()
+	cancel()
+	_, err = ioutil.ReadAll(rsc)
+	if err == nil {
+		t.Fatal("read after cancel succeeded")
+	}
+	rsc.Close()
+	if !fake.closed {
+		t.Fatal("node not closed")
+	}
+}
+
+func TestOpenMissingContent(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Hash = "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"
+	l.cfg.GetFileRetries = -1
+
+	_, _, err := l.Open(context.Background(), "")
+	if err == nil {
+		t.Fatal("opened missing content")
+	}
+	if !fake.closed {
+		t.Fatal("node not closed")
+	}
+}
+
+func TestOpenNoPeers(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 0)
+	defer done()
+
+	_, _, err := l.Open(context.Background(), "")
+	if err == nil {
+		t.Fatal("opened without peers")
+	}
+	if !fake.closed {
+		t.Fatal("node not closed")
+	}
+}
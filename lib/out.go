@@ -5,15 +5,47 @@ import (
 	"fmt"
 )
 
+// OutVersion is the current version of the JSON envelope of Out. It is
+// bumped on every incompatible change of the envelope.
+const OutVersion = 1
+
+// outVersion is the envelope version written by NewOut.
+var outVersion = OutVersion
+
+// SetOutVersion pins the version of the JSON envelope so that a consumer
+// keeps getting the layout it was written for. Only OutVersion is
+// supported so far.
+func SetOutVersion(v int) error {
+	if v != OutVersion {
+		return fmt.Errorf("unsupported output version %d", v)
+	}
+	outVersion = v
+	return nil
+}
+
+// Out is the result of a command. In JSON it is written as the envelope
+//
+//	{
+//	  "version": 1,
+//	  "status": 200,
+//	  "message": "Download complete",
+//	  "details": "<error, if any>",
+//	  "data": <command specific>
+//	}
+//
+// details and data are omitted when empty. version is OutVersion unless
+// pinned with SetOutVersion.
 type Out struct {
+	Version int         `json:"version"`
 	Status  int         `json:"status"`
 	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
 	Details string      `json:"details,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 func NewOut(status int, message, err string, data interface{}) *Out {
 	o := &Out{
+		Version: outVersion,
 		Status:  status,
 		Message: message,
 		Data:    data,
@@ -31,6 +63,7 @@ var (
 	GeneralErr      = "Something went wrong"
 	DownloadSuccess = "Download complete"
 	MetaInfo        = "Metadata"
+	PreflightPassed = "Preflight check passed"
 )
 
 func OutMessage(cliOut *Out, jFlag bool) {
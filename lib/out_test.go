@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOutEnvelope(t *testing.T) {
+	buf, err := json.Marshal(NewOut(success, "Done", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":1,"status":200,"message":"Done"}`
+	if string(buf) != want {
+		t.Fatalf("expected %s got %s", want, buf)
+	}
+
+	buf, err = json.Marshal(NewOut(internalError, "Failed", "oops", []int{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"version":1,"status":500,"message":"Failed","details":"oops","data":[1]}`
+	if string(buf) != want {
+		t.Fatalf("expected %s got %s", want, buf)
+	}
+}
+
+func TestSetOutVersion(t *testing.T) {
+	if err := SetOutVersion(OutVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOutVersion(OutVersion + 1); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,315 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// OutputMode is what StartOutput makes of the content.
+type OutputMode string
+
+const (
+	// OutputFile downloads to the destination, as Start does.
+	OutputFile OutputMode = "file"
+	// OutputCAR streams the blocks of the content as a CARv1 archive, the
+	// root first and every block before its children, so that the content
+	// can be imported as is elsewhere.
+	OutputCAR OutputMode = "car"
+	// OutputTar streams the content as a tar archive, see StartToTar.
+	OutputTar OutputMode = "tar"
+	// OutputBytes streams the content of a file as is. Directories are
+	// refused.
+	OutputBytes OutputMode = "bytes"
+	// OutputDiscard fetches every block of the content without writing
+	// anything, e.g. to fill Config.Datastore or measure the swarm.
+	OutputDiscard OutputMode = "discard"
+)
+
+// OutputModes lists the valid modes.
+var OutputModes = []OutputMode{OutputFile, OutputCAR, OutputTar, OutputBytes, OutputDiscard}
+
+var errOutputDir = errors.New("directories can't be output as bytes")
+
+// StartOutput fetches the content of sharable and hands it over as mode
+// says. OutputFile downloads to the destination as Start does and ignores
+// w. The other modes stream to w, without writing anything to disk. Every
+// mode runs the same download: the attempts, session refreshes, limits,
+// verification, trace and webhook of Start apply and only the sink the
+// content is written to differs. With Config.VerifyContent a stream is
+// checked by its blocks, what was written to w can't be read back. w may
+// be nil with OutputDiscard. The download is abandoned when ctx is done.
+// With stat the result holds a StatOut.
+//
+// Progress is reported with the bytes of file content written so far,
+// or the bytes of blocks for OutputCAR and OutputDiscard.
+func (l *LightClient) StartOutput(
+	ctx context.Context,
+	sharable string,
+	mode OutputMode,
+	w io.Writer,
+	stat bool,
+	progUpd ProgressUpdater,
+) *Out {
+	var sink outputSink
+	switch mode {
+	case OutputFile:
+		return l.traced(sharable, func() *Out {
+			return l.notify(sharable, stat, func(stat bool) *Out {
+				return l.start(ctx, sharable, false, stat, progUpd)
+			})
+		})
+	case OutputCAR:
+		sink = &blockSink{stream: newStream(w)}
+	case OutputTar:
+		sink = &tarSink{stream: newStream(w), l: l}
+	case OutputBytes:
+		sink = &bytesSink{stream: newStream(w)}
+	case OutputDiscard:
+		sink = &blockSink{stream: newStream(ioutil.Discard)}
+	default:
+		return NewOut(invalidInput, "Invalid output mode", fmt.Sprintf("%q is not one of %v", mode, OutputModes), nil)
+	}
+	if w == nil && mode != OutputDiscard {
+		return NewOut(invalidInput, "Invalid output mode", fmt.Sprintf("%s output needs a writer", mode), nil)
+	}
+	return l.output(ctx, sharable, sink, stat, progUpd)
+}
+
+// outputRoot is the content of a download written to an outputSink.
+type outputRoot struct {
+	cid  cid.Cid
+	name string
+	// size told by the metadata, zero if unknown
+	size int64
+	// file reads the content, nil for directories
+	file ufsio.DagReader
+}
+
+// outputSink writes the content of a download. prepare is called on every
+// attempt once the root block is available. It returns the bytes write
+// reports progress against and how many of them were written by earlier
+// attempts, which write continues after. write adds the bytes it writes
+// to done as it goes and returns the files given up on, if any. verify
+// checks what was written for Config.VerifyContent and firstWrite tells
+// when the content was first written, zero until it is.
+type outputSink interface {
+	prepare(ctx context.Context, lite node, root *outputRoot) (total int64, written int64, err error)
+	write(ctx context.Context, lite node, done *int64) ([]DestinationResult, error)
+	verify(ctx context.Context, lite node, c cid.Cid) error
+	firstWrite() time.Time
+}
+
+// sinkError is an error of an outputSink which tells the result of the
+// download.
+type sinkError struct {
+	out *Out
+}
+
+func (e *sinkError) Error() string {
+	return e.out.Message + ": " + e.out.Details
+}
+
+// output runs a download whose content is written to sink instead of the
+// destination.
+func (l *LightClient) output(ctx context.Context, sharable string, sink outputSink, stat bool, progUpd ProgressUpdater) *Out {
+	return l.traced(sharable, func() *Out {
+		return l.notify(sharable, stat, func(stat bool) *Out {
+			return l.stream(ctx, sharable, sink, stat, progUpd)
+		})
+	})
+}
+
+// stream gets the metadata of sharable, or uses Config.BYOMetadata, and
+// downloads the content into sink.
+func (l *LightClient) stream(ctx context.Context, sharable string, sink outputSink, stat bool, progUpd ProgressUpdater) *Out {
+	timings := newTimings()
+	mark := time.Now()
+	metadata, out := l.sharableInfo(ctx, sharable)
+	if out != nil {
+		return out
+	}
+	timings.Metadata = lap(&mark)
+	if out := l.checkMetadata(metadata); out != nil {
+		return out
+	}
+	var budget *retryBudget
+	if l.cfg.RetryBudget != nil {
+		budget = newRetryBudget(*l.cfg.RetryBudget)
+	}
+	l.setSession(&session{
+		sharable: sharable,
+		metadata: metadata,
+		budget:   budget,
+	})
+	defer l.setSession(nil)
+	res, metadata := l.transfer(ctx, sharable, metadata, sink, budget, stat, progUpd, timings)
+	return withClockHint(res, metadata.clockSkew)
+}
+
+// stream is what the sinks streaming the content to a writer share. The
+// writer can't be read back, so only the blocks are verified.
+type stream struct {
+	out firstWriteWriter
+}
+
+func newStream(w io.Writer) stream {
+	return stream{out: firstWriteWriter{Writer: w}}
+}
+
+func (s *stream) firstWrite() time.Time {
+	return s.out.first
+}
+
+func (s *stream) verify(ctx context.Context, lite node, c cid.Cid) error {
+	_, _, err := verifyDAG(ctx, lite, c)
+	return err
+}
+
+// bytesSink writes the content of a file as is. A retried download
+// continues after what was written.
+type bytesSink struct {
+	stream
+	file    ufsio.DagReader
+	written int64
+}
+
+func (s *bytesSink) prepare(_ context.Context, _ node, root *outputRoot) (int64, int64, error) {
+	if root.file == nil {
+		return 0, 0, &sinkError{NewOut(invalidInput, "Content is a directory", errOutputDir.Error(), nil)}
+	}
+	s.file = root.file
+	if s.written > 0 {
+		_, err := s.file.Seek(s.written, io.SeekStart)
+		if err != nil {
+			return 0, 0, &sinkError{NewOut(internalError, "Failed resuming download", err.Error(), nil)}
+		}
+	}
+	return int64(s.file.Size()), s.written, nil
+}
+
+func (s *bytesSink) write(_ context.Context, _ node, done *int64) ([]DestinationResult, error) {
+	n, err := copyExact(&countingWriter{Writer: &s.out, count: done}, s.file, int64(s.file.Size())-s.written)
+	s.written += n
+	return nil, err
+}
+
+// blockSink fetches every block of the content, root first and each block
+// before its children, and writes them as a CARv1 archive. A retried
+// download skips the blocks already written.
+type blockSink struct {
+	stream
+	root    ipld.Node
+	header  bool
+	written map[cid.Cid]struct{}
+	bytes   int64
+}
+
+func (s *blockSink) prepare(ctx context.Context, lite node, root *outputRoot) (int64, int64, error) {
+	nd, err := lite.Get(ctx, root.cid)
+	if err != nil {
+		return 0, 0, err
+	}
+	s.root = nd
+	size, err := nd.Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	if s.written == nil {
+		s.written = make(map[cid.Cid]struct{})
+	}
+	return int64(size), s.bytes, nil
+}
+
+func (s *blockSink) write(ctx context.Context, lite node, done *int64) ([]DestinationResult, error) {
+	if !s.header {
+		err := writeCARHeader(&s.out, s.root.Cid())
+		if err != nil {
+			return nil, err
+		}
+		s.header = true
+	}
+	seen := make(map[cid.Cid]struct{})
+	return nil, s.walk(ctx, lite, s.root, seen, done)
+}
+
+func (s *blockSink) walk(ctx context.Context, lite node, nd ipld.Node, seen map[cid.Cid]struct{}, done *int64) error {
+	if _, ok := seen[nd.Cid()]; ok {
+		return nil
+	}
+	seen[nd.Cid()] = struct{}{}
+	if _, ok := s.written[nd.Cid()]; !ok {
+		err := writeCARBlock(&s.out, nd)
+		if err != nil {
+			return err
+		}
+		s.written[nd.Cid()] = struct{}{}
+		s.bytes += int64(len(nd.RawData()))
+		atomic.AddInt64(done, int64(len(nd.RawData())))
+	}
+	// The children are fetched at the same time and written in order
+	for _, p := range ipld.GetDAG(ctx, lite, nd) {
+		child, err := p.Get(ctx)
+		if err != nil {
+			return err
+		}
+		err = s.walk(ctx, lite, child, seen, done)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carHeader is the header of a CARv1 archive.
+type carHeader struct {
+	Roots   []cid.Cid
+	Version uint64
+}
+
+func init() {
+	cbor.RegisterCborType(carHeader{})
+}
+
+// writeCARHeader writes the header of a CARv1 archive of root.
+func writeCARHeader(w io.Writer, root cid.Cid) error {
+	hdr, err := cbor.DumpObject(&carHeader{Roots: []cid.Cid{root}, Version: 1})
+	if err != nil {
+		return err
+	}
+	return writeCARSection(w, hdr)
+}
+
+// writeCARBlock writes nd as a section of a CARv1 archive: its CID followed
+// by its data.
+func writeCARBlock(w io.Writer, nd ipld.Node) error {
+	return writeCARSection(w, nd.Cid().Bytes(), nd.RawData())
+}
+
+// writeCARSection writes the parts prefixed with their total length as an
+// unsigned varint.
+func writeCARSection(w io.Writer, parts ...[]byte) error {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	_, err := w.Write(buf[:binary.PutUvarint(buf, uint64(n))])
+	for _, p := range parts {
+		if err != nil {
+			break
+		}
+		_, err = w.Write(p)
+	}
+	return err
+}
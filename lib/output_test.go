@@ -0,0 +1,319 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// readCAR returns the roots of the CARv1 archive in r and its blocks in a
+// new DAG, checking that every block matches its CID. order lists the
+// blocks as they came.
+func readCAR(t *testing.T, r io.Reader) (roots []cid.Cid, order []cid.Cid, dag ipld.DAGService) {
+	br := bufio.NewReader(r)
+	section := func() []byte {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, n)
+		_, err = io.ReadFull(br, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	hdr := map[string]interface{}{}
+	err := cbor.DecodeInto(section(), &hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := hdr["version"].(uint64); !ok || v != 1 {
+		t.Fatalf("unexpected header %v", hdr)
+	}
+	for _, r := range hdr["roots"].([]interface{}) {
+		roots = append(roots, r.(cid.Cid))
+	}
+	dag = mdtest.Mock()
+	for buf := section(); buf != nil; buf = section() {
+		n, c, err := cid.CidFromBytes(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := buf[n:]
+		sum, err := c.Prefix().Sum(data)
+		if err != nil || !sum.Equals(c) {
+			t.Fatalf("block %s doesn't match its data", c)
+		}
+		var nd ipld.Node = merkledag.NewRawNode(data)
+		if c.Type() == cid.DagProtobuf {
+			nd, err = merkledag.DecodeProtobuf(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !nd.Cid().Equals(c) {
+			t.Fatalf("block %s decoded as %s", c, nd.Cid())
+		}
+		err = dag.Add(context.Background(), nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, c)
+	}
+	return roots, order, dag
+}
+
+func TestStartOutputCAR(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 3*1024*1024+5, 3)
+	defer done()
+	ctx := context.Background()
+	buf := &bytes.Buffer{}
+	out := l.StartOutput(ctx, "", OutputCAR, buf, false, nil)
+	if out.Status != success {
+		t.Fatalf("output failed %+v", out)
+	}
+	roots, order, dag := readCAR(t, buf)
+	if len(roots) != 1 || roots[0].String() != l.cfg.BYOMetadata.Hash || !order[0].Equals(roots[0]) {
+		t.Fatalf("unexpected roots %v", roots)
+	}
+	// The leaves and the root
+	if len(order) != 14 {
+		t.Fatalf("expected 14 blocks got %d", len(order))
+	}
+	root, err := dag.Get(ctx, roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ufsio.NewDagReader(ctx, root, dag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("archived content differs")
+	}
+}
+
+func TestStartOutputModes(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	ctx := context.Background()
+
+	buf := &bytes.Buffer{}
+	out := l.StartOutput(ctx, "", OutputBytes, buf, false, nil)
+	if out.Status != success || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("unexpected bytes output %+v", out)
+	}
+	out = l.StartOutput(ctx, "", OutputDiscard, nil, false, nil)
+	if out.Status != success {
+		t.Fatalf("discarding failed %+v", out)
+	}
+	buf.Reset()
+	out = l.StartOutput(ctx, "", OutputTar, buf, false, nil)
+	if entries := readTar(t, buf); out.Status != success || !bytes.Equal(entries[l.cfg.BYOMetadata.Hash], data) {
+		t.Fatalf("unexpected tar output %+v", out)
+	}
+	out = l.StartOutput(ctx, "", OutputFile, nil, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("unexpected file %v", err)
+	}
+
+	for _, mode := range []OutputMode{"zip", OutputCAR} {
+		out = l.StartOutput(ctx, "", mode, nil, false, nil)
+		if out.Status != invalidInput {
+			t.Fatalf("expected %s to be refused got %+v", mode, out)
+		}
+	}
+}
+
+func TestStartOutputBytesDir(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	ctx := context.Background()
+	dir := ufsio.NewDirectory(fake.DAGService)
+	a := merkledag.NewRawNode([]byte("hello"))
+	if err := dir.AddChild(ctx, "a.txt", a); err != nil {
+		t.Fatal(err)
+	}
+	dirNode, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddMany(ctx, []ipld.Node{a, dirNode}); err != nil {
+		t.Fatal(err)
+	}
+	l.cfg.BYOMetadata.Hash = dirNode.Cid().String()
+
+	out := l.StartOutput(ctx, "", OutputBytes, &bytes.Buffer{}, false, nil)
+	if out.Status != invalidInput {
+		t.Fatalf("expected the directory to be refused got %+v", out)
+	}
+	buf := &bytes.Buffer{}
+	out = l.StartOutput(ctx, "", OutputCAR, buf, false, nil)
+	if out.Status != success {
+		t.Fatalf("output failed %+v", out)
+	}
+	if _, order, _ := readCAR(t, buf); len(order) != 2 {
+		t.Fatalf("expected 2 blocks got %d", len(order))
+	}
+}
+
+func TestStartOutputStats(t *testing.T) {
+	rec := &webhookRecorder{statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.CompletionWebhook = srv.URL
+	l.cfg.VerifyContent = true
+	ctx := context.Background()
+
+	buf := &bytes.Buffer{}
+	out := l.StartOutput(ctx, "", OutputBytes, buf, true, nil)
+	st, ok := out.Data.(StatOut)
+	if out.Status != success || !ok || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if st.Bytes != int64(len(data)) || st.TimeToFirstByte <= 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	// The stats are only in the webhook payload
+	out = l.StartOutput(ctx, "", OutputDiscard, nil, false, nil)
+	if out.Status != success || out.Data != nil {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if len(rec.bodies) != 2 {
+		t.Fatalf("expected both outputs to be reported got %d", len(rec.bodies))
+	}
+}
+
+func TestStartOutputFileCancel(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := l.StartOutput(ctx, "", OutputFile, nil, false, nil)
+	if out.Status == success {
+		t.Fatal("download ran after its context was done")
+	}
+	if _, err := os.Stat(l.destination); !os.IsNotExist(err) {
+		t.Fatalf("destination written: %v", err)
+	}
+}
+
+// TestOutputSinkResumes stops the streaming sinks halfway through and
+// checks that the next attempt continues after what was written.
+func TestOutputSinkResumes(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fake.Get(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first two chunks can be fetched before stopping
+	free := map[cid.Cid]bool{c: true, root.Links()[0].Cid: true, root.Links()[1].Cid: true}
+	half := int64(len(data) / 2)
+	dserv := fake.DAGService
+	attempt := func(sink outputSink, stop bool) (int64, int64, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		fake.DAGService = dserv
+		if stop {
+			fake.DAGService = &heldDAG{gatedDAG: gatedDAG{DAGService: dserv, gate: make(chan struct{})}, free: free}
+		}
+		rsc, err := fake.GetFile(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsc.Close()
+		_, written, err := sink.prepare(ctx, fake, &outputRoot{cid: c, name: "file", file: rsc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int64
+		errc := make(chan error, 1)
+		go func() {
+			_, err := sink.write(ctx, fake, &n)
+			errc <- err
+		}()
+		if stop {
+			for atomic.LoadInt64(&n) < half {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}
+		err = <-errc
+		return written, atomic.LoadInt64(&n), err
+	}
+
+	for name, tc := range map[string]struct {
+		sink  func(io.Writer) outputSink
+		check func(*bytes.Buffer) bool
+	}{
+		"bytes": {
+			sink:  func(w io.Writer) outputSink { return &bytesSink{stream: newStream(w)} },
+			check: func(buf *bytes.Buffer) bool { return bytes.Equal(buf.Bytes(), data) },
+		},
+		"car": {
+			sink: func(w io.Writer) outputSink { return &blockSink{stream: newStream(w)} },
+			check: func(buf *bytes.Buffer) bool {
+				_, order, _ := readCAR(t, buf)
+				return len(order) == len(root.Links())+1
+			},
+		},
+		"tar": {
+			sink:  func(w io.Writer) outputSink { return &tarSink{stream: newStream(w), l: l} },
+			check: func(buf *bytes.Buffer) bool { return bytes.Equal(readTar(t, buf)["file"], data) },
+		},
+	} {
+		buf := &bytes.Buffer{}
+		sink := tc.sink(buf)
+		written, first, err := attempt(sink, true)
+		if err == nil || written != 0 {
+			t.Fatalf("%s: expected the first attempt to stop got %d %v", name, first, err)
+		}
+		written, _, err = attempt(sink, false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if written != first {
+			t.Fatalf("%s: expected to continue after %d bytes got %d", name, first, written)
+		}
+		if !tc.check(buf) {
+			t.Fatalf("%s: unexpected output after resuming", name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// discardPartial removes the part file, or directory, of a failed
+// download unless Config.KeepPartialOnError or Config.PartialRetentionDir
+// ask to keep it. Where it is kept is added to the details of res.
+func (l *LightClient) discardPartial(partPath string, res *Out) {
+	kept := partPath
+	switch {
+	case len(l.cfg.PartialRetentionDir) > 0:
+		dst := filepath.Join(l.cfg.PartialRetentionDir,
+			time.Now().Format("20060102T150405")+"-"+filepath.Base(partPath))
+		err := os.MkdirAll(l.cfg.PartialRetentionDir, l.dirMode())
+		if err == nil {
+			err = os.Rename(partPath, dst)
+		}
+		if err != nil {
+			// Left in place rather than lost
+			log.Warnf("Failed moving partial download to %s Err: %s", l.cfg.PartialRetentionDir, err.Error())
+		} else {
+			kept = dst
+		}
+	case l.cfg.KeepPartialOnError:
+	default:
+		os.RemoveAll(partPath)
+		return
+	}
+	log.Infof("Partial download kept at %s", kept)
+	if len(res.Details) > 0 {
+		res.Details += ", "
+	}
+	res.Details += "partial download kept at " + kept
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiscardPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	retention := filepath.Join(dir, "failed")
+
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		kept bool
+	}{
+		{"default", Config{}, false},
+		{"keep", Config{KeepPartialOnError: true}, true},
+		{"retention", Config{PartialRetentionDir: retention}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			part := filepath.Join(dir, tc.name+partSuffix)
+			err := ioutil.WriteFile(part, []byte("partial"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := &LightClient{cfg: &tc.cfg}
+			res := NewOut(internalError, "Failed writing to destination", "unexpected EOF", nil)
+			l.discardPartial(part, res)
+
+			_, err = os.Stat(part)
+			inPlace := err == nil
+			if inPlace != tc.cfg.KeepPartialOnError {
+				t.Fatalf("expected part file kept in place %t", tc.cfg.KeepPartialOnError)
+			}
+			if !tc.kept {
+				if res.Details != "unexpected EOF" {
+					t.Fatalf("unexpected details %q", res.Details)
+				}
+				return
+			}
+			if !strings.HasPrefix(res.Details, "unexpected EOF, partial download kept at ") {
+				t.Fatalf("unexpected details %q", res.Details)
+			}
+			kept := strings.TrimPrefix(res.Details, "unexpected EOF, partial download kept at ")
+			data, err := ioutil.ReadFile(kept)
+			if err != nil || string(data) != "partial" {
+				t.Fatalf("partial download not kept at %s: %v", kept, err)
+			}
+			if len(tc.cfg.PartialRetentionDir) > 0 && filepath.Dir(kept) != retention {
+				t.Fatalf("expected the part file moved to %s got %s", retention, kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// pauser holds back the transfer of the downloads of a LightClient while
+// it is paused. The zero value is not paused.
+type pauser struct {
+	mtx sync.Mutex
+	// resumed is closed on resume, nil when not paused.
+	resumed chan struct{}
+}
+
+// pause returns false if already paused.
+func (p *pauser) pause() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.resumed != nil {
+		return false
+	}
+	p.resumed = make(chan struct{})
+	return true
+}
+
+// resume returns false if not paused.
+func (p *pauser) resume() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.resumed == nil {
+		return false
+	}
+	close(p.resumed)
+	p.resumed = nil
+	return true
+}
+
+func (p *pauser) isPaused() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.resumed != nil
+}
+
+// wait returns once not paused, or with the error of ctx once it is done.
+func (p *pauser) wait(ctx context.Context) error {
+	p.mtx.Lock()
+	resumed := p.resumed
+	p.mtx.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pauseWriter waits for the download to be resumed before every write.
+// Nothing is read from the swarm meanwhile, so the fetching stops once
+// the blocks fetched ahead arrived.
+type pauseWriter struct {
+	io.Writer
+	ctx context.Context
+	p   *pauser
+}
+
+func (w *pauseWriter) Write(b []byte) (int, error) {
+	err := w.p.wait(w.ctx)
+	if err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(b)
+}
+
+// Pause holds back the download in progress until Resume. The node and its
+// connections to the peers are kept, so that it continues right away, and
+// the data already written stays on disk. The download timeout keeps
+// running while paused. A download started while paused waits for Resume
+// to transfer anything. It returns false if already paused.
+func (l *LightClient) Pause() bool {
+	if !l.paused.pause() {
+		return false
+	}
+	// STEP : Paused
+	l.step(StepPaused, success, "Download paused")
+	return true
+}
+
+// Resume continues the download held back by Pause. It returns false if
+// it was not paused.
+func (l *LightClient) Resume() bool {
+	if !l.paused.resume() {
+		return false
+	}
+	// STEP : Resumed
+	l.step(StepResumed, success, "Download resumed")
+	return true
+}
+
+// Paused tells whether downloads are held back by Pause.
+func (l *LightClient) Paused() bool {
+	return l.paused.isPaused()
+}
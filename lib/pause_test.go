@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 4*1024*1024, 3)
+	defer done()
+	var steps []StepCode
+	l.cfg.StepHook = func(code StepCode, message string) {
+		if code == StepPaused || code == StepResumed {
+			steps = append(steps, code)
+		}
+	}
+	if l.Resume() {
+		t.Fatal("resumed a download which was not paused")
+	}
+	if !l.Pause() || l.Pause() || !l.Paused() {
+		t.Fatal("expected to pause once")
+	}
+
+	// Started while paused
+	res := make(chan *Out, 1)
+	go func() {
+		res <- l.Start("", false, true, nil)
+	}()
+	select {
+	case out := <-res:
+		t.Fatalf("paused download completed %+v", out)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if !l.Resume() {
+		t.Fatal("failed resuming")
+	}
+	out := <-res
+	st, ok := out.Data.(StatOut)
+	if out.Status != success || !ok || st.Bytes != int64(len(data)) {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if len(steps) != 2 || steps[0] != StepPaused || steps[1] != StepResumed {
+		t.Fatalf("unexpected steps %v", steps)
+	}
+}
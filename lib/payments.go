@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/StreamSpace/scp/engine"
+)
+
+// The SCP engine keeps its ledger in memory and its constructor doesn't take
+// a datastore, so the receipts are snapshotted to Config.PaymentsFile
+// instead. They can then be reported after a crash or an interrupted
+// download.
+
+// savedPayments is the content of Config.PaymentsFile.
+type savedPayments struct {
+	Cookie   string              `json:"cookie"`
+	Receipts []*engine.SSReceipt `json:"receipts"`
+	SavedAt  time.Time           `json:"saved_at"`
+}
+
+// LoadPayments reads the micropayment receipts saved to path during a
+// previous download along with the cookie of that download, so that they
+// can be reconciled.
+func LoadPayments(path string) (string, []*engine.SSReceipt, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	sp := &savedPayments{}
+	err = json.Unmarshal(buf, sp)
+	if err != nil {
+		return "", nil, err
+	}
+	return sp.Cookie, sp.Receipts, nil
+}
+
+// paidReceipt is implemented by SCP receipts able to tell the amount they
+// are for.
+type paidReceipt interface {
+	Amount() float64
+}
+
+var errPaymentsUnsupported = errors.New("SCP receipts don't tell their amount")
+
+func (n liteNode) paid() (float64, error) {
+	receipts, err := n.microPayments()
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, r := range receipts {
+		p, ok := interface{}(r).(paidReceipt)
+		if !ok {
+			return 0, errPaymentsUnsupported
+		}
+		total += p.Amount()
+	}
+	return total, nil
+}
+
+func savePayments(path, cookieID string, lite node) error {
+	receipts, err := lite.microPayments()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(&savedPayments{
+		Cookie:   cookieID,
+		Receipts: receipts,
+		SavedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// persistPayments saves the receipts of lite to path every
+// stateSaveInterval until stop is closed.
+func persistPayments(path, cookieID string, lite node, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(stateSaveInterval):
+			err := savePayments(path, cookieID, lite)
+			if err != nil {
+				log.Warnf("Failed saving micropayments Err: %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPayments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "payments.json")
+	buf := []byte(`{"cookie":"abc","receipts":[{}],"saved_at":"2020-01-01T00:00:00Z"}`)
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cookieID, receipts, err := LoadPayments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookieID != "abc" || len(receipts) != 1 {
+		t.Fatalf("unexpected payments %s %v", cookieID, receipts)
+	}
+	if _, _, err := LoadPayments(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
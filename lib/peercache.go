@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+// How long cached peers are dialed when Config.PeerCacheTTL is not set.
+const defaultPeerCacheTTL = 24 * time.Hour
+
+// peerCacheEntry is the list of peers of a swarm.
+type peerCacheEntry struct {
+	Peers   []peer.AddrInfo `json:"peers"`
+	SavedAt time.Time       `json:"saved_at"`
+}
+
+// peerCache is the content of Config.PeerCacheFile, by swarm fingerprint.
+type peerCache map[string]peerCacheEntry
+
+// peerCacheMtx serializes the updates of the cache by the clients of the
+// process.
+var peerCacheMtx sync.Mutex
+
+func (l *LightClient) peerCacheTTL() time.Duration {
+	if l.cfg.PeerCacheTTL > 0 {
+		return l.cfg.PeerCacheTTL
+	}
+	return defaultPeerCacheTTL
+}
+
+// loadPeerCache reads the cache at path, without the expired entries. A
+// missing file is an empty cache.
+func loadPeerCache(path string, ttl time.Duration) (peerCache, error) {
+	cache := peerCache{}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &cache)
+	if err != nil {
+		return nil, err
+	}
+	for k, e := range cache {
+		if time.Since(e.SavedAt) > ttl {
+			delete(cache, k)
+		}
+	}
+	return cache, nil
+}
+
+// cachedPeers returns the peers cached for the swarm of psk.
+func (l *LightClient) cachedPeers(psk pnet.PSK) []peer.AddrInfo {
+	if len(l.cfg.PeerCacheFile) == 0 {
+		return nil
+	}
+	peerCacheMtx.Lock()
+	defer peerCacheMtx.Unlock()
+	cache, err := loadPeerCache(l.cfg.PeerCacheFile, l.peerCacheTTL())
+	if err != nil {
+		log.Warnf("Failed reading peer cache Err: %s", err.Error())
+		return nil
+	}
+	return cache[SwarmFingerprint(psk)].Peers
+}
+
+// cachePeers saves the peers h is connected to as the ones of the swarm of
+// psk, with the addresses of the connections. The file only holds peer IDs
+// and addresses.
+func (l *LightClient) cachePeers(psk pnet.PSK, h host.Host) error {
+	if len(l.cfg.PeerCacheFile) == 0 {
+		return nil
+	}
+	var peers []peer.AddrInfo
+	for _, p := range h.Network().Peers() {
+		// The addresses which worked rather than every known one
+		pi := peer.AddrInfo{ID: p}
+		for _, c := range h.Network().ConnsToPeer(p) {
+			pi.Addrs = append(pi.Addrs, c.RemoteMultiaddr())
+		}
+		if len(pi.Addrs) > 0 {
+			peers = append(peers, pi)
+		}
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+	peerCacheMtx.Lock()
+	defer peerCacheMtx.Unlock()
+	cache, err := loadPeerCache(l.cfg.PeerCacheFile, l.peerCacheTTL())
+	if err != nil {
+		log.Warnf("Replacing unreadable peer cache Err: %s", err.Error())
+		cache = peerCache{}
+	}
+	cache[SwarmFingerprint(psk)] = peerCacheEntry{Peers: peers, SavedAt: time.Now()}
+	buf, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(l.cfg.PeerCacheFile), filepath.Base(l.cfg.PeerCacheFile))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.cfg.PeerCacheFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
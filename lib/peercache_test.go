@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestPeerCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peercache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mn := mocknet.New(context.Background())
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mn.LinkAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mn.ConnectAllButSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk, err := decodeSwarmKey(testSwarmKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &LightClient{cfg: &Config{PeerCacheFile: filepath.Join(dir, "peers.json")}}
+	if peers := l.cachedPeers(psk); len(peers) != 0 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	err = l.cachePeers(psk, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := l.cachedPeers(psk)
+	if len(peers) != 1 || peers[0].ID != seed.ID() || len(peers[0].Addrs) == 0 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	// Other swarms don't get them
+	other := pnet.PSK(make([]byte, 32))
+	if peers := l.cachedPeers(other); len(peers) != 0 {
+		t.Fatalf("unexpected peers for another swarm %v", peers)
+	}
+
+	l.cfg.PeerCacheTTL = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if peers := l.cachedPeers(psk); len(peers) != 0 {
+		t.Fatalf("expired peers returned %v", peers)
+	}
+}
+
+func TestStartDialsCachedPeers(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.PeerCacheFile = filepath.Join(filepath.Dir(l.destination), "peers.json")
+	mn := mocknet.New(context.Background())
+	seed, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []*fakeNode
+	l.newNode = func(context.Context, *info, pnet.PSK, *contributions) (node, error) {
+		h, err := mn.GenPeer()
+		if err != nil {
+			return nil, err
+		}
+		err = mn.LinkAll()
+		if err == nil {
+			_, err = mn.ConnectPeers(h.ID(), seed.ID())
+		}
+		if err != nil {
+			return nil, err
+		}
+		n := &fakeNode{DAGService: fake.DAGService, h: h, peers: []int{3}}
+		nodes = append(nodes, n)
+		return n, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		out := l.Start("", false, false, nil)
+		if out.Status != success {
+			t.Fatalf("download failed %+v", out)
+		}
+	}
+	// The second download dials the cached peers then the leaders
+	if nodes[0].bootstraps != 1 || nodes[1].bootstraps != 2 {
+		t.Fatalf("expected 1 then 2 bootstraps got %d and %d", nodes[0].bootstraps, nodes[1].bootstraps)
+	}
+}
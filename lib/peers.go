@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerInfo describes a peer the client is connected to and what it served
+// so far in the download in progress.
+type PeerInfo struct {
+	ID     string   `json:"id"`
+	Addrs  []string `json:"addrs"`
+	Bytes  int64    `json:"bytes"`
+	Blocks int      `json:"blocks"`
+}
+
+// ConnectedPeers returns the peers the client is currently connected to
+// along with their contribution to the download in progress. It can be
+// called from any goroutine while a download runs. It returns nil when no
+// download is in progress.
+func (l *LightClient) ConnectedPeers() []PeerInfo {
+	l.mtx.Lock()
+	var h host.Host
+	var contrib *contributions
+	if l.active != nil {
+		h, contrib = l.active.host, l.active.contrib
+	}
+	l.mtx.Unlock()
+
+	if h == nil {
+		return nil
+	}
+	var served map[peer.ID]PeerContribution
+	if contrib != nil {
+		served = contrib.byPeer()
+	}
+	out := []PeerInfo{}
+	for _, p := range h.Network().Peers() {
+		pi := PeerInfo{ID: p.String()}
+		for _, c := range h.Network().ConnsToPeer(p) {
+			pi.Addrs = append(pi.Addrs, c.RemoteMultiaddr().String())
+		}
+		if pc, ok := served[p]; ok {
+			pi.Bytes = pc.Bytes
+			pi.Blocks = pc.Blocks
+		}
+		out = append(out, pi)
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestConnectedPeers(t *testing.T) {
+	l := &LightClient{cfg: &Config{}}
+	if l.ConnectedPeers() != nil {
+		t.Fatal("expected no peers without a download")
+	}
+
+	mn, err := mocknet.FullMeshLinked(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	contrib := newContributions()
+	contrib.BlockReceived(hosts[1].ID(), cid.Undef, 100)
+	contrib.BlockReceived(hosts[1].ID(), cid.Undef, 50)
+
+	l.setSession(&session{sharable: "sharable"})
+	l.setSessionNode(&fakeNode{h: hosts[0]}, contrib)
+
+	peers := l.ConnectedPeers()
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 peers got %d", len(peers))
+	}
+	for _, p := range peers {
+		if len(p.Addrs) == 0 {
+			t.Errorf("peer %s has no address", p.ID)
+		}
+		switch p.ID {
+		case hosts[1].ID().String():
+			if p.Bytes != 150 || p.Blocks != 2 {
+				t.Errorf("unexpected contribution %+v", p)
+			}
+		case hosts[2].ID().String():
+			if p.Bytes != 0 || p.Blocks != 0 {
+				t.Errorf("unexpected contribution %+v", p)
+			}
+		default:
+			t.Errorf("unexpected peer %s", p.ID)
+		}
+	}
+}
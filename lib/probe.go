@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// InfoResult is the result of DeepInfo: the metadata of a sharable with
+// the estimated cost of downloading it, and whether it can be downloaded
+// right now.
+type InfoResult struct {
+	FileInfo
+	// LeadersReachable is the number of leaders which could be connected.
+	LeadersReachable int `json:"leaders_reachable"`
+	// RootAvailable is set when the root block of the content could be
+	// fetched from the swarm.
+	RootAvailable bool `json:"root_available"`
+}
+
+// DeepInfo gets the metadata of sharable like Info, then probes its swarm:
+// the leaders are connected and the root block of the content is fetched,
+// nothing else. The service is not told of a download. The result is an
+// InfoResult, with a success status even when the content is unavailable.
+func (l *LightClient) DeepInfo(ctx context.Context, sharable string) *Out {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lite, metadata, c, count, out := l.startNode(ctx, sharable)
+	if out != nil {
+		return out
+	}
+	defer lite.close()
+	res := InfoResult{
+		FileInfo:         metadata.fileInfo(),
+		LeadersReachable: count,
+	}
+	if count > 0 {
+		res.RootAvailable = fetchRoot(ctx, lite, c) == nil
+	}
+	return NewOut(success, MetaInfo, "", res)
+}
+
+// PreflightCheck tells whether sharable can be downloaded right now, before
+// paying for it: the metadata is fetched, the swarm is joined with its key,
+// the leaders are connected and the root block of the content is fetched,
+// then the node is stopped. Nothing else is downloaded and the service is
+// not told of a download. The status tells which check failed, the data is
+// an InfoResult once the metadata is known.
+func (l *LightClient) PreflightCheck(ctx context.Context, sharable string) *Out {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lite, metadata, c, count, out := l.startNode(ctx, sharable)
+	if out != nil {
+		return out
+	}
+	defer lite.close()
+	res := InfoResult{
+		FileInfo:         metadata.fileInfo(),
+		LeadersReachable: count,
+	}
+	err := l.checkBudget(metadata)
+	if err != nil {
+		log.Errorf("Refusing download Err: %s", err.Error())
+		return budgetOut(err, res)
+	}
+	if count == 0 {
+		details := fmt.Sprintf("none of the %d leaders could be connected", len(metadata.Cookie.Leaders))
+		return NewOut(noPeers, "No peers connected", details, res)
+	}
+	err = fetchRoot(ctx, lite, c)
+	if err == context.DeadlineExceeded {
+		return NewOut(timeoutError, "Content not available", err.Error(), res)
+	}
+	if err != nil {
+		return NewOut(internalError, "Content not available", err.Error(), res)
+	}
+	res.RootAvailable = true
+	return NewOut(success, PreflightPassed, "", res)
+}
+
+// fetchRoot fetches the root block of the content c, giving up after
+// rootFetchTimeout.
+func fetchRoot(ctx context.Context, lite node, c cid.Cid) error {
+	ctx, cancel := context.WithTimeout(ctx, rootFetchTimeout)
+	defer cancel()
+	_, err := lite.Get(ctx, c)
+	if err != nil {
+		log.Warnf("Failed getting root block %s Err: %s", c, err.Error())
+	}
+	return err
+}
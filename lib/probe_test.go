@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestDeepInfo(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 2)
+	defer done()
+
+	out := l.DeepInfo(context.Background(), "")
+	res, ok := out.Data.(InfoResult)
+	if out.Status != success || !ok {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if res.Hash != l.cfg.BYOMetadata.Hash || res.LeadersReachable != 2 || !res.RootAvailable {
+		t.Fatalf("unexpected info %+v", res)
+	}
+	if !fake.closed {
+		t.Fatal("node not closed")
+	}
+
+	// The root block is missing from the swarm
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.DAGService = &failingDAG{DAGService: fake.DAGService, bad: c}
+	out = l.DeepInfo(context.Background(), "")
+	res, ok = out.Data.(InfoResult)
+	if out.Status != success || !ok || res.RootAvailable {
+		t.Fatalf("expected the root unavailable got %+v", out)
+	}
+}
+
+func TestPreflightCheck(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 2)
+	defer done()
+
+	out := l.PreflightCheck(context.Background(), "")
+	res, ok := out.Data.(InfoResult)
+	if out.Status != success || out.Message != PreflightPassed || !ok {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if res.LeadersReachable != 2 || !res.RootAvailable {
+		t.Fatalf("unexpected info %+v", res)
+	}
+	if !fake.closed {
+		t.Fatal("node not closed")
+	}
+
+	// The root block is missing from the swarm
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.DAGService = &failingDAG{DAGService: fake.DAGService, bad: c}
+	out = l.PreflightCheck(context.Background(), "")
+	res, ok = out.Data.(InfoResult)
+	if out.Status != internalError || !ok || res.RootAvailable {
+		t.Fatalf("expected the root unavailable got %+v", out)
+	}
+}
+
+func TestPreflightCheckNoPeers(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 1024*1024, 0)
+	defer done()
+
+	out := l.PreflightCheck(context.Background(), "")
+	if res, ok := out.Data.(InfoResult); out.Status != noPeers || !ok || res.LeadersReachable != 0 {
+		t.Fatalf("expected no peers got %+v", out)
+	}
+}
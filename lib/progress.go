@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// How often the progress of a download is checked.
+var progressInterval = 500 * time.Millisecond
+
+// unknownSize is the total of a download whose size isn't known, only the
+// bytes written are reported then.
+const unknownSize = -1
+
+// startProgress runs trackProgress in the background and returns a
+// function stopping it. The function waits for the tracking to end, so
+// that progUpd is never called once it returned, and can be called more
+// than once. Nothing is tracked when progUpd is nil. A panic in progUpd is
+// logged and stops the tracking, not the download.
+func startProgress(
+	ctx context.Context,
+	progUpd ProgressUpdater,
+	every int,
+	current func() (int64, error),
+	total int64,
+) (stop func()) {
+	if progUpd == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("Progress updater panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		trackProgress(ctx, progUpd, every, current, total)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+// trackProgress reports the progress of a download to progUpd until it is
+// complete or ctx is done. current returns the number of bytes written so
+// far and total is the expected size. With every set to zero progUpd is
+// called every progressInterval, otherwise only when the percentage
+// crosses a multiple of every. A download of zero bytes is complete right
+// away. When total is unknownSize, or any negative value, progUpd is
+// called every progressInterval the bytes written changed.
+func trackProgress(
+	ctx context.Context,
+	progUpd ProgressUpdater,
+	every int,
+	current func() (int64, error),
+	total int64,
+) {
+	if total == 0 {
+		log.Infof("Nothing to download, progress complete")
+		progUpd.UpdateProgress(progressOut(0, 0))
+		return
+	}
+	lastMilestone := -1
+	var lastDone int64 = -1
+	for {
+		done, err := current()
+		if err == nil && total < 0 {
+			if done != lastDone {
+				lastDone = done
+				progUpd.UpdateProgress(progressOut(done, total))
+			}
+		} else if err == nil {
+			prog := float64(done) / float64(total) * 100
+			milestone := int(prog)
+			if every > 0 {
+				milestone -= milestone % every
+			}
+			if every <= 0 || milestone != lastMilestone || prog == 100 {
+				lastMilestone = milestone
+				log.Infof("Updating progress %d", int(prog))
+				progUpd.UpdateProgress(progressOut(done, total))
+			}
+			if prog == 100 {
+				log.Infof("Progress complete")
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			// The download may have completed since the last check
+			if done, err := current(); err == nil && (done == total || total < 0 && done != lastDone) {
+				progUpd.UpdateProgress(progressOut(done, total))
+			}
+			log.Debug("Stopping progress updates")
+			return
+		case <-time.After(progressInterval):
+		}
+	}
+}
+
+func progressOut(done, total int64) ProgressOut {
+	if total < 0 {
+		return ProgressOut{
+			Downloaded:      fmt.Sprintf("%.2fMB", float32(done)/(1024*1024)),
+			TotalSize:       "unknown",
+			DownloadedBytes: done,
+			TotalBytes:      unknownSize,
+			SizeUnknown:     true,
+		}
+	}
+	prog := float64(100)
+	if total > 0 {
+		prog = float64(done) / float64(total) * 100
+	}
+	return ProgressOut{
+		Percentage:      int(prog),
+		Percent:         prog,
+		Downloaded:      fmt.Sprintf("%.2fMB", float32(done)/(1024*1024)),
+		TotalSize:       fmt.Sprintf("%.2fMB", float32(total)/(1024*1024)),
+		DownloadedBytes: done,
+		TotalBytes:      total,
+	}
+}
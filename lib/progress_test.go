@@ -0,0 +1,419 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+type recordUpdater struct {
+	updates []int
+}
+
+func (r *recordUpdater) UpdateProgress(p ProgressOut) {
+	r.updates = append(r.updates, p.Percentage)
+}
+
+func TestTrackProgressMilestones(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = old }()
+
+	steps := []int64{0, 3, 8, 12, 14, 31, 100}
+	i := 0
+	current := func() (int64, error) {
+		v := steps[i]
+		if i < len(steps)-1 {
+			i++
+		}
+		return v, nil
+	}
+	r := &recordUpdater{}
+	trackProgress(context.Background(), r, 10, current, 100)
+
+	want := []int{0, 12, 31, 100}
+	if len(r.updates) != len(want) {
+		t.Fatalf("expected updates %v got %v", want, r.updates)
+	}
+	for j := range want {
+		if r.updates[j] != want[j] {
+			t.Fatalf("expected updates %v got %v", want, r.updates)
+		}
+	}
+}
+
+type fractionUpdater struct {
+	percents []float64
+}
+
+func (r *fractionUpdater) UpdateProgress(p ProgressOut) {
+	r.percents = append(r.percents, p.Percent)
+}
+
+func TestTrackProgressFraction(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = old }()
+
+	steps := []int64{1, 5, 1000}
+	i := 0
+	current := func() (int64, error) {
+		v := steps[i]
+		if i < len(steps)-1 {
+			i++
+		}
+		return v, nil
+	}
+	r := &fractionUpdater{}
+	trackProgress(context.Background(), r, 0, current, 1000)
+
+	want := []float64{0.1, 0.5, 100}
+	if len(r.percents) != len(want) {
+		t.Fatalf("expected updates %v got %v", want, r.percents)
+	}
+	for j := range want {
+		if math.Abs(r.percents[j]-want[j]) > 1e-9 {
+			t.Fatalf("expected updates %v got %v", want, r.percents)
+		}
+	}
+}
+
+// countUpdater counts the updates it gets, from any goroutine.
+type countUpdater struct {
+	n    int32
+	last int32
+}
+
+func (c *countUpdater) UpdateProgress(p ProgressOut) {
+	atomic.AddInt32(&c.n, 1)
+	atomic.StoreInt32(&c.last, int32(p.Percentage))
+}
+
+func TestStartProgressStop(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Hour
+	defer func() { progressInterval = old }()
+
+	// Nothing to track
+	startProgress(context.Background(), nil, 0, nil, 100)()
+	// Nothing to download
+	empty := &countUpdater{}
+	startProgress(context.Background(), empty, 0, nil, 0)()
+	if n, last := atomic.LoadInt32(&empty.n), atomic.LoadInt32(&empty.last); n != 1 || last != 100 {
+		t.Fatalf("expected completion of an empty download got %d updates, last %d", n, last)
+	}
+
+	var done int64
+	c := &countUpdater{}
+	stop := startProgress(context.Background(), c, 0, func() (int64, error) {
+		return atomic.LoadInt64(&done), nil
+	}, 100)
+	for atomic.LoadInt32(&c.n) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt64(&done, 100)
+	stop()
+	stop()
+	// The completion is reported even if it happened between two checks
+	if n, last := atomic.LoadInt32(&c.n), atomic.LoadInt32(&c.last); n != 2 || last != 100 {
+		t.Fatalf("expected the start and completion reported got %d updates, last %d", n, last)
+	}
+}
+
+type panicUpdater struct{}
+
+func (panicUpdater) UpdateProgress(p ProgressOut) {
+	panic("broken updater")
+}
+
+func TestStartProgressPanic(t *testing.T) {
+	stop := startProgress(context.Background(), panicUpdater{}, 0, func() (int64, error) {
+		return 50, nil
+	}, 100)
+	// Returns once the tracking ended on the panic
+	stop()
+}
+
+func TestProgressStopsOnFailure(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = old }()
+
+	l, fake, _, done := newFakeClient(t, 2*1024*1024, 3)
+	defer done()
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fake.Get(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.DAGService = &failingDAG{DAGService: fake.DAGService, bad: root.Links()[4].Cid}
+
+	before := runtime.NumGoroutine()
+	u := &countUpdater{}
+	out := l.Start("", false, false, u)
+	if out.Status == success {
+		t.Fatal("expected the download to fail")
+	}
+	n := atomic.LoadInt32(&u.n)
+	time.Sleep(20 * progressInterval)
+	if atomic.LoadInt32(&u.n) != n {
+		t.Fatal("progress updated after the download returned")
+	}
+	if n := goroutinesSettle(before + 5); n > before+5 {
+		t.Fatalf("goroutines leaked: %d before, %d after", before, n)
+	}
+
+	// nil is a valid updater
+	fake.DAGService = fake.DAGService.(*failingDAG).DAGService
+	out = l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+}
+
+// outUpdater keeps the updates it gets, from any goroutine.
+type outUpdater struct {
+	mtx     sync.Mutex
+	updates []ProgressOut
+}
+
+func (u *outUpdater) UpdateProgress(p ProgressOut) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.updates = append(u.updates, p)
+}
+
+func (u *outUpdater) list() []ProgressOut {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	return append([]ProgressOut{}, u.updates...)
+}
+
+func TestTrackProgressUnknownSize(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = old }()
+
+	steps := []int64{0, 0, 10, 10, 25}
+	i := 0
+	current := func() (int64, error) {
+		v := steps[i]
+		if i < len(steps)-1 {
+			i++
+		}
+		return v, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	u := &outUpdater{}
+	trackProgress(ctx, u, 10, current, unknownSize)
+
+	// Only the changes of the bytes written
+	updates := u.list()
+	if len(updates) != 3 {
+		t.Fatalf("expected 3 updates got %+v", updates)
+	}
+	for j, want := range []int64{0, 10, 25} {
+		p := updates[j]
+		if p.DownloadedBytes != want || !p.SizeUnknown || p.TotalBytes != unknownSize || p.Percent != 0 {
+			t.Fatalf("unexpected update %+v", p)
+		}
+	}
+}
+
+func TestStartEmptyFile(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 0, 3)
+	defer done()
+
+	u := &outUpdater{}
+	out := l.Start("", false, false, u)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	fi, err := os.Stat(l.destination)
+	if err != nil || fi.Size() != 0 {
+		t.Fatalf("expected an empty destination got %v %v", fi, err)
+	}
+	updates := u.list()
+	if len(updates) != 1 || updates[0].Percentage != 100 || math.IsNaN(updates[0].Percent) {
+		t.Fatalf("expected the download complete right away got %+v", updates)
+	}
+}
+
+// unsizedNode serves readers which don't tell the size of the content.
+type unsizedNode struct {
+	*fakeNode
+}
+
+type unsizedReader struct {
+	ufsio.DagReader
+}
+
+func (unsizedReader) Size() uint64 {
+	return 0
+}
+
+func (n unsizedNode) GetFile(ctx context.Context, c cid.Cid) (ufsio.DagReader, error) {
+	r, err := n.fakeNode.GetFile(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return unsizedReader{r}, nil
+}
+
+func TestStartUnknownSize(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = old }()
+
+	l, fake, data, done := newFakeClient(t, 2*1024*1024, 3)
+	defer done()
+	l.newNode = func(context.Context, *info, pnet.PSK, *contributions) (node, error) {
+		return unsizedNode{fake}, nil
+	}
+	metadata, err := l.cfg.BYOMetadata.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.Cookie.Size = int64(len(data))
+
+	u := &outUpdater{}
+	out := l.fetch(context.Background(), "sharable", metadata, false, false, u, &Timings{})
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file differs from the content")
+	}
+	updates := u.list()
+	if len(updates) == 0 {
+		t.Fatal("expected progress updates")
+	}
+	for _, p := range updates {
+		if !p.SizeUnknown || p.Percentage != 0 {
+			t.Fatalf("expected progress in bytes only got %+v", p)
+		}
+	}
+	if last := updates[len(updates)-1]; last.DownloadedBytes != int64(len(data)) {
+		t.Fatalf("expected %d bytes reported got %+v", len(data), last)
+	}
+
+	// The content ends before the size told by the metadata
+	metadata.Cookie.Size++
+	out = l.fetch(context.Background(), "sharable", metadata, false, false, nil, &Timings{})
+	if out.Status != internalError || out.Message != "Downloaded file is incomplete" {
+		t.Fatalf("expected the download to be incomplete got %+v", out)
+	}
+}
+
+func TestResumeProgress(t *testing.T) {
+	old := progressInterval
+	progressInterval = time.Millisecond
+	defer func() { progressInterval = old }()
+
+	l, fake, data, done := newFakeClient(t, 20*256*1024, 3)
+	defer done()
+	root, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := fake.Get(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root and the block resumed from, the others come once the first
+	// update is made
+	gate := make(chan struct{})
+	fake.DAGService = &heldDAG{
+		gatedDAG: gatedDAG{DAGService: fake.DAGService, gate: gate},
+		free:     map[cid.Cid]bool{root: true, nd.Links()[18].Cid: true},
+	}
+	// 90% of the content is there already
+	resumed := len(data) * 9 / 10
+	err = ioutil.WriteFile(l.destination+partSuffix, data[:resumed], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := l.cfg.BYOMetadata.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &gateUpdater{gate: gate}
+	out := l.fetch(context.Background(), "sharable", metadata, true, false, u, &Timings{})
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("resumed file differs from the content")
+	}
+	updates := u.list()
+	if len(updates) < 2 {
+		t.Fatalf("expected progress updates got %+v", updates)
+	}
+	// At most the block resumed from is written by then
+	if first := updates[0]; first.Percentage < 90 || first.Percentage == 100 || first.DownloadedBytes < int64(resumed) {
+		t.Fatalf("expected to start from the resumed bytes got %+v", first)
+	}
+	for i := 1; i < len(updates); i++ {
+		if updates[i].DownloadedBytes < updates[i-1].DownloadedBytes {
+			t.Fatalf("progress went back %+v", updates)
+		}
+	}
+	if last := updates[len(updates)-1]; last.Percentage != 100 || last.DownloadedBytes != int64(len(data)) {
+		t.Fatalf("expected the progress to complete got %+v", last)
+	}
+}
+
+// gateUpdater closes the gate on the first update.
+type gateUpdater struct {
+	outUpdater
+	once sync.Once
+	gate chan struct{}
+}
+
+func (u *gateUpdater) UpdateProgress(p ProgressOut) {
+	u.outUpdater.UpdateProgress(p)
+	u.once.Do(func() { close(u.gate) })
+}
+
+// heldDAG serves the free blocks right away and holds the others until the
+// gate is closed. Blocks requested together don't wait for each other.
+type heldDAG struct {
+	gatedDAG
+	free map[cid.Cid]bool
+}
+
+func (d *heldDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if d.free[c] {
+		return d.DAGService.Get(ctx, c)
+	}
+	return d.gatedDAG.Get(ctx, c)
+}
+
+func (d *heldDAG) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	return getManyVia(ctx, keys, d.Get)
+}
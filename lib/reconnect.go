@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Reconnection to a leader which dropped is attempted with a delay doubling
+// from reconnectBaseDelay up to reconnectMaxDelay, at most
+// maxLeaderReconnects times per leader over the whole download.
+var (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+)
+
+const maxLeaderReconnects = 5
+
+// leaderWatch reconnects to the leaders when they disconnect during a
+// download, so that a good source is not lost for the rest of it.
+type leaderWatch struct {
+	ctx     context.Context
+	h       host.Host
+	leaders map[peer.ID]peer.AddrInfo
+	budget  *retryBudget
+
+	mtx        sync.Mutex
+	attempts   map[peer.ID]int
+	pending    map[peer.ID]bool
+	reconnects int
+}
+
+// watchLeaders starts watching the connections to leaders until ctx is
+// done. Reconnections draw from budget.
+func watchLeaders(ctx context.Context, h host.Host, leaders []peer.AddrInfo, budget *retryBudget) *leaderWatch {
+	w := &leaderWatch{
+		ctx:      ctx,
+		h:        h,
+		budget:   budget,
+		leaders:  make(map[peer.ID]peer.AddrInfo, len(leaders)),
+		attempts: make(map[peer.ID]int),
+		pending:  make(map[peer.ID]bool),
+	}
+	for _, l := range leaders {
+		w.leaders[l.ID] = l
+	}
+	nb := &network.NotifyBundle{DisconnectedF: w.disconnected}
+	h.Network().Notify(nb)
+	go func() {
+		<-ctx.Done()
+		h.Network().StopNotify(nb)
+	}()
+	return w
+}
+
+func (w *leaderWatch) disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	pinfo, ok := w.leaders[p]
+	if !ok || n.Connectedness(p) == network.Connected {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.pending[p] || w.attempts[p] >= maxLeaderReconnects {
+		return
+	}
+	w.pending[p] = true
+	go w.reconnect(pinfo)
+}
+
+func (w *leaderWatch) reconnect(pinfo peer.AddrInfo) {
+	defer func() {
+		w.mtx.Lock()
+		w.pending[pinfo.ID] = false
+		w.mtx.Unlock()
+	}()
+	delay := reconnectBaseDelay
+	for {
+		w.mtx.Lock()
+		if w.attempts[pinfo.ID] >= maxLeaderReconnects {
+			w.mtx.Unlock()
+			log.Warnf("Giving up reconnecting to leader %s", pinfo.ID)
+			return
+		}
+		w.attempts[pinfo.ID]++
+		w.mtx.Unlock()
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if w.h.Network().Connectedness(pinfo.ID) == network.Connected {
+			return
+		}
+		if !w.budget.take(retryReconnect) {
+			return
+		}
+		err := w.h.Connect(w.ctx, pinfo)
+		if err == nil {
+			log.Infof("Reconnected to leader %s", pinfo.ID)
+			w.mtx.Lock()
+			w.reconnects++
+			w.mtx.Unlock()
+			return
+		}
+		log.Warnf("Failed reconnecting to leader %s Err: %s", pinfo.ID, err.Error())
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// count returns the number of successful reconnections.
+func (w *leaderWatch) count() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.reconnects
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestLeaderReconnect(t *testing.T) {
+	old := reconnectBaseDelay
+	reconnectBaseDelay = 10 * time.Millisecond
+	defer func() { reconnectBaseDelay = old }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshLinked(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, leader := mn.Hosts()[0], mn.Hosts()[1]
+	w := watchLeaders(ctx, h, []peer.AddrInfo{{ID: leader.ID(), Addrs: leader.Addrs()}}, nil)
+	if _, err := mn.ConnectPeers(h.ID(), leader.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.DisconnectPeers(h.ID(), leader.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("leader not reconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h.Network().Connectedness(leader.ID()) != network.Connected {
+		t.Fatal("leader not connected")
+	}
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// A download which doesn't receive any data for sessionStallTimeout while
+// its cookie is older than cookieLifetime is assumed to have been dropped
+// by the peers because the session expired.
+var sessionStallTimeout = 2 * time.Minute
+
+// Number of times the session is refreshed during a single download.
+const maxSessionRefreshes = 3
+
+// expired tells whether the cookie may no longer be honored by the peers
+// at now.
+func (i *info) expired(now time.Time) bool {
+	return !i.FetchedAt.IsZero() && now.Sub(i.FetchedAt) > cookieLifetime
+}
+
+// watchStall calls onExpired and returns if the download stalls while the
+// session of metadata is expired. written is the number of bytes written
+// so far. The time the download is paused, when paused is set, doesn't
+// count. Time is told by clk.
+func watchStall(ctx context.Context, clk Clock, written *int64, metadata *info, paused func() bool, onExpired func()) {
+	last := atomic.LoadInt64(written)
+	lastChange := clk.Now()
+	wasPaused := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clk.After(sessionStallTimeout / 4):
+		}
+		if paused != nil && paused() {
+			wasPaused = true
+			continue
+		}
+		if wasPaused {
+			// Measured again from the resumption
+			wasPaused = false
+			last, lastChange = atomic.LoadInt64(written), clk.Now()
+			continue
+		}
+		if n := atomic.LoadInt64(written); n != last {
+			last, lastChange = n, clk.Now()
+			continue
+		}
+		if clk.Since(lastChange) >= sessionStallTimeout && metadata.expired(clk.Now()) {
+			log.Warnf("No data for %s with a session from %s", clk.Since(lastChange), metadata.FetchedAt)
+			onExpired()
+			return
+		}
+	}
+}
+
+// refreshInfo fetches new metadata for the content of old. The peers of old
+// are kept so they are dialed again along with the new leaders.
+func (l *LightClient) refreshInfo(ctx context.Context, sharable string, old *info) (*info, error) {
+	if old.byo {
+		return nil, errors.New("provided metadata can't be refreshed")
+	}
+	var metadata *info
+	var err error
+	if len(old.name) > 0 {
+		metadata, err = l.getNameInfo(ctx, old.name)
+	} else {
+		metadata, err = l.getInfo(ctx, sharable)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata.Cookie.Filename) == 0 {
+		metadata.Cookie.Filename = old.Cookie.Filename
+	}
+	metadata.peers = old.peers
+	return metadata, nil
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchStall(t *testing.T) {
+	old := sessionStallTimeout
+	sessionStallTimeout = 20 * time.Millisecond
+	defer func() { sessionStallTimeout = old }()
+
+	var written int64
+	expired := make(chan struct{})
+	stale := &info{FetchedAt: time.Now().Add(-2 * cookieLifetime)}
+	go watchStall(context.Background(), realClock{}, &written, stale, nil, func() { close(expired) })
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("stalled download with an expired session not detected")
+	}
+
+	// A stall with a recent cookie isn't blamed on the session
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	fresh := &info{FetchedAt: time.Now()}
+	called := false
+	watchStall(ctx, realClock{}, &written, fresh, nil, func() { called = true })
+	if called {
+		t.Fatal("fresh session reported as expired")
+	}
+
+	// Nor is a pause, however long
+	var paused int32 = 1
+	ctx, cancel = context.WithTimeout(context.Background(), 10*sessionStallTimeout)
+	defer cancel()
+	watchStall(ctx, realClock{}, &written, stale, func() bool { return atomic.LoadInt32(&paused) == 1 }, func() { called = true })
+	if called {
+		t.Fatal("paused download reported as expired")
+	}
+
+	// The stall is measured from the resumption
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resumed := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(5 * sessionStallTimeout)
+		resumed <- time.Now()
+		atomic.StoreInt32(&paused, 0)
+	}()
+	watchStall(ctx, realClock{}, &written, stale, func() bool { return atomic.LoadInt32(&paused) == 1 }, func() { called = true })
+	if !called {
+		t.Fatal("stall after the resumption not detected")
+	}
+	if at := <-resumed; time.Since(at) < sessionStallTimeout {
+		t.Fatalf("stall reported %s after the resumption", time.Since(at))
+	}
+}
+
+func TestWatchStallFollowsClock(t *testing.T) {
+	clk := newFakeClock()
+	var written int64
+	// Fresh by the time of the system, not by the clock of the download
+	metadata := &info{FetchedAt: clk.Now()}
+	clk.advance(2 * cookieLifetime)
+	expired := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchStall(ctx, clk, &written, metadata, nil, func() { close(expired) })
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-expired:
+			return
+		case <-deadline:
+			t.Fatal("stall by the clock of the download not detected")
+		case <-time.After(time.Millisecond):
+			clk.advance(sessionStallTimeout / 4)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package lib
+
+import "encoding/json"
+
+// StatSchemaVersion is the version of the StatReport layout. It is bumped
+// on every incompatible change.
+const StatSchemaVersion = 1
+
+// StatReport is the JSON representation of StatOut. Unlike StatOut it does
+// not embed types from other packages, so its layout only changes along
+// with StatSchemaVersion:
+//
+//	{
+//	  "schema_version": 1,
+//	  "peers": ["<peer id>", ...],
+//	  "download_time_sec": 12,
+//	  "bytes": 1048576,
+//	  "timings_ms": {"metadata": 120, "setup": 30, "bootstrap": 900,
+//	                 "first_byte": 400, "transfer": 9000, "settlement": 5000},
+//	  "time_to_first_byte_ms": 1450,
+//	  "contributions": {"<peer id>": {"bytes": 524288, "blocks": 2,
+//	                                  "first_seen": "2020-07-01T00:00:00Z"}},
+//	  "ledger": {"receipts": 3},
+//	  "destinations": [{"path": "<file>"}, {"path": "<mirror>", "error": "..."}],
+//	  "session_refreshes": 0,
+//	  "leader_reconnects": 0,
+//	  "block_source": {"hits": 120, "misses": 8},
+//	  "retry_budget": {"used": {"get_file": 1}, "remaining_retries": 4,
+//	                   "remaining_time_ms": -1, "exhausted": false},
+//	  "network_bytes": 1049600,
+//	  "served_from_cache": false,
+//	  "bootstrap_transports": {"<peer id>": "tcp"},
+//	  "payment_channels": [{"peer": "<peer id>", "sent": 0.25, "status": "open"}],
+//	  "bandwidth": {"downloaded_bytes": 1049600, "uploaded_bytes": 20480,
+//	                "by_protocol": {"/ipfs/bitswap/1.2.0": {"downloaded_bytes": 1048900,
+//	                                                        "uploaded_bytes": 12288}}}
+//	}
+//
+// destinations is only present when mirrors are configured, block_source
+// when a block source is and retry_budget when a retry budget is.
+// bootstrap_transports is left out when no peer was connected at
+// bootstrap, e.g. with only the peers of previous downloads,
+// payment_channels when the SCP engine doesn't report them or none is open
+// and bandwidth when the traffic of the node isn't counted.
+type StatReport struct {
+	SchemaVersion       int                         `json:"schema_version"`
+	Peers               []string                    `json:"peers"`
+	DownloadTimeSec     int                         `json:"download_time_sec"`
+	Bytes               int64                       `json:"bytes"`
+	Timings             TimingsReport               `json:"timings_ms"`
+	TimeToFirstByte     int64                       `json:"time_to_first_byte_ms"`
+	Contributions       map[string]PeerContribution `json:"contributions"`
+	Ledger              LedgerSummary               `json:"ledger"`
+	Destinations        []DestinationResult         `json:"destinations,omitempty"`
+	SessionRefreshes    int                         `json:"session_refreshes"`
+	LeaderReconnects    int                         `json:"leader_reconnects"`
+	BlockSource         *BlockSourceSummary         `json:"block_source,omitempty"`
+	RetryBudget         *RetryBudgetReport          `json:"retry_budget,omitempty"`
+	NetworkBytes        int64                       `json:"network_bytes"`
+	ServedFromCache     bool                        `json:"served_from_cache"`
+	BootstrapTransports map[string]string           `json:"bootstrap_transports,omitempty"`
+	PaymentChannels     []ChannelState              `json:"payment_channels,omitempty"`
+	Bandwidth           *BandwidthReport            `json:"bandwidth,omitempty"`
+}
+
+// TimingsReport is Timings in milliseconds.
+type TimingsReport struct {
+	Metadata   int64 `json:"metadata"`
+	Setup      int64 `json:"setup"`
+	Bootstrap  int64 `json:"bootstrap"`
+	FirstByte  int64 `json:"first_byte"`
+	Transfer   int64 `json:"transfer"`
+	Settlement int64 `json:"settlement"`
+}
+
+// BlockSourceSummary is the number of blocks found or not in the block
+// source.
+type BlockSourceSummary struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// LedgerSummary summarizes the micropayment receipts of the download.
+type LedgerSummary struct {
+	Receipts int `json:"receipts"`
+}
+
+// Report returns the stable representation of the stats.
+func (s StatOut) Report() StatReport {
+	peers := s.ConnectedPeers
+	if peers == nil {
+		peers = []string{}
+	}
+	var source *BlockSourceSummary
+	if s.BlockSourceHits > 0 || s.BlockSourceMisses > 0 {
+		source = &BlockSourceSummary{Hits: s.BlockSourceHits, Misses: s.BlockSourceMisses}
+	}
+	return StatReport{
+		SchemaVersion:   StatSchemaVersion,
+		Peers:           peers,
+		DownloadTimeSec: s.DownloadTime,
+		Bytes:           s.Bytes,
+		Timings: TimingsReport{
+			Metadata:   s.Timings.Metadata.Milliseconds(),
+			Setup:      s.Timings.Setup.Milliseconds(),
+			Bootstrap:  s.Timings.Bootstrap.Milliseconds(),
+			FirstByte:  s.Timings.FirstByte.Milliseconds(),
+			Transfer:   s.Timings.Transfer.Milliseconds(),
+			Settlement: s.Timings.Settlement.Milliseconds(),
+		},
+		TimeToFirstByte: s.TimeToFirstByte.Milliseconds(),
+		Contributions:   s.Contributions,
+		Ledger: LedgerSummary{
+			Receipts: len(s.Ledgers),
+		},
+		Destinations:        s.Destinations,
+		SessionRefreshes:    s.SessionRefreshes,
+		LeaderReconnects:    s.LeaderReconnects,
+		BlockSource:         source,
+		RetryBudget:         s.RetryBudget,
+		NetworkBytes:        s.NetworkBytes,
+		ServedFromCache:     s.ServedFromCache,
+		BootstrapTransports: s.BootstrapTransports,
+		PaymentChannels:     s.PaymentChannels,
+		Bandwidth:           s.Bandwidth,
+	}
+}
+
+// MarshalJSON encodes the stats as a StatReport.
+func (s StatOut) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Report())
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatOutJSON(t *testing.T) {
+	st := StatOut{
+		ConnectedPeers:  []string{"QmPeer"},
+		DownloadTime:    2,
+		Bytes:           1024,
+		Timings:         Timings{Transfer: 1500 * time.Millisecond},
+		TimeToFirstByte: 700 * time.Millisecond,
+	}
+	buf, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := map[string]interface{}{}
+	err = json.Unmarshal(buf, &rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"schema_version", "peers", "download_time_sec", "bytes", "timings_ms", "time_to_first_byte_ms", "contributions", "ledger", "session_refreshes", "leader_reconnects", "network_bytes", "served_from_cache"} {
+		if _, ok := rep[k]; !ok {
+			t.Errorf("missing %q in %s", k, string(buf))
+		}
+	}
+	if v := rep["schema_version"].(float64); v != StatSchemaVersion {
+		t.Errorf("unexpected schema version %v", v)
+	}
+	if v := rep["timings_ms"].(map[string]interface{})["transfer"].(float64); v != 1500 {
+		t.Errorf("unexpected transfer timing %v", v)
+	}
+	if v := rep["time_to_first_byte_ms"].(float64); v != 700 {
+		t.Errorf("unexpected time to first byte %v", v)
+	}
+}
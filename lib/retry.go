@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// How long the root block of the content is looked for before assuming
+// the swarm is momentarily unable to serve it.
+var rootFetchTimeout = time.Minute
+
+// Number of times getting the file is retried when Config.GetFileRetries
+// is not set.
+const defaultGetFileRetries = 2
+
+func (l *LightClient) getFileRetries() int {
+	switch {
+	case l.cfg.GetFileRetries < 0:
+		return 0
+	case l.cfg.GetFileRetries == 0:
+		return defaultGetFileRetries
+	default:
+		return l.cfg.GetFileRetries
+	}
+}
+
+// isTransient tells whether failing to get a block may be fixed by trying
+// again with more peers.
+func isTransient(err error) bool {
+	return err == context.DeadlineExceeded || err == ipld.ErrNotFound
+}
+
+// getFile opens the content c, looking for its root block with a timeout
+// first. If no peer serves it in time the node is bootstrapped again with
+// peers and the lookup retried. The reader itself uses ctx.
+func (l *LightClient) getFile(ctx context.Context, lite node, c cid.Cid, peers func() int) (ufsio.DagReader, error) {
+	retries := l.getFileRetries()
+	for attempt := 0; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, rootFetchTimeout)
+		_, err := lite.Get(actx, c)
+		cancel()
+		if err == nil {
+			return lite.GetFile(ctx, c)
+		}
+		if ctx.Err() != nil || !isTransient(err) || attempt >= retries || !l.retryBudget().take(retryGetFile) {
+			return nil, err
+		}
+		log.Warnf("Failed getting %s, retrying Err: %s", c, err.Error())
+		log.Infof("Bootstrapped again with %d peers", peers())
+	}
+}
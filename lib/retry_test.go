@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-merkledag"
+)
+
+func TestGetFileRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lite, err := ipfslite.New(ctx, datastore.NewMapDatastore(), nil, nil, &ipfslite.Config{Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := merkledag.NewRawNode([]byte("hello"))
+
+	bootstraps := 0
+	peers := func() int {
+		bootstraps++
+		return 0
+	}
+	l := &LightClient{cfg: &Config{GetFileRetries: 3}}
+	if _, err := l.getFile(ctx, liteNode{Peer: lite}, nd.Cid(), peers); err == nil {
+		t.Fatal("expected an error for a missing block")
+	}
+	if bootstraps != 3 {
+		t.Fatalf("expected 3 bootstraps got %d", bootstraps)
+	}
+
+	bootstraps = 0
+	l.cfg.GetFileRetries = -1
+	if _, err := l.getFile(ctx, liteNode{Peer: lite}, nd.Cid(), peers); err == nil || bootstraps != 0 {
+		t.Fatalf("expected no retry got %d %v", bootstraps, err)
+	}
+
+	if err := lite.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	rsc, err := l.getFile(ctx, liteNode{Peer: lite}, nd.Cid(), peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsc.Size() != 5 {
+		t.Fatalf("unexpected size %d", rsc.Size())
+	}
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryBudget bounds the retries of a whole download, whichever step they
+// come from: starting the download over, refreshing the session, looking
+// for the content again, reconnecting to leaders, reporting the completion
+// and failing over to another metadata endpoint. Once it is exhausted the
+// steps fail instead of retrying.
+type RetryBudget struct {
+	// MaxRetries is the number of retries allowed, zero for no limit.
+	MaxRetries int
+	// MaxTime is the time after the start of the download past which no
+	// retry is made, zero for no limit.
+	MaxTime time.Duration
+}
+
+// Operations drawing from the retry budget.
+const (
+	retryAttempt   = "attempt"
+	retryRefresh   = "session_refresh"
+	retryGetFile   = "get_file"
+	retryReconnect = "leader_reconnect"
+	retryComplete  = "complete"
+	retryFailover  = "endpoint_failover"
+)
+
+// RetryBudgetReport tells how much of Config.RetryBudget a download used.
+// The remaining values are -1 when not limited.
+type RetryBudgetReport struct {
+	Used               map[string]int `json:"used"`
+	RemainingRetries   int            `json:"remaining_retries"`
+	RemainingTimeMilli int64          `json:"remaining_time_ms"`
+	Exhausted          bool           `json:"exhausted"`
+}
+
+// retryBudget tracks the retries of a download. A nil *retryBudget
+// allows every retry.
+type retryBudget struct {
+	limits RetryBudget
+	start  time.Time
+
+	mtx       sync.Mutex
+	used      map[string]int
+	total     int
+	exhausted bool
+}
+
+func newRetryBudget(limits RetryBudget) *retryBudget {
+	return &retryBudget{
+		limits: limits,
+		start:  time.Now(),
+		used:   make(map[string]int),
+	}
+}
+
+// take tells whether op may be retried and records the retry if so.
+func (b *retryBudget) take(op string) bool {
+	if b == nil {
+		return true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if (b.limits.MaxRetries > 0 && b.total >= b.limits.MaxRetries) ||
+		(b.limits.MaxTime > 0 && time.Since(b.start) >= b.limits.MaxTime) {
+		if !b.exhausted {
+			log.Warnf("Retry budget exhausted, not retrying %s", op)
+		}
+		b.exhausted = true
+		return false
+	}
+	b.total++
+	b.used[op]++
+	return true
+}
+
+func (b *retryBudget) isExhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.exhausted
+}
+
+func (b *retryBudget) report() *RetryBudgetReport {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	rep := &RetryBudgetReport{
+		Used:               make(map[string]int, len(b.used)),
+		RemainingRetries:   -1,
+		RemainingTimeMilli: -1,
+		Exhausted:          b.exhausted,
+	}
+	for op, n := range b.used {
+		rep.Used[op] = n
+	}
+	if b.limits.MaxRetries > 0 {
+		rep.RemainingRetries = b.limits.MaxRetries - b.total
+	}
+	if b.limits.MaxTime > 0 {
+		rep.RemainingTimeMilli = (b.limits.MaxTime - time.Since(b.start)).Milliseconds()
+		if rep.RemainingTimeMilli < 0 {
+			rep.RemainingTimeMilli = 0
+		}
+	}
+	return rep
+}
+
+// retryBudget returns the budget of the download in progress, nil when
+// there is none or Config.RetryBudget is not set.
+func (l *LightClient) retryBudget() *retryBudget {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.active == nil {
+		return nil
+	}
+	return l.active.budget
+}
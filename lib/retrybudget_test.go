@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	var none *retryBudget
+	if !none.take(retryGetFile) || none.report() != nil {
+		t.Fatal("nil budget limited retries")
+	}
+
+	b := newRetryBudget(RetryBudget{MaxRetries: 2})
+	if !b.take(retryGetFile) || !b.take(retryAttempt) || b.take(retryGetFile) {
+		t.Fatal("budget not enforced")
+	}
+	rep := b.report()
+	if !rep.Exhausted || rep.RemainingRetries != 0 || rep.RemainingTimeMilli != -1 {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+	if rep.Used[retryGetFile] != 1 || rep.Used[retryAttempt] != 1 {
+		t.Fatalf("unexpected use %v", rep.Used)
+	}
+
+	b = newRetryBudget(RetryBudget{MaxTime: time.Millisecond})
+	time.Sleep(2 * time.Millisecond)
+	if b.take(retryComplete) {
+		t.Fatal("retry allowed past the time budget")
+	}
+}
+
+func TestStartRetryBudget(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Hash = "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"
+	l.cfg.GetFileRetries = 2
+	l.cfg.RetryBudget = &RetryBudget{MaxRetries: 1}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != internalError || out.Message != "Retry budget exhausted" {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	// A single attempt, bootstrapping once plus once for its only retry
+	if fake.bootstraps != 2 {
+		t.Fatalf("expected 2 bootstraps got %d", fake.bootstraps)
+	}
+}
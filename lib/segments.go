@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Files are only split in segments of at least minSegmentSize, smaller
+// ones are not worth the extra readers.
+var minSegmentSize int64 = 4 * 1024 * 1024
+
+// segmentCount returns the number of segments to fetch the size bytes
+// left of a file in, 1 to fetch them sequentially. Segments are written at
+// their position in the destination, so they are not used along with
+// mirrors, which are written in order, nor with Config.StateFile, since a
+// file with holes can't be resumed, nor with Config.StreamOrder.
+func (l *LightClient) segmentCount(size int64, w io.Writer) int {
+	n := l.cfg.ParallelSegments
+	if n <= 1 || len(l.cfg.StateFile) > 0 || l.cfg.StreamOrder {
+		return 1
+	}
+	if mw, ok := w.(*mirrorWriter); !ok || len(mw.mirrors) > 0 {
+		return 1
+	}
+	if max := size / minSegmentSize; int64(n) > max {
+		n = int(max)
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// segment is a byte range of the file fetched by its own reader.
+type segment struct {
+	start, length int64
+	done          int64
+}
+
+// sectionWriter writes at the successive positions of a segment of dst.
+// The time of the first write of all the segments is set in fw.
+type sectionWriter struct {
+	dst  *os.File
+	seg  *segment
+	fw   *firstWriteWriter
+	once *sync.Once
+}
+
+func (w *sectionWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { w.fw.first = time.Now() })
+	n, err := w.dst.WriteAt(p, w.seg.start+atomic.LoadInt64(&w.seg.done))
+	atomic.AddInt64(&w.seg.done, int64(n))
+	return n, err
+}
+
+// copySegments fetches the size bytes of c following offset in n segments
+// at the same time, each written at its position in dst. The blocks are
+// checked against their hash as they are fetched and each segment must
+// have exactly its length, so the file is complete once they all are.
+// written is increased with the bytes written. On failure dst is cut
+// after the data written without gap, so that it can be resumed from
+// there, and its position is left at its end.
+func (l *LightClient) copySegments(
+	ctx context.Context,
+	lite node,
+	c cid.Cid,
+	dst *os.File,
+	offset, size int64,
+	n int,
+	written *int64,
+	fw *firstWriteWriter,
+) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	segs := make([]*segment, n)
+	per := size / int64(n)
+	for i := range segs {
+		segs[i] = &segment{start: offset + int64(i)*per, length: per}
+	}
+	segs[n-1].length = size - int64(n-1)*per
+	log.Infof("Fetching %d bytes in %d segments", size, n)
+
+	var once sync.Once
+	errs := make(chan error, n)
+	for _, seg := range segs {
+		go func(seg *segment) {
+			rsc, err := lite.GetFile(ctx, c)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer rsc.Close()
+			_, err = rsc.Seek(seg.start, io.SeekStart)
+			if err == nil {
+				sw := &sectionWriter{dst: dst, seg: seg, fw: fw, once: &once}
+				pw := &pauseWriter{Writer: sw, ctx: ctx, p: &l.paused}
+				_, err = copyExact(&countingWriter{Writer: pw, count: written}, io.LimitReader(rsc, seg.length), seg.length)
+			}
+			if err != nil {
+				err = fmt.Errorf("segment at %d: %w", seg.start, err)
+				// The other segments are useless now
+				cancel()
+			}
+			errs <- err
+		}(seg)
+	}
+	var err error
+	for range segs {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	// Only the data up to the first incomplete segment is kept
+	end := offset
+	for _, seg := range segs {
+		end += seg.done
+		if seg.done < seg.length {
+			break
+		}
+	}
+	if err != nil {
+		if terr := dst.Truncate(end); terr != nil {
+			log.Errorf("Failed cutting incomplete segments Err: %s", terr.Error())
+		}
+	}
+	if _, serr := dst.Seek(end, io.SeekStart); serr != nil && err == nil {
+		err = serr
+	}
+	return end - offset, err
+}
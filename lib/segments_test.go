@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// failingDAG fails to get the block bad.
+type failingDAG struct {
+	ipld.DAGService
+	bad cid.Cid
+}
+
+func (d *failingDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if c.Equals(d.bad) {
+		return nil, errors.New("unavailable")
+	}
+	return d.DAGService.Get(ctx, c)
+}
+
+func (d *failingDAG) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	return getManyVia(ctx, keys, d.Get)
+}
+
+func TestStartParallelSegments(t *testing.T) {
+	old := minSegmentSize
+	minSegmentSize = 1024 * 1024
+	defer func() { minSegmentSize = old }()
+
+	// Not a multiple of the number of segments nor of the chunks
+	l, _, data, done := newFakeClient(t, 10*1024*1024+123, 3)
+	defer done()
+	l.cfg.ParallelSegments = 4
+	if n := l.segmentCount(int64(len(data)), &mirrorWriter{}); n != 4 {
+		t.Fatalf("expected 4 segments got %d", n)
+	}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("reassembled file differs from the content")
+	}
+}
+
+func TestSegmentCount(t *testing.T) {
+	l := &LightClient{cfg: &Config{ParallelSegments: 8}}
+	w := &mirrorWriter{}
+	for _, c := range []struct {
+		size int64
+		want int
+	}{
+		{minSegmentSize - 1, 1},
+		{3 * minSegmentSize, 3},
+		{100 * minSegmentSize, 8},
+	} {
+		if n := l.segmentCount(c.size, w); n != c.want {
+			t.Fatalf("%d bytes: expected %d segments got %d", c.size, c.want, n)
+		}
+	}
+	if n := l.segmentCount(100*minSegmentSize, &mirrorWriter{mirrors: []*mirror{{}}}); n != 1 {
+		t.Fatalf("expected sequential fetch with mirrors got %d segments", n)
+	}
+	l.cfg.StreamOrder = true
+	if n := l.segmentCount(100*minSegmentSize, w); n != 1 {
+		t.Fatalf("expected sequential fetch in stream order got %d segments", n)
+	}
+	l.cfg.StreamOrder = false
+	l.cfg.StateFile = "state"
+	if n := l.segmentCount(100*minSegmentSize, w); n != 1 {
+		t.Fatalf("expected sequential fetch with a state file got %d segments", n)
+	}
+}
+
+func TestCopySegmentsFailure(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 10*1024*1024, 3)
+	defer done()
+	ctx := context.Background()
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fake.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A leaf of the third of 4 segments
+	fake.DAGService = &failingDAG{DAGService: fake.DAGService, bad: root.Links()[25].Cid}
+
+	dst, err := os.Create(filepath.Join(filepath.Dir(l.destination), "part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	var written int64
+	n, err := l.copySegments(ctx, fake, c, dst, 0, int64(len(data)), 4, &written, &firstWriteWriter{})
+	if err == nil {
+		t.Fatal("expected the missing block to fail the download")
+	}
+	if n > 6*1024*1024+256*1024 {
+		t.Fatalf("kept %d bytes past the missing block", n)
+	}
+	got, err := ioutil.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The data kept is without gap so the download can be resumed
+	if int64(len(got)) != n || !bytes.Equal(got, data[:n]) {
+		t.Fatalf("kept %d bytes, reported %d, not matching the content", len(got), n)
+	}
+}
+
+// BenchmarkSegments compares fetching a file sequentially and in segments
+// from peers taking some time to serve each block.
+func BenchmarkSegments(b *testing.B) {
+	for _, segs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d", segs), func(b *testing.B) {
+			l, fake, data, done := newFakeClient(b, 32*1024*1024, 3)
+			defer done()
+			fake.DAGService = &slowDAG{DAGService: fake.DAGService, contrib: newContributions(), from: fake.h.ID()}
+			c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+			if err != nil {
+				b.Fatal(err)
+			}
+			dst, err := os.Create(filepath.Join(filepath.Dir(l.destination), "part"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer dst.Close()
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var written int64
+				_, err := l.copySegments(context.Background(), fake, c, dst, 0, int64(len(data)), segs, &written, &firstWriteWriter{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// Time left by default to SCP to send the last micropayments.
+const defaultSettleWait = 5 * time.Second
+
+// settle lets the micropayments of the download be sent before the node
+// is stopped, by flushing them if asked to or by waiting.
+func (l *LightClient) settle(ctx context.Context, lite node) {
+	if l.cfg.FlushPayments {
+		err := lite.flushPayments(ctx)
+		if err == nil {
+			return
+		}
+		log.Warnf("Failed flushing micropayments, waiting instead Err: %s", err.Error())
+	}
+	wait := l.cfg.SettleWait
+	if wait == 0 {
+		wait = defaultSettleWait
+	}
+	if wait < 0 {
+		return
+	}
+	select {
+	case <-l.clock().After(wait):
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultSharableHosts are the hosts whose links are accepted in place of a
+// bare sharable.
+var DefaultSharableHosts = []string{"hive.streamspace.io"}
+
+// Query parameter carrying the sharable in links which don't have it as the
+// last path segment.
+const sharableParam = "link"
+
+// ValidateSharable checks that the sharable looks like a token issued by the
+// service. Sharables are shortid strings, so only alphanumerics, '-' and '_'
+// are allowed.
+func ValidateSharable(sharable string) error {
+	if len(sharable) == 0 {
+		return errors.New("sharable is empty")
+	}
+	for _, r := range sharable {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_':
+		default:
+			return fmt.Errorf("invalid character %q in sharable", r)
+		}
+	}
+	return nil
+}
+
+// ParseSharable returns the sharable from either a bare sharable or a full
+// link like https://hive.streamspace.io/d/<sharable>. Links are only accepted
+// from DefaultSharableHosts and the extra allowedHosts.
+func ParseSharable(s string, allowedHosts []string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		return s, ValidateSharable(s)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if !hostAllowed(u.Hostname(), allowedHosts) {
+		return "", fmt.Errorf("links from host %q are not allowed", u.Hostname())
+	}
+	sharable := u.Query().Get(sharableParam)
+	if len(sharable) == 0 {
+		sharable = path.Base(strings.TrimRight(u.Path, "/"))
+	}
+	if err := ValidateSharable(sharable); err != nil {
+		return "", fmt.Errorf("no sharable found in link: %s", err.Error())
+	}
+	return sharable, nil
+}
+
+func hostAllowed(host string, allowedHosts []string) bool {
+	for _, list := range [][]string{DefaultSharableHosts, allowedHosts} {
+		for _, h := range list {
+			if strings.EqualFold(h, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package lib
+
+import "testing"
+
+func TestParseSharable(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		allowed []string
+		out     string
+		fail    bool
+	}{
+		{in: "fzhnp4jhFnMUKVGMKpt4kBMrvX", out: "fzhnp4jhFnMUKVGMKpt4kBMrvX"},
+		{in: " fzhnp4jhFnMUKVGMKpt4kBMrvX\n", out: "fzhnp4jhFnMUKVGMKpt4kBMrvX"},
+		{in: "https://hive.streamspace.io/d/fzhnp4jhFnMUKVGMKpt4kBMrvX?x=1", out: "fzhnp4jhFnMUKVGMKpt4kBMrvX"},
+		{in: "https://hive.streamspace.io/d/fzhnp4jhFnMUKVGMKpt4kBMrvX/", out: "fzhnp4jhFnMUKVGMKpt4kBMrvX"},
+		{in: "https://hive.streamspace.io/share?link=fzhnp4jhFnMUKVGMKpt4kBMrvX", out: "fzhnp4jhFnMUKVGMKpt4kBMrvX"},
+		{in: "https://mirror.example.com/d/fzhnp4jhFnMUKVGMKpt4kBMrvX", fail: true},
+		{in: "https://mirror.example.com/d/fzhnp4jhFnMUKVGMKpt4kBMrvX", allowed: []string{"mirror.example.com"}, out: "fzhnp4jhFnMUKVGMKpt4kBMrvX"},
+		{in: "https://hive.streamspace.io/", fail: true},
+		{in: "", fail: true},
+		{in: "fzhnp4jh/FnMUKVGMKpt4kBMrvX", fail: true},
+	} {
+		out, err := ParseSharable(tc.in, tc.allowed)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tc.in, out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.in, err.Error())
+			continue
+		}
+		if out != tc.out {
+			t.Errorf("%q: expected %q got %q", tc.in, tc.out, out)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Suffixes of the sidecar files written with Config.WriteSidecar.
+const (
+	cidSuffix    = ".cid"
+	sha256Suffix = ".sha256"
+)
+
+// writeSidecars writes the sidecar files of the completed destination.
+// Each is written to a temporary file first, so a sidecar is either
+// complete or missing.
+func (l *LightClient) writeSidecars(hash string) error {
+	if l.cfg.WriteSidecar {
+		err := writeAtomic(l.destination+cidSuffix, []byte(hash+"\n"), l.fileMode())
+		if err != nil {
+			return err
+		}
+	}
+	if !l.cfg.SidecarSHA256 {
+		return nil
+	}
+	fi, err := os.Stat(l.destination)
+	if err != nil {
+		return err
+	}
+	// Directories don't have a single checksum
+	if fi.IsDir() {
+		return nil
+	}
+	sum, err := fileSHA256(l.destination)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(l.destination))
+	return writeAtomic(l.destination+sha256Suffix, []byte(line), l.fileMode())
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeAtomic(path string, buf []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, buf, mode)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartWritesSidecars(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.WriteSidecar = true
+	l.cfg.SidecarSHA256 = true
+
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination + cidSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != l.cfg.BYOMetadata.Hash+"\n" {
+		t.Fatalf("unexpected cid sidecar %q", got)
+	}
+	got, err = ioutil.ReadFile(l.destination + sha256Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:]) + "  " + filepath.Base(l.destination) + "\n"
+	if string(got) != want {
+		t.Fatalf("expected sha256 sidecar %q got %q", want, got)
+	}
+}
+
+func TestStartFailureNoSidecar(t *testing.T) {
+	l, _, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.WriteSidecar = true
+	l.cfg.BYOMetadata.Hash = "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"
+	l.cfg.GetFileRetries = -1
+
+	out := l.Start("", false, false, nil)
+	if out.Status == success {
+		t.Fatal("download of missing content succeeded")
+	}
+	if _, err := os.Stat(l.destination + cidSuffix); !os.IsNotExist(err) {
+		t.Fatal("sidecar written for a failed download")
+	}
+}
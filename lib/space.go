@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// errSpaceUnknown is returned by freeSpace on platforms where the free
+// space of a file system can't be found.
+var errSpaceUnknown = errors.New("free space unknown")
+
+// checkDestination fails when the directory the download is written to
+// doesn't exist, isn't writable or is full. It is done before asking the
+// service for the metadata, so that no session is started for a download
+// which can't complete.
+func (l *LightClient) checkDestination() error {
+	dir := filepath.Dir(l.destination)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("destination directory %s doesn't exist", dir)
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("destination directory %s is not a directory", dir)
+	}
+	f, err := ioutil.TempFile(dir, ".swrm-check-")
+	if err != nil {
+		return fmt.Errorf("destination directory %s is not writable: %s", dir, err.Error())
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return checkSpace(dir, 1)
+}
+
+// checkSpace fails when the file system of dir doesn't have size bytes
+// available. Nothing is checked where the free space is unknown.
+func checkSpace(dir string, size int64) error {
+	free, err := freeSpace(dir)
+	if err != nil {
+		return nil
+	}
+	if free < uint64(size) {
+		return fmt.Errorf("not enough space in %s, %d bytes needed %d available", dir, size, free)
+	}
+	return nil
+}
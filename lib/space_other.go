@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package lib
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errSpaceUnknown
+}
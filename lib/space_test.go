@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startWithoutService runs Start against a metadata service failing the
+// test if it is asked anything.
+func startWithoutService(t *testing.T, destination string) *Out {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("metadata requested for an unusable destination")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	old := ApiAddr
+	ApiAddr = srv.URL
+	defer func() { ApiAddr = old }()
+
+	l, err := NewLightClient(destination, "1m", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.Start("fzhnp4jhFnMUKVGMKpt4kBMrvX", false, false, nil)
+}
+
+func TestStartMissingDestinationDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := startWithoutService(t, filepath.Join(dir, "missing", "file"))
+	if out.Status != destinationErr {
+		t.Fatalf("expected status %d got %+v", destinationErr, out)
+	}
+}
+
+func TestStartReadOnlyDestinationDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	dir, err := ioutil.TempDir("", "space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	out := startWithoutService(t, filepath.Join(dir, "file"))
+	if out.Status != destinationErr {
+		t.Fatalf("expected status %d got %+v", destinationErr, out)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatal("nothing should be left in the destination directory")
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkSpace(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := freeSpace(dir); err != nil {
+		t.Skip("free space unknown on this platform")
+	}
+	if err := checkSpace(dir, 1<<62); err == nil {
+		t.Fatal("expected an error for an impossible size")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package lib
+
+import "syscall"
+
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
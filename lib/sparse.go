@@ -0,0 +1,302 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+// Number of nodes whose children are fetched at the same time by
+// copySparse. The children of a node are requested at once, so this is a
+// few hundred blocks in flight.
+const sparseWindow = 2
+
+// byteRange is the data of a file from Start to End, excluded.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// rangeSet is the data of a file written so far with Config.SparseAssembly,
+// as sorted byte ranges without overlap nor contact.
+type rangeSet struct {
+	mtx    sync.Mutex
+	ranges []byteRange
+}
+
+func newRangeSet(ranges []byteRange) *rangeSet {
+	s := &rangeSet{}
+	for _, r := range ranges {
+		s.add(r.Start, r.End)
+	}
+	return s
+}
+
+// add marks the data from start to end written.
+func (s *rangeSet) add(start, end int64) {
+	if end <= start {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// First range ending at or after start, it is merged if it touches
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].End >= start })
+	j := i
+	for j < len(s.ranges) && s.ranges[j].Start <= end {
+		if s.ranges[j].Start < start {
+			start = s.ranges[j].Start
+		}
+		if s.ranges[j].End > end {
+			end = s.ranges[j].End
+		}
+		j++
+	}
+	merged := append([]byteRange{{Start: start, End: end}}, s.ranges[j:]...)
+	s.ranges = append(s.ranges[:i], merged...)
+}
+
+// contains tells if all the data from start to end is written.
+func (s *rangeSet) contains(start, end int64) bool {
+	if end <= start {
+		return true
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].End >= end })
+	return i < len(s.ranges) && s.ranges[i].Start <= start
+}
+
+// total returns the number of bytes written.
+func (s *rangeSet) total() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var n int64
+	for _, r := range s.ranges {
+		n += r.End - r.Start
+	}
+	return n
+}
+
+// list returns a copy of the ranges, for the state file.
+func (s *rangeSet) list() []byteRange {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]byteRange{}, s.ranges...)
+}
+
+func (s *rangeSet) reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.ranges = nil
+}
+
+// prefix returns the length of the data written without gap from the
+// beginning of ranges.
+func prefix(ranges []byteRange) int64 {
+	if len(ranges) == 0 || ranges[0].Start > 0 {
+		return 0
+	}
+	return ranges[0].End
+}
+
+// sparse tells if the file written to w is assembled with copySparse.
+// Like segments, it is not used along with mirrors, which are written in
+// order, nor with Config.StreamOrder.
+func (l *LightClient) sparse(w io.Writer) bool {
+	if !l.cfg.SparseAssembly || l.cfg.StreamOrder {
+		return false
+	}
+	mw, ok := w.(*mirrorWriter)
+	return ok && len(mw.mirrors) == 0
+}
+
+// nodeParts returns the file data held by nd itself and the sizes of the
+// data of its children, in the order of its links.
+func nodeParts(nd ipld.Node) ([]byte, []int64, error) {
+	switch nd := nd.(type) {
+	case *merkledag.RawNode:
+		return nd.RawData(), nil, nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return nil, nil, err
+		}
+		if fsn.NumChildren() != len(nd.Links()) {
+			return nil, nil, fmt.Errorf("node %s has %d links and %d block sizes", nd.Cid(), len(nd.Links()), fsn.NumChildren())
+		}
+		sizes := make([]int64, fsn.NumChildren())
+		for i := range sizes {
+			sizes[i] = int64(fsn.BlockSize(i))
+		}
+		return fsn.Data(), sizes, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected node %s", nd.Cid())
+}
+
+// assembler writes the blocks of a file at their position in dst as they
+// arrive.
+type assembler struct {
+	lite    node
+	dst     *os.File
+	done    *rangeSet
+	written *int64
+	fw      *firstWriteWriter
+	once    sync.Once
+	paused  *pauser
+	// window bounds the nodes whose children are fetched at once.
+	window chan struct{}
+}
+
+// write writes data at off unless it was already.
+func (a *assembler) write(ctx context.Context, data []byte, off int64) error {
+	end := off + int64(len(data))
+	if len(data) == 0 || a.done.contains(off, end) {
+		return nil
+	}
+	err := a.paused.wait(ctx)
+	if err != nil {
+		return err
+	}
+	a.once.Do(func() { a.fw.first = time.Now() })
+	_, err = a.dst.WriteAt(data, off)
+	if err != nil {
+		return err
+	}
+	a.done.add(off, end)
+	atomic.AddInt64(a.written, int64(len(data)))
+	return nil
+}
+
+// fill writes the data of nd and of its children, at off. The children
+// missing are fetched at once and written in the order they arrive.
+func (a *assembler) fill(ctx context.Context, nd ipld.Node, off int64) error {
+	data, sizes, err := nodeParts(nd)
+	if err != nil {
+		return err
+	}
+	err = a.write(ctx, data, off)
+	if err != nil {
+		return err
+	}
+	off += int64(len(data))
+	// A block can be linked several times, e.g. the blocks of zeros
+	offsets := make(map[cid.Cid][]int64)
+	var keys []cid.Cid
+	for i, lnk := range nd.Links() {
+		if !a.done.contains(off, off+sizes[i]) {
+			if _, ok := offsets[lnk.Cid]; !ok {
+				keys = append(keys, lnk.Cid)
+			}
+			offsets[lnk.Cid] = append(offsets[lnk.Cid], off)
+		}
+		off += sizes[i]
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg    sync.WaitGroup
+		mtx   sync.Mutex
+		first error
+	)
+	fail := func(err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if first == nil {
+			first = err
+			cancel()
+		}
+	}
+	select {
+	case a.window <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for opt := range a.lite.GetMany(ctx, keys) {
+		if opt.Err != nil {
+			fail(opt.Err)
+			continue
+		}
+		for _, o := range offsets[opt.Node.Cid()] {
+			if len(opt.Node.Links()) == 0 {
+				if err := a.fill(ctx, opt.Node, o); err != nil {
+					fail(err)
+				}
+				continue
+			}
+			// Its children are fetched once this node is done with the
+			// window, not to hold it while waiting for room
+			wg.Add(1)
+			go func(nd ipld.Node, o int64) {
+				defer wg.Done()
+				if err := a.fill(ctx, nd, o); err != nil {
+					fail(err)
+				}
+			}(opt.Node, o)
+		}
+	}
+	<-a.window
+	wg.Wait()
+	if first == nil {
+		first = ctx.Err()
+	}
+	return first
+}
+
+// copySparse fetches the size bytes of c missing from done and writes each
+// block at its position in dst as soon as it arrives, instead of in order,
+// so that a block slow to come doesn't hold back the ones after it. dst is
+// extended to size first, the holes take no space where the file system
+// supports sparse files. done is increased with the data written, which
+// is also added to written. The file is complete once done covers it.
+func (l *LightClient) copySparse(
+	ctx context.Context,
+	lite node,
+	c cid.Cid,
+	dst *os.File,
+	size int64,
+	done *rangeSet,
+	written *int64,
+	fw *firstWriteWriter,
+) (int64, error) {
+	err := dst.Truncate(size)
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("Assembling %d bytes as they arrive, %d already written", size, done.total())
+	a := &assembler{
+		lite:    lite,
+		dst:     dst,
+		done:    done,
+		written: written,
+		fw:      fw,
+		paused:  &l.paused,
+		window:  make(chan struct{}, sparseWindow),
+	}
+	before := atomic.LoadInt64(written)
+	root, err := lite.Get(ctx, c)
+	if err == nil {
+		err = a.fill(ctx, root, 0)
+	}
+	n := atomic.LoadInt64(written) - before
+	if err != nil {
+		return n, err
+	}
+	if !done.contains(0, size) {
+		return n, fmt.Errorf("%w: assembled %d bytes, expected %d", errSizeMismatch, done.total(), size)
+	}
+	return n, nil
+}
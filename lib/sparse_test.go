@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// shuffledDAG serves the blocks after a random delay, some much longer,
+// and GetMany returns them as they arrive like bitswap does.
+type shuffledDAG struct {
+	ipld.DAGService
+}
+
+func (d *shuffledDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	delay := time.Duration(rand.Intn(5)) * time.Millisecond
+	if rand.Intn(20) == 0 {
+		delay = 50 * time.Millisecond
+	}
+	time.Sleep(delay)
+	return d.DAGService.Get(ctx, c)
+}
+
+func (d *shuffledDAG) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	return getManyVia(ctx, keys, d.Get)
+}
+
+func TestRangeSet(t *testing.T) {
+	s := newRangeSet(nil)
+	s.add(10, 20)
+	s.add(30, 40)
+	s.add(0, 5)
+	s.add(20, 25)
+	s.add(15, 18)
+	want := []byteRange{{0, 5}, {10, 25}, {30, 40}}
+	if got := s.list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+	if !s.contains(12, 25) || s.contains(4, 6) || s.contains(24, 31) {
+		t.Fatalf("unexpected coverage of %v", s.list())
+	}
+	if s.total() != 30 || prefix(s.list()) != 5 {
+		t.Fatalf("unexpected total %d or prefix %d", s.total(), prefix(s.list()))
+	}
+	// Bridging several ranges
+	s.add(3, 35)
+	if got := s.list(); !reflect.DeepEqual(got, []byteRange{{0, 40}}) {
+		t.Fatalf("expected a single range got %v", got)
+	}
+	if prefix(nil) != 0 || prefix([]byteRange{{5, 10}}) != 0 {
+		t.Fatal("expected no prefix")
+	}
+}
+
+func TestStartSparseAssembly(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 10*1024*1024+123, 3)
+	defer done()
+	fake.DAGService = &shuffledDAG{DAGService: fake.DAGService}
+	l.cfg.SparseAssembly = true
+	if !l.sparse(&mirrorWriter{}) {
+		t.Fatal("expected the file to be assembled sparse")
+	}
+
+	out := l.Start("", false, true, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	if st, ok := out.Data.(StatOut); !ok || st.Bytes != int64(len(data)) {
+		t.Fatalf("unexpected stats %+v", out.Data)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("assembled file differs from the content")
+	}
+}
+
+func TestCopySparseResume(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 10*1024*1024, 3)
+	defer done()
+	ctx := context.Background()
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := fake.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dag := fake.DAGService
+	bad := root.Links()[25].Cid
+	fake.DAGService = &failingDAG{DAGService: &shuffledDAG{DAGService: dag}, bad: bad}
+
+	dst, err := os.Create(filepath.Join(filepath.Dir(l.destination), "part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	assembled := newRangeSet(nil)
+	var written int64
+	_, err = l.copySparse(ctx, fake, c, dst, int64(len(data)), assembled, &written, &firstWriteWriter{})
+	if err == nil {
+		t.Fatal("expected the missing block to fail the download")
+	}
+	// The blocks after the missing one may have been written
+	blockStart, blockEnd := int64(25*256*1024), int64(26*256*1024)
+	if assembled.contains(blockStart, blockEnd) || written != assembled.total() {
+		t.Fatalf("unexpected ranges %v after writing %d bytes", assembled.list(), written)
+	}
+
+	// Resumed from the ranges in the state
+	l.setSession(&session{sharable: "sharable", destination: l.destination, assembled: assembled})
+	state := filepath.Join(filepath.Dir(l.destination), "state")
+	err = l.SaveState(state)
+	l.setSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf, []byte(`"ranges":[{"start":0,`)) {
+		t.Fatalf("ranges not saved in %s", buf)
+	}
+	st := &downloadState{}
+	err = json.Unmarshal(buf, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Completed > blockStart {
+		t.Fatalf("completed %d past the missing block", st.Completed)
+	}
+	resumed := newRangeSet(st.Ranges)
+	fake.DAGService = dag
+	before := written
+	_, err = l.copySparse(ctx, fake, c, dst, int64(len(data)), resumed, &written, &firstWriteWriter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written-before != int64(len(data))-assembled.total() {
+		t.Fatalf("wrote %d bytes again, %d were missing", written-before, int64(len(data))-assembled.total())
+	}
+	got, err := ioutil.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("resumed file differs from the content")
+	}
+}
+
+// BenchmarkSparseAssembly compares writing a file in order and as the
+// blocks arrive, from peers serving them out of order and some late.
+func BenchmarkSparseAssembly(b *testing.B) {
+	for _, sparse := range []bool{false, true} {
+		name := "sequential"
+		if sparse {
+			name = "sparse"
+		}
+		b.Run(name, func(b *testing.B) {
+			l, fake, data, done := newFakeClient(b, 32*1024*1024, 3)
+			defer done()
+			fake.DAGService = &shuffledDAG{DAGService: fake.DAGService}
+			c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+			if err != nil {
+				b.Fatal(err)
+			}
+			dst, err := os.Create(filepath.Join(filepath.Dir(l.destination), "part"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer dst.Close()
+			ctx := context.Background()
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var written int64
+				if sparse {
+					_, err = l.copySparse(ctx, fake, c, dst, int64(len(data)), newRangeSet(nil), &written, &firstWriteWriter{})
+				} else {
+					var rsc io.ReadCloser
+					rsc, err = fake.GetFile(ctx, c)
+					if err == nil {
+						dst.Seek(0, io.SeekStart)
+						_, err = copyExact(dst, rsc, int64(len(data)))
+						rsc.Close()
+					}
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -3,28 +3,33 @@ package lib
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/StreamSpace/scp"
 	"github.com/StreamSpace/scp/engine"
 	ipfslite "github.com/StreamSpace/ss-light-client"
 	externalip "github.com/glendc/go-external-ip"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	syncds "github.com/ipfs/go-datastore/sync"
 	logger "github.com/ipfs/go-log/v2"
+	ufsio "github.com/ipfs/go-unixfs/io"
 	crypto "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/pnet"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -41,10 +46,19 @@ const (
 	peerThreshold int    = 5
 
 	success        = 200
+	partialContent = 206
 	internalError  = 500
 	timeoutError   = 504
 	serviceError   = 503
 	destinationErr = 404
+	swarmKeyErr    = 403
+	sessionExpired = 401
+	invalidInput   = 400
+	costExceeded   = 402
+	noPeers        = 502
+	throughputLow  = 408
+	clockSkewed    = 412
+	linkExpired    = 410
 )
 
 // API objects
@@ -55,24 +69,157 @@ type cookie struct {
 	Filename      string
 	Hash          string
 	Link          string
+	// Size of the file in bytes, when the service provides it
+	Size int64
+	// ValidUntil is when the sharable expires, when the service provides
+	// it. Expired sharables are not downloaded.
+	ValidUntil validUntil
 }
 
+// StatOut holds the stats of a download. It is encoded to JSON as a
+// StatReport.
 type StatOut struct {
-	ConnectedPeers []string            `json:"connected_peers"`
-	Ledgers        []*engine.SSReceipt `json:"ledger"`
-	DownloadTime   int                 `json:"download_time"`
+	ConnectedPeers []string
+	Ledgers        []*engine.SSReceipt
+	DownloadTime   int
+	Bytes          int64
+	Contributions  map[string]PeerContribution
+	Timings        Timings
+	Destinations   []DestinationResult
+	// SessionRefreshes is the number of times the session expired and
+	// new metadata was fetched to complete the download.
+	SessionRefreshes int
+	// LeaderReconnects is the number of times a leader which dropped was
+	// connected again.
+	LeaderReconnects int
+	// BlockSourceHits and BlockSourceMisses are the number of blocks found
+	// or not in Config.BlockSource.
+	BlockSourceHits   int64
+	BlockSourceMisses int64
+	// RetryBudget is the use of Config.RetryBudget, nil if not set.
+	RetryBudget *RetryBudgetReport
+	// NetworkBytes is the size of the blocks received from peers, the
+	// others being already in the datastore or served by
+	// Config.BlockSource. ServedFromCache is set when all of them were in
+	// the datastore, so that the download cost nothing.
+	NetworkBytes    int64
+	ServedFromCache bool
+	// TimeToFirstByte is the time from the start of the download to the
+	// first byte written, when a player reading the destination could
+	// start. With Config.StreamOrder the first byte is the beginning of
+	// the file. Zero for directories.
+	TimeToFirstByte time.Duration
+	// BootstrapTransports maps the peers connected at bootstrap to the
+	// transport of their connection, e.g. "tcp". See
+	// Config.PreferredTransports.
+	BootstrapTransports map[string]string
+	// PaymentChannels are the payment channels open at the end of the
+	// download, nil when the SCP engine doesn't report them. See
+	// LightClient.PaymentChannels.
+	PaymentChannels []ChannelState
+	// Bandwidth is the traffic of the download, uploads included, nil
+	// when run over a host given to NewLightClientWithHost.
+	Bandwidth *BandwidthReport
 }
 
+// Identity is the peer ID and the addresses the node listens on.
+type Identity struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// ProgressOut is the progress of a download. Percentage is rounded down,
+// Percent keeps the fraction for smooth progress bars. The byte counts are
+// exact where Downloaded and TotalSize are human readable. When the size
+// of the content isn't known SizeUnknown is set, TotalBytes is -1 and the
+// percentages are zero: only the bytes downloaded are told.
 type ProgressOut struct {
-	Percentage int    `json:"percentage"`
-	Downloaded string `json:"downloaded"`
-	TotalSize  string `json:"total_size"`
+	Percentage      int     `json:"percentage"`
+	Percent         float64 `json:"percent"`
+	Downloaded      string  `json:"downloaded"`
+	TotalSize       string  `json:"total_size"`
+	DownloadedBytes int64   `json:"downloaded_bytes"`
+	TotalBytes      int64   `json:"total_bytes"`
+	SizeUnknown     bool    `json:"size_unknown,omitempty"`
 }
 
 type info struct {
 	Cookie   cookie
 	SwarmKey []byte
 	Rate     string
+	// FetchedAt is when the cookie was issued by the service
+	FetchedAt time.Time
+
+	// name is set when the content is addressed by an IPNS name or DNSLink
+	// which has to be resolved to get the hash.
+	name string
+	// peers are dialed along with the leaders. They come from the state
+	// of a previous run.
+	peers []peer.AddrInfo
+	// byo is set when the metadata was provided with Config.BYOMetadata
+	// rather than by the service.
+	byo bool
+	// clockSkew is set when the local clock was found incorrect while
+	// getting the metadata.
+	clockSkew *ClockSkewError
+	// endpoint is the address of the metadata service which served it,
+	// told first of the completion.
+	endpoint string
+	// assembled are the ranges of the part file written by a previous run
+	// with Config.SparseAssembly, nil if it was written in order.
+	assembled []byteRange
+}
+
+// FileInfo is the part of the sharable metadata which is safe to show to
+// users.
+type FileInfo struct {
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Link     string `json:"link"`
+	Rate     string `json:"rate"`
+	Leaders  int    `json:"leaders"`
+	Size     int64  `json:"size,omitempty"`
+	Cost     *Cost  `json:"cost,omitempty"`
+	// ValidUntil is when the sharable expires, if it does.
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+func (i *info) fileInfo() FileInfo {
+	fi := FileInfo{
+		Filename: i.Cookie.Filename,
+		Hash:     i.Cookie.Hash,
+		Link:     i.Cookie.Link,
+		Rate:     i.Rate,
+		Leaders:  len(i.Cookie.Leaders),
+		Size:     i.Cookie.Size,
+	}
+	if until := i.Cookie.ValidUntil.Time; !until.IsZero() {
+		fi.ValidUntil = &until
+	}
+	cost, err := EstimateCost(fi)
+	if err != nil {
+		log.Warnf("Unable to estimate cost Err: %s", err.Error())
+	} else {
+		fi.Cost = &cost
+	}
+	return fi
+}
+
+// validate checks the hash provided by the service. Content addressed by
+// name gets its hash once the name is resolved.
+func (i *info) validate() error {
+	if len(i.name) > 0 {
+		return nil
+	}
+	_, err := cid.Decode(i.Cookie.Hash)
+	if err != nil {
+		return fmt.Errorf("hash %q: %s", i.Cookie.Hash, err.Error())
+	}
+	return nil
+}
+
+func (i *info) bootstrapPeers() []peer.AddrInfo {
+	return append(append([]peer.AddrInfo{}, i.Cookie.Leaders...), i.peers...)
 }
 
 func combineArgs(separator string, args ...string) (retPath string) {
@@ -85,63 +232,266 @@ func combineArgs(separator string, args ...string) (retPath string) {
 	return
 }
 
-func getExternalIp() string {
+// externalIP returns the public IP of the client, "0.0.0.0" if unknown. It
+// gives up once ctx is done, even if the discovery doesn't.
+func (l *LightClient) externalIP(ctx context.Context) string {
+	discover := l.cfg.ExternalIPFunc
+	if discover == nil {
+		discover = getExternalIp
+	}
+	type result struct {
+		ip  string
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		ip, err := discover(ctx)
+		res <- result{ip, err}
+	}()
+	select {
+	case r := <-res:
+		if r.err != nil {
+			log.Warnf("Failed getting external IP Err: %s", r.err.Error())
+			return "0.0.0.0"
+		}
+		return r.ip
+	case <-ctx.Done():
+		log.Warnf("Gave up getting external IP Err: %s", ctx.Err().Error())
+		return "0.0.0.0"
+	}
+}
+
+// getExternalIp asks public IP echo services, within the timeout of the
+// consensus which doesn't take a context.
+var getExternalIp = func(context.Context) (string, error) {
 	consensus := externalip.DefaultConsensus(nil, nil)
 	ip, err := consensus.ExternalIP()
 	if err != nil {
-		return "0.0.0.0"
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+func (l *LightClient) getInfo(ctx context.Context, sharable string) (*info, error) {
+	return l.fetchInfo(ctx, "link", sharable)
+}
+
+// sharableInfo gets the metadata of sharable, or uses Config.BYOMetadata,
+// and checks its hash. On failure the Out to return is set instead.
+func (l *LightClient) sharableInfo(ctx context.Context, sharable string) (*info, *Out) {
+	var metadata *info
+	var err error
+	if l.cfg.BYOMetadata != nil {
+		metadata, err = l.cfg.BYOMetadata.info()
+		if err != nil {
+			log.Errorf("Invalid metadata provided Err: %s", err.Error())
+			return nil, NewOut(invalidInput, "Invalid metadata provided", err.Error(), nil)
+		}
+	} else {
+		sharable, err = ParseSharable(sharable, l.cfg.AllowedHosts)
+		if err != nil {
+			log.Errorf("Invalid sharable Err: %s", err.Error())
+			return nil, NewOut(invalidInput, "Invalid sharable", err.Error(), nil)
+		}
+		metadata, err = l.getInfo(ctx, sharable)
+		if err != nil {
+			log.Errorf("Failed getting metadata Err: %s", err.Error())
+			return nil, metadataOut(err)
+		}
+	}
+	// STEP : Got metadata
+	l.step(StepMetadata, success, "Got metadata")
+	err = metadata.validate()
+	if err != nil {
+		log.Errorf("Invalid metadata Err: %s", err.Error())
+		return nil, NewOut(serviceError, "Invalid file hash in metadata", err.Error(), nil)
+	}
+	return metadata, nil
+}
+
+// getNameInfo fetches the swarm details for content published under an
+// IPNS name or DNSLink. The service is expected to return the same response
+// as for a sharable, the hash being optional as the name is resolved by the
+// client.
+func (l *LightClient) getNameInfo(ctx context.Context, name string) (*info, error) {
+	i, err := l.fetchInfo(ctx, "name", name)
+	if err != nil {
+		return nil, err
 	}
-	return ip.String()
+	i.name = name
+	return i, nil
 }
 
-func getInfo(sharable string, pubKey crypto.PubKey) (*info, error) {
-	pubKB, _ := pubKey.Bytes()
+func (l *LightClient) fetchInfo(ctx context.Context, key, value string) (*info, error) {
+	pubKB, _ := l.pubKey.Bytes()
 	args := map[string]interface{}{
 		"public_key": base64.StdEncoding.EncodeToString(pubKB),
-		"src_ip":     getExternalIp(),
+		"src_ip":     l.externalIP(ctx),
 	}
-	fetchUrl := fmt.Sprintf("%s/%s?link=%s", ApiAddr, fetchPath, sharable)
 	buf, err := json.Marshal(args)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.Post(fetchUrl, "application/json", bytes.NewReader(buf))
+	endpoints := l.endpoints("")
+	for n, endpoint := range endpoints {
+		var i *info
+		var failover bool
+		l.trace("metadata_request", map[string]string{"endpoint": endpoint, key: value})
+		sent := time.Now()
+		i, failover, err = l.fetchInfoFrom(ctx, endpoint, len(endpoints) > 1, key, value, buf)
+		l.traceMetadata(endpoint, sent, i, err)
+		if err == nil {
+			log.Debugf("Metadata served by %s", endpoint)
+			i.endpoint = endpoint
+			return i, nil
+		}
+		if !failover || ctx.Err() != nil || n == len(endpoints)-1 || !l.retryBudget().take(retryFailover) {
+			break
+		}
+		log.Warnf("Metadata endpoint %s failed, trying %s Err: %s", endpoint, endpoints[n+1], err.Error())
+	}
+	return nil, err
+}
+
+// fetchInfoFrom asks endpoint for the metadata, within
+// endpointAttemptTimeout when bounded is set. It returns whether a failure
+// is worth trying another endpoint for.
+func (l *LightClient) fetchInfoFrom(
+	ctx context.Context,
+	endpoint string,
+	bounded bool,
+	key, value string,
+	buf []byte,
+) (*info, bool, error) {
+	if bounded {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, endpointAttemptTimeout)
+		defer cancel()
+	}
+	fetchUrl := fmt.Sprintf("%s/%s?%s=%s", endpoint, fetchPath, key, url.QueryEscape(value))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fetchUrl, bytes.NewReader(buf))
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sent := time.Now()
+	resp, err := l.httpClient().Do(req)
+	if err != nil {
+		return nil, true, err
 	}
 	defer resp.Body.Close()
-	respBuf, err := ioutil.ReadAll(resp.Body)
+	skew := l.checkClock(resp, sent)
+	if skew != nil && l.cfg.RejectClockSkew {
+		return nil, false, skew
+	}
+	respBuf, err := l.readMetadata(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(string(respBuf))
+		failover := resp.StatusCode >= 500
+		if skew != nil {
+			return nil, failover, fmt.Errorf("%s, %s", respBuf, skew.Error())
+		}
+		return nil, failover, errors.New(string(respBuf))
 	}
 	respData := &info{}
 	err = json.Unmarshal(respBuf, respData)
 	if err != nil {
 		log.Errorf("Failed unmarshaling result Err:%s Resp:%s", err.Error(), string(respBuf))
-		return nil, err
+		return nil, false, err
+	}
+	respData.FetchedAt = l.clock().Now()
+	respData.clockSkew = skew
+	return respData, false, nil
+}
+
+// Number of times the complete request is sent before giving up, and the
+// delay before the first retry. The delay doubles after each attempt.
+const completeAttempts = 3
+
+var completeRetryDelay = time.Second
+
+// Time given to each metadata endpoint for the metadata before failing
+// over to the next one, when there are several.
+var endpointAttemptTimeout = 30 * time.Second
+
+// completeKey derives the idempotency key of the complete request for a
+// download. It only depends on the cookie and the reported time, so every
+// retry of the same report carries the same key.
+func completeKey(i *info, timeConsumed int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", i.Cookie.Id, timeConsumed)))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateInfo reports the completed download to the service. Failed attempts
+// are retried, so the gateway is expected to honor the Idempotency-Key header
+// and only bill a download once per key. The endpoint which served the
+// metadata is told first, the others once its attempts failed.
+func (l *LightClient) updateInfo(i *info, timeConsumed int64) error {
+	key := completeKey(i, timeConsumed)
+	endpoints := l.endpoints(i.endpoint)
+	var err error
+	for n, endpoint := range endpoints {
+		completeUrl := fmt.Sprintf("%s/%s?cookie=%s&time=%d",
+			endpoint, completePath, i.Cookie.Id, timeConsumed)
+		var failover bool
+		failover, err = l.completeAt(completeUrl, key)
+		if err == nil {
+			log.Debugf("Completion reported to %s", endpoint)
+			return nil
+		}
+		if !failover || n == len(endpoints)-1 || !l.retryBudget().take(retryFailover) {
+			break
+		}
+		log.Warnf("Metadata endpoint %s failed, trying %s Err: %s", endpoint, endpoints[n+1], err.Error())
+	}
+	return err
+}
+
+// completeAt sends the complete request to completeUrl, retrying transient
+// failures. It returns whether the last failure was transient.
+func (l *LightClient) completeAt(completeUrl, key string) (bool, error) {
+	delay := completeRetryDelay
+	var retry bool
+	var err error
+	for attempt := 1; attempt <= completeAttempts; attempt++ {
+		retry, err = l.postComplete(completeUrl, key)
+		if err == nil || !retry {
+			return retry, err
+		}
+		if attempt == completeAttempts || !l.retryBudget().take(retryComplete) {
+			break
+		}
+		log.Warnf("Failed reporting download, retrying Err: %s", err.Error())
+		<-time.After(delay)
+		delay *= 2
 	}
-	return respData, nil
+	return retry, err
 }
 
-func updateInfo(i *info, timeConsumed int64) error {
-	completeUrl := fmt.Sprintf("%s/%s?cookie=%s&time=%d",
-		ApiAddr, completePath, i.Cookie.Id, timeConsumed)
-	resp, err := http.Post(completeUrl, "application/json", nil)
+// postComplete sends the complete request once. It returns whether a failure
+// is transient and worth retrying.
+func (l *LightClient) postComplete(completeUrl, key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, completeUrl, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := l.httpClient().Do(req)
 	if err != nil {
-		return err
+		return true, err
 	}
 	defer resp.Body.Close()
-	respBuf, err := ioutil.ReadAll(resp.Body)
+	respBuf, err := l.readMetadata(resp.Body)
 	if err != nil {
-		return err
+		return err != errMetadataTooLarge, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return errors.New(string(respBuf))
+		return resp.StatusCode >= 500, errors.New(string(respBuf))
 	}
-	return nil
+	return false, nil
 }
 
 type LightClient struct {
@@ -149,25 +499,139 @@ type LightClient struct {
 	repoRoot    string
 	jsonOut     bool
 	timeout     time.Duration
+	cfg         *Config
+	dhtMode     ipfslite.DHTMode
+	security    ipfslite.SecurityTransport
+	autoNAT     ipfslite.AutoNATMode
+	listenAddrs []multiaddr.Multiaddr
+	// extAddrs is Config.ExternalAddrs
+	extAddrs []multiaddr.Multiaddr
+	// addrFilter is nil when every address may be dialed
+	addrFilter *ipfslite.AddrFilter
+	// newNode sets up the node of each download, setupNode unless replaced
+	// by tests
+	newNode nodeFactory
+	// shared is the host given to NewLightClientWithHost, nil when every
+	// download sets up its own
+	shared *sharedHost
 
 	privKey crypto.PrivKey
 	pubKey  crypto.PubKey
+	// ds holds the blocks and stateDS the rest of the state of the
+	// client, see Config.DatastoreNamespace
 	ds      datastore.Batching
+	stateDS datastore.Batching
+	// http is the client of the metadata service
+	http *http.Client
+	// clk is Config.Clock, nil for the real time
+	clk Clock
+	// tr is the trace of the download in progress, nil if not traced
+	tr *tracer
+
+	mtx    sync.Mutex
+	active *session
+	paused pauser
+	resume *downloadState
+	// hashes maps the sharables seen so far to their hash
+	hashes map[string]string
 }
 
 func NewLightClient(
 	destination string,
 	timeout string,
 	jsonOut bool,
+	cfg *Config,
 ) (*LightClient, error) {
 
-	priv, pubk, err := crypto.GenerateKeyPair(crypto.Ed25519, 2048)
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	dhtMode, err := ipfslite.ParseDHTMode(cfg.DHTMode)
+	if err != nil {
+		return nil, err
+	}
+	security, err := ipfslite.ParseSecurityTransport(cfg.SecurityTransport)
+	if err != nil {
+		return nil, err
+	}
+	autoNAT, err := ipfslite.ParseAutoNATMode(cfg.AutoNAT)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.MirrorPolicy {
+	case "", MirrorAbort, MirrorContinue:
+	default:
+		return nil, fmt.Errorf("invalid mirror policy %q", cfg.MirrorPolicy)
+	}
+	if cfg.DSCP < 0 || cfg.DSCP > 63 {
+		return nil, fmt.Errorf("invalid DSCP %d, expected 0 to 63", cfg.DSCP)
+	}
+	if len(cfg.DestinationTemplate) > 0 {
+		err = validateTemplate(cfg.DestinationTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var listenAddrs []multiaddr.Multiaddr
+	for _, a := range cfg.ListenAddrs {
+		addr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %s", a, err.Error())
+		}
+		listenAddrs = append(listenAddrs, addr)
+	}
+	var extAddrs []multiaddr.Multiaddr
+	for _, a := range cfg.ExternalAddrs {
+		addr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external address %q: %s", a, err.Error())
+		}
+		extAddrs = append(extAddrs, addr)
+	}
+
+	addrFilter, err := newAddrFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, pubk, err := generateKey(cfg.KeyType, cfg.KeyBits)
 	if err != nil {
 		log.Errorf("Failed generating key pair Err:%s", err.Error())
 		return nil, err
 	}
 
-	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	var ds datastore.Batching = syncds.MutexWrap(datastore.NewMapDatastore())
+	stateDS := ds
+	if len(cfg.CacheDir) > 0 {
+		if cfg.Datastore != nil {
+			return nil, errors.New("cache directory and datastore cannot be used together")
+		}
+		ds, err = newDirDatastore(cfg.CacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache directory: %s", err.Error())
+		}
+	} else if cfg.Datastore != nil {
+		ds = cfg.Datastore
+	}
+	// The state of the client is kept apart from the blocks of datastores
+	// which may be shared or reused
+	if ds != stateDS {
+		ns := cfg.DatastoreNamespace
+		if len(ns) == 0 {
+			id, err := peer.IDFromPublicKey(pubk)
+			if err != nil {
+				return nil, err
+			}
+			ns = id.Pretty()
+		}
+		stateDS = namespace.Wrap(ds, datastore.NewKey("/clients").ChildString(ns))
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = NewHTTPClient()
+	}
+	httpClient = limitRedirects(httpClient, cfg)
 
 	to, err := time.ParseDuration(timeout)
 	if err != nil {
@@ -179,251 +643,811 @@ func NewLightClient(
 		destination: destination,
 		jsonOut:     jsonOut,
 		timeout:     to,
+		cfg:         cfg,
+		dhtMode:     dhtMode,
+		security:    security,
+		autoNAT:     autoNAT,
+		listenAddrs: listenAddrs,
+		extAddrs:    extAddrs,
+		addrFilter:  addrFilter,
 		privKey:     priv,
 		pubKey:      pubk,
 		ds:          ds,
+		stateDS:     stateDS,
+		http:        httpClient,
+		clk:         cfg.Clock,
 	}, nil
 }
 
+// Info fetches only the metadata of the sharable. The request is abandoned
+// when ctx is done. The swarm key and session cookie are not part of the
+// result.
+func (l *LightClient) Info(ctx context.Context, sharable string) *Out {
+	sharable, err := ParseSharable(sharable, l.cfg.AllowedHosts)
+	if err != nil {
+		log.Errorf("Invalid sharable Err: %s", err.Error())
+		return NewOut(invalidInput, "Invalid sharable", err.Error(), nil)
+	}
+	metadata, err := l.getInfo(ctx, sharable)
+	if err != nil {
+		log.Errorf("Failed getting metadata Err: %s", err.Error())
+		if ctx.Err() == context.DeadlineExceeded {
+			return NewOut(timeoutError, "Timed out getting metadata", err.Error(), nil)
+		}
+		return metadataOut(err)
+	}
+	return NewOut(success, MetaInfo, "", metadata.fileInfo())
+}
+
+// metadataOut is the result of failing to get the metadata.
+func metadataOut(err error) *Out {
+	var skew *ClockSkewError
+	if errors.As(err, &skew) {
+		return NewOut(clockSkewed, "System clock appears incorrect", err.Error(), skew)
+	}
+	return NewOut(serviceError, "Failed getting metadata", err.Error(), nil)
+}
+
+// ProgressUpdater is told the progress of a download. Wherever one is
+// taken it may be nil to not track the progress. UpdateProgress is called
+// from another goroutine but never after the download returned. A resumed
+// download counts the bytes it resumed from, so its first update starts
+// from them rather than from zero.
 type ProgressUpdater interface {
 	UpdateProgress(ProgressOut)
 }
 
+// Start downloads the content of sharable to the destination, or only
+// gets its metadata with onlyInfo. With stat the result holds a StatOut.
+// progUpd may be nil.
 func (l *LightClient) Start(
 	sharable string,
 	onlyInfo bool,
 	stat bool,
 	progUpd ProgressUpdater,
 ) *Out {
-	metadata, err := getInfo(sharable, l.pubKey)
+	if onlyInfo {
+		return l.start(context.Background(), sharable, true, stat, progUpd)
+	}
+	return l.traced(sharable, func() *Out {
+		return l.notify(sharable, stat, func(stat bool) *Out {
+			return l.start(context.Background(), sharable, false, stat, progUpd)
+		})
+	})
+}
+
+func (l *LightClient) start(
+	ctx context.Context,
+	sharable string,
+	onlyInfo bool,
+	stat bool,
+	progUpd ProgressUpdater,
+) *Out {
+	if l.cfg.BYOMetadata != nil {
+		return l.startBYO(ctx, onlyInfo, stat, progUpd)
+	}
+	sharable, err := ParseSharable(sharable, l.cfg.AllowedHosts)
+	if err != nil {
+		log.Errorf("Invalid sharable Err: %s", err.Error())
+		return NewOut(invalidInput, "Invalid sharable", err.Error(), nil)
+	}
+	if !onlyInfo && len(l.cfg.StateFile) > 0 {
+		if _, err := os.Stat(l.cfg.StateFile); err == nil {
+			err = l.LoadState(l.cfg.StateFile)
+			if err != nil {
+				log.Warnf("Failed loading download state Err: %s", err.Error())
+			}
+		}
+	}
+	var resume *downloadState
+	if !onlyInfo {
+		resume = l.resumeState(sharable)
+		if resume != nil {
+			l.destination = resume.Destination
+		}
+		err = l.checkDestination()
+		if err != nil {
+			log.Errorf("Unusable destination Err: %s", err.Error())
+			return NewOut(destinationErr, "Destination not usable", err.Error(), nil)
+		}
+	}
+	timings := newTimings()
+	mark := time.Now()
+	var metadata *info
+	// The state is saved all along the download, the cookie may be much
+	// older than the state
+	if resume != nil && l.clock().Since(resume.Metadata.FetchedAt) < cookieLifetime {
+		metadata = resume.Metadata
+		// STEP : Reusing metadata
+		l.step(StepResuming, success, "Resuming download")
+	} else {
+		metadata, err = l.getInfo(ctx, sharable)
+		if err != nil {
+			log.Errorf("Failed getting metadata Err: %s", err.Error())
+			return metadataOut(err)
+		}
+		// STEP : Got metadata
+		l.step(StepMetadata, success, "Got metadata")
+	}
+	timings.Metadata = lap(&mark)
+
+	log.Infof("Got metadata info %+v", metadata)
+	if onlyInfo {
+		return NewOut(success, MetaInfo, "", metadata.fileInfo())
+	}
+	if resume != nil {
+		metadata.peers = resume.Peers
+		metadata.assembled = resume.Ranges
+	}
+	return l.fetch(ctx, sharable, metadata, resume != nil, stat, progUpd, timings)
+}
+
+// StartName downloads the content currently published under an IPNS name
+// or DNSLink domain, given as "/ipns/<name>" or "<name>". The metadata
+// service provides the swarm details for the name and the name itself is
+// resolved inside the swarm.
+func (l *LightClient) StartName(
+	ctx context.Context,
+	name string,
+	stat bool,
+	progUpd ProgressUpdater,
+) *Out {
+	return l.traced(name, func() *Out {
+		return l.notify(name, stat, func(stat bool) *Out {
+			return l.startName(ctx, name, stat, progUpd)
+		})
+	})
+}
+
+func (l *LightClient) startName(
+	ctx context.Context,
+	name string,
+	stat bool,
+	progUpd ProgressUpdater,
+) *Out {
+	err := l.checkDestination()
+	if err != nil {
+		log.Errorf("Unusable destination Err: %s", err.Error())
+		return NewOut(destinationErr, "Destination not usable", err.Error(), nil)
+	}
+	timings := newTimings()
+	mark := time.Now()
+	metadata, err := l.getNameInfo(ctx, name)
 	if err != nil {
 		log.Errorf("Failed getting metadata Err: %s", err.Error())
-		return NewOut(serviceError, "Failed getting metadata", err.Error(), nil)
+		return metadataOut(err)
 	}
+	timings.Metadata = lap(&mark)
 	// STEP : Got metadata
-	showStep(success, "Got metadata", l.jsonOut)
+	l.step(StepMetadata, success, "Got metadata")
 
 	log.Infof("Got metadata info %+v", metadata)
-	if onlyInfo {
-		return NewOut(success, MetaInfo, "", metadata)
+	if len(metadata.Cookie.Filename) == 0 {
+		metadata.Cookie.Filename = path.Base(name)
+	}
+	return l.fetch(ctx, name, metadata, false, stat, progUpd, timings)
+}
+
+// fetch downloads the content described by metadata. The data is written to
+// a part file which is renamed to the destination once complete. When
+// resuming, the data already in the part file is kept and the download
+// continues after it.
+func (l *LightClient) fetch(
+	parent context.Context,
+	sharable string,
+	metadata *info,
+	resuming bool,
+	stat bool,
+	progUpd ProgressUpdater,
+	timings *Timings,
+) (out *Out) {
+	// Check the metadata before doing any setup
+	if out := l.checkMetadata(metadata); out != nil {
+		return out
 	}
-	if l.destination == "." {
+	var err error
+	// Downloads into a directory are named after the content
+	if l.destination == "." || existingDir(l.destination) {
 		l.destination = combineArgs(fpSeparator, l.destination, metadata.Cookie.Filename)
 	}
-	dst, err := os.Create(l.destination)
+	partPath := l.destination + partSuffix
+	// Left by a directory download
+	partIsDir := existingDir(partPath)
+	if partIsDir && !resuming {
+		err = os.RemoveAll(partPath)
+		if err != nil {
+			log.Errorf("Failed removing stale part directory Err: %s", err.Error())
+			return NewOut(destinationErr, "Failed creating destination file", err.Error(), nil)
+		}
+		partIsDir = false
+	}
+	if metadata.Cookie.Size > 0 {
+		need := metadata.Cookie.Size
+		if fi, err := os.Stat(partPath); err == nil && resuming && !partIsDir {
+			need -= fi.Size()
+			if metadata.assembled != nil {
+				// The part file has holes
+				need = metadata.Cookie.Size - newRangeSet(metadata.assembled).total()
+			}
+		}
+		err = checkSpace(filepath.Dir(l.destination), need)
+		if err != nil {
+			log.Errorf("Unusable destination Err: %s", err.Error())
+			return NewOut(destinationErr, "Not enough space for the download", err.Error(), nil)
+		}
+	}
+	flags := os.O_CREATE | os.O_WRONLY
+	if !resuming {
+		flags |= os.O_TRUNC
+	}
+	var dst *os.File
+	if partIsDir {
+		// Only closed and named, the files in it are kept
+		dst, err = os.Open(partPath)
+	} else {
+		dst, err = os.OpenFile(partPath, flags, l.fileMode())
+	}
 	if err != nil {
 		log.Errorf("Failed creating dest file Err: %s", err.Error())
 		return NewOut(destinationErr, "Failed creating destination file", err.Error(), nil)
 	}
+	cl := &cleanups{}
+	defer func() { cl.run(out) }()
+	part := cl.add(func(res *Out) {
+		// Kept to be resumed from otherwise
+		if len(l.cfg.StateFile) == 0 {
+			l.discardPartial(partPath, res)
+		}
+	})
+	cl.add(func(*Out) { dst.Close() })
+	var offset int64
+	if !partIsDir {
+		offset, err = dst.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return NewOut(destinationErr, "Failed opening destination file", err.Error(), nil)
+	}
+	if offset > 0 {
+		log.Infof("Resuming download after %d bytes", offset)
+	}
+	mirrors, err := openMirrors(l.cfg.Mirrors, partPath, offset, l.fileMode())
+	if err != nil {
+		log.Errorf("Failed creating mirror file Err: %s", err.Error())
+		return NewOut(destinationErr, "Failed creating mirror file", err.Error(), nil)
+	}
+	closing := cl.add(func(*Out) { closeMirrors(mirrors, false) })
+	w := &mirrorWriter{
+		primary:   dst,
+		mirrors:   mirrors,
+		keepGoing: l.cfg.MirrorPolicy == MirrorContinue,
+	}
+	var assembled *rangeSet
+	switch {
+	case l.sparse(w):
+		assembled = newRangeSet(metadata.assembled)
+		if metadata.assembled == nil {
+			// Left by a sequential download
+			assembled.add(0, offset)
+		}
+	case metadata.assembled != nil:
+		// Left by a sparse download, only the data before its first hole
+		// can be continued
+		offset = prefix(metadata.assembled)
+		err = dst.Truncate(offset)
+		if err == nil {
+			_, err = dst.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			return NewOut(destinationErr, "Failed opening destination file", err.Error(), nil)
+		}
+	}
+
+	var budget *retryBudget
+	if l.cfg.RetryBudget != nil {
+		budget = newRetryBudget(*l.cfg.RetryBudget)
+	}
+	l.setSession(&session{
+		sharable:    sharable,
+		destination: l.destination,
+		metadata:    metadata,
+		budget:      budget,
+		assembled:   assembled,
+	})
+	defer l.setSession(nil)
+	if len(l.cfg.StateFile) > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go l.saveStatePeriodically(l.cfg.StateFile, stop)
+	}
+
+	sink := &fileSink{l: l, dst: dst, w: w}
+	res, metadata := l.transfer(parent, sharable, metadata, sink, budget, stat, progUpd, timings)
+	if l.cfg.Durable && (res.Status == success || res.Status == partialContent) {
+		if existingDir(partPath) {
+			// The files were synced as they were written
+			err = syncDir(partPath)
+		} else {
+			err = dst.Sync()
+		}
+		if err != nil {
+			log.Errorf("Failed syncing downloaded file Err: %s", err.Error())
+			res = NewOut(destinationErr, "Failed syncing downloaded file", err.Error(), nil)
+		}
+	}
+	dst.Close()
+	if res.Status != success && res.Status != partialContent {
+		// Keep the partial data around if the download can be resumed
+		if len(l.cfg.StateFile) > 0 {
+			err = l.SaveState(l.cfg.StateFile)
+			if err != nil {
+				log.Warnf("Failed saving download state Err: %s", err.Error())
+			}
+		}
+		return withClockHint(res, metadata.clockSkew)
+	}
+	if len(l.cfg.DestinationTemplate) > 0 {
+		final, err := l.templatePath(metadata)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(final), l.dirMode())
+		}
+		if err != nil {
+			log.Errorf("Failed naming destination Err: %s", err.Error())
+			return NewOut(destinationErr, "Failed naming destination from template", err.Error(), nil)
+		}
+		l.destination = final
+	}
+	err = os.Rename(partPath, l.destination)
+	if err != nil {
+		log.Errorf("Failed moving downloaded file Err: %s", err.Error())
+		return NewOut(destinationErr, "Failed moving downloaded file to destination", err.Error(), nil)
+	}
+	part.drop()
+	if l.cfg.Durable {
+		err = syncDir(filepath.Dir(l.destination))
+		if err != nil {
+			log.Errorf("Failed syncing destination directory Err: %s", err.Error())
+			return NewOut(destinationErr, "Failed syncing destination directory", err.Error(), nil)
+		}
+	}
+	if len(l.cfg.StateFile) > 0 {
+		os.Remove(l.cfg.StateFile)
+	}
+	l.rememberHash(sharable, metadata.Cookie.Hash)
+	if res.Status == success {
+		err = l.writeSidecars(metadata.Cookie.Hash)
+		if err != nil {
+			log.Errorf("Failed writing sidecar Err: %s", err.Error())
+			return NewOut(destinationErr, "Failed writing sidecar file", err.Error(), nil)
+		}
+	}
+	if len(mirrors) > 0 {
+		closing.drop()
+		results := append([]DestinationResult{{Path: l.destination}}, closeMirrors(mirrors, true)...)
+		if st, ok := res.Data.(StatOut); ok {
+			st.Destinations = results
+			res.Data = st
+		} else {
+			res.Data = results
+		}
+	}
+	return res
+}
+
+// checkMetadata refuses the downloads metadata doesn't allow, returning
+// the Out to return.
+func (l *LightClient) checkMetadata(metadata *info) *Out {
+	err := metadata.validate()
+	if err != nil {
+		log.Errorf("Invalid metadata Err: %s", err.Error())
+		return NewOut(serviceError, "Invalid file hash in metadata", err.Error(), nil)
+	}
+	if out := metadata.expiredOut(); out != nil {
+		return out
+	}
+	_, err = decodeSwarmKey(metadata.SwarmKey, l.cfg.AllowedSwarmFingerprints)
+	if err != nil {
+		log.Errorf("Refusing swarm key Err: %s", err.Error())
+		return NewOut(internalError, "Swarm key not accepted", err.Error(), nil)
+	}
+	err = l.checkBudget(metadata)
+	if err != nil {
+		log.Errorf("Refusing download Err: %s", err.Error())
+		return budgetOut(err, metadata.fileInfo())
+	}
+	return nil
+}
+
+// transfer runs the attempts of downloading the content of metadata into
+// sink. When the session expires a new cookie is fetched, as long as the
+// retry budget allows, and the sink continues after what it wrote. It
+// returns the result along with the metadata of the last session.
+func (l *LightClient) transfer(
+	parent context.Context,
+	sharable string,
+	metadata *info,
+	sink outputSink,
+	budget *retryBudget,
+	stat bool,
+	progUpd ProgressUpdater,
+	timings *Timings,
+) (*Out, *info) {
+	res := l.attempts(parent, metadata, sink, stat, progUpd, timings)
+	refreshes := 0
+	for res.Status == sessionExpired && refreshes < maxSessionRefreshes && budget.take(retryRefresh) {
+		refreshes++
+		// The peers stopped serving the expired session, so get a new cookie
+		// and continue after the data already written
+		fresh, err := l.refreshInfo(parent, sharable, metadata)
+		if err != nil {
+			log.Errorf("Failed refreshing metadata Err: %s", err.Error())
+			res = NewOut(serviceError, "Failed refreshing session", err.Error(), nil)
+			break
+		}
+		metadata = fresh
+		l.setSessionMetadata(metadata)
+		// STEP : Session refreshed
+		l.step(StepSessionRefresh, success, "Session refreshed")
+		res = l.attempts(parent, metadata, sink, stat, progUpd, timings)
+	}
+	if st, ok := res.Data.(StatOut); ok {
+		st.SessionRefreshes = refreshes
+		res.Data = st
+	}
+	return res, metadata
+}
 
+// attempts runs the download, starting over up to three times if it
+// doesn't start within 3 minutes. It stops once parent is done.
+func (l *LightClient) attempts(
+	parent context.Context,
+	metadata *info,
+	sink outputSink,
+	stat bool,
+	progUpd ProgressUpdater,
+	timings *Timings,
+) *Out {
 	var res *Out
 	redo := true
 	i := 1
+	budget := l.retryBudget()
 	for redo && i < 4 {
-		showStep(success, fmt.Sprintf("Attempt #%d", i), l.jsonOut)
+		if i > 1 && !budget.take(retryAttempt) {
+			return NewOut(internalError, "Retry budget exhausted", "Download failed to start", nil)
+		}
+		l.step(StepAttempt, success, fmt.Sprintf("Attempt #%d", i))
 		i++
-		ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+		ctx, cancel := context.WithTimeout(parent, l.timeout)
 		defer cancel()
 
 		ready := make(chan bool)
+		done := make(chan struct{})
 		wg := sync.WaitGroup{}
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			res = l.download(ctx, metadata, dst, stat, progUpd, ready)
+			defer close(done)
+			res = l.download(ctx, metadata, sink, stat, progUpd, ready, timings)
 		}()
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			select {
-			case <-time.After(time.Minute * 3):
+			case <-l.clock().After(time.Minute * 3):
 				cancel()
 			case <-ready:
 				redo = false
-				showStep(success, "Download started", l.jsonOut)
+				l.step(StepDownloadStarted, success, "Download started")
+			case <-done:
 			}
 		}()
 		wg.Wait()
+		// Retrying doesn't help when the peers don't accept the swarm key or
+		// the content can't be written as asked, and the reasons none could
+		// be connected are worth reporting right away
+		if res.Status == swarmKeyErr || res.Status == noPeers || res.Status == invalidInput || parent.Err() != nil {
+			return res
+		}
 	}
 	if i == 4 && redo {
-		return NewOut(internalError, "Failed on retrying thrice", "Download failed to start", nil)
+		last := res
+		res = NewOut(internalError, "Failed on retrying thrice", "Download failed to start", nil)
+		// Keep the network stats of the last attempt
+		if st, ok := last.Data.(NetworkStats); ok {
+			res.Data = st
+		}
 	}
 	return res
 }
 
+// download runs an attempt of downloading the content of metadata into
+// sink.
 func (l *LightClient) download(
 	ctx context.Context,
 	metadata *info,
-	dst *os.File,
+	sink outputSink,
 	stat bool,
 	progUpd ProgressUpdater,
 	started chan<- bool,
-) *Out {
-	psk, err := pnet.DecodeV1PSK(bytes.NewReader(metadata.SwarmKey))
+	timings *Timings,
+) (res *Out) {
+	mark := time.Now()
+	psk, err := decodeSwarmKey(metadata.SwarmKey, l.cfg.AllowedSwarmFingerprints)
 	if err != nil {
 		log.Errorf("Failed decoding swarm key Err: %s", err.Error())
 		return NewOut(internalError, "Failed decoding swarm key provided", err.Error(), nil)
 	}
-	listenIP4, _ := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/45000")
-	listenWS, _ := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/45001/ws")
-	listenIP6, _ := multiaddr.NewMultiaddr("/ip6/::/tcp/45000")
-	h, dht, err := ipfslite.SetupLibp2p(
-		ctx,
-		l.privKey,
-		psk,
-		[]multiaddr.Multiaddr{listenIP4, listenIP6, listenWS},
-		l.ds,
-		ipfslite.Libp2pOptionsExtra...,
-	)
-	if err != nil {
-		log.Errorf("Failed setting up libp2p node Err: %s", err.Error())
-		return NewOut(internalError, "Failed setting up p2p peer", err.Error(), nil)
-	}
-	cfg := &ipfslite.Config{
-		Mtdt: map[string]interface{}{
-			"download_index": metadata.Cookie.DownloadIndex,
-		},
-		Rate: metadata.Rate,
-	}
-	lite, err := ipfslite.New(ctx, l.ds, h, dht, cfg)
-	if err != nil {
-		log.Errorf("Failed setting up p2p xfer node Err: %s", err.Error())
+	contrib := newContributions()
+	if len(l.cfg.ManifestFile) > 0 {
+		contrib.recordBlocks()
+	}
+	tr := l.tracer()
+	if tr != nil {
+		contrib.onBlock = tr.block
+	}
+	newNode := l.newNode
+	if newNode == nil {
+		newNode = l.setupNode
+	}
+	lite, err := newNode(ctx, metadata, psk, contrib)
+	if err != nil {
 		return NewOut(internalError, "Failed setting up light client", err.Error(), nil)
 	}
-	lite.Scp.AddHook(scp.PeerConnected, func() {
-		err := lite.Dht.Bootstrap(ctx)
-		if err != nil {
-			log.Errorf("Failed DHT Bootstrap: %s", err.Error())
+	defer lite.close()
+	h := lite.host()
+	if l.cfg.NetworkStatsOnFailure {
+		// Taken before the node is closed
+		defer func() { l.reportNetworkStats(h, res) }()
+	}
+	l.setSessionNode(lite, contrib)
+	if tr != nil {
+		n := tr.notifiee()
+		h.Network().Notify(n)
+		defer h.Network().StopNotify(n)
+	}
+	log.Infof("Peer ID %s listening on %v", h.ID(), h.Addrs())
+	if l.cfg.ShowIdentity && !l.cfg.Quiet {
+		id := Identity{ID: h.ID().Pretty()}
+		for _, addr := range h.Addrs() {
+			id.Addrs = append(id.Addrs, addr.String())
 		}
-	})
-	// STEP : Download agent created
-	showStep(success, "Download agent initialized", l.jsonOut)
-
-	count := lite.Bootstrap(metadata.Cookie.Leaders)
-	// STEP : Bootstrap done
-	showStep(success, fmt.Sprintf("Bootstrapped agent with %d leaders", count), l.jsonOut)
-
-	if count < peerThreshold {
-		go func() {
-			start := time.Now()
-			for count < peerThreshold {
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(time.Second * 30):
-					if time.Since(start) > time.Minute*15 {
-						log.Warn("Tried getting more peers for 15mins")
-						showStep(timeoutError, "Download timed out", l.jsonOut)
-						return
-					}
-					// Try to re-bootstrap if client was unable to bootstrap previously
-					oldCount := count
-					if count < len(metadata.Cookie.Leaders) {
-						count = lite.Bootstrap(metadata.Cookie.Leaders)
-						// STEP : Re-Bootstrap done
-						if count > oldCount {
-							showStep(success, "Found more peers to connect", l.jsonOut)
-						}
-					}
-				}
+		OutMessage(NewOut(success, "Identity", "", id), l.jsonOut)
+	}
+	if len(l.cfg.PaymentsFile) > 0 {
+		stop := make(chan struct{})
+		go persistPayments(l.cfg.PaymentsFile, metadata.Cookie.Id, lite, stop)
+		defer func() {
+			close(stop)
+			err := savePayments(l.cfg.PaymentsFile, metadata.Cookie.Id, lite)
+			if err != nil {
+				log.Warnf("Failed saving micropayments Err: %s", err.Error())
 			}
-			log.Infof("Done lagged bootstrapping. New count %d", count)
 		}()
 	}
-	if count == 0 {
-		log.Warn("No nodes connected. Waiting to find more")
-		for {
-			select {
-			case <-ctx.Done():
-				log.Info("Client stopped while waiting for more peers")
-				return NewOut(internalError, "Stopped while waiting for peers", "context cancelled", nil)
-			case <-time.After(time.Second):
-				break
-			}
-			if count > 0 {
-				break
+	if l.dhtMode != ipfslite.DHTModeOff {
+		lite.onPeerConnected(func() {
+			err := lite.bootstrapDHT(ctx)
+			if err != nil {
+				log.Errorf("Failed DHT Bootstrap: %s", err.Error())
 			}
+		})
+	}
+	timings.Setup = lap(&mark)
+	// STEP : Download agent created
+	l.step(StepAgentReady, success, "Download agent initialized")
+
+	leaders := watchLeaders(ctx, h, l.dialable(metadata.Cookie.Leaders), l.retryBudget())
+	// Peers of previous downloads in the swarm are dialed first
+	reconnected := 0
+	if cached := l.dialable(l.cachedPeers(psk)); len(cached) > 0 {
+		reconnected = lite.Bootstrap(cached)
+		log.Infof("Reconnected to %d of %d cached peers", reconnected, len(cached))
+	}
+	peers := metadata.bootstrapPeers()
+	results, err := lite.BootstrapReport(l.dialable(peers))
+	l.traceDials(peers, results)
+	count := ipfslite.Connected(results)
+	if count < reconnected {
+		count = reconnected
+	}
+	if err == ipfslite.ErrSwarmKeyMismatch && reconnected == 0 {
+		log.Errorf("Failed bootstrapping Err: %s", err.Error())
+		return NewOut(swarmKeyErr, "Swarm key mismatch", err.Error(), nil)
+	}
+	target := l.minPeers()
+	if len(peers) > 0 && len(peers) < target {
+		target = len(peers)
+	}
+	// The events of the following attempts stop with the transfer
+	events := l.newBootstrapEvents(target)
+	defer events.stop()
+	events.report(count)
+	if count == 0 {
+		details := fmt.Sprintf("none of the %d leaders could be connected", len(peers))
+		if len(peers) == 0 {
+			details = "no leaders in metadata"
 		}
+		log.Errorf("Failed bootstrapping Err: %s", details)
+		return NewOut(noPeers, "No peers connected", details, bootstrapFailures(peers, results))
 	}
+	// STEP : Bootstrap done
+	l.step(StepBootstrapped, success, fmt.Sprintf("Bootstrapped agent with %d leaders", count))
+
+	go l.rebootstrap(ctx, lite, metadata.Cookie.Leaders, target, events, count)
 	log.Infof("Connected to %d peers. Starting download", count)
 
-	c, err := cid.Decode(metadata.Cookie.Hash)
-	if err != nil {
-		log.Errorf("Failed decoding file hash Err: %s", err.Error())
-		return NewOut(internalError, "Failed decoding filehash provided", err.Error(), nil)
+	var c cid.Cid
+	if len(metadata.name) > 0 {
+		c, err = lite.ResolveName(ctx, metadata.name)
+		if err != nil {
+			log.Errorf("Failed resolving name %s Err: %s", metadata.name, err.Error())
+			return NewOut(internalError, "Failed resolving name", err.Error(), nil)
+		}
+		metadata.Cookie.Hash = c.String()
+	} else {
+		c, err = cid.Decode(metadata.Cookie.Hash)
+		if err != nil {
+			log.Errorf("Failed decoding file hash Err: %s", err.Error())
+			return NewOut(internalError, "Failed decoding filehash provided", err.Error(), nil)
+		}
 	}
+	timings.Bootstrap = lap(&mark)
 	// STEP : Starting Download
-	showStep(success, "Starting download", l.jsonOut)
+	l.step(StepDownloading, success, "Starting download")
+
+	// Cancelled to stop the transfer when it costs more than allowed or is
+	// too slow
+	ctx, stopTransfer := context.WithCancel(ctx)
+	budget := watchBudget(ctx, l.cfg.MaxCost, lite.paid, stopTransfer)
+	slow := watchThroughput(ctx, l.cfg.MinThroughput, l.cfg.ThroughputWindow, l.cfg.ThroughputGrace, contrib.total, l.Paused, stopTransfer)
+	defer func() {
+		stopTransfer()
+		slow.wait()
+	}()
 
 	startTime := time.Now().Unix()
-	rsc, err := lite.GetFile(ctx, c)
-	if err != nil {
+	// Cancelled to stop the transfer when the session expires
+	sessCtx, expire := context.WithCancel(ctx)
+	defer expire()
+	rsc, err := l.getFile(sessCtx, lite, c, func() int {
+		n := lite.Bootstrap(l.dialable(metadata.bootstrapPeers()))
+		events.report(n)
+		return n
+	})
+	if err != nil && err != ufsio.ErrIsDir {
 		return NewOut(500, "Failed getting file", err.Error(), nil)
 	}
-	defer rsc.Close()
-
-	started <- true
+	root := &outputRoot{cid: c, name: filepath.Base(metadata.Cookie.Filename), size: metadata.Cookie.Size}
+	if len(metadata.Cookie.Filename) == 0 {
+		root.name = c.String()
+	}
+	if err == nil {
+		defer rsc.Close()
+		root.file = rsc
+	}
+	var written int64
+	var failed []DestinationResult
+	total, offset, err := sink.prepare(sessCtx, lite, root)
+	if err == nil {
+		events.stop()
+		started <- true
 
-	if progUpd != nil {
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		watching := make(chan struct{})
 		go func() {
-			for {
-				st, err := dst.Stat()
-				if err == nil {
-					prog := float64(st.Size()) / float64(rsc.Size()) * 100
-					log.Infof("Updating progress %d", int(prog))
-					progOut := ProgressOut{
-						Percentage: int(prog),
-						Downloaded: fmt.Sprintf("%.2fMB", float32(st.Size())/(1024*1024)),
-						TotalSize:  fmt.Sprintf("%.2fMB", float32(rsc.Size())/(1024*1024)),
-					}
-					progUpd.UpdateProgress(progOut)
-					if prog == 100 {
-						log.Infof("Progress complete")
-						return
-					}
-				}
-				select {
-				case <-ctx.Done():
-					log.Warn("Stopping progress updated on context cancel")
-				case <-time.After(time.Millisecond * 500):
-					break
-				}
-			}
+			defer close(watching)
+			watchStall(watchCtx, l.clock(), &written, metadata, l.Paused, expire)
 		}()
+		stopProgress := startProgress(ctx, progUpd, l.cfg.ProgressEvery, func() (int64, error) {
+			return offset + atomic.LoadInt64(&written), nil
+		}, total)
+		failed, err = sink.write(sessCtx, lite, &written)
+		stopWatch()
+		<-watching
+		stopProgress()
 	}
-	_, err = io.Copy(dst, rsc)
 	if err != nil {
+		var sinkErr *sinkError
+		switch {
+		case errors.As(err, &sinkErr):
+			return sinkErr.out
+		case sessCtx.Err() != nil && ctx.Err() == nil:
+			return NewOut(sessionExpired, "Session expired", err.Error(), nil)
+		case budget.failed() != nil:
+			return budgetOut(budget.failed(), nil)
+		}
+		if rep := slow.tooLow(); rep != nil {
+			return NewOut(throughputLow, "Throughput too low", rep.String(), rep)
+		}
 		if err == context.DeadlineExceeded {
 			return NewOut(timeoutError, "Unable to fetch data", err.Error(), nil)
 		}
-		return NewOut(internalError, "Failed writing to destination", err.Error(), nil)
+		if errors.Is(err, errSizeMismatch) {
+			return NewOut(internalError, "Downloaded file is incomplete", err.Error(), nil)
+		}
+		return NewOut(internalError, "Failed writing to destination", err.Error(), failed)
+	}
+	if l.cfg.VerifyContent && len(failed) == 0 {
+		err = sink.verify(ctx, lite, c)
+		if err != nil {
+			log.Errorf("Downloaded content failed verification Err: %s", err.Error())
+			return NewOut(internalError, "Verification failed", err.Error(), nil)
+		}
 	}
 	downloadTime := time.Now().Unix() - startTime
+	var firstByte time.Duration
+	if first := sink.firstWrite(); !first.IsZero() {
+		// Written by an earlier attempt otherwise
+		if first.After(mark) {
+			timings.FirstByte = first.Sub(mark)
+		}
+		if !timings.start.IsZero() {
+			firstByte = first.Sub(timings.start)
+		}
+	}
+	timings.Transfer = lap(&mark) - timings.FirstByte
+	if len(l.cfg.ManifestFile) > 0 {
+		err = writeManifest(l.cfg.ManifestFile, &Manifest{
+			Root:        c.String(),
+			Destination: l.destination,
+			CreatedAt:   time.Now(),
+			Blocks:      contrib.receivedBlocks(),
+		}, l.fileMode())
+		if err != nil {
+			log.Errorf("Failed writing manifest Err: %s", err.Error())
+			return NewOut(destinationErr, "Failed writing manifest", err.Error(), nil)
+		}
+	}
+
+	err = l.cachePeers(psk, h)
+	if err != nil {
+		log.Warnf("Failed saving peer cache Err: %s", err.Error())
+	}
 
 	// STEP : Waiting for micropayments clean up
-	showStep(success, "Finishing download", l.jsonOut)
-	// Wait 5 secs for SCP to send all MPs. This can be optimized
-	<-time.After(time.Second * 5)
+	l.step(StepFinishing, success, "Finishing download")
+	l.settle(ctx, lite)
 
-	err = updateInfo(metadata, downloadTime)
-	if err != nil {
-		log.Warn("Failed updating metadata after download Err: %s", err.Error())
+	if !metadata.byo {
+		err = l.updateInfo(metadata, downloadTime)
+		if err != nil {
+			log.Warn("Failed updating metadata after download Err: %s", err.Error())
+		}
+	}
+	timings.Settlement = lap(&mark)
+	log.Infof("Download timings %s", timings)
+	if len(failed) > 0 {
+		return NewOut(partialContent, "Download incomplete", fmt.Sprintf("%d files failed", len(failed)), failed)
 	}
 	if !stat {
 		return NewOut(200, DownloadSuccess, "", nil)
 	}
 	connectedPeers := []string{}
-	for _, pID := range lite.Host.Network().Peers() {
+	for _, pID := range h.Network().Peers() {
 		connectedPeers = append(connectedPeers, pID.String())
 	}
-	ledgers, _ := lite.Scp.GetMicroPayments()
+	ledgers, _ := lite.microPayments()
+	channels, _ := lite.paymentChannels()
+	hits, misses := lite.blockSourceStats()
 	out := StatOut{
-		ConnectedPeers: connectedPeers,
-		Ledgers:        ledgers,
-		DownloadTime:   int(downloadTime),
+		ConnectedPeers:      connectedPeers,
+		Ledgers:             ledgers,
+		DownloadTime:        int(downloadTime),
+		Bytes:               atomic.LoadInt64(&written),
+		Contributions:       contrib.snapshot(),
+		Timings:             *timings,
+		LeaderReconnects:    leaders.count(),
+		BlockSourceHits:     hits,
+		BlockSourceMisses:   misses,
+		RetryBudget:         l.retryBudget().report(),
+		NetworkBytes:        contrib.total(),
+		TimeToFirstByte:     firstByte,
+		BootstrapTransports: bootstrapTransports(results),
+		PaymentChannels:     channels,
+		Bandwidth:           lite.bandwidth(),
 	}
+	out.ServedFromCache = out.NetworkBytes == 0 && hits == 0
 	return NewOut(success, "Stats", "", out)
 }
 
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchBogusHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "file")
+	l := &LightClient{destination: dst, cfg: &Config{}}
+	metadata := &info{
+		Cookie:   cookie{Hash: "not-a-cid"},
+		SwarmKey: testSwarmKey,
+	}
+	out := l.fetch(context.Background(), "sharable", metadata, false, false, nil, &Timings{})
+	if out.Status != serviceError {
+		t.Fatalf("expected status %d got %+v", serviceError, out)
+	}
+	if _, err := os.Stat(dst + partSuffix); !os.IsNotExist(err) {
+		t.Fatal("destination should not be created for a bogus hash")
+	}
+}
+
+func TestUpdateInfoRetriesWithSameKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	oldAddr, oldDelay := ApiAddr, completeRetryDelay
+	ApiAddr, completeRetryDelay = srv.URL, time.Millisecond
+	defer func() { ApiAddr, completeRetryDelay = oldAddr, oldDelay }()
+
+	l := &LightClient{cfg: &Config{}}
+	err := l.updateInfo(&info{Cookie: cookie{Id: "cookie"}}, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 attempts got %d", len(keys))
+	}
+	for _, k := range keys {
+		if len(k) == 0 || k != keys[0] {
+			t.Fatalf("retries should reuse the key %v", keys)
+		}
+	}
+}
+
+func TestUpdateInfoNoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	oldAddr := ApiAddr
+	ApiAddr = srv.URL
+	defer func() { ApiAddr = oldAddr }()
+
+	l := &LightClient{cfg: &Config{}}
+	if l.updateInfo(&info{Cookie: cookie{Id: "cookie"}}, 42) == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected a single attempt got %d", attempts)
+	}
+}
+
+func TestFetchInfoFailover(t *testing.T) {
+	var served []string
+	newServer := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = append(served, name)
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				w.Write([]byte(`{"Cookie": {"Id": "cookie", "Hash": "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"}}`))
+			}
+		}))
+	}
+	failing := newServer("failing", http.StatusServiceUnavailable)
+	defer failing.Close()
+	down := newServer("down", http.StatusOK)
+	down.Close()
+	healthy := newServer("healthy", http.StatusOK)
+	defer healthy.Close()
+
+	l, err := NewLightClient(".", "1m", false, &Config{
+		Endpoint:       failing.URL,
+		Endpoints:      []string{down.URL, healthy.URL},
+		ExternalIPFunc: staticIP("203.0.113.7"),
+		Quiet:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := l.getInfo(context.Background(), "sharable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.endpoint != healthy.URL || len(served) != 2 || served[0] != "failing" {
+		t.Fatalf("expected the metadata from the healthy endpoint got %q after %v", metadata.endpoint, served)
+	}
+
+	// The endpoint which served the metadata is told of the completion
+	served = nil
+	err = l.updateInfo(metadata, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 1 || served[0] != "healthy" {
+		t.Fatalf("expected the completion reported to the healthy endpoint got %v", served)
+	}
+}
+
+func TestFetchInfoNoFailoverOnClientError(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	l, err := NewLightClient(".", "1m", false, &Config{
+		Endpoints:      []string{srv.URL, srv.URL},
+		ExternalIPFunc: staticIP("203.0.113.7"),
+		Quiet:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.getInfo(context.Background(), "sharable"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected a single attempt got %d", attempts)
+	}
+}
+
+// staticIP is a Config.ExternalIPFunc returning ip, which keeps the tests
+// off the public IP echo services.
+func staticIP(ip string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		return ip, nil
+	}
+}
+
+func TestInfoGivesUpSlowExternalIP(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	old := getExternalIp
+	getExternalIp = func(context.Context) (string, error) {
+		// Like the consensus, deaf to the context
+		<-release
+		return "203.0.113.7", nil
+	}
+	defer func() { getExternalIp = old }()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	l, err := NewLightClient(".", "1m", false, &Config{Endpoint: srv.URL, Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	out := l.Info(ctx, "fzhnp4jhFnMUKVGMKpt4kBMrvX")
+	if out.Status != timeoutError {
+		t.Fatalf("expected a timeout got %+v", out)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Fatalf("Info returned %s after its context", took)
+	}
+	if requests != 0 {
+		t.Fatalf("metadata requested after the context was done")
+	}
+}
+
+func TestExternalIPFunc(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&args)
+		got = append(got, fmt.Sprint(args["src_ip"]))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	for _, ipFunc := range []func(context.Context) (string, error){
+		staticIP("203.0.113.7"),
+		func(context.Context) (string, error) {
+			return "", errors.New("no metadata service")
+		},
+	} {
+		l, err := NewLightClient(".", "1m", false, &Config{
+			Endpoint:       srv.URL,
+			ExternalIPFunc: ipFunc,
+			Quiet:          true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.getInfo(context.Background(), "sharable")
+	}
+	if len(got) != 2 || got[0] != "203.0.113.7" || got[1] != "0.0.0.0" {
+		t.Fatalf("unexpected source IPs %v", got)
+	}
+}
@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Suffix of the file being written until the download is complete.
+const partSuffix = ".part"
+
+// The service doesn't tell how long a cookie stays valid, so cookies older
+// than this are refreshed when resuming a download.
+const cookieLifetime = 30 * time.Minute
+
+// How often the state is saved when Config.StateFile is set.
+const stateSaveInterval = 10 * time.Second
+
+// downloadState is what is persisted to resume a download after a restart.
+type downloadState struct {
+	Sharable    string          `json:"sharable"`
+	Destination string          `json:"destination"`
+	Completed   int64           `json:"completed"`
+	Peers       []peer.AddrInfo `json:"peers"`
+	Metadata    *info           `json:"metadata"`
+	SavedAt     time.Time       `json:"saved_at"`
+	// Ranges are written with Config.SparseAssembly, the part file has
+	// holes in between. Null when it is written in order.
+	Ranges []byteRange `json:"ranges"`
+	// The parts of the metadata which are not marshalled along with it
+	Name     string `json:"name,omitempty"`
+	BYO      bool   `json:"byo,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// session is the download in progress.
+type session struct {
+	sharable    string
+	destination string
+	metadata    *info
+	lite        node
+	host        host.Host
+	contrib     *contributions
+	// budget is nil when Config.RetryBudget is not set
+	budget *retryBudget
+	// assembled is nil unless the file is written with
+	// Config.SparseAssembly.
+	assembled *rangeSet
+}
+
+func (l *LightClient) setSession(s *session) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.active = s
+}
+
+func (l *LightClient) setSessionNode(lite node, contrib *contributions) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.active != nil {
+		l.active.lite = lite
+		l.active.host = lite.host()
+		l.active.contrib = contrib
+	}
+}
+
+// SaveState writes what is needed to resume the download in progress to
+// path. The file contains the session cookie and swarm key, so it is only
+// readable by the current user.
+func (l *LightClient) SaveState(path string) error {
+	l.mtx.Lock()
+	s := l.active
+	var st *downloadState
+	if s != nil {
+		st = &downloadState{
+			Sharable:    s.sharable,
+			Destination: s.destination,
+			Metadata:    s.metadata,
+			SavedAt:     time.Now(),
+		}
+		if s.metadata != nil {
+			st.Name, st.BYO, st.Endpoint = s.metadata.name, s.metadata.byo, s.metadata.endpoint
+		}
+		if s.assembled != nil {
+			st.Ranges = s.assembled.list()
+		}
+		if s.host != nil {
+			for _, p := range s.host.Network().Peers() {
+				st.Peers = append(st.Peers, s.host.Peerstore().PeerInfo(p))
+			}
+		}
+	}
+	l.mtx.Unlock()
+
+	if st == nil {
+		return errors.New("no download in progress")
+	}
+	if fi, err := os.Stat(st.Destination + partSuffix); err == nil {
+		st.Completed = fi.Size()
+	}
+	if st.Ranges != nil {
+		st.Completed = prefix(st.Ranges)
+	}
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadState reads a state saved with SaveState. The next Start for the same
+// sharable resumes from it: the metadata is reused if the cookie was
+// fetched recently enough, the peers of the previous run are dialed along
+// with the leaders and the partially written file is continued.
+func (l *LightClient) LoadState(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	st := &downloadState{}
+	err = json.Unmarshal(buf, st)
+	if err != nil {
+		return err
+	}
+	if st.Metadata == nil || len(st.Sharable) == 0 {
+		return errors.New("incomplete download state")
+	}
+	st.Metadata.name = st.Name
+	st.Metadata.byo = st.BYO
+	st.Metadata.endpoint = st.Endpoint
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.resume = st
+	return nil
+}
+
+// resumeState returns the loaded state if it belongs to sharable. The state
+// is only used once.
+func (l *LightClient) resumeState(sharable string) *downloadState {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	st := l.resume
+	l.resume = nil
+	if st != nil && st.Sharable != sharable {
+		log.Warnf("Ignoring saved state of %s while downloading %s", st.Sharable, sharable)
+		return nil
+	}
+	return st
+}
+
+// sessionAssembly returns the ranges written of the download in progress
+// with Config.SparseAssembly, nil if it is written in order.
+func (l *LightClient) sessionAssembly() *rangeSet {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.active == nil {
+		return nil
+	}
+	return l.active.assembled
+}
+
+func (l *LightClient) saveStatePeriodically(path string, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(stateSaveInterval):
+			err := l.SaveState(path)
+			if err != nil {
+				log.Warnf("Failed saving download state Err: %s", err.Error())
+			}
+		}
+	}
+}
+
+// setSessionMetadata replaces the metadata of the download in progress
+// once its session was refreshed.
+func (l *LightClient) setSessionMetadata(metadata *info) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.active != nil {
+		s := *l.active
+		s.metadata = metadata
+		l.active = &s
+	}
+}
@@ -0,0 +1,289 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mn := mocknet.New(context.Background())
+	h1, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mn.ConnectPeers(h1.ID(), h2.ID()); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := (&Metadata{Hash: "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB", SwarmKey: string(testSwarmKey)}).info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.Cookie.Id = "cookie"
+	metadata.name = "example.com"
+	metadata.endpoint = "https://metadata.example.com"
+	dst := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(dst+partSuffix, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(dir, "state")
+
+	l := &LightClient{cfg: &Config{}}
+	if err := l.SaveState(state); err == nil {
+		t.Fatal("state saved without a download")
+	}
+	l.setSession(&session{sharable: "sharable", destination: dst, metadata: metadata, host: h1})
+	if err := l.SaveState(state); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// It holds the cookie and the swarm key
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("state readable with %s", fi.Mode())
+	}
+
+	other := &LightClient{cfg: &Config{}}
+	if err := other.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	if other.resumeState("another") != nil {
+		t.Fatal("state of another sharable resumed")
+	}
+	if err := other.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	st := other.resumeState("sharable")
+	if st == nil || st.Destination != dst || st.Completed != int64(len("partial")) || time.Since(st.SavedAt) > time.Minute {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	if st.Metadata.Cookie.Id != "cookie" || st.Metadata.Cookie.Hash != metadata.Cookie.Hash || !bytes.Equal(st.Metadata.SwarmKey, metadata.SwarmKey) {
+		t.Fatalf("unexpected metadata %+v", st.Metadata)
+	}
+	if st.Metadata.name != "example.com" || st.Metadata.endpoint != "https://metadata.example.com" || !st.Metadata.byo {
+		t.Fatalf("unexported metadata lost %+v", st.Metadata)
+	}
+	if len(st.Peers) != 1 || st.Peers[0].ID != h2.ID() {
+		t.Fatalf("unexpected peers %v", st.Peers)
+	}
+	if other.resumeState("sharable") != nil {
+		t.Fatal("state resumed twice")
+	}
+
+	if err := ioutil.WriteFile(state, []byte(`{"sharable": "sharable"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.LoadState(state); err == nil {
+		t.Fatal("state without metadata loaded")
+	}
+}
+
+func TestStartResumesPartFile(t *testing.T) {
+	var mtx sync.Mutex
+	completed := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		completed++
+	}))
+	defer srv.Close()
+	l, _, data, done := newFakeClient(t, 2*1024*1024, 3)
+	defer done()
+	metadata, err := l.cfg.BYOMetadata.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As served by the metadata service
+	metadata.byo = false
+	metadata.Cookie.Id = "cookie"
+	metadata.Cookie.Size = int64(len(data))
+	metadata.FetchedAt = time.Now()
+
+	// Zeros, so that what was resumed is told apart from the content
+	half := len(data) / 2
+	if err := ioutil.WriteFile(l.destination+partSuffix, make([]byte, half), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(filepath.Dir(l.destination), "state")
+	l.setSession(&session{sharable: "fzhnp4jhFnMUKVGMKpt4kBMrvX", destination: l.destination, metadata: metadata})
+	err = l.SaveState(state)
+	l.setSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata service is only told about the completion
+	l.cfg.BYOMetadata = nil
+	l.cfg.StateFile = state
+	l.cfg.Endpoint = srv.URL
+	l.http = NewHTTPClient()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.privKey, l.pubKey = priv, priv.GetPublic()
+	out := l.Start("fzhnp4jhFnMUKVGMKpt4kBMrvX", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:half], make([]byte, half)) || !bytes.Equal(got[half:], data[half:]) {
+		t.Fatal("download not resumed from the part file")
+	}
+	if _, err := os.Stat(l.destination + partSuffix); !os.IsNotExist(err) {
+		t.Fatalf("part file left behind: %v", err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if completed != 1 {
+		t.Fatalf("expected the completion to be reported once got %d", completed)
+	}
+}
+
+func TestStartResumesStaleCookie(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	metadata, err := l.cfg.BYOMetadata.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.byo = false
+	metadata.Cookie.Id = "stale"
+	metadata.Cookie.Size = int64(len(data))
+	metadata.FetchedAt = time.Now().Add(-2 * cookieLifetime)
+	fresh, err := json.Marshal(&info{
+		Cookie:   cookie{Id: "fresh", Hash: metadata.Cookie.Hash, Size: metadata.Cookie.Size},
+		SwarmKey: metadata.SwarmKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mtx sync.Mutex
+	fetched := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.URL.Path == "/"+fetchPath {
+			fetched++
+			w.Write(fresh)
+		}
+	}))
+	defer srv.Close()
+
+	half := len(data) / 2
+	if err := ioutil.WriteFile(l.destination+partSuffix, data[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Saved just now, with the cookie of a download started long ago
+	state := filepath.Join(filepath.Dir(l.destination), "state")
+	l.setSession(&session{sharable: "fzhnp4jhFnMUKVGMKpt4kBMrvX", destination: l.destination, metadata: metadata})
+	err = l.SaveState(state)
+	l.setSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.cfg.BYOMetadata = nil
+	l.cfg.StateFile = state
+	l.cfg.Endpoint = srv.URL
+	l.cfg.ExternalIPFunc = staticIP("203.0.113.7")
+	l.http = NewHTTPClient()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.privKey, l.pubKey = priv, priv.GetPublic()
+	out := l.Start("fzhnp4jhFnMUKVGMKpt4kBMrvX", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("unexpected destination %v", err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if fetched != 1 {
+		t.Fatalf("expected the stale cookie to be replaced got %d requests", fetched)
+	}
+}
+
+// watchedDAG calls check before serving each block.
+type watchedDAG struct {
+	ipld.DAGService
+	check func()
+}
+
+func (d *watchedDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	d.check()
+	return d.DAGService.Get(ctx, c)
+}
+
+func (d *watchedDAG) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	return getManyVia(ctx, keys, d.Get)
+}
+
+func TestStartWritesPartFile(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 2*1024*1024, 3)
+	defer done()
+	var mtx sync.Mutex
+	var seen []string
+	fake.DAGService = &watchedDAG{DAGService: fake.DAGService, check: func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if _, err := os.Stat(l.destination); !os.IsNotExist(err) {
+			seen = append(seen, "destination")
+		}
+		if _, err := os.Stat(l.destination + partSuffix); err != nil {
+			seen = append(seen, "no part file")
+		}
+	}}
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(seen) > 0 {
+		t.Fatalf("written in place while downloading: %v", seen)
+	}
+	got, err := ioutil.ReadFile(l.destination)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("unexpected destination %v", err)
+	}
+	if _, err := os.Stat(l.destination + partSuffix); !os.IsNotExist(err) {
+		t.Fatalf("part file left behind: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// StepCode identifies a milestone of a download.
+type StepCode int
+
+// Download milestones, in the order they are usually reached.
+const (
+	StepMetadata StepCode = iota + 1
+	StepResuming
+	StepAttempt
+	StepAgentReady
+	StepBootstrapped
+	StepMorePeers
+	StepPeersTimeout
+	StepDownloading
+	StepDownloadStarted
+	StepFinishing
+	StepSessionRefresh
+	StepBootstrapProgress
+	StepClockSkew
+	StepPaused
+	StepResumed
+)
+
+var stepNames = map[StepCode]string{
+	StepMetadata:          "metadata",
+	StepResuming:          "resuming",
+	StepAttempt:           "attempt",
+	StepAgentReady:        "agent_ready",
+	StepBootstrapped:      "bootstrapped",
+	StepMorePeers:         "more_peers",
+	StepPeersTimeout:      "peers_timeout",
+	StepDownloading:       "downloading",
+	StepDownloadStarted:   "download_started",
+	StepFinishing:         "finishing",
+	StepSessionRefresh:    "session_refresh",
+	StepBootstrapProgress: "bootstrap_progress",
+	StepClockSkew:         "clock_skew",
+	StepPaused:            "paused",
+	StepResumed:           "resumed",
+}
+
+func (c StepCode) String() string {
+	if n, ok := stepNames[c]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// StepHook is called on every download milestone.
+type StepHook func(code StepCode, message string)
+
+// step reports a milestone to the hook, if any, and prints it. In quiet
+// mode only failed steps are printed.
+func (l *LightClient) step(code StepCode, status int, message string) {
+	l.trace("step", map[string]string{"code": code.String(), "message": message})
+	if l.cfg.StepHook != nil {
+		l.runStepHook(code, message)
+	}
+	if l.cfg.Quiet && status == success {
+		return
+	}
+	showStep(status, message, l.jsonOut)
+}
+
+func (l *LightClient) runStepHook(code StepCode, message string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Step hook panicked on %s: %v\n%s", code, r, debug.Stack())
+		}
+	}()
+	l.cfg.StepHook(code, message)
+}
+
+// BootstrapEvent is the progress of connecting to the peers of a download,
+// reported to Config.BootstrapHook after every bootstrap attempt until the
+// transfer starts. Target is the number of peers looked for.
+type BootstrapEvent struct {
+	Attempt      int   `json:"attempt"`
+	Connected    int   `json:"connected"`
+	Target       int   `json:"target"`
+	ElapsedMilli int64 `json:"elapsed_ms"`
+}
+
+// BootstrapHook is called with the progress of bootstrapping.
+type BootstrapHook func(BootstrapEvent)
+
+// bootstrapEvents numbers the bootstrap attempts of a download and reports
+// them as StepBootstrapProgress until stopped.
+type bootstrapEvents struct {
+	l       *LightClient
+	start   time.Time
+	target  int
+	attempt int32
+	stopped int32
+}
+
+func (l *LightClient) newBootstrapEvents(target int) *bootstrapEvents {
+	return &bootstrapEvents{l: l, start: time.Now(), target: target}
+}
+
+// report tells the outcome of a bootstrap attempt.
+func (b *bootstrapEvents) report(connected int) {
+	if atomic.LoadInt32(&b.stopped) == 1 {
+		return
+	}
+	ev := BootstrapEvent{
+		Attempt:      int(atomic.AddInt32(&b.attempt, 1)),
+		Connected:    connected,
+		Target:       b.target,
+		ElapsedMilli: time.Since(b.start).Milliseconds(),
+	}
+	b.l.trace("bootstrap_attempt", ev)
+	if b.l.cfg.BootstrapHook != nil {
+		b.l.runBootstrapHook(ev)
+	}
+	b.l.step(StepBootstrapProgress, success, fmt.Sprintf("Connecting to peers: %d/%d", ev.Connected, ev.Target))
+}
+
+// stop ends the events, once the transfer starts.
+func (b *bootstrapEvents) stop() {
+	atomic.StoreInt32(&b.stopped, 1)
+}
+
+func (l *LightClient) runBootstrapHook(ev BootstrapEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Bootstrap hook panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	l.cfg.BootstrapHook(ev)
+}
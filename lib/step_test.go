@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestStepHook(t *testing.T) {
+	var got []StepCode
+	l := &LightClient{cfg: &Config{
+		StepHook: func(code StepCode, message string) {
+			got = append(got, code)
+			if code == StepFinishing {
+				panic("buggy hook")
+			}
+		},
+	}}
+	l.step(StepMetadata, success, "Got metadata")
+	l.step(StepFinishing, success, "Finishing download")
+	if len(got) != 2 || got[0] != StepMetadata || got[1] != StepFinishing {
+		t.Fatalf("unexpected steps %v", got)
+	}
+}
+
+func TestQuietStep(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	l := &LightClient{cfg: &Config{Quiet: true}}
+	l.step(StepMetadata, success, "Got metadata")
+	l.step(StepPeersTimeout, timeoutError, "Download timed out")
+	os.Stdout = stdout
+	w.Close()
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "Got metadata") {
+		t.Fatal("successful step printed in quiet mode")
+	}
+	if !strings.Contains(string(buf), "Download timed out") {
+		t.Fatal("failed step not printed in quiet mode")
+	}
+}
+
+// missingOnceDAG doesn't have c the first time it is asked for it.
+type missingOnceDAG struct {
+	ipld.DAGService
+	c      cid.Cid
+	missed int32
+}
+
+func (d *missingOnceDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if c.Equals(d.c) && atomic.CompareAndSwapInt32(&d.missed, 0, 1) {
+		return nil, ipld.ErrNotFound
+	}
+	return d.DAGService.Get(ctx, c)
+}
+
+func TestBootstrapEvents(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 2, 4)
+	defer done()
+	c, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root is only found after bootstrapping again
+	fake.DAGService = &missingOnceDAG{DAGService: fake.DAGService, c: c}
+
+	var mtx sync.Mutex
+	var events []BootstrapEvent
+	steps := 0
+	l.cfg.BootstrapHook = func(ev BootstrapEvent) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		events = append(events, ev)
+	}
+	l.cfg.StepHook = func(code StepCode, message string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if code == StepBootstrapProgress {
+			steps++
+		}
+	}
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+
+	// No event once the transfer started
+	b := l.newBootstrapEvents(1)
+	b.stop()
+	b.report(1)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(events) != 2 || steps != 2 {
+		t.Fatalf("expected 2 events got %+v and %d steps", events, steps)
+	}
+	for i, want := range []BootstrapEvent{{Attempt: 1, Connected: 2}, {Attempt: 2, Connected: 4}} {
+		ev := events[i]
+		if ev.Attempt != want.Attempt || ev.Connected != want.Connected || ev.Target != peerThreshold || ev.ElapsedMilli < 0 {
+			t.Fatalf("unexpected event %d %+v", i, ev)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+// SwarmFingerprint returns the hex encoded SHA-256 of a decoded swarm key.
+// It identifies a private swarm without revealing its key.
+func SwarmFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(psk)
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeSwarmKey decodes the swarm key provided by the metadata service. If
+// allowed is not empty, the key fingerprint has to be one of them.
+func decodeSwarmKey(swarmKey []byte, allowed []string) (pnet.PSK, error) {
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(swarmKey))
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) == 0 {
+		return psk, nil
+	}
+	fp := SwarmFingerprint(psk)
+	for _, a := range allowed {
+		if strings.EqualFold(a, fp) {
+			return psk, nil
+		}
+	}
+	return nil, fmt.Errorf("swarm key with fingerprint %s is not allowed", fp)
+}
@@ -0,0 +1,28 @@
+package lib
+
+import "testing"
+
+var testSwarmKey = []byte("/key/swarm/psk/1.0.0/\n/base16/\n2cc2c79ea52c9cc85dfd3061961dd8c4230cce0b09f182a0822c1536bf1d5f21\n")
+
+func TestDecodeSwarmKey(t *testing.T) {
+	psk, err := decodeSwarmKey(testSwarmKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := SwarmFingerprint(psk)
+
+	_, err = decodeSwarmKey(testSwarmKey, []string{"deadbeef", fp})
+	if err != nil {
+		t.Fatal("expected matching fingerprint to be accepted", err)
+	}
+
+	_, err = decodeSwarmKey(testSwarmKey, []string{"deadbeef"})
+	if err == nil {
+		t.Fatal("expected non-matching fingerprint to be rejected")
+	}
+
+	_, err = decodeSwarmKey([]byte("not a swarm key"), nil)
+	if err == nil {
+		t.Fatal("expected invalid swarm key to fail")
+	}
+}
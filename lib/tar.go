@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// StartToTar fetches the content of sharable and streams it to w as a tar
+// archive, gzipped if compress is set, without writing anything to disk.
+// A directory is archived under its name with the relative paths of its
+// files, a single file is the only entry of the archive. Files are
+// fetched one after the other as they are written, so the archive is
+// never held in memory. UnixFS doesn't record file modes here, the
+// entries get Config.FileMode and the directory mode derived from it.
+//
+// Progress is reported with the bytes of file content written so far out
+// of the size of all the files. The download runs as with StartOutput.
+func (l *LightClient) StartToTar(
+	ctx context.Context,
+	sharable string,
+	w io.Writer,
+	compress bool,
+	stat bool,
+	progUpd ProgressUpdater,
+) *Out {
+	return l.output(ctx, sharable, &tarSink{stream: newStream(w), l: l, compress: compress}, stat, progUpd)
+}
+
+// tarSink writes the content as a tar archive. The entries are listed
+// once and a retried download continues in the entry it stopped in.
+type tarSink struct {
+	stream
+	l        *LightClient
+	compress bool
+	entries  []dirEntry
+	modTime  time.Time
+	tw       *tar.Writer
+	gz       *gzip.Writer
+	// next is the entry being written, whose header was written with
+	// header and partial bytes of its content after it
+	next    int
+	header  bool
+	partial int64
+	written int64
+}
+
+func (s *tarSink) prepare(ctx context.Context, lite node, root *outputRoot) (int64, int64, error) {
+	if s.entries == nil {
+		entries := []dirEntry{}
+		if root.file == nil {
+			entries = append(entries, dirEntry{path: root.name, cid: root.cid, dir: true})
+			children, err := listDir(ctx, lite, root.cid, root.name)
+			if err != nil {
+				return 0, 0, err
+			}
+			entries = append(entries, children...)
+		} else {
+			entries = append(entries, dirEntry{path: root.name, cid: root.cid, size: int64(root.file.Size())})
+		}
+		s.entries = entries
+		s.modTime = time.Now()
+	}
+	var total int64
+	for _, e := range s.entries {
+		total += e.size
+	}
+	return total, s.written, nil
+}
+
+func (s *tarSink) write(ctx context.Context, lite node, done *int64) ([]DestinationResult, error) {
+	if s.tw == nil {
+		var w io.Writer = &s.out
+		if s.compress {
+			s.gz = gzip.NewWriter(w)
+			w = s.gz
+		}
+		s.tw = tar.NewWriter(w)
+	}
+	for ; s.next < len(s.entries); s.next++ {
+		e := s.entries[s.next]
+		if !s.header {
+			err := s.tw.WriteHeader(s.l.tarHeader(e, s.modTime))
+			if err != nil {
+				return nil, err
+			}
+			s.header = true
+		}
+		if !e.dir {
+			n, err := s.l.copyTarEntry(ctx, lite, s.tw, e, s.partial, done)
+			s.partial += n
+			s.written += n
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.header = false
+		s.partial = 0
+	}
+	err := s.tw.Close()
+	if err == nil && s.gz != nil {
+		err = s.gz.Close()
+	}
+	return nil, err
+}
+
+// tarHeader is the header of e in a tar archive.
+func (l *LightClient) tarHeader(e dirEntry, modTime time.Time) *tar.Header {
+	hdr := &tar.Header{
+		Name:    filepath.ToSlash(e.path),
+		ModTime: modTime,
+	}
+	if e.dir {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name = path.Clean(hdr.Name) + "/"
+		hdr.Mode = int64(l.dirMode().Perm())
+		return hdr
+	}
+	hdr.Typeflag = tar.TypeReg
+	hdr.Mode = int64(l.fileMode().Perm())
+	hdr.Size = e.size
+	return hdr
+}
+
+// copyTarEntry copies the content of the file e to tw after its first
+// offset bytes, fetched as it is copied.
+func (l *LightClient) copyTarEntry(ctx context.Context, lite node, tw *tar.Writer, e dirEntry, offset int64, done *int64) (int64, error) {
+	rsc, err := lite.GetFile(ctx, e.cid)
+	if err != nil {
+		return 0, err
+	}
+	defer rsc.Close()
+	if offset > 0 {
+		_, err = rsc.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+	}
+	pw := &pauseWriter{Writer: tw, ctx: ctx, p: &l.paused}
+	return copyExact(&countingWriter{Writer: pw, count: done}, rsc, e.size-offset)
+}
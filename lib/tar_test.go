@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// readTar returns the content of the entries of an archive by name, nil
+// for directories.
+func readTar(t *testing.T, r io.Reader) map[string][]byte {
+	out := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			out[hdr.Name] = nil
+			continue
+		}
+		buf, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[hdr.Name] = buf
+	}
+}
+
+func TestStartToTarFile(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Filename = "file.bin"
+
+	buf := &bytes.Buffer{}
+	out := l.StartToTar(context.Background(), "", buf, false, false, nil)
+	if out.Status != success {
+		t.Fatalf("archive failed %+v", out)
+	}
+	entries := readTar(t, buf)
+	if len(entries) != 1 || !bytes.Equal(entries["file.bin"], data) {
+		t.Fatalf("unexpected entries %d", len(entries))
+	}
+}
+
+func TestStartToTarDir(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024, 3)
+	defer done()
+	ctx := context.Background()
+
+	topNode := addTestDir(t, fake.DAGService)
+	l.cfg.BYOMetadata.Hash = topNode.Cid().String()
+	l.cfg.BYOMetadata.Filename = "photos"
+
+	buf := &bytes.Buffer{}
+	out := l.StartToTar(ctx, "", buf, true, false, nil)
+	if out.Status != success {
+		t.Fatalf("archive failed %+v", out)
+	}
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := readTar(t, gz)
+	want := map[string]string{
+		"photos/":          "",
+		"photos/a.txt":     "hello",
+		"photos/sub/":      "",
+		"photos/sub/b.txt": "world!",
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	for name, content := range want {
+		got, ok := entries[name]
+		if !ok || string(got) != content {
+			t.Fatalf("entry %s: expected %q got %q", name, content, got)
+		}
+	}
+}
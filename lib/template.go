@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var placeholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// templateFields are the placeholders of Config.DestinationTemplate.
+var templateFields = map[string]func(metadata *info, now time.Time) string{
+	"{filename}": func(m *info, _ time.Time) string { return m.Cookie.Filename },
+	"{hash}":     func(m *info, _ time.Time) string { return m.Cookie.Hash },
+	"{hash8}": func(m *info, _ time.Time) string {
+		if len(m.Cookie.Hash) > 8 {
+			return m.Cookie.Hash[:8]
+		}
+		return m.Cookie.Hash
+	},
+	"{date}": func(_ *info, now time.Time) string { return now.Format("2006-01-02") },
+}
+
+func validateTemplate(tmpl string) error {
+	if filepath.IsAbs(tmpl) {
+		return fmt.Errorf("destination template %q is not relative", tmpl)
+	}
+	for _, p := range placeholder.FindAllString(tmpl, -1) {
+		if _, ok := templateFields[p]; !ok {
+			return fmt.Errorf("unknown placeholder %s in destination template", p)
+		}
+	}
+	if strings.ContainsAny(placeholder.ReplaceAllString(tmpl, ""), "{}") {
+		return fmt.Errorf("unbalanced braces in destination template %q", tmpl)
+	}
+	return nil
+}
+
+// templatePath expands Config.DestinationTemplate for the content of
+// metadata. The result is in the directory of the destination, paths
+// leaving it are refused.
+func (l *LightClient) templatePath(metadata *info) (string, error) {
+	now := time.Now()
+	name := placeholder.ReplaceAllStringFunc(l.cfg.DestinationTemplate, func(p string) string {
+		return templateFields[p](metadata, now)
+	})
+	dir := filepath.Dir(l.destination)
+	p := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("destination %q is outside of %s", name, dir)
+	}
+	return p, nil
+}
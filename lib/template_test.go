@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	for tmpl, valid := range map[string]bool{
+		"{filename}-{hash8}.bin":  true,
+		"{date}/{filename}":       true,
+		"plain":                   true,
+		"{size}":                  false,
+		"{filename":               false,
+		"/tmp/{filename}":         false,
+		"{filename}}-{hash}":      false,
+		"archive/{hash}/{hash8}":  true,
+		"../{filename}-{date}.gz": true,
+	} {
+		err := validateTemplate(tmpl)
+		if valid && err != nil {
+			t.Errorf("%q should be valid: %s", tmpl, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%q should be invalid", tmpl)
+		}
+	}
+}
+
+func TestTemplatePath(t *testing.T) {
+	metadata := &info{Cookie: cookie{
+		Filename: "greeter.txt",
+		Hash:     "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB",
+	}}
+	dir := filepath.Join("dl", "files")
+	l := &LightClient{destination: filepath.Join(dir, "greeter.txt"), cfg: &Config{}}
+
+	for tmpl, want := range map[string]string{
+		"{filename}-{hash8}.bin": "greeter.txt-QmPZ9gcC.bin",
+		"{hash}":                 metadata.Cookie.Hash,
+		"{date}/{filename}":      filepath.Join(time.Now().Format("2006-01-02"), "greeter.txt"),
+	} {
+		l.cfg.DestinationTemplate = tmpl
+		got, err := l.templatePath(metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != filepath.Join(dir, want) {
+			t.Errorf("%q expanded to %s", tmpl, got)
+		}
+	}
+
+	for _, tmpl := range []string{"../{filename}", "{filename}/../..", "."} {
+		l.cfg.DestinationTemplate = tmpl
+		if _, err := l.templatePath(metadata); err == nil {
+			t.Errorf("%q should be refused", tmpl)
+		}
+	}
+	// The metadata can't escape either
+	l.cfg.DestinationTemplate = "{filename}"
+	evil := &info{Cookie: cookie{Filename: "../../etc/passwd"}}
+	if _, err := l.templatePath(evil); err == nil {
+		t.Error("filename leaving the directory should be refused")
+	}
+}
+
+func TestStartDestinationTemplate(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024, 3)
+	defer done()
+	l.cfg.BYOMetadata.Filename = "greeter.txt"
+	l.cfg.DestinationTemplate = "{hash8}/{filename}"
+	want := filepath.Join(filepath.Dir(l.destination), l.cfg.BYOMetadata.Hash[:8], "greeter.txt")
+
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	if l.destination != want {
+		t.Fatalf("expected destination %s got %s", want, l.destination)
+	}
+	got, err := ioutil.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Fatal("downloaded content differs")
+	}
+}
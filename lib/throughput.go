@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How often the throughput of a download is measured against
+// Config.MinThroughput.
+var throughputCheckInterval = time.Second
+
+// Defaults of Config.ThroughputWindow and Config.ThroughputGrace.
+const (
+	defaultThroughputWindow = 30 * time.Second
+	defaultThroughputGrace  = 2 * time.Minute
+)
+
+// ThroughputReport is the data of the Out of a download stopped because
+// its throughput stayed below Config.MinThroughput.
+type ThroughputReport struct {
+	// AverageThroughput is the bytes per second received over the last
+	// window when the download was stopped.
+	AverageThroughput int64 `json:"average_throughput"`
+	MinThroughput     int64 `json:"min_throughput"`
+	// BelowForMilli is how long the throughput had been too low.
+	BelowForMilli int64 `json:"below_for_ms"`
+	Received      int64 `json:"received"`
+}
+
+// throughputWatch stops a download whose throughput stays too low.
+type throughputWatch struct {
+	mtx    sync.Mutex
+	report *ThroughputReport
+	// done is closed once the watch is over
+	done chan struct{}
+}
+
+type throughputSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// watchThroughput calls stop once the average throughput over window has
+// stayed below min for longer than grace. received returns the bytes
+// received so far. The time the download is paused, when paused is set,
+// doesn't count. The watch is over once ctx is done or stop was called.
+func watchThroughput(
+	ctx context.Context,
+	min int64,
+	window, grace time.Duration,
+	received func() int64,
+	paused func() bool,
+	stop func(),
+) *throughputWatch {
+	t := &throughputWatch{done: make(chan struct{})}
+	if min <= 0 {
+		close(t.done)
+		return t
+	}
+	if window <= 0 {
+		window = defaultThroughputWindow
+	}
+	if grace <= 0 {
+		grace = defaultThroughputGrace
+	}
+	every := throughputCheckInterval
+	go func() {
+		defer close(t.done)
+		samples := []throughputSample{{at: time.Now()}}
+		var belowSince time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(every):
+			}
+			now := time.Now()
+			if paused != nil && paused() {
+				// Measured again from the resumption
+				samples = []throughputSample{{at: now, bytes: received()}}
+				belowSince = time.Time{}
+				continue
+			}
+			samples = append(samples, throughputSample{at: now, bytes: received()})
+			// Keep the last sample older than the window to average over it
+			for len(samples) > 2 && now.Sub(samples[1].at) >= window {
+				samples = samples[1:]
+			}
+			first, last := samples[0], samples[len(samples)-1]
+			avg := int64(float64(last.bytes-first.bytes) / last.at.Sub(first.at).Seconds())
+			if avg >= min {
+				belowSince = time.Time{}
+				continue
+			}
+			if belowSince.IsZero() {
+				belowSince = now
+			}
+			if now.Sub(belowSince) >= grace {
+				log.Errorf("Throughput of %d B/s below the minimum of %d B/s for %s", avg, min, now.Sub(belowSince))
+				t.mtx.Lock()
+				t.report = &ThroughputReport{
+					AverageThroughput: avg,
+					MinThroughput:     min,
+					BelowForMilli:     now.Sub(belowSince).Milliseconds(),
+					Received:          last.bytes,
+				}
+				t.mtx.Unlock()
+				stop()
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// tooLow returns the report of the download if it was stopped, nil
+// otherwise.
+func (t *throughputWatch) tooLow() *ThroughputReport {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.report
+}
+
+// wait waits for the watch to be over.
+func (t *throughputWatch) wait() {
+	<-t.done
+}
+
+func (r *ThroughputReport) String() string {
+	return fmt.Sprintf("average throughput %d B/s below the minimum of %d B/s for %dms",
+		r.AverageThroughput, r.MinThroughput, r.BelowForMilli)
+}
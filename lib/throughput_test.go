@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+func TestWatchThroughput(t *testing.T) {
+	old := throughputCheckInterval
+	throughputCheckInterval = time.Millisecond
+	defer func() { throughputCheckInterval = old }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var received int64
+	stopped := make(chan struct{})
+	w := watchThroughput(ctx, 1000, 20*time.Millisecond, 50*time.Millisecond, func() int64 {
+		// A megabyte per millisecond
+		return atomic.AddInt64(&received, 1<<20)
+	}, nil, func() { close(stopped) })
+	select {
+	case <-stopped:
+		t.Fatal("fast download stopped")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if w.tooLow() != nil {
+		t.Fatal("fast download reported too slow")
+	}
+	cancel()
+	w.wait()
+
+	// Paused downloads are not too slow
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	w = watchThroughput(ctx, 1000, 20*time.Millisecond, 50*time.Millisecond, func() int64 {
+		return 10
+	}, func() bool { return true }, func() {})
+	time.Sleep(200 * time.Millisecond)
+	if w.tooLow() != nil {
+		t.Fatal("paused download reported too slow")
+	}
+	cancel()
+	w.wait()
+
+	w = watchThroughput(context.Background(), 1000, 20*time.Millisecond, 50*time.Millisecond, func() int64 {
+		return 10
+	}, nil, func() {})
+	w.wait()
+	rep := w.tooLow()
+	if rep == nil {
+		t.Fatal("stalled download not stopped")
+	}
+	if rep.MinThroughput != 1000 || rep.AverageThroughput != 0 || rep.Received != 10 || rep.BelowForMilli < 50 {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+}
+
+func TestStartStopsTooSlow(t *testing.T) {
+	old := throughputCheckInterval
+	throughputCheckInterval = time.Millisecond
+	defer func() { throughputCheckInterval = old }()
+
+	l, fake, _, done := newFakeClient(t, 10*1024*1024, 3)
+	defer done()
+	l.cfg.MinThroughput = 1 << 40
+	l.cfg.ThroughputWindow = 10 * time.Millisecond
+	l.cfg.ThroughputGrace = 20 * time.Millisecond
+	l.newNode = func(_ context.Context, _ *info, _ pnet.PSK, contrib *contributions) (node, error) {
+		fake.DAGService = &slowDAG{DAGService: fake.DAGService, contrib: contrib, from: fake.h.ID()}
+		return fake, nil
+	}
+
+	out := l.Start("", false, false, nil)
+	if out.Status != throughputLow {
+		t.Fatalf("expected status %d got %+v", throughputLow, out)
+	}
+	rep, ok := out.Data.(*ThroughputReport)
+	if !ok || rep.MinThroughput != 1<<40 || rep.AverageThroughput >= rep.MinThroughput {
+		t.Fatalf("unexpected data %+v", out.Data)
+	}
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Timings breaks the duration of a download down by phase.
+type Timings struct {
+	Metadata   time.Duration `json:"metadata"`
+	Setup      time.Duration `json:"setup"`
+	Bootstrap  time.Duration `json:"bootstrap"`
+	FirstByte  time.Duration `json:"first_byte"`
+	Transfer   time.Duration `json:"transfer"`
+	Settlement time.Duration `json:"settlement"`
+
+	start time.Time
+}
+
+// newTimings returns the Timings of a download starting now.
+func newTimings() *Timings {
+	return &Timings{start: time.Now()}
+}
+
+func (t Timings) String() string {
+	return fmt.Sprintf("metadata=%s setup=%s bootstrap=%s first_byte=%s transfer=%s settlement=%s",
+		t.Metadata, t.Setup, t.Bootstrap, t.FirstByte, t.Transfer, t.Settlement)
+}
+
+// lap returns the time elapsed since mark and moves mark to now.
+func lap(mark *time.Time) time.Duration {
+	now := time.Now()
+	d := now.Sub(*mark)
+	*mark = now
+	return d
+}
+
+// firstWriteWriter remembers when the first byte was written through it.
+type firstWriteWriter struct {
+	io.Writer
+	first time.Time
+}
+
+func (w *firstWriteWriter) Write(p []byte) (int, error) {
+	if w.first.IsZero() && len(p) > 0 {
+		w.first = time.Now()
+	}
+	return w.Writer.Write(p)
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+func TestStartTimings(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 4*1024*1024, 3)
+	defer done()
+	l.newNode = func(_ context.Context, _ *info, _ pnet.PSK, contrib *contributions) (node, error) {
+		fake.DAGService = &slowDAG{DAGService: fake.DAGService, contrib: contrib, from: fake.h.ID()}
+		return fake, nil
+	}
+	l.cfg.SettleWait = 20 * time.Millisecond
+
+	start := time.Now()
+	out := l.Start("", false, true, nil)
+	elapsed := time.Since(start)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	st := out.Data.(StatOut)
+	tm := st.Timings
+	phases := []time.Duration{tm.Metadata, tm.Setup, tm.Bootstrap, tm.FirstByte, tm.Transfer, tm.Settlement}
+	var sum time.Duration
+	for i, d := range phases {
+		if d <= 0 {
+			t.Fatalf("phase %d not timed: %s", i, tm)
+		}
+		sum += d
+	}
+	// The phases follow each other within the download
+	if sum > elapsed {
+		t.Fatalf("phases of %s for a download of %s: %s", sum, elapsed, tm)
+	}
+	if tm.Settlement < l.cfg.SettleWait {
+		t.Fatalf("settlement of %s shorter than the wait", tm.Settlement)
+	}
+	// The first byte comes after the phases before it, and before the rest
+	beforeFirst := tm.Metadata + tm.Setup + tm.Bootstrap + tm.FirstByte
+	if st.TimeToFirstByte < beforeFirst || st.TimeToFirstByte+tm.Transfer+tm.Settlement > elapsed {
+		t.Fatalf("time to first byte %s out of order with %s", st.TimeToFirstByte, tm)
+	}
+}
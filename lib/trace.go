@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TraceEvent is a line of Config.TraceFile. Event is one of:
+//
+//	download           the download starts: sharable and destination
+//	metadata_request   a request to the metadata service: endpoint and key
+//	metadata_response  its outcome, see TraceMetadata
+//	step               a milestone: code and message, as told to StepHook
+//	bootstrap_attempt  a bootstrap attempt, see BootstrapEvent
+//	bootstrap          the outcome of dialing the leaders, see TraceDial
+//	peer_connected     a connection to a peer was opened: peer and addr
+//	peer_disconnected  a connection to a peer was closed: peer and addr
+//	block              a block was received: cid, size, peer and since_ms,
+//	                   the time since the download started
+//	result             the Out returned
+//
+// The session cookie, the swarm key and the keys of the client are never
+// part of the trace.
+type TraceEvent struct {
+	Time  time.Time   `json:"time"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// TraceMetadata is the outcome of a request to the metadata service. Info
+// and Leaders are only set when it succeeded.
+type TraceMetadata struct {
+	Endpoint     string    `json:"endpoint"`
+	ElapsedMilli int64     `json:"elapsed_ms"`
+	Error        string    `json:"error,omitempty"`
+	Info         *FileInfo `json:"info,omitempty"`
+	Leaders      []string  `json:"leaders,omitempty"`
+}
+
+// TraceDial is the outcome of dialing a peer at bootstrap.
+type TraceDial struct {
+	Peer      string `json:"peer"`
+	Transport string `json:"transport,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// tracer writes the events of a download to Config.TraceFile, one JSON
+// object per line. A nil tracer drops them.
+type tracer struct {
+	mtx   sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+func openTrace(path string, mode os.FileMode) (*tracer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &tracer{f: f, enc: json.NewEncoder(f), start: time.Now()}, nil
+}
+
+func (t *tracer) event(name string, data interface{}) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.f == nil {
+		return
+	}
+	err := t.enc.Encode(TraceEvent{Time: time.Now().UTC(), Event: name, Data: data})
+	if err != nil {
+		log.Warnf("Failed writing trace Err: %s", err.Error())
+	}
+}
+
+// block records a received block.
+func (t *tracer) block(from peer.ID, k cid.Cid, size int) {
+	t.event("block", map[string]interface{}{
+		"cid":      k.String(),
+		"size":     size,
+		"peer":     from.String(),
+		"since_ms": time.Since(t.start).Milliseconds(),
+	})
+}
+
+// notifiee records the connections opened and closed by the node.
+func (t *tracer) notifiee() network.Notifiee {
+	conn := func(event string) func(network.Network, network.Conn) {
+		return func(_ network.Network, c network.Conn) {
+			t.event(event, map[string]string{
+				"peer": c.RemotePeer().String(),
+				"addr": c.RemoteMultiaddr().String(),
+			})
+		}
+	}
+	return &network.NotifyBundle{
+		ConnectedF:    conn("peer_connected"),
+		DisconnectedF: conn("peer_disconnected"),
+	}
+}
+
+// close stops the trace. Later events are dropped.
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	err := t.f.Close()
+	if err != nil {
+		log.Warnf("Failed closing trace Err: %s", err.Error())
+	}
+	t.f = nil
+}
+
+// traced runs a download with start, its events being written to
+// Config.TraceFile when set. A trace which can't be written doesn't fail
+// the download.
+func (l *LightClient) traced(sharable string, start func() *Out) *Out {
+	if len(l.cfg.TraceFile) == 0 {
+		return start()
+	}
+	t, err := openTrace(l.cfg.TraceFile, l.fileMode())
+	if err != nil {
+		log.Warnf("Failed creating trace Err: %s", err.Error())
+		return start()
+	}
+	l.setTracer(t)
+	defer func() {
+		l.setTracer(nil)
+		t.close()
+	}()
+	t.event("download", map[string]string{
+		"sharable":    sharable,
+		"destination": l.destination,
+	})
+	out := start()
+	t.event("result", out)
+	return out
+}
+
+func (l *LightClient) setTracer(t *tracer) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.tr = t
+}
+
+// trace records an event of the download in progress, if traced.
+func (l *LightClient) trace(event string, data interface{}) {
+	l.tracer().event(event, data)
+}
+
+func (l *LightClient) tracer() *tracer {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.tr
+}
+
+// traceMetadata records the outcome of a request to the metadata service.
+func (l *LightClient) traceMetadata(endpoint string, sent time.Time, i *info, err error) {
+	t := l.tracer()
+	if t == nil {
+		return
+	}
+	ev := TraceMetadata{
+		Endpoint:     endpoint,
+		ElapsedMilli: time.Since(sent).Milliseconds(),
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	if i != nil {
+		fi := i.fileInfo()
+		ev.Info = &fi
+		for _, p := range i.Cookie.Leaders {
+			addrs, _ := peer.AddrInfoToP2pAddrs(&p)
+			for _, a := range addrs {
+				ev.Leaders = append(ev.Leaders, a.String())
+			}
+		}
+	}
+	t.event("metadata_response", ev)
+}
+
+// traceDials records the outcome of dialing the leaders.
+func (l *LightClient) traceDials(peers []peer.AddrInfo, results []ipfslite.DialResult) {
+	t := l.tracer()
+	if t == nil {
+		return
+	}
+	dials := make([]TraceDial, 0, len(results))
+	for _, f := range bootstrapFailures(peers, results) {
+		dials = append(dials, TraceDial{Peer: f.Peer, Reason: f.Reason, Error: f.Error})
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			dials = append(dials, TraceDial{Peer: r.ID.String(), Transport: r.Transport})
+		}
+	}
+	t.event("bootstrap", dials)
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+// readTrace returns the events of the trace at path.
+func readTrace(t *testing.T, path string) []TraceEvent {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []TraceEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		ev := TraceEvent{}
+		err = json.Unmarshal(sc.Bytes(), &ev)
+		if err != nil {
+			t.Fatalf("invalid trace line %s: %s", sc.Bytes(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestTraceFile(t *testing.T) {
+	l, fake, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.newNode = func(_ context.Context, _ *info, _ pnet.PSK, contrib *contributions) (node, error) {
+		fake.DAGService = &slowDAG{DAGService: fake.DAGService, contrib: contrib, from: fake.h.ID()}
+		return fake, nil
+	}
+	l.cfg.Quiet = true
+	l.cfg.TraceFile = filepath.Join(filepath.Dir(l.destination), "trace.jsonl")
+	out := l.Start("", false, false, nil)
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+
+	events := readTrace(t, l.cfg.TraceFile)
+	if len(events) == 0 || events[0].Event != "download" || events[len(events)-1].Event != "result" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	counts := map[string]int{}
+	var blockBytes int
+	for i, ev := range events {
+		counts[ev.Event]++
+		if i > 0 && ev.Time.Before(events[i-1].Time) {
+			t.Fatalf("events out of order %+v", events)
+		}
+		if ev.Event == "block" {
+			if size, ok := ev.Data.(map[string]interface{})["size"].(float64); ok {
+				blockBytes += int(size)
+			}
+		}
+	}
+	for _, name := range []string{"step", "bootstrap_attempt", "bootstrap", "block"} {
+		if counts[name] == 0 {
+			t.Fatalf("no %s event in %v", name, counts)
+		}
+	}
+	// The leaves and the root
+	if blockBytes < len(data) {
+		t.Fatalf("blocks of %d bytes traced for %d bytes", blockBytes, len(data))
+	}
+	// Traces end with the download
+	l.trace("step", nil)
+	if len(readTrace(t, l.cfg.TraceFile)) != len(events) {
+		t.Fatal("event traced after the download")
+	}
+}
+
+func TestTraceMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Cookie": {"Id": "secret-cookie", "Hash": "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"},
+			"SwarmKey": "c2VjcmV0LXN3YXJtLWtleQ=="}`))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trace := filepath.Join(dir, "trace.jsonl")
+	l, err := NewLightClient(".", "1m", false, &Config{
+		Endpoint:       srv.URL,
+		ExternalIPFunc: staticIP("203.0.113.7"),
+		Quiet:          true,
+		TraceFile:      trace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.traced("sharable", func() *Out {
+		_, err := l.getInfo(context.Background(), "sharable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewOut(success, MetaInfo, "", nil)
+	})
+
+	events := readTrace(t, trace)
+	if len(events) != 4 || events[1].Event != "metadata_request" || events[2].Event != "metadata_response" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	buf, err := json.Marshal(events[2].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := TraceMetadata{}
+	err = json.Unmarshal(buf, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Endpoint != srv.URL || resp.Info == nil || resp.Info.Hash != "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB" {
+		t.Fatalf("unexpected response %s", buf)
+	}
+	raw, err := ioutil.ReadFile(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-cookie", "c2VjcmV0LXN3YXJtLWtleQ==", "secret-swarm-key"} {
+		if bytes.Contains(raw, []byte(secret)) {
+			t.Fatalf("%s in the trace", secret)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	ipfslite "github.com/StreamSpace/ss-light-client"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// VerifyResult is the outcome of Verify.
+type VerifyResult struct {
+	Passed bool `json:"passed"`
+	// Expected is the hash given by the metadata and Computed the one
+	// computed from the content of the root block.
+	Expected string `json:"expected"`
+	Computed string `json:"computed"`
+	// HashFunction is the multihash function of the expected hash, which
+	// every block is checked with, e.g. sha2-256 or blake2b-256.
+	HashFunction string `json:"hash_function"`
+	// Blocks is the number of blocks checked.
+	Blocks int `json:"blocks"`
+	// Bytes and SHA256 describe the file content. They are not set for
+	// directories.
+	Bytes  int64  `json:"bytes,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Verify downloads the content of sharable without writing it anywhere
+// and checks that every block of it hashes to its CID, starting with the
+// root which has to be the hash given by the metadata. Nothing is created
+// on disk. The result is a VerifyResult, with a failure status when the
+// check didn't pass.
+func (l *LightClient) Verify(ctx context.Context, sharable string) *Out {
+	metadata, out := l.sharableInfo(ctx, sharable)
+	if out != nil {
+		return out
+	}
+	if out := metadata.expiredOut(); out != nil {
+		return out
+	}
+	c, err := cid.Decode(metadata.Cookie.Hash)
+	if err != nil {
+		return NewOut(internalError, "Failed decoding filehash provided", err.Error(), nil)
+	}
+	psk, err := decodeSwarmKey(metadata.SwarmKey, l.cfg.AllowedSwarmFingerprints)
+	if err != nil {
+		log.Errorf("Refusing swarm key Err: %s", err.Error())
+		return NewOut(internalError, "Swarm key not accepted", err.Error(), nil)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	newNode := l.newNode
+	if newNode == nil {
+		newNode = l.setupNode
+	}
+	lite, err := newNode(ctx, metadata, psk, newContributions())
+	if err != nil {
+		return NewOut(internalError, "Failed setting up light client", err.Error(), nil)
+	}
+	defer lite.close()
+	// STEP : Download agent created
+	l.step(StepAgentReady, success, "Download agent initialized")
+	start := time.Now()
+	count, err := lite.BootstrapWithError(l.dialable(metadata.bootstrapPeers()))
+	if err == ipfslite.ErrSwarmKeyMismatch {
+		log.Errorf("Failed bootstrapping Err: %s", err.Error())
+		return NewOut(swarmKeyErr, "Swarm key mismatch", err.Error(), nil)
+	}
+	// STEP : Bootstrap done
+	l.step(StepBootstrapped, success, fmt.Sprintf("Bootstrapped agent with %d leaders", count))
+
+	res := VerifyResult{
+		Expected:     c.String(),
+		HashFunction: mh.Codes[c.Prefix().MhType],
+	}
+	rsc, err := l.getFile(ctx, lite, c, func() int {
+		return lite.Bootstrap(l.dialable(metadata.bootstrapPeers()))
+	})
+	switch {
+	case err == ufsio.ErrIsDir:
+	case err != nil:
+		if err == context.DeadlineExceeded {
+			return NewOut(timeoutError, "Unable to fetch data", err.Error(), nil)
+		}
+		return NewOut(internalError, "Failed getting file", err.Error(), nil)
+	default:
+		// Reading the file first fetches the blocks concurrently, the
+		// check below then finds them in the datastore
+		sum := sha256.New()
+		res.Bytes, err = io.Copy(sum, rsc)
+		rsc.Close()
+		if err != nil {
+			return NewOut(internalError, "Failed reading content", err.Error(), nil)
+		}
+		res.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	}
+	computed, blocks, verr := verifyDAG(ctx, lite, c)
+	if verr != nil && ctx.Err() != nil {
+		return NewOut(timeoutError, "Unable to fetch data", verr.Error(), nil)
+	}
+	res.Computed = computed.String()
+	res.Blocks = blocks
+	res.Passed = verr == nil && computed.Equals(c)
+	if res.Passed && res.SHA256 != "" && metadata.Cookie.Size > 0 && res.Bytes != metadata.Cookie.Size {
+		res.Passed = false
+		verr = fmt.Errorf("expected %d bytes got %d", metadata.Cookie.Size, res.Bytes)
+	}
+
+	l.settle(ctx, lite)
+	// The service is only told about content delivered as expected
+	if !metadata.byo && res.Passed {
+		err = l.updateInfo(metadata, int64(time.Since(start).Seconds()))
+		if err != nil {
+			log.Warnf("Failed updating metadata after verification Err: %s", err.Error())
+		}
+	}
+	if !res.Passed {
+		details := "content doesn't match the expected hash"
+		if verr != nil {
+			details = verr.Error()
+		}
+		return NewOut(internalError, "Verification failed", details, res)
+	}
+	return NewOut(success, "Verification passed", "", res)
+}
+
+// verifyDAG fetches every block of the DAG of root and checks that its
+// content hashes to its CID, using the hash function and length of the
+// multihash of each CID rather than assuming sha2-256. It returns the CID
+// computed from the content of the root block and the number of blocks
+// checked.
+func verifyDAG(ctx context.Context, dag ipld.DAGService, root cid.Cid) (cid.Cid, int, error) {
+	computed := cid.Undef
+	blocks := 0
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := dag.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := c.Prefix().Sum(nd.RawData())
+		if err != nil {
+			return nil, err
+		}
+		if c.Equals(root) {
+			computed = sum
+		}
+		if !sum.Equals(c) {
+			return nil, fmt.Errorf("block %s hashes to %s", c, sum)
+		}
+		blocks++
+		return nd.Links(), nil
+	}
+	err := merkledag.Walk(ctx, getLinks, root, cid.NewSet().Visit)
+	return computed, blocks, err
+}
+
+// verifyDownload checks the content written to path against the DAG of c,
+// whose blocks are in the datastore after the transfer. Every block has to
+// hash to its CID and, unless dir is set, the file has to hold exactly the
+// content of the DAG.
+func verifyDownload(ctx context.Context, lite node, c cid.Cid, path string, dir bool) error {
+	_, _, err := verifyDAG(ctx, lite, c)
+	if err != nil || dir {
+		return err
+	}
+	rsc, err := lite.GetFile(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer rsc.Close()
+	want := sha256.New()
+	_, err = io.Copy(want, rsc)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	got := sha256.New()
+	n, err := io.Copy(got, f)
+	if err != nil {
+		return err
+	}
+	if uint64(n) != rsc.Size() || !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+		return fmt.Errorf("file of %d bytes doesn't match the %d bytes of %s", n, rsc.Size(), c)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// corruptDAG serves the content of another node for bad.
+type corruptDAG struct {
+	ipld.DAGService
+	bad cid.Cid
+}
+
+func (d *corruptDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if c.Equals(d.bad) {
+		return merkledag.NewRawNode([]byte("not the block")), nil
+	}
+	return d.DAGService.Get(ctx, c)
+}
+
+// aliasDAG serves the node of target for alias.
+type aliasDAG struct {
+	ipld.DAGService
+	alias, target cid.Cid
+}
+
+func (d *aliasDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if c.Equals(d.alias) {
+		return d.DAGService.Get(ctx, d.target)
+	}
+	return d.DAGService.Get(ctx, c)
+}
+
+func TestVerify(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+
+	out := l.Verify(context.Background(), "")
+	res, ok := out.Data.(VerifyResult)
+	if out.Status != success || !ok || !res.Passed {
+		t.Fatalf("verification failed %+v", out)
+	}
+	if res.Computed != res.Expected || res.Bytes != int64(len(data)) || res.Blocks != 5 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if _, err := os.Stat(l.destination); !os.IsNotExist(err) {
+		t.Fatal("verification wrote to the destination")
+	}
+	if _, err := os.Stat(l.destination + partSuffix); !os.IsNotExist(err) {
+		t.Fatal("verification wrote a part file")
+	}
+}
+
+func TestVerifyCorruptBlock(t *testing.T) {
+	l, fake, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	root, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := fake.Get(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.DAGService = &corruptDAG{DAGService: fake.DAGService, bad: nd.Links()[2].Cid}
+
+	out := l.Verify(context.Background(), "")
+	res, ok := out.Data.(VerifyResult)
+	if out.Status != internalError || !ok || res.Passed {
+		t.Fatalf("expected a failed verification got %+v", out)
+	}
+	if res.Computed != res.Expected {
+		t.Fatalf("root should still match %+v", res)
+	}
+}
+
+// importWith adds data to dag with blocks hashed by the multihash function
+// code.
+func importWith(t *testing.T, dag ipld.DAGService, data []byte, code uint64) ipld.Node {
+	params := helpers.DagBuilderParams{
+		Dagserv:    dag,
+		Maxlinks:   helpers.DefaultLinksPerBlock,
+		CidBuilder: cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: code, MhLength: -1},
+	}
+	db, err := params.New(chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func TestVerifyHashFunctions(t *testing.T) {
+	for _, code := range []uint64{mh.SHA2_256, mh.SHA2_512, mh.SHA3_256, mh.BLAKE2B_MIN + 31, mh.BLAKE2S_MIN + 31} {
+		name := mh.Codes[code]
+		t.Run(name, func(t *testing.T) {
+			l, fake, data, done := newFakeClient(t, 1024*1024, 3)
+			defer done()
+			root := importWith(t, fake.DAGService, data, code)
+			if root.Cid().Prefix().MhType != code {
+				t.Fatalf("content not hashed with %s", name)
+			}
+			l.cfg.BYOMetadata.Hash = root.Cid().String()
+
+			out := l.Verify(context.Background(), "")
+			res, ok := out.Data.(VerifyResult)
+			if out.Status != success || !ok || !res.Passed || res.HashFunction != name {
+				t.Fatalf("verification failed %+v", out)
+			}
+
+			// The digest of a block labelled with another hash function
+			// doesn't pass
+			leaf := root.Links()[1].Cid
+			dec, err := mh.Decode(leaf.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			other := uint64(mh.SHA2_256)
+			if code == mh.SHA2_256 {
+				other = mh.BLAKE2B_MIN + 31
+			}
+			relabelled, err := mh.Encode(dec.Digest, other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			alias := cid.NewCidV1(cid.DagProtobuf, relabelled)
+			dag := &aliasDAG{DAGService: fake.DAGService, alias: alias, target: leaf}
+			_, _, err = verifyDAG(context.Background(), dag, alias)
+			if err == nil {
+				t.Fatalf("%s digest accepted as %s", name, mh.Codes[other])
+			}
+		})
+	}
+}
+
+func TestVerifyContentBeforeCompletion(t *testing.T) {
+	var completes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, completePath) {
+			atomic.AddInt32(&completes, 1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	oldAddr := ApiAddr
+	ApiAddr = srv.URL
+	defer func() { ApiAddr = oldAddr }()
+
+	l, fake, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.VerifyContent = true
+	fetch := func() *Out {
+		metadata, err := l.cfg.BYOMetadata.info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Downloads of provided metadata are never reported
+		metadata.byo = false
+		return l.fetch(context.Background(), "sharable", metadata, false, false, nil, &Timings{})
+	}
+
+	out := fetch()
+	if out.Status != success {
+		t.Fatalf("download failed %+v", out)
+	}
+	if got, _ := ioutil.ReadFile(l.destination); !bytes.Equal(got, data) {
+		t.Fatal("downloaded content differs")
+	}
+	if n := atomic.LoadInt32(&completes); n != 1 {
+		t.Fatalf("expected the completion reported once got %d", n)
+	}
+
+	// A leaf served with the content of another one of the same size
+	root, err := cid.Decode(l.cfg.BYOMetadata.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := fake.Get(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.DAGService = &aliasDAG{DAGService: fake.DAGService, alias: nd.Links()[2].Cid, target: nd.Links()[0].Cid}
+	l.destination += "-corrupt"
+	out = fetch()
+	if out.Status != internalError || out.Message != "Verification failed" {
+		t.Fatalf("expected a failed verification got %+v", out)
+	}
+	if n := atomic.LoadInt32(&completes); n != 1 {
+		t.Fatal("completion reported for content failing verification")
+	}
+	if _, err := os.Stat(l.destination + partSuffix); !os.IsNotExist(err) {
+		t.Fatal("content failing verification kept")
+	}
+}
@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// VerifyFile checks that the file at path, downloaded earlier, still is the
+// content of sharable. Only the metadata is fetched: the CID of the file is
+// computed locally the way content is added by default, with 256KiB chunks
+// in a balanced DAG, using the CID version and hash function of the
+// expected hash. Content added with other settings can't be matched.
+// Directories are not supported. The result is a VerifyResult, with a
+// failure status when the hashes differ.
+func (l *LightClient) VerifyFile(ctx context.Context, sharable, path string) *Out {
+	fi, err := os.Stat(path)
+	if err != nil {
+		log.Errorf("Unusable file Err: %s", err.Error())
+		return NewOut(destinationErr, "Failed reading file", err.Error(), nil)
+	}
+	if fi.IsDir() {
+		return NewOut(invalidInput, "Only files can be verified", path+" is a directory", nil)
+	}
+	metadata, out := l.sharableInfo(ctx, sharable)
+	if out != nil {
+		return out
+	}
+	c, err := cid.Decode(metadata.Cookie.Hash)
+	if err != nil {
+		return NewOut(internalError, "Failed decoding filehash provided", err.Error(), nil)
+	}
+
+	res := VerifyResult{
+		Expected:     c.String(),
+		HashFunction: mh.Codes[c.Prefix().MhType],
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return NewOut(destinationErr, "Failed reading file", err.Error(), nil)
+	}
+	defer f.Close()
+	sum := sha256.New()
+	counter := &countingDAG{}
+	computed, err := fileCID(io.TeeReader(f, sum), c.Prefix(), counter)
+	if err != nil {
+		log.Errorf("Failed hashing file Err: %s", err.Error())
+		return NewOut(destinationErr, "Failed reading file", err.Error(), nil)
+	}
+	res.Computed = computed.String()
+	res.Blocks = counter.blocks
+	res.Bytes = fi.Size()
+	res.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	res.Passed = computed.Equals(c)
+	if !res.Passed {
+		return NewOut(internalError, "Verification failed", "file doesn't match the expected hash", res)
+	}
+	return NewOut(success, "Verification passed", "", res)
+}
+
+// fileCID computes the CID of the content of r as added with the default
+// settings for the version and hash function of prefix. CIDv1 content is
+// added with raw leaves. The blocks are added to dag.
+func fileCID(r io.Reader, prefix cid.Prefix, dag ipld.DAGService) (cid.Cid, error) {
+	params := helpers.DagBuilderParams{
+		Maxlinks: helpers.DefaultLinksPerBlock,
+		Dagserv:  dag,
+	}
+	if prefix.Version > 0 {
+		params.RawLeaves = true
+		params.CidBuilder = cid.Prefix{
+			Version:  1,
+			Codec:    cid.DagProtobuf,
+			MhType:   prefix.MhType,
+			MhLength: -1,
+		}
+	}
+	db, err := params.New(chunk.NewSizeSplitter(r, chunk.DefaultBlockSize))
+	if err != nil {
+		return cid.Undef, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+var errNotStored = errors.New("blocks are not stored")
+
+// countingDAG counts the blocks added to it and drops them, so that the
+// CID of a file can be computed without holding its content.
+type countingDAG struct {
+	blocks int
+}
+
+func (d *countingDAG) Get(context.Context, cid.Cid) (ipld.Node, error) {
+	return nil, errNotStored
+}
+
+func (d *countingDAG) GetMany(_ context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(keys))
+	for range keys {
+		out <- &ipld.NodeOption{Err: errNotStored}
+	}
+	close(out)
+	return out
+}
+
+func (d *countingDAG) Add(context.Context, ipld.Node) error {
+	d.blocks++
+	return nil
+}
+
+func (d *countingDAG) AddMany(_ context.Context, nds []ipld.Node) error {
+	d.blocks += len(nds)
+	return nil
+}
+
+func (d *countingDAG) Remove(context.Context, cid.Cid) error {
+	return nil
+}
+
+func (d *countingDAG) RemoveMany(context.Context, []cid.Cid) error {
+	return nil
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestVerifyFile(t *testing.T) {
+	l, _, data, done := newFakeClient(t, 3*1024*1024+5, 1)
+	defer done()
+	dir := filepath.Dir(l.destination)
+	path := filepath.Join(dir, "local")
+	err := ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := l.VerifyFile(context.Background(), "", path)
+	res, ok := out.Data.(VerifyResult)
+	if out.Status != success || !ok || !res.Passed {
+		t.Fatalf("expected the file to match got %+v", out)
+	}
+	if res.Computed != l.cfg.BYOMetadata.Hash || res.Bytes != int64(len(data)) || res.Blocks < 13 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	// Changed since the download
+	data[len(data)/2] ^= 1
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = l.VerifyFile(context.Background(), "", path)
+	res, ok = out.Data.(VerifyResult)
+	if out.Status != internalError || !ok || res.Passed || res.Computed == res.Expected {
+		t.Fatalf("expected a mismatch got %+v", out)
+	}
+
+	out = l.VerifyFile(context.Background(), "", dir)
+	if out.Status != invalidInput {
+		t.Fatalf("expected a directory to be refused got %+v", out)
+	}
+	out = l.VerifyFile(context.Background(), "", filepath.Join(dir, "missing"))
+	if out.Status != destinationErr {
+		t.Fatalf("expected a missing file to fail got %+v", out)
+	}
+}
+
+func TestFileCIDv1(t *testing.T) {
+	data := []byte("small enough for a single block")
+	prefix := cid.Prefix{Version: 1, Codec: cid.DagProtobuf, MhType: mh.SHA2_256, MhLength: -1}
+	c, err := fileCID(bytes.NewReader(data), prefix, &countingDAG{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A single raw leaf
+	want, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equals(want) {
+		t.Fatalf("expected %s got %s", want, c)
+	}
+}
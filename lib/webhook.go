@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookSignatureHeader holds the HMAC-SHA256 of the body of webhook
+// requests, keyed with Config.WebhookSecret, as "sha256=<hex>".
+const WebhookSignatureHeader = "X-Signature-256"
+
+// Number of times a webhook request is sent before giving up, and how long
+// each may take.
+const (
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+// Wait before the first retry of a webhook request, doubled for each one.
+var webhookRetryDelay = time.Second
+
+// WebhookPayload is the body POSTed to Config.CompletionWebhook once a
+// download ended. Result is the Out returned by Start or StartName, its
+// data holds the stats of successful downloads.
+type WebhookPayload struct {
+	// Sharable is the sharable or name downloaded.
+	Sharable    string    `json:"sharable"`
+	Destination string    `json:"destination"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Result      *Out      `json:"result"`
+}
+
+// notify runs a download with start, always asking for the stats, and
+// posts its result to Config.CompletionWebhook. The stats are only kept
+// in the result returned with stat.
+func (l *LightClient) notify(sharable string, stat bool, start func(stat bool) *Out) *Out {
+	if len(l.cfg.CompletionWebhook) == 0 {
+		return start(stat)
+	}
+	out := start(true)
+	l.postWebhook(&WebhookPayload{
+		Sharable:    sharable,
+		Destination: l.destination,
+		FinishedAt:  time.Now().UTC(),
+		Result:      out,
+	})
+	if _, ok := out.Data.(StatOut); ok && !stat {
+		res := *out
+		res.Data = nil
+		return &res
+	}
+	return out
+}
+
+// postWebhook delivers payload to Config.CompletionWebhook, retrying
+// transient failures. Failures are only logged: the download result
+// doesn't depend on the webhook.
+func (l *LightClient) postWebhook(payload *WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("Failed encoding webhook payload Err: %s", err.Error())
+		return
+	}
+	delay := webhookRetryDelay
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		var retry bool
+		retry, err = l.sendWebhook(body)
+		if err == nil {
+			log.Debugf("Download result sent to webhook")
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			break
+		}
+		log.Warnf("Failed sending download result to webhook, retrying Err: %s", err.Error())
+		<-time.After(delay)
+		delay *= 2
+	}
+	log.Errorf("Failed sending download result to webhook Err: %s", err.Error())
+}
+
+// sendWebhook sends the webhook request once. It returns whether a failure
+// is transient and worth retrying.
+func (l *LightClient) sendWebhook(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.cfg.CompletionWebhook, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The same for every attempt, so that the receiver can drop repeats
+	sum := sha256.Sum256(body)
+	req.Header.Set("Idempotency-Key", hex.EncodeToString(sum[:]))
+	if len(l.cfg.WebhookSecret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(body, l.cfg.WebhookSecret))
+	}
+	resp, err := l.httpClient().Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return false, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body keyed with secret.
+func signWebhook(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder answers the webhook requests with the given statuses in
+// turn, the last one repeated, and keeps what was received.
+type webhookRecorder struct {
+	mtx      sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.bodies = append(w.bodies, body)
+	w.headers = append(w.headers, r.Header)
+	status := w.statuses[0]
+	if len(w.statuses) > 1 {
+		w.statuses = w.statuses[1:]
+	}
+	rw.WriteHeader(status)
+}
+
+func TestCompletionWebhook(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+	rec := &webhookRecorder{statuses: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	l, _, data, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.CompletionWebhook = srv.URL
+	l.cfg.WebhookSecret = "secret"
+	out := l.Start("", false, false, nil)
+	if out.Status != success || out.Data != nil {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if len(rec.bodies) != 2 {
+		t.Fatalf("expected a retry, got %d requests", len(rec.bodies))
+	}
+	body, hdr := rec.bodies[1], rec.headers[1]
+	if got := hdr.Get(WebhookSignatureHeader); got != "sha256="+signWebhook(body, "secret") {
+		t.Fatalf("unexpected signature %q", got)
+	}
+	if hdr.Get("Idempotency-Key") != rec.headers[0].Get("Idempotency-Key") {
+		t.Fatal("expected the same idempotency key on retries")
+	}
+	payload := struct {
+		Destination string
+		Result      struct {
+			Status int
+			Data   StatReport
+		}
+	}{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Destination != l.destination || payload.Result.Status != success {
+		t.Fatalf("unexpected payload %s", body)
+	}
+	if payload.Result.Data.Bytes != int64(len(data)) {
+		t.Fatalf("expected the stats in the payload %s", body)
+	}
+}
+
+func TestCompletionWebhookFailure(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+	rec := &webhookRecorder{statuses: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	l, _, _, done := newFakeClient(t, 1024*1024, 3)
+	defer done()
+	l.cfg.CompletionWebhook = srv.URL
+	out := l.Start("", false, true, nil)
+	if out.Status != success {
+		t.Fatalf("webhook failed the download %+v", out)
+	}
+	if _, ok := out.Data.(StatOut); !ok {
+		t.Fatalf("expected stats got %+v", out.Data)
+	}
+	// Client errors are not retried
+	if len(rec.bodies) != 1 || len(rec.headers[0].Get(WebhookSignatureHeader)) != 0 {
+		t.Fatalf("unexpected requests %d", len(rec.bodies))
+	}
+
+	// Failed downloads are reported too
+	l.destination = filepath.Join(l.destination, "missing", "file")
+	out = l.Start("", false, false, nil)
+	if out.Status != destinationErr {
+		t.Fatalf("expected the download to fail %+v", out)
+	}
+	if len(rec.bodies) != 2 {
+		t.Fatal("failed download not reported")
+	}
+}
@@ -0,0 +1,82 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// limitedGetter bounds the number of blocks being requested from the
+// network at the same time.
+type limitedGetter struct {
+	ipld.NodeGetter
+	sem chan struct{}
+}
+
+func newLimitedGetter(ng ipld.NodeGetter, max int) *limitedGetter {
+	return &limitedGetter{
+		NodeGetter: ng,
+		sem:        make(chan struct{}, max),
+	}
+}
+
+func (g *limitedGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	select {
+	case g.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-g.sem }()
+	return g.NodeGetter.Get(ctx, c)
+}
+
+// GetMany takes a slot per key and requests the keys it got slots for in
+// one batch, so that the underlying GetMany can still look for them
+// together. Each slot is given back once its block arrives.
+func (g *limitedGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(keys))
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for len(keys) > 0 {
+			select {
+			case g.sem <- struct{}{}:
+			case <-ctx.Done():
+				out <- &ipld.NodeOption{Err: ctx.Err()}
+				return
+			}
+			n := 1
+		fill:
+			for n < len(keys) {
+				select {
+				case g.sem <- struct{}{}:
+					n++
+				default:
+					break fill
+				}
+			}
+			wg.Add(1)
+			go func(batch []cid.Cid) {
+				defer wg.Done()
+				held := len(batch)
+				defer func() {
+					for ; held > 0; held-- {
+						<-g.sem
+					}
+				}()
+				for opt := range g.NodeGetter.GetMany(ctx, batch) {
+					out <- opt
+					if held > 0 {
+						<-g.sem
+						held--
+					}
+				}
+			}(keys[:n])
+			keys = keys[n:]
+		}
+	}()
+	return out
+}
@@ -0,0 +1,171 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// batchGetter serves GetMany only and records the batches and the number
+// of keys requested at the same time.
+type batchGetter struct {
+	t        *testing.T
+	mtx      sync.Mutex
+	batches  []int
+	inflight int
+	max      int
+}
+
+func (g *batchGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	g.t.Error("batch split into single requests")
+	return merkledag.NewRawNode(c.Bytes()), nil
+}
+
+func (g *batchGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	g.mtx.Lock()
+	g.batches = append(g.batches, len(keys))
+	g.inflight += len(keys)
+	if g.inflight > g.max {
+		g.max = g.inflight
+	}
+	g.mtx.Unlock()
+	out := make(chan *ipld.NodeOption)
+	go func() {
+		defer close(out)
+		for _, k := range keys {
+			time.Sleep(time.Millisecond)
+			g.mtx.Lock()
+			g.inflight--
+			g.mtx.Unlock()
+			out <- &ipld.NodeOption{Node: merkledag.NewRawNode(k.Bytes())}
+		}
+	}()
+	return out
+}
+
+func testKeys(n int) []cid.Cid {
+	keys := make([]cid.Cid, n)
+	for i := range keys {
+		keys[i] = merkledag.NewRawNode([]byte(fmt.Sprint(i))).Cid()
+	}
+	return keys
+}
+
+func TestLimitedGetterGetMany(t *testing.T) {
+	bg := &batchGetter{t: t}
+	keys := testKeys(64)
+	n := 0
+	for opt := range newLimitedGetter(bg, 8).GetMany(context.Background(), keys) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+		n++
+	}
+	if n != len(keys) {
+		t.Fatalf("expected %d blocks got %d", len(keys), n)
+	}
+	bg.mtx.Lock()
+	defer bg.mtx.Unlock()
+	if bg.max > 8 {
+		t.Fatalf("%d blocks requested at the same time", bg.max)
+	}
+	if bg.batches[0] != 8 {
+		t.Fatalf("expected a first batch of 8 got %v", bg.batches)
+	}
+}
+
+func TestLimitedGetterCancel(t *testing.T) {
+	bg := &batchGetter{t: t}
+	g := newLimitedGetter(bg, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for opt := range g.GetMany(ctx, testKeys(4)) {
+		if opt.Err == nil {
+			continue
+		}
+		if opt.Err != context.Canceled {
+			t.Fatal(opt.Err)
+		}
+	}
+	// Every slot is given back
+	for opt := range g.GetMany(context.Background(), testKeys(4)) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+	}
+}
+
+// benchmarkGetMany fetches blocks with bitswap from a peer behind a
+// saturated link.
+func benchmarkGetMany(b *testing.B, max int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+	mn.SetLinkDefaults(mocknet.LinkOptions{
+		Latency:   5 * time.Millisecond,
+		Bandwidth: 2 * 1024 * 1024,
+	})
+	var peers [2]*Peer
+	for i := range peers {
+		h, err := mn.GenPeer()
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer h.Close()
+		peers[i], err = New(ctx, dssync.MutexWrap(datastore.NewMapDatastore()), h, nil, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := mn.LinkAll(); err != nil {
+		b.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		b.Fatal(err)
+	}
+	seed, leech := peers[0], peers[1]
+	var ng ipld.NodeGetter = leech
+	if max > 0 {
+		ng = newLimitedGetter(leech, max)
+	}
+
+	keys := make([]cid.Cid, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := range keys {
+			data := make([]byte, 16*1024)
+			rand.Read(data)
+			nd := merkledag.NewRawNode(data)
+			if err := seed.Add(ctx, nd); err != nil {
+				b.Fatal(err)
+			}
+			keys[j] = nd.Cid()
+		}
+		b.StartTimer()
+		got := 0
+		for opt := range ng.GetMany(ctx, keys) {
+			if opt.Err != nil {
+				b.Fatal(opt.Err)
+			}
+			got++
+		}
+		if got != len(keys) {
+			b.Fatalf("expected %d blocks got %d", len(keys), got)
+		}
+	}
+}
+
+func BenchmarkGetManyUnlimited(b *testing.B) { benchmarkGetMany(b, 0) }
+func BenchmarkGetManyLimit4(b *testing.B)    { benchmarkGetMany(b, 4) }
+func BenchmarkGetManyLimit8(b *testing.B)    { benchmarkGetMany(b, 8) }
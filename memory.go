@@ -0,0 +1,341 @@
+package ipfslite
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	ufsio "github.com/ipfs/go-unixfs/io"
+)
+
+// Reservation of a block whose size is not known yet, until a larger one
+// is fetched. It is the block size of the default chunker.
+const defaultBlockEstimate = 256 * 1024
+
+// memoryBudget bounds the file data fetched by the readers of GetFile and
+// not read yet, see Config.MaxMemory. A block is reserved the size of the
+// largest block seen while it is fetched, then its data counts until it is
+// read. Requests wait for room in the order they are made, which is the
+// order the readers need the blocks in, so the data buffered ahead of a
+// reader is always read eventually.
+type memoryBudget struct {
+	max int64
+
+	mtx      sync.Mutex
+	used     int64
+	peak     int64
+	estimate int64
+	queue    []*memoryRequest
+}
+
+// memoryRequest is a block request waiting for room in the budget.
+type memoryRequest struct {
+	g     *budgetGetter
+	ready chan struct{}
+	// amount is reserved once ready is closed.
+	amount int64
+	epoch  int
+}
+
+func newMemoryBudget(max int64) *memoryBudget {
+	return &memoryBudget{max: max, estimate: defaultBlockEstimate}
+}
+
+// grant reserves room for the requests at the head of the queue while
+// there is some. A reader without blocks in flight and with less than a
+// block buffered is let through anyway, so that every reader progresses
+// when the others fill the budget or when it is smaller than a block.
+func (b *memoryBudget) grant() {
+	for len(b.queue) > 0 {
+		req := b.queue[0]
+		full := b.used > 0 && b.used+b.estimate > b.max
+		if full && (req.g.inflight > 0 || req.g.buffered >= b.estimate) {
+			return
+		}
+		b.queue = b.queue[1:]
+		req.amount = b.estimate
+		req.g.inflight++
+		b.add(req.amount)
+		close(req.ready)
+	}
+}
+
+func (b *memoryBudget) add(n int64) {
+	b.used += n
+	if b.used > b.peak {
+		b.peak = b.used
+	}
+}
+
+// budgetGetter fetches the blocks of one reader within a memoryBudget. Its
+// counters are guarded by the mutex of the budget.
+type budgetGetter struct {
+	ipld.NodeGetter
+	budget *memoryBudget
+
+	inflight int
+	buffered int64
+	// epoch changes when the reader drops the blocks it fetched ahead,
+	// the blocks in flight then are not counted once fetched.
+	epoch int
+	order linkOrder
+}
+
+func (b *memoryBudget) getter(ng ipld.NodeGetter) *budgetGetter {
+	return &budgetGetter{NodeGetter: ng, budget: b}
+}
+
+// request queues requests for n blocks, which are granted in order.
+func (g *budgetGetter) request(n int) []*memoryRequest {
+	b := g.budget
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	reqs := make([]*memoryRequest, n)
+	for i := range reqs {
+		reqs[i] = &memoryRequest{g: g, ready: make(chan struct{}), epoch: g.epoch}
+	}
+	b.queue = append(b.queue, reqs...)
+	b.grant()
+	return reqs
+}
+
+// wait waits for req to be granted.
+func (g *budgetGetter) wait(ctx context.Context, req *memoryRequest) error {
+	select {
+	case <-req.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancel withdraws requests which won't be fetched.
+func (g *budgetGetter) cancel(reqs []*memoryRequest) {
+	b := g.budget
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for _, req := range reqs {
+		select {
+		case <-req.ready:
+			g.inflight--
+			b.used -= req.amount
+		default:
+			for i, r := range b.queue {
+				if r == req {
+					b.queue = append(b.queue[:i], b.queue[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	b.grant()
+}
+
+// fetched replaces the reservation of a block with the size of its data,
+// which counts until it is read.
+func (g *budgetGetter) fetched(nd ipld.Node, req *memoryRequest) {
+	b := g.budget
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	g.inflight--
+	b.used -= req.amount
+	g.order.fetched(nd)
+	if nd != nil && req.epoch == g.epoch {
+		if size, ok := leafSize(nd); ok {
+			g.buffered += size
+			b.add(size)
+			if size > b.estimate {
+				b.estimate = size
+			}
+		}
+	}
+	b.grant()
+}
+
+// read releases n bytes of data read.
+func (g *budgetGetter) read(n int64) {
+	b := g.budget
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if n > g.buffered {
+		n = g.buffered
+	}
+	g.buffered -= n
+	b.used -= n
+	b.grant()
+}
+
+// drop releases the data fetched ahead, which the reader won't read.
+func (g *budgetGetter) drop() {
+	b := g.budget
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.used -= g.buffered
+	g.buffered = 0
+	g.epoch++
+	b.grant()
+}
+
+func (g *budgetGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	reqs := g.request(1)
+	err := g.wait(ctx, reqs[0])
+	if err != nil {
+		g.cancel(reqs)
+		return nil, err
+	}
+	nd, err := g.NodeGetter.Get(ctx, c)
+	g.fetched(nd, reqs[0])
+	return nd, err
+}
+
+func (g *budgetGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	// Requests for blocks needed later must not take the room of the
+	// next one. They are queued at once so that the requests made after
+	// these don't get ahead of them.
+	keys = g.order.sort(keys)
+	reqs := g.request(len(keys))
+	out := make(chan *ipld.NodeOption, len(keys))
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for i, k := range keys {
+			err := g.wait(ctx, reqs[i])
+			if err != nil {
+				g.cancel(reqs[i:])
+				out <- &ipld.NodeOption{Err: err}
+				return
+			}
+			wg.Add(1)
+			go func(k cid.Cid, req *memoryRequest) {
+				defer wg.Done()
+				nd, err := g.NodeGetter.Get(ctx, k)
+				g.fetched(nd, req)
+				out <- &ipld.NodeOption{Node: nd, Err: err}
+			}(k, reqs[i])
+		}
+	}()
+	return out
+}
+
+// leafSize returns the size of the file data of a leaf, which is what
+// readers return of it. Internal nodes only link to the data.
+func leafSize(nd ipld.Node) (int64, bool) {
+	if len(nd.Links()) > 0 {
+		return 0, false
+	}
+	switch nd := nd.(type) {
+	case *merkledag.RawNode:
+		return int64(len(nd.RawData())), true
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return 0, false
+		}
+		return int64(len(fsn.Data())), true
+	}
+	return 0, false
+}
+
+// budgetReader releases the data of its budgetGetter as it is read.
+type budgetReader struct {
+	ufsio.DagReader
+	g *budgetGetter
+}
+
+// newFileReader returns a reader of the file c fetched with ng, within
+// budget when it is set.
+func newFileReader(ctx context.Context, c cid.Cid, ng ipld.NodeGetter, budget *memoryBudget) (ufsio.DagReader, error) {
+	var g *budgetGetter
+	if budget != nil {
+		g = budget.getter(ng)
+		ng = g
+	}
+	n, err := ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ufsio.NewDagReader(ctx, n, ng)
+	if g == nil {
+		return r, err
+	}
+	if err != nil {
+		g.drop()
+		return nil, err
+	}
+	return &budgetReader{DagReader: r, g: g}, nil
+}
+
+// Reads are split in chunks much smaller than blocks, so that the data
+// read is released before the reader waits for the next block.
+const budgetReadSize = 32 * 1024
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	if len(p) > budgetReadSize {
+		p = p[:budgetReadSize]
+	}
+	n, err := r.DagReader.Read(p)
+	r.g.read(int64(n))
+	return n, err
+}
+
+func (r *budgetReader) CtxReadFull(ctx context.Context, p []byte) (int, error) {
+	var done int
+	for done < len(p) {
+		end := done + budgetReadSize
+		if end > len(p) {
+			end = len(p)
+		}
+		n, err := r.DagReader.CtxReadFull(ctx, p[done:end])
+		r.g.read(int64(n))
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+func (r *budgetReader) WriteTo(w io.Writer) (int64, error) {
+	return r.DagReader.WriteTo(&budgetWriter{w: w, g: r.g})
+}
+
+func (r *budgetReader) Seek(offset int64, whence int) (int64, error) {
+	cur, err := r.DagReader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return cur, err
+	}
+	target := offset
+	switch whence {
+	case io.SeekCurrent:
+		target += cur
+	case io.SeekEnd:
+		target += int64(r.Size())
+	}
+	if target != cur {
+		// The blocks fetched ahead of the current position are not read
+		r.g.drop()
+	}
+	return r.DagReader.Seek(offset, whence)
+}
+
+func (r *budgetReader) Close() error {
+	r.g.drop()
+	return r.DagReader.Close()
+}
+
+// budgetWriter releases the data written by budgetReader.WriteTo.
+type budgetWriter struct {
+	w io.Writer
+	g *budgetGetter
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.g.read(int64(n))
+	return n, err
+}
@@ -0,0 +1,141 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/importer"
+)
+
+// jitterGetter serves nodes after a random delay, so that the blocks
+// fetched ahead arrive out of order.
+type jitterGetter struct {
+	ipld.DAGService
+	max time.Duration
+}
+
+func (g *jitterGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	time.Sleep(time.Duration(rand.Int63n(int64(g.max))))
+	return g.DAGService.Get(ctx, c)
+}
+
+// slowWriter is a destination slower than the network.
+type slowWriter struct {
+	bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(100 * time.Microsecond)
+	return w.Buffer.Write(p)
+}
+
+func newBudgetDAG(t *testing.T, size int) (*jitterGetter, cid.Cid, []byte) {
+	data := make([]byte, size)
+	rand.Read(data)
+	dserv := mdtest.Mock()
+	root, err := importer.BuildDagFromReader(dserv, chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jitterGetter{DAGService: dserv, max: 2 * time.Millisecond}, root.Cid(), data
+}
+
+// waitReleased waits for the blocks still in flight after the readers are
+// closed to be released.
+func waitReleased(t *testing.T, b *memoryBudget) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mtx.Lock()
+		used := b.used
+		b.mtx.Unlock()
+		if used == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d bytes still counted after closing", used)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ng, c, data := newBudgetDAG(t, 32*1024*1024)
+	budget := newMemoryBudget(1024 * 1024)
+
+	r, err := newFileReader(ctx, c, ng, budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w slowWriter
+	_, err = io.Copy(&w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if !bytes.Equal(w.Bytes(), data) {
+		t.Fatal("content differs")
+	}
+	if limit := budget.max + defaultBlockEstimate; budget.peak > limit {
+		t.Fatalf("buffered %d bytes, expected at most %d", budget.peak, limit)
+	}
+	waitReleased(t, budget)
+}
+
+func TestMemoryBudgetReaders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ng, c, data := newBudgetDAG(t, 16*1024*1024)
+	// Smaller than the blocks of all the readers
+	budget := newMemoryBudget(512 * 1024)
+
+	n := 4
+	per := len(data) / n
+	got := make([][]byte, n)
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := newFileReader(ctx, c, ng, budget)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer r.Close()
+			_, err = r.Seek(int64(i*per), io.SeekStart)
+			if err != nil {
+				errs <- err
+				return
+			}
+			got[i], err = ioutil.ReadAll(io.LimitReader(r, int64(per)))
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.Join(got, nil), data) {
+		t.Fatal("content differs")
+	}
+	if limit := budget.max + int64(n)*defaultBlockEstimate; budget.peak > limit {
+		t.Fatalf("buffered %d bytes, expected at most %d", budget.peak, limit)
+	}
+	waitReleased(t, budget)
+}
@@ -0,0 +1,87 @@
+package ipfslite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipns "github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// maxResolveDepth bounds the number of IPNS/DNSLink indirections followed
+// by ResolveName.
+const maxResolveDepth = 8
+
+// ResolveName resolves an IPNS name or a DNSLink domain to the CID it
+// currently points to. The name may be given as "/ipns/<name>" or
+// "<name>". IPNS keys are looked up in the DHT, so they cannot be resolved
+// when the DHT is disabled. Names pointing to paths inside a DAG are not
+// supported.
+func (p *Peer) ResolveName(ctx context.Context, name string) (cid.Cid, error) {
+	for depth := 0; depth < maxResolveDepth; depth++ {
+		name = strings.TrimPrefix(name, "/ipns/")
+
+		var value string
+		var err error
+		if pid, perr := peer.Decode(name); perr == nil {
+			value, err = p.resolveIPNS(ctx, pid)
+		} else {
+			value, err = resolveDNSLink(ctx, name)
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		logger.Debugf("resolved %s to %s", name, value)
+
+		switch {
+		case strings.HasPrefix(value, "/ipns/"):
+			name = value
+		case strings.HasPrefix(value, "/ipfs/"):
+			value = strings.TrimPrefix(value, "/ipfs/")
+			if strings.Contains(value, "/") {
+				return cid.Undef, fmt.Errorf("%s points to a path, only CIDs are supported", name)
+			}
+			return cid.Decode(value)
+		default:
+			return cid.Undef, fmt.Errorf("%s resolved to an invalid path %q", name, value)
+		}
+	}
+	return cid.Undef, errors.New("too many name indirections")
+}
+
+func (p *Peer) resolveIPNS(ctx context.Context, pid peer.ID) (string, error) {
+	buf, err := p.Dht.GetValue(ctx, ipns.RecordKey(pid))
+	if err != nil {
+		return "", err
+	}
+	entry := new(ipnspb.IpnsEntry)
+	err = entry.Unmarshal(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(entry.GetValue()), nil
+}
+
+func resolveDNSLink(ctx context.Context, domain string) (string, error) {
+	var lastErr error
+	for _, d := range []string{"_dnslink." + domain, domain} {
+		txts, err := dnsResolver.Backend.LookupTXT(ctx, d)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, txt := range txts {
+			if strings.HasPrefix(txt, "dnslink=") {
+				return strings.TrimPrefix(txt, "dnslink="), nil
+			}
+		}
+	}
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", fmt.Errorf("no dnslink record found for %s", domain)
+}
@@ -0,0 +1,90 @@
+package ipfslite
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipns "github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// ipnsRecords serves the IPNS records of its peers.
+type ipnsRecords struct {
+	nullRouting
+	values map[peer.ID]string
+}
+
+func (r ipnsRecords) GetValue(_ context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	for pid, v := range r.values {
+		if ipns.RecordKey(pid) == key {
+			// Left unchecked by ResolveName, the DHT validates the records
+			eol := ipnspb.IpnsEntry_EOL
+			return (&ipnspb.IpnsEntry{
+				Value:        []byte(v),
+				Signature:    []byte("signature"),
+				ValidityType: &eol,
+				Validity:     []byte("2030-01-01T00:00:00Z"),
+			}).Marshal()
+		}
+	}
+	return nil, routing.ErrNotFound
+}
+
+func TestResolveName(t *testing.T) {
+	defer func(r *madns.Resolver) { dnsResolver = r }(dnsResolver)
+	const (
+		hash  = "QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"
+		keyID = "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+	)
+	dnsResolver = &madns.Resolver{Backend: &madns.MockBackend{TXT: map[string][]string{
+		"_dnslink.example.com":   {"v=spf1 -all", "dnslink=/ipfs/" + hash},
+		"bare.example.com":       {"dnslink=/ipfs/" + hash},
+		"_dnslink.key.example":   {"dnslink=/ipns/" + keyID},
+		"_dnslink.chain.example": {"dnslink=/ipns/example.com"},
+		"_dnslink.loop.example":  {"dnslink=/ipns/loop.example"},
+		"_dnslink.none.example":  {"v=spf1 -all"},
+		"_dnslink.path.example":  {"dnslink=/ipfs/" + hash + "/index.html"},
+		"_dnslink.bad.example":   {"dnslink=" + hash},
+		"_dnslink.cid.example":   {"dnslink=/ipfs/notacid"},
+	}}}
+	pid, err := peer.Decode(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Peer{Dht: ipnsRecords{values: map[peer.ID]string{pid: "/ipns/chain.example"}}}
+
+	for _, tc := range []struct {
+		name string
+		err  string
+	}{
+		{"example.com", ""},
+		{"/ipns/example.com", ""},
+		{"bare.example.com", ""},
+		{"chain.example", ""},
+		{"/ipns/" + keyID, ""},
+		{"key.example", ""},
+		{"missing.example", "no dnslink record"},
+		{"none.example", "no dnslink record"},
+		{"path.example", "points to a path"},
+		{"bad.example", "invalid path"},
+		{"cid.example", "encoding"},
+		{"loop.example", "too many name indirections"},
+		{"/ipns/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ", routing.ErrNotFound.Error()},
+	} {
+		c, err := p.ResolveName(context.Background(), tc.name)
+		if len(tc.err) == 0 {
+			if err != nil || c.String() != hash {
+				t.Errorf("%s: expected %s got %s %v", tc.name, hash, c, err)
+			}
+			continue
+		}
+		if err == nil || c != cid.Undef || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error with %q got %s %v", tc.name, tc.err, c, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ipfslite
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// AutoNATMode selects how a host learns whether it can be dialed from the
+// swarm. The reachability decides whether AutoRelay reserves relays for
+// the host and, with DHTModeAuto, whether the DHT runs in server mode.
+//
+// Auto, the libp2p default, asks the peers connected to dial back the
+// addresses the host listens on or was observed at through identify.
+// Public and private skip the probing and assume the outcome, e.g. when
+// the host is known to have a forwarded port or to sit behind a NAT which
+// drops inbound connections. Service probes like auto and also dials back
+// the peers which ask for it, which only makes sense on reachable hosts.
+//
+// libp2p always runs an AutoNAT client, so there is no mode turning it
+// off: forcing the reachability is the only way to stop the probing.
+type AutoNATMode string
+
+// Supported AutoNAT modes.
+const (
+	AutoNATAuto    AutoNATMode = "auto"
+	AutoNATPublic  AutoNATMode = "public"
+	AutoNATPrivate AutoNATMode = "private"
+	AutoNATService AutoNATMode = "service"
+)
+
+// ParseAutoNATMode returns the AutoNATMode named by s. An empty string
+// selects AutoNATAuto.
+func ParseAutoNATMode(s string) (AutoNATMode, error) {
+	switch m := AutoNATMode(s); m {
+	case "":
+		return AutoNATAuto, nil
+	case AutoNATAuto, AutoNATPublic, AutoNATPrivate, AutoNATService:
+		return m, nil
+	case "off":
+		return "", fmt.Errorf("invalid AutoNAT mode %q, use %q or %q to stop probing", s, AutoNATPublic, AutoNATPrivate)
+	default:
+		return "", fmt.Errorf("invalid AutoNAT mode %q", s)
+	}
+}
+
+// Option returns the libp2p option applying m, to be passed to
+// SetupLibp2p. It is nil for AutoNATAuto, which libp2p ignores.
+func (m AutoNATMode) Option() libp2p.Option {
+	switch m {
+	case AutoNATPublic:
+		return libp2p.ForceReachabilityPublic()
+	case AutoNATPrivate:
+		return libp2p.ForceReachabilityPrivate()
+	case AutoNATService:
+		return libp2p.EnableNATService()
+	default:
+		return nil
+	}
+}
+
+// ExternalAddrs returns the libp2p option making the host announce addrs
+// on top of the addresses it listens on and was observed at, e.g. the
+// public address of a port forwarded to it. Peers learn them through
+// identify and the DHT and can then dial the host back.
+func ExternalAddrs(addrs []multiaddr.Multiaddr) libp2p.Option {
+	return libp2p.AddrsFactory(func(own []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		out := append([]multiaddr.Multiaddr{}, own...)
+		for _, a := range addrs {
+			if !containsAddr(own, a) {
+				out = append(out, a)
+			}
+		}
+		return out
+	})
+}
+
+func containsAddr(addrs []multiaddr.Multiaddr, a multiaddr.Multiaddr) bool {
+	for _, b := range addrs {
+		if b.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
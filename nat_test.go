@@ -0,0 +1,47 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestParseAutoNATMode(t *testing.T) {
+	for s, want := range map[string]AutoNATMode{
+		"":        AutoNATAuto,
+		"auto":    AutoNATAuto,
+		"public":  AutoNATPublic,
+		"private": AutoNATPrivate,
+		"service": AutoNATService,
+	} {
+		got, err := ParseAutoNATMode(s)
+		if err != nil || got != want {
+			t.Fatalf("%q: expected %q got %q %v", s, want, got, err)
+		}
+	}
+	if _, err := ParseAutoNATMode("off"); err == nil {
+		t.Fatal("off accepted")
+	}
+}
+
+func TestExternalAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ext := multiaddr.StringCast("/ip4/203.0.113.7/tcp/4001")
+	h, err := libp2p.New(ctx,
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		ExternalAddrs([]multiaddr.Multiaddr{ext}),
+		AutoNATPrivate.Option(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	addrs := h.Addrs()
+	if len(addrs) != 2 || !containsAddr(addrs, ext) {
+		t.Fatalf("expected the listen and the external address got %v", addrs)
+	}
+}
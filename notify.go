@@ -0,0 +1,27 @@
+package ipfslite
+
+import (
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	"github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// BlockNotifier is informed about every block received through bitswap
+// along with the peer that served it. It can be used to attribute a
+// download to the peers that contributed to it.
+type BlockNotifier interface {
+	BlockReceived(from peer.ID, c cid.Cid, size int)
+}
+
+// notifierTap adapts a BlockNotifier to the bitswap WireTap interface.
+type notifierTap struct {
+	notifier BlockNotifier
+}
+
+func (t *notifierTap) MessageReceived(from peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, blk := range msg.Blocks() {
+		t.notifier.BlockReceived(from, blk.Cid(), len(blk.RawData()))
+	}
+}
+
+func (t *notifierTap) MessageSent(peer.ID, bsmsg.BitSwapMessage) {}
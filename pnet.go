@@ -0,0 +1,96 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/davidlazar/go-crypto/salsa20"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	pnet "github.com/libp2p/go-libp2p-core/pnet"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// ErrSwarmKeyMismatch is returned by BootstrapWithError when every
+// bootstrap peer rejected the connection during the security handshake.
+// With a private network this is what happens when the swarm key differs
+// from the one of the peers, or when they are not part of a private
+// network at all.
+var ErrSwarmKeyMismatch = errors.New("swarm key mismatch: not a member of the private network")
+
+// handshakeProbeTimeout bounds the connection made by swarmKeyRejected.
+var handshakeProbeTimeout = 2 * time.Second
+
+// multistreamHeader is what a libp2p host sends first on every connection,
+// before negotiating the security protocol.
+var multistreamHeader = []byte("\x13/multistream/1.0.0\n")
+
+// isHandshakeFailure tells whether a dial error comes from the security
+// negotiation. With a wrong swarm key the private network layer doesn't
+// fail itself, it turns the stream into garbage and the negotiation that
+// follows fails instead.
+func isHandshakeFailure(err error) bool {
+	return strings.Contains(err.Error(), "failed to negotiate security protocol")
+}
+
+// swarmKeyRejected tells whether a peer whose dial timed out is a member
+// of another private network than psk. The garbage read with a wrong swarm
+// key doesn't always fail the negotiation: it may be taken for the length
+// of a message which never arrives, and the dial then times out instead.
+// The peer is connected again over TCP and the header it sends right away
+// is decrypted with psk, which only gives the multistream header with the
+// key of the peer. A peer which doesn't send anything in time, e.g. one
+// which is overloaded, isn't blamed on the key.
+func swarmKeyRejected(ctx context.Context, pinfo peer.AddrInfo, psk pnet.PSK) bool {
+	if len(psk) != 32 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, handshakeProbeTimeout)
+	defer cancel()
+	var d net.Dialer
+	for _, a := range pinfo.Addrs {
+		if AddrTransport(a) != "tcp" {
+			continue
+		}
+		network, addr, err := manet.DialArgs(a)
+		if err != nil {
+			continue
+		}
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			continue
+		}
+		header, err := readPrivateHeader(ctx, c, psk)
+		c.Close()
+		if err == nil {
+			return !bytes.Equal(header, multistreamHeader)
+		}
+	}
+	return false
+}
+
+// readPrivateHeader reads as many bytes as the multistream header from c
+// and decrypts them with psk, as the private network layer would.
+func readPrivateHeader(ctx context.Context, c net.Conn, psk pnet.PSK) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetReadDeadline(deadline)
+	}
+	nonce := make([]byte, 24)
+	_, err := io.ReadFull(c, nonce)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(multistreamHeader))
+	_, err = io.ReadFull(c, header)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], psk)
+	salsa20.New(&key, nonce).XORKeyStream(header, header)
+	return header, nil
+}
@@ -0,0 +1,84 @@
+package ipfslite
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func newPSK(t *testing.T) pnet.PSK {
+	psk := make([]byte, 32)
+	if _, err := rand.Read(psk); err != nil {
+		t.Fatal(err)
+	}
+	return psk
+}
+
+func TestBootstrapSwarmKeyMismatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := newPSK(t)
+	h1, err := libp2p.New(ctx, libp2p.PrivateNetwork(key), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2, err := libp2p.New(ctx, libp2p.PrivateNetwork(newPSK(t)), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	// The negotiation sometimes hangs rather than failing
+	p := &Peer{ctx: ctx, cfg: &Config{DialTimeout: time.Second, SwarmKey: key}, Host: h1, Dht: nullRouting{}}
+	n, err := p.BootstrapWithError([]peer.AddrInfo{{ID: h2.ID(), Addrs: h2.Addrs()}})
+	if err != ErrSwarmKeyMismatch {
+		t.Fatalf("expected a swarm key mismatch got %d %v", n, err)
+	}
+}
+
+func TestSwarmKeyRejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := newPSK(t)
+	h, err := libp2p.New(ctx, libp2p.PrivateNetwork(key), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	pinfo := peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+	if !swarmKeyRejected(ctx, pinfo, newPSK(t)) {
+		t.Fatal("expected another swarm key to be rejected")
+	}
+	if swarmKeyRejected(ctx, pinfo, key) {
+		t.Fatal("swarm key of the peer rejected")
+	}
+
+	// Accepts connections and never answers, as an overloaded peer may
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := manet.FromNetAddr(l.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(d time.Duration) { handshakeProbeTimeout = d }(handshakeProbeTimeout)
+	handshakeProbeTimeout = 100 * time.Millisecond
+	if swarmKeyRejected(ctx, peer.AddrInfo{Addrs: []multiaddr.Multiaddr{addr}}, key) {
+		t.Fatal("silent peer blamed on the swarm key")
+	}
+	l.Close()
+	if swarmKeyRejected(ctx, peer.AddrInfo{Addrs: []multiaddr.Multiaddr{addr}}, key) {
+		t.Fatal("swarm key blamed for a closed port")
+	}
+}
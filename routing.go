@@ -0,0 +1,45 @@
+package ipfslite
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// nullRouting is used in place of the DHT when it is disabled. Content can
+// then only be found on the peers we are directly connected to.
+type nullRouting struct{}
+
+var _ routing.Routing = nullRouting{}
+
+func (nullRouting) Provide(context.Context, cid.Cid, bool) error {
+	return nil
+}
+
+func (nullRouting) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func (nullRouting) FindPeer(context.Context, peer.ID) (peer.AddrInfo, error) {
+	return peer.AddrInfo{}, routing.ErrNotFound
+}
+
+func (nullRouting) PutValue(context.Context, string, []byte, ...routing.Option) error {
+	return routing.ErrNotSupported
+}
+
+func (nullRouting) GetValue(context.Context, string, ...routing.Option) ([]byte, error) {
+	return nil, routing.ErrNotFound
+}
+
+func (nullRouting) SearchValue(context.Context, string, ...routing.Option) (<-chan []byte, error) {
+	return nil, routing.ErrNotFound
+}
+
+func (nullRouting) Bootstrap(context.Context) error {
+	return nil
+}
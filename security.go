@@ -0,0 +1,51 @@
+package ipfslite
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+	noise "github.com/libp2p/go-libp2p-noise"
+	tls "github.com/libp2p/go-libp2p-tls"
+)
+
+// SecurityTransport selects the security protocols a host negotiates on
+// its connections, on top of the private network protection.
+//
+// Both offers Noise first then TLS 1.3, which is what the libp2p defaults
+// and so the hiver nodes of the swarms offer. Forcing one of them fails the
+// connections to the peers which don't enable it, so it should only be
+// done when every leader of the swarm is known to support it.
+type SecurityTransport string
+
+// Supported security transports.
+const (
+	SecurityBoth  SecurityTransport = "both"
+	SecurityNoise SecurityTransport = "noise"
+	SecurityTLS   SecurityTransport = "tls"
+)
+
+// ParseSecurityTransport returns the SecurityTransport named by s. An empty
+// string selects SecurityBoth.
+func ParseSecurityTransport(s string) (SecurityTransport, error) {
+	switch t := SecurityTransport(s); t {
+	case "":
+		return SecurityBoth, nil
+	case SecurityBoth, SecurityNoise, SecurityTLS:
+		return t, nil
+	default:
+		return "", fmt.Errorf("invalid security transport %q", s)
+	}
+}
+
+// Option returns the libp2p option enabling the security protocols of t,
+// to be passed to SetupLibp2p.
+func (t SecurityTransport) Option() libp2p.Option {
+	switch t {
+	case SecurityNoise:
+		return libp2p.Security(noise.ID, noise.New)
+	case SecurityTLS:
+		return libp2p.Security(tls.ID, tls.New)
+	default:
+		return libp2p.DefaultSecurity
+	}
+}
@@ -0,0 +1,58 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestParseSecurityTransport(t *testing.T) {
+	for s, want := range map[string]SecurityTransport{
+		"":      SecurityBoth,
+		"both":  SecurityBoth,
+		"noise": SecurityNoise,
+		"tls":   SecurityTLS,
+	} {
+		got, err := ParseSecurityTransport(s)
+		if err != nil || got != want {
+			t.Fatalf("%q: expected %q got %q %v", s, want, got, err)
+		}
+	}
+	if _, err := ParseSecurityTransport("secio"); err == nil {
+		t.Fatal("secio accepted")
+	}
+}
+
+func TestSecurityTransportOption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	psk := newPSK(t)
+	newHost := func(s SecurityTransport) host.Host {
+		h, err := libp2p.New(ctx, libp2p.PrivateNetwork(psk), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), s.Option())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	for _, tc := range []struct {
+		dialer, listener SecurityTransport
+		ok               bool
+	}{
+		{SecurityNoise, SecurityBoth, true},
+		{SecurityTLS, SecurityBoth, true},
+		{SecurityTLS, SecurityTLS, true},
+		{SecurityNoise, SecurityTLS, false},
+	} {
+		d, l := newHost(tc.dialer), newHost(tc.listener)
+		err := d.Connect(ctx, peer.AddrInfo{ID: l.ID(), Addrs: l.Addrs()})
+		if (err == nil) != tc.ok {
+			t.Errorf("%s to %s: expected success %v got %v", tc.dialer, tc.listener, tc.ok, err)
+		}
+		d.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,109 @@
+package ipfslite
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// BlockSource serves blocks from outside the swarm, such as a local HTTP
+// mirror or another IPFS node. Get returns false when the source doesn't
+// have the block.
+type BlockSource interface {
+	Get(ctx context.Context, c cid.Cid) ([]byte, bool)
+}
+
+// sourceStats counts the blocks found or not in the BlockSource.
+type sourceStats struct {
+	hits   int64
+	misses int64
+}
+
+// BlockSourceStats returns the number of blocks served by the BlockSource
+// of the configuration and the number of blocks it didn't have.
+func (p *Peer) BlockSourceStats() (hits, misses int64) {
+	return atomic.LoadInt64(&p.srcStats.hits), atomic.LoadInt64(&p.srcStats.misses)
+}
+
+// sourceGetter asks the BlockSource for blocks before getting them from
+// the network. Blocks from the source are checked against their CID and
+// added to the DAG service, so they are only fetched once.
+type sourceGetter struct {
+	ipld.NodeGetter
+	source BlockSource
+	dag    ipld.DAGService
+	stats  *sourceStats
+}
+
+func (g *sourceGetter) fromSource(ctx context.Context, c cid.Cid) ipld.Node {
+	data, ok := g.source.Get(ctx, c)
+	if !ok {
+		atomic.AddInt64(&g.stats.misses, 1)
+		return nil
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil || !sum.Equals(c) {
+		logger.Warnf("block source served invalid data for %s", c)
+		atomic.AddInt64(&g.stats.misses, 1)
+		return nil
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		atomic.AddInt64(&g.stats.misses, 1)
+		return nil
+	}
+	nd, err := ipld.Decode(blk)
+	if err != nil {
+		logger.Warnf("failed decoding %s from block source: %s", c, err)
+		atomic.AddInt64(&g.stats.misses, 1)
+		return nil
+	}
+	err = g.dag.Add(ctx, nd)
+	if err != nil {
+		logger.Warnf("failed storing %s from block source: %s", c, err)
+	}
+	atomic.AddInt64(&g.stats.hits, 1)
+	return nd
+}
+
+func (g *sourceGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if nd := g.fromSource(ctx, c); nd != nil {
+		return nd, nil
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func (g *sourceGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(keys))
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		var mtx sync.Mutex
+		var missing []cid.Cid
+		for _, k := range keys {
+			wg.Add(1)
+			go func(k cid.Cid) {
+				defer wg.Done()
+				if nd := g.fromSource(ctx, k); nd != nil {
+					out <- &ipld.NodeOption{Node: nd}
+					return
+				}
+				mtx.Lock()
+				missing = append(missing, k)
+				mtx.Unlock()
+			}(k)
+		}
+		wg.Wait()
+		if len(missing) == 0 {
+			return
+		}
+		for opt := range g.NodeGetter.GetMany(ctx, missing) {
+			out <- opt
+		}
+	}()
+	return out
+}
@@ -0,0 +1,82 @@
+package ipfslite
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipfs/go-unixfs/importer"
+)
+
+type mapSource map[cid.Cid][]byte
+
+func (s mapSource) Get(ctx context.Context, c cid.Cid) ([]byte, bool) {
+	data, ok := s[c]
+	return data, ok
+}
+
+func TestSourceGetter(t *testing.T) {
+	ctx := context.Background()
+	network := mdtest.Mock()
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	root, err := importer.BuildDagFromReader(network, chunk.NewSizeSplitter(bytes.NewReader(data), 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := root.Links()
+
+	// The source has the first two leaves and garbage for the third one
+	src := mapSource{}
+	for _, l := range links[:2] {
+		nd, err := network.Get(ctx, l.Cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src[l.Cid] = nd.RawData()
+	}
+	src[links[2].Cid] = []byte("garbage")
+
+	local := mdtest.Mock()
+	g := &sourceGetter{NodeGetter: network, source: src, dag: local, stats: &sourceStats{}}
+	keys := []cid.Cid{}
+	for _, l := range links {
+		keys = append(keys, l.Cid)
+	}
+	got := map[cid.Cid]bool{}
+	for opt := range g.GetMany(ctx, keys) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+		got[opt.Node.Cid()] = true
+	}
+	if len(got) != len(links) {
+		t.Fatalf("expected %d nodes got %d", len(links), len(got))
+	}
+	if g.stats.hits != 2 || g.stats.misses != 2 {
+		t.Fatalf("expected 2 hits and 2 misses got %+v", g.stats)
+	}
+	// Only the valid blocks of the source are kept
+	for i, l := range links {
+		_, err := local.Get(ctx, l.Cid)
+		if i < 2 && err != nil {
+			t.Fatalf("block %d from the source not stored: %s", i, err)
+		}
+		if i >= 2 && err != ipld.ErrNotFound {
+			t.Fatalf("block %d should not be stored", i)
+		}
+	}
+
+	nd, err := g.Get(ctx, links[0].Cid)
+	if err != nil || !nd.Cid().Equals(links[0].Cid) {
+		t.Fatalf("unexpected node %v %v", nd, err)
+	}
+	if g.stats.hits != 3 {
+		t.Fatalf("expected 3 hits got %d", g.stats.hits)
+	}
+}
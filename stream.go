@@ -0,0 +1,93 @@
+package ipfslite
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Number of internal nodes kept to sort the keys of GetMany, more than
+// the depth of the DAGs of any file.
+const maxParents = 16
+
+// linkOrder remembers the last internal nodes fetched by a reader, so that
+// the blocks it asks for together are requested in the order it needs
+// them. ipld.GetNodes doesn't keep that order.
+type linkOrder struct {
+	mtx     sync.Mutex
+	parents []ipld.Node
+}
+
+// fetched remembers nd if it links to other blocks.
+func (o *linkOrder) fetched(nd ipld.Node) {
+	if nd == nil || len(nd.Links()) == 0 {
+		return
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.parents = append(o.parents, nd)
+	if len(o.parents) > maxParents {
+		o.parents = o.parents[1:]
+	}
+}
+
+// sort returns keys in the order of the links of a parent fetched before,
+// or as they are when none links to all of them.
+func (o *linkOrder) sort(keys []cid.Cid) []cid.Cid {
+	o.mtx.Lock()
+	parents := append([]ipld.Node(nil), o.parents...)
+	o.mtx.Unlock()
+	for i := len(parents) - 1; i >= 0; i-- {
+		pos := make(map[cid.Cid]int)
+		for j, l := range parents[i].Links() {
+			if _, ok := pos[l.Cid]; !ok {
+				pos[l.Cid] = j
+			}
+		}
+		all := true
+		for _, k := range keys {
+			if _, ok := pos[k]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			sorted := append([]cid.Cid(nil), keys...)
+			sort.SliceStable(sorted, func(a, b int) bool {
+				return pos[sorted[a]] < pos[sorted[b]]
+			})
+			return sorted
+		}
+	}
+	return keys
+}
+
+// orderedGetter requests the blocks of a file in the order of the file,
+// see Config.StreamOrder. Bitswap gives the blocks wanted first the
+// highest priority, so the blocks read next arrive first.
+type orderedGetter struct {
+	ipld.NodeGetter
+	order linkOrder
+}
+
+func (g *orderedGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := g.NodeGetter.Get(ctx, c)
+	g.order.fetched(nd)
+	return nd, err
+}
+
+func (g *orderedGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	in := g.NodeGetter.GetMany(ctx, g.order.sort(keys))
+	out := make(chan *ipld.NodeOption, len(keys))
+	go func() {
+		defer close(out)
+		for opt := range in {
+			g.order.fetched(opt.Node)
+			out <- opt
+		}
+	}()
+	return out
+}
@@ -0,0 +1,51 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// recordGetter records the keys of GetMany in the order they are asked.
+type recordGetter struct {
+	ipld.NodeGetter
+	keys []cid.Cid
+}
+
+func (g *recordGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	g.keys = append(g.keys, keys...)
+	return g.NodeGetter.GetMany(ctx, keys)
+}
+
+func TestOrderedGetter(t *testing.T) {
+	ctx := context.Background()
+	ng, c, _ := newBudgetDAG(t, 4*1024*1024)
+	rec := &recordGetter{NodeGetter: ng}
+	g := &orderedGetter{NodeGetter: rec}
+
+	root, err := g.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []cid.Cid
+	for _, l := range root.Links() {
+		keys = append([]cid.Cid{l.Cid}, keys...)
+	}
+	n := 0
+	for opt := range g.GetMany(ctx, keys) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+		n++
+	}
+	if n != len(keys) {
+		t.Fatalf("expected %d nodes got %d", len(keys), n)
+	}
+	for i, l := range root.Links() {
+		if !rec.keys[i].Equals(l.Cid) {
+			t.Fatalf("block %d requested out of order", i)
+		}
+	}
+}
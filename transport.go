@@ -0,0 +1,142 @@
+package ipfslite
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/transport"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	tcp "github.com/libp2p/go-tcp-transport"
+	ws "github.com/libp2p/go-ws-transport"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// TransportOptions sets up the default libp2p transports (TCP and
+// websockets) with the given TCP connect timeout and TCP keepalive period.
+// Zero values keep the libp2p defaults (5s connect timeout, 15s keepalive).
+// It is meant to be used in place of libp2p.DefaultTransports, see
+// Libp2pOptions.
+//
+// On cellular or otherwise unreliable networks a connect timeout around 3s
+// lets bootstrap give up on dead leaders sooner and a keepalive around 10s
+// detects dropped peers sooner. On wired networks the defaults are fine.
+func TransportOptions(connectTimeout, keepAlive time.Duration) libp2p.Option {
+	return TCPTransportOptions(TCPConfig{
+		ConnectTimeout: connectTimeout,
+		KeepAlive:      keepAlive,
+	})
+}
+
+// TCPConfig tunes the TCP connections dialed to other peers. Zero values
+// keep the libp2p defaults.
+type TCPConfig struct {
+	ConnectTimeout time.Duration
+	KeepAlive      time.Duration
+	// DSCP is the differentiated services code point, 0 to 63, marking
+	// the packets of the connections so that routers can prioritize them,
+	// e.g. 8 (CS1) for bulk traffic. It is only applied on Linux and macOS
+	// and ignored elsewhere.
+	DSCP int
+}
+
+// TCPTransportOptions is TransportOptions with the full TCP settings.
+func TCPTransportOptions(cfg TCPConfig) libp2p.Option {
+	return libp2p.ChainOptions(
+		libp2p.Transport(func(u *tptu.Upgrader) *tcpTransport {
+			t := tcp.NewTCPTransport(u)
+			if cfg.ConnectTimeout > 0 {
+				t.ConnectTimeout = cfg.ConnectTimeout
+			}
+			return &tcpTransport{TcpTransport: t, keepAlive: cfg.KeepAlive, dscp: cfg.DSCP}
+		}),
+		libp2p.Transport(ws.New),
+	)
+}
+
+// tcpTransport is the libp2p TCP transport with a configurable keepalive
+// period and DSCP. Connections dialed with either of them don't use port
+// reuse. Accepted connections are left as is.
+type tcpTransport struct {
+	*tcp.TcpTransport
+	keepAlive time.Duration
+	dscp      int
+}
+
+func (t *tcpTransport) Dial(ctx context.Context, raddr multiaddr.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	if t.keepAlive <= 0 && t.dscp <= 0 {
+		return t.TcpTransport.Dial(ctx, raddr, p)
+	}
+	conn, err := t.dial(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return t.Upgrader.UpgradeOutbound(ctx, t, conn, p)
+}
+
+func (t *tcpTransport) dial(ctx context.Context, raddr multiaddr.Multiaddr) (manet.Conn, error) {
+	if t.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.ConnectTimeout)
+		defer cancel()
+	}
+	d := manet.Dialer{Dialer: net.Dialer{KeepAlive: t.keepAlive}}
+	if t.dscp > 0 {
+		d.Dialer.Control = dscpControl(t.dscp)
+	}
+	return d.DialContext(ctx, raddr)
+}
+
+// AddrTransport names the transport of a: "tcp", "ws", "wss", "quic" or
+// "relay" for relay circuits. It is empty for other addresses.
+func AddrTransport(a multiaddr.Multiaddr) string {
+	if _, err := a.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return "relay"
+	}
+	for _, t := range []struct {
+		code int
+		name string
+	}{
+		{multiaddr.P_QUIC, "quic"},
+		{multiaddr.P_WSS, "wss"},
+		{multiaddr.P_WS, "ws"},
+		{multiaddr.P_TCP, "tcp"},
+	} {
+		if _, err := a.ValueForProtocol(t.code); err == nil {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// PreferTransports returns peers with only their addresses using one of
+// transports, as named by AddrTransport, so that no time is spent dialing
+// the others. Peers without any such address keep all of theirs. Empty
+// transports returns peers as is.
+func PreferTransports(peers []peer.AddrInfo, transports []string) []peer.AddrInfo {
+	if len(transports) == 0 {
+		return peers
+	}
+	out := make([]peer.AddrInfo, 0, len(peers))
+	for _, p := range peers {
+		addrs := make([]multiaddr.Multiaddr, 0, len(p.Addrs))
+		for _, a := range p.Addrs {
+			t := AddrTransport(a)
+			for _, pref := range transports {
+				if t == pref {
+					addrs = append(addrs, a)
+					break
+				}
+			}
+		}
+		if len(addrs) == 0 {
+			logger.Debugf("no address of %s uses the preferred transports", p.ID)
+			addrs = p.Addrs
+		}
+		out = append(out, peer.AddrInfo{ID: p.ID, Addrs: addrs})
+	}
+	return out
+}
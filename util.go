@@ -2,6 +2,7 @@ package ipfslite
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/ipfs/go-datastore"
@@ -16,6 +17,7 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
 	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/config"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -27,11 +29,52 @@ func DefaultBootstrapPeers() []peer.AddrInfo {
 
 // Libp2pOptionsExtra provides some useful libp2p options
 // to create a fully featured libp2p host. It can be used with
-// SetupLibp2p.
-var Libp2pOptionsExtra = []libp2p.Option{
-	libp2p.ConnectionManager(connmgr.NewConnManager(100, 600, time.Minute)),
-	libp2p.EnableAutoRelay(),
-	libp2p.DefaultTransports,
+// SetupLibp2p. Its connection manager is shared by every host built
+// with it and closed along with the first of them, so hosts which
+// don't live as long as the process should use Libp2pOptions instead.
+var Libp2pOptionsExtra = Libp2pOptions(libp2p.DefaultTransports)
+
+// Libp2pOptions returns the same options as Libp2pOptionsExtra using the
+// given transports option, e.g. from TransportOptions, in place of
+// libp2p.DefaultTransports.
+func Libp2pOptions(transports libp2p.Option) []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.ConnectionManager(connmgr.NewConnManager(100, 600, time.Minute)),
+		libp2p.EnableAutoRelay(),
+		transports,
+	}
+}
+
+// DHTMode selects how the DHT set up by SetupLibp2pWithDHT takes part in
+// the network.
+//
+// Client mode only issues queries and is the right choice for downloaders
+// behind NAT. Server mode also answers queries from other peers, which
+// requires the host to be publicly reachable and costs bandwidth and memory.
+// Auto switches between the two based on the reachability detected by
+// libp2p. Off disables the DHT entirely, leaving the directly connected
+// peers (the leaders) as the only source of content.
+type DHTMode string
+
+// Supported DHT modes.
+const (
+	DHTModeAuto   DHTMode = "auto"
+	DHTModeClient DHTMode = "client"
+	DHTModeServer DHTMode = "server"
+	DHTModeOff    DHTMode = "off"
+)
+
+// ParseDHTMode returns the DHTMode named by s. An empty string selects
+// DHTModeClient.
+func ParseDHTMode(s string) (DHTMode, error) {
+	switch m := DHTMode(s); m {
+	case "":
+		return DHTModeClient, nil
+	case DHTModeAuto, DHTModeClient, DHTModeServer, DHTModeOff:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid DHT mode %q", s)
+	}
 }
 
 // SetupLibp2p returns a routed host and DHT instances that can be used to
@@ -55,6 +98,22 @@ func SetupLibp2p(
 	ds datastore.Batching,
 	opts ...libp2p.Option,
 ) (host.Host, *dualdht.DHT, error) {
+	return SetupLibp2pWithDHT(ctx, hostKey, secret, listenAddrs, ds, DHTModeClient, opts...)
+}
+
+// SetupLibp2pWithDHT works like SetupLibp2p but lets the caller choose the
+// DHT mode. When mode is DHTModeOff no DHT is created and the returned DHT
+// is nil. AutoRelay then only uses static relays, as there is nothing to
+// discover other relays with.
+func SetupLibp2pWithDHT(
+	ctx context.Context,
+	hostKey crypto.PrivKey,
+	secret pnet.PSK,
+	listenAddrs []multiaddr.Multiaddr,
+	ds datastore.Batching,
+	mode DHTMode,
+	opts ...libp2p.Option,
+) (host.Host, *dualdht.DHT, error) {
 
 	var ddht *dualdht.DHT
 	var err error
@@ -63,12 +122,17 @@ func SetupLibp2p(
 		libp2p.Identity(hostKey),
 		libp2p.ListenAddrs(listenAddrs...),
 		libp2p.PrivateNetwork(secret),
-		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			ddht, err = newDHT(ctx, h, ds)
+	}
+	if mode != DHTModeOff {
+		finalOpts = append(finalOpts, libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			ddht, err = newDHT(ctx, h, ds, mode)
 			return ddht, err
-		}),
+		}))
 	}
 	finalOpts = append(finalOpts, opts...)
+	if mode == DHTModeOff {
+		finalOpts = append(finalOpts, withoutDiscoveredRelays)
+	}
 
 	h, err := libp2p.New(
 		ctx,
@@ -80,12 +144,29 @@ func SetupLibp2p(
 	return h, ddht, nil
 }
 
-func newDHT(ctx context.Context, h host.Host, ds datastore.Batching) (*dualdht.DHT, error) {
+// withoutDiscoveredRelays turns AutoRelay off when it would have to find
+// relays through the DHT, e.g. with Libp2pOptionsExtra and DHTModeOff, as
+// libp2p refuses to start without routing then. Static relays are kept.
+func withoutDiscoveredRelays(cfg *config.Config) error {
+	if len(cfg.StaticRelays) == 0 {
+		cfg.EnableAutoRelay = false
+	}
+	return nil
+}
+
+func newDHT(ctx context.Context, h host.Host, ds datastore.Batching, mode DHTMode) (*dualdht.DHT, error) {
+	dhtMode := dht.ModeClient
+	switch mode {
+	case DHTModeAuto:
+		dhtMode = dht.ModeAuto
+	case DHTModeServer:
+		dhtMode = dht.ModeServer
+	}
 	dhtOpts := []dht.Option{
 		dht.NamespacedValidator("pk", record.PublicKeyValidator{}),
 		dht.NamespacedValidator("ipns", ipns.Validator{KeyBook: h.Peerstore()}),
 		dht.Concurrency(10),
-		dht.Mode(dht.ModeClient),
+		dht.Mode(dhtMode),
 	}
 	if ds != nil {
 		dhtOpts = append(dhtOpts, dht.Datastore(ds))
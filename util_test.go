@@ -0,0 +1,32 @@
+package ipfslite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestSetupLibp2pDHTModeOff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")}
+	// Libp2pOptionsExtra enables AutoRelay, which needs the DHT to find relays
+	h, dht, err := SetupLibp2pWithDHT(ctx, priv, newPSK(t), listen, nil, DHTModeOff, Libp2pOptionsExtra...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if dht != nil {
+		t.Fatal("DHT set up in off mode")
+	}
+	if len(h.Addrs()) == 0 {
+		t.Fatal("host is not listening")
+	}
+}